@@ -1,23 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
-
-	"golang.org/x/sys/unix"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mas9612/nwspeaker/pkg/arp"
-	"github.com/mas9612/nwspeaker/pkg/endian"
 	"github.com/mas9612/nwspeaker/pkg/ethernet"
 	"github.com/mas9612/nwspeaker/pkg/iface"
 )
 
 type options struct {
-	Interface string `short:"i" long:"interface" required:"true" description:"Output interface name. Required."`
-	Garp      bool   `short:"g" long:"garp" description:"Send GARP instead of normal ARP request."`
-	Check     bool   `short:"c" long:"check" description:"Check the response from other host. If this is not true, simply send data and exit."`
+	Interface string        `short:"i" long:"interface" required:"true" description:"Output interface name. Required."`
+	Garp      bool          `short:"g" long:"garp" description:"Send GARP instead of normal ARP request."`
+	Check     bool          `short:"c" long:"check" description:"Check the response from other host. If this is not true, simply send data and exit."`
+	Timeout   time.Duration `short:"t" long:"timeout" default:"3s" description:"Time to wait for the response when -c flag is on."`
 	Args      struct {
 		TargetIP string `description:"IP address want to get MAC address. Not used when -g flag is on."`
 	} `positional-args:"yes"`
@@ -69,36 +69,35 @@ func main() {
 	}
 
 	// prepare raw socket
-	soc, err := ethernet.Dial(endian.Htons(ethernet.TypeARP))
+	soc, err := ethernet.Listen(opts.Interface, ethernet.TypeARP)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create ethernet raw socket: %s\n", err.Error())
 		os.Exit(1)
 	}
-	addr := &unix.SockaddrLinklayer{
-		Protocol: endian.Htons(ethernet.TypeARP),
-		Ifindex:  oif.Index,
-		Halen:    ethernet.EtherLen,
-	}
-	if err := soc.Bind(addr); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to bind interface: %s\n", err.Error())
-		os.Exit(1)
-	}
 
 	if err := soc.Send(data, 0, "ff:ff:ff:ff:ff:ff"); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to send ARP frame: %s\n", err.Error())
 		os.Exit(1)
 	}
 	if opts.Check {
-		b, err := soc.Recv(0)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to receive ARP reply: %s\n", err.Error())
-			os.Exit(1)
-		}
-		res := arp.Parse(b)
-		if res.SrcPAddr.String() == opts.Args.TargetIP {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		defer cancel()
+		for {
+			b, err := soc.RecvContext(ctx, 0)
+			if err != nil {
+				if ethernet.IsTimeout(err) {
+					fmt.Printf("could not get the MAC address\n")
+					return
+				}
+				fmt.Fprintf(os.Stderr, "failed to receive ARP reply: %s\n", err.Error())
+				os.Exit(1)
+			}
+			res := arp.Parse(b)
+			if res == nil || res.Op != arp.OpReply || res.SrcPAddr.String() != opts.Args.TargetIP {
+				continue
+			}
 			fmt.Printf("MAC address of %s is %s\n", res.SrcPAddr.String(), res.SrcHAddr.String())
-		} else {
-			fmt.Printf("could not get the MAC address\n")
+			return
 		}
 	}
 }
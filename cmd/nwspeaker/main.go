@@ -2,19 +2,152 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
+	"github.com/mas9612/nwspeaker/pkg/command"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/pcap"
+	"github.com/mas9612/nwspeaker/pkg/pcapng"
 	"github.com/mitchellh/cli"
 )
 
+const globalHelp = `
+Global options (available on every command, given before the command name):
+    --write-pcap FILE    Record every frame sent and received to FILE in pcap format.
+                         If FILE ends with ".pcapng", pcapng format is used and
+                         frames are labeled with the interface and the direction.
+    --pcap-nano          Record timestamps in nanoseconds instead of microseconds.
+                         pcapng always uses nanoseconds.
+    --pcap-comment TEXT  Attach TEXT to every sent frame. Only kept in pcapng.
+`
+
+// globalOptions are options accepted by every command.
+type globalOptions struct {
+	writePcap   string
+	pcapNano    bool
+	pcapComment string
+}
+
+// parseGlobalOptions parses global options which precede the command name
+// and returns the command name and its arguments as they are.
+func parseGlobalOptions(args []string) ([]string, *globalOptions, error) {
+	opts := &globalOptions{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--write-pcap", arg == "--pcap-comment":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+			if arg == "--write-pcap" {
+				opts.writePcap = args[i]
+			} else {
+				opts.pcapComment = args[i]
+			}
+		case strings.HasPrefix(arg, "--write-pcap="):
+			opts.writePcap = strings.TrimPrefix(arg, "--write-pcap=")
+		case strings.HasPrefix(arg, "--pcap-comment="):
+			opts.pcapComment = strings.TrimPrefix(arg, "--pcap-comment=")
+		case arg == "--pcap-nano":
+			opts.pcapNano = true
+		case arg == "--": // end of global options
+			return args[i+1:], opts, nil
+		default: // command name
+			return args[i:], opts, nil
+		}
+	}
+	return nil, opts, nil
+}
+
 func main() {
+	args, opts, err := parseGlobalOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	var w io.Closer
+	if opts.writePcap != "" {
+		var r ethernet.Recorder
+		if strings.HasSuffix(opts.writePcap, ".pcapng") {
+			r, err = pcapng.Create(opts.writePcap)
+		} else {
+			r, err = pcap.Create(opts.writePcap, pcap.SetNanosecond(opts.pcapNano))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		ethernet.SetRecorder(r)
+		ethernet.SetRecordComment(opts.pcapComment)
+		w = r.(io.Closer)
+	}
+
 	c := cli.NewCLI("nwspeaker", "0.1")
-	c.Args = os.Args[1:]
-	c.Commands = map[string]cli.CommandFactory{}
+	c.Args = args
+	c.HelpFunc = func(commands map[string]cli.CommandFactory) string {
+		return cli.BasicHelpFunc("nwspeaker")(commands) + globalHelp
+	}
+	c.Commands = map[string]cli.CommandFactory{
+		"arp": func() (cli.Command, error) {
+			return &command.ArpCommand{}, nil
+		},
+		"fingerprint": func() (cli.Command, error) {
+			return &command.FingerprintCommand{}, nil
+		},
+		"icmp": func() (cli.Command, error) {
+			return &command.ICMPCommand{}, nil
+		},
+		"icmp-query": func() (cli.Command, error) {
+			return &command.ICMPQueryCommand{}, nil
+		},
+		"listen": func() (cli.Command, error) {
+			return &command.ListenCommand{}, nil
+		},
+		"pmtu": func() (cli.Command, error) {
+			return &command.PMTUCommand{}, nil
+		},
+		"rdisc": func() (cli.Command, error) {
+			return &command.RdiscCommand{}, nil
+		},
+		"read": func() (cli.Command, error) {
+			return &command.ReadCommand{}, nil
+		},
+		"replay": func() (cli.Command, error) {
+			return &command.ReplayCommand{}, nil
+		},
+		"respond": func() (cli.Command, error) {
+			return &command.RespondCommand{}, nil
+		},
+		"synscan": func() (cli.Command, error) {
+			return &command.SynScanCommand{}, nil
+		},
+		"tcp": func() (cli.Command, error) {
+			return &command.TCPCommand{}, nil
+		},
+		"tcp-host": func() (cli.Command, error) {
+			return &command.TCPHostCommand{}, nil
+		},
+		"traceroute": func() (cli.Command, error) {
+			return &command.TracerouteCommand{}, nil
+		},
+		"udp": func() (cli.Command, error) {
+			return &command.UDPCommand{}, nil
+		},
+	}
 
 	exitStatus, err := c.Run()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
+	if err := ethernet.CloseSockets(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	if w != nil {
+		if err := w.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
 	os.Exit(exitStatus)
 }
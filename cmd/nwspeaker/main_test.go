@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGlobalOptions(t *testing.T) {
+	tests := []struct {
+		in   []string
+		rest []string
+		opts globalOptions
+		ok   bool
+	}{
+		{[]string{"arp", "-i", "eth0"}, []string{"arp", "-i", "eth0"}, globalOptions{}, true},
+		{
+			[]string{"--write-pcap", "a.pcap", "--pcap-nano", "arp", "-i", "eth0"},
+			[]string{"arp", "-i", "eth0"},
+			globalOptions{writePcap: "a.pcap", pcapNano: true},
+			true,
+		},
+		{
+			[]string{"--write-pcap=a.pcapng", "--pcap-comment=test", "listen"},
+			[]string{"listen"},
+			globalOptions{writePcap: "a.pcapng", pcapComment: "test"},
+			true,
+		},
+		// options after the command name belong to the command
+		{
+			[]string{"replay", "--pcap-nano", "--write-pcap", "b.pcap"},
+			[]string{"replay", "--pcap-nano", "--write-pcap", "b.pcap"},
+			globalOptions{},
+			true,
+		},
+		{[]string{"--pcap-nano", "--", "--write-pcap"}, []string{"--write-pcap"}, globalOptions{pcapNano: true}, true},
+		{[]string{}, nil, globalOptions{}, true},
+		{[]string{"--write-pcap"}, nil, globalOptions{}, false},
+	}
+
+	for _, tt := range tests {
+		rest, opts, err := parseGlobalOptions(tt.in)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("parseGlobalOptions(%q) should return error\n", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGlobalOptions(%q) returns error: %v\n", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(rest, tt.rest) || *opts != tt.opts {
+			t.Errorf("parseGlobalOptions(%q) = %q, %+v, but got %q, %+v\n", tt.in, tt.rest, tt.opts, rest, *opts)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package bpf
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Assemble assembles the source of a raw BPF program.
+//
+// The source is one instruction per line like "ldh [12]" or "jeq #0x800, ip, drop".
+// Lines may start with a label like "drop:" and jump targets are labels or
+// absolute instruction numbers, so the output of "tcpdump -d" and Program.String
+// can be assembled too. jne, jlt and jle are accepted and converted by swapping targets.
+// Comments start with ";" or "//".
+// The numeric output of "tcpdump -dd" and "tcpdump -ddd" is also accepted.
+func Assemble(src string) (Program, error) {
+	lines := make([]string, 0, 32)
+	numbers := make([]int, 0, 32) // line number of each element of lines
+	for i, line := range strings.Split(src, "\n") {
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+		if idx := strings.Index(line, ";"); idx >= 0 {
+			line = line[:idx]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+			numbers = append(numbers, i+1)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("empty program")
+	}
+	if isNumeric(lines[0]) {
+		return assembleNumeric(lines, numbers)
+	}
+
+	type pending struct {
+		jt, jf string // jump targets to be resolved
+		line   int
+	}
+	prog := make(Program, 0, len(lines))
+	targets := make([]pending, 0, len(lines))
+	labels := map[string]int{}
+	for i, line := range lines {
+		// prefix of "tcpdump -d" output
+		if strings.HasPrefix(line, "(") {
+			if idx := strings.Index(line, ")"); idx > 0 {
+				if _, err := strconv.Atoi(line[1:idx]); err == nil {
+					line = strings.TrimSpace(line[idx+1:])
+				}
+			}
+		}
+		if fields := strings.Fields(line); len(fields) > 0 && strings.HasSuffix(fields[0], ":") {
+			label := strings.TrimSuffix(fields[0], ":")
+			if _, dup := labels[label]; dup {
+				return nil, errors.Errorf("line %d: duplicated label '%s'", numbers[i], label)
+			}
+			labels[label] = len(prog)
+			line = strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+			if line == "" {
+				continue
+			}
+		}
+		ins, jt, jf, err := assembleLine(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", numbers[i])
+		}
+		prog = append(prog, ins)
+		targets = append(targets, pending{jt: jt, jf: jf, line: numbers[i]})
+	}
+
+	resolve := func(target string, pc, line int) (uint32, error) {
+		dst, ok := labels[target]
+		if !ok {
+			n, err := strconv.Atoi(target)
+			if err != nil {
+				return 0, errors.Errorf("line %d: unknown label '%s'", line, target)
+			}
+			dst = n
+		}
+		if dst <= pc || dst >= len(prog) {
+			return 0, errors.Errorf("line %d: jump target '%s' must be after the instruction", line, target)
+		}
+		return uint32(dst - pc - 1), nil
+	}
+	for pc, t := range targets {
+		if t.jt == "" {
+			continue
+		}
+		ins := &prog[pc]
+		jt, err := resolve(t.jt, pc, t.line)
+		if err != nil {
+			return nil, err
+		}
+		if ins.Code&0xf0 == jmpJA {
+			ins.K = jt
+			continue
+		}
+		jf := uint32(0) // fall through
+		if t.jf != "" {
+			if jf, err = resolve(t.jf, pc, t.line); err != nil {
+				return nil, err
+			}
+		}
+		if jt > 0xff || jf > 0xff {
+			return nil, errors.Errorf("line %d: conditional jump is too far", t.line)
+		}
+		ins.Jt, ins.Jf = uint8(jt), uint8(jf)
+	}
+	if err := prog.Validate(); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// assembleLine assembles a line without label and returns the instruction and its jump targets.
+func assembleLine(line string) (unix.SockFilter, string, string, error) {
+	fields := strings.Fields(line)
+	op := strings.ToLower(fields[0])
+	arg := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	var ins unix.SockFilter
+
+	switch op {
+	case "ld", "ldh", "ldb":
+		size := map[string]uint16{"ld": sizeW, "ldh": sizeH, "ldb": sizeB}[op]
+		switch {
+		case op == "ld" && (arg == "#len" || arg == "#pktlen" || arg == "len"):
+			ins.Code = classLD | sizeW | modeLEN
+			return ins, "", "", nil
+		case op == "ld" && strings.HasPrefix(arg, "#"):
+			k, err := parseK(arg[1:])
+			ins.Code, ins.K = classLD|sizeW|modeIMM, k
+			return ins, "", "", err
+		case op == "ld" && strings.HasPrefix(arg, "M["):
+			k, err := parseMem(arg)
+			ins.Code, ins.K = classLD|sizeW|modeMEM, k
+			return ins, "", "", err
+		case strings.HasPrefix(arg, "[") && strings.HasSuffix(arg, "]"):
+			inner := strings.Replace(arg[1:len(arg)-1], " ", "", -1)
+			mode := uint16(modeABS)
+			if strings.HasPrefix(inner, "x+") {
+				mode, inner = modeIND, inner[2:]
+			} else if inner == "x" {
+				mode, inner = modeIND, "0"
+			}
+			k, err := parseK(inner)
+			ins.Code, ins.K = classLD|size|mode, k
+			return ins, "", "", err
+		}
+	case "ldx", "ldxb":
+		compact := strings.Replace(arg, " ", "", -1)
+		switch {
+		case strings.HasPrefix(compact, "4*([") && strings.HasSuffix(compact, "]&0xf)"):
+			k, err := parseK(strings.TrimSuffix(strings.TrimPrefix(compact, "4*(["), "]&0xf)"))
+			ins.Code, ins.K = classLDX|sizeB|modeMSH, k
+			return ins, "", "", err
+		case op == "ldxb":
+		case arg == "#len" || arg == "#pktlen" || arg == "len":
+			ins.Code = classLDX | sizeW | modeLEN
+			return ins, "", "", nil
+		case strings.HasPrefix(arg, "#"):
+			k, err := parseK(arg[1:])
+			ins.Code, ins.K = classLDX|sizeW|modeIMM, k
+			return ins, "", "", err
+		case strings.HasPrefix(arg, "M["):
+			k, err := parseMem(arg)
+			ins.Code, ins.K = classLDX|sizeW|modeMEM, k
+			return ins, "", "", err
+		}
+	case "st", "stx":
+		k, err := parseMem(arg)
+		ins.Code, ins.K = classST, k
+		if op == "stx" {
+			ins.Code = classSTX
+		}
+		return ins, "", "", err
+	case "tax":
+		ins.Code = classMISC | miscTAX
+		return ins, "", "", nil
+	case "txa":
+		ins.Code = classMISC | miscTXA
+		return ins, "", "", nil
+	case "ret":
+		switch strings.ToLower(strings.TrimPrefix(arg, "%")) {
+		case "a":
+			ins.Code = classRET | retA
+			return ins, "", "", nil
+		case "x":
+			ins.Code = classRET | retX
+			return ins, "", "", nil
+		}
+		k, err := parseK(strings.TrimPrefix(arg, "#"))
+		ins.Code, ins.K = classRET|retK, k
+		return ins, "", "", err
+	case "ja", "jmp":
+		if arg == "" {
+			break
+		}
+		ins.Code = classJMP | jmpJA
+		return ins, arg, "", nil
+	case "jeq", "jne", "jgt", "jge", "jlt", "jle", "jset":
+		return assembleJump(op, arg)
+	default:
+		for code, name := range aluNames {
+			if name != op {
+				continue
+			}
+			ins.Code = classALU | code
+			if code == aluNEG {
+				return ins, "", "", nil
+			}
+			if strings.TrimPrefix(arg, "%") == "x" {
+				ins.Code |= srcX
+				return ins, "", "", nil
+			}
+			if !strings.HasPrefix(arg, "#") {
+				break
+			}
+			k, err := parseK(arg[1:])
+			ins.K = k
+			return ins, "", "", err
+		}
+	}
+	return ins, "", "", errors.Errorf("invalid instruction '%s'", line)
+}
+
+// assembleJump assembles conditional jumps. Targets are given as
+// "#k, Ltrue, Lfalse", "#k, Ltrue" or "#k jt 3 jf 5".
+func assembleJump(op, arg string) (unix.SockFilter, string, string, error) {
+	var ins unix.SockFilter
+	fields := strings.Fields(strings.Replace(arg, ",", " ", -1))
+	if len(fields) < 2 {
+		return ins, "", "", errors.Errorf("too few operands of %s", op)
+	}
+	operand, rest := fields[0], fields[1:]
+	var jt, jf string
+	switch {
+	case len(rest) == 4 && rest[0] == "jt" && rest[2] == "jf":
+		jt, jf = rest[1], rest[3]
+	case len(rest) == 1:
+		jt = rest[0]
+	case len(rest) == 2:
+		jt, jf = rest[0], rest[1]
+	default:
+		return ins, "", "", errors.Errorf("invalid jump targets '%s'", arg)
+	}
+
+	switch op {
+	case "jeq", "jne":
+		ins.Code = classJMP | jmpJEQ
+	case "jgt", "jle":
+		ins.Code = classJMP | jmpJGT
+	case "jge", "jlt":
+		ins.Code = classJMP | jmpJGE
+	case "jset":
+		ins.Code = classJMP | jmpJSET
+	}
+	if op == "jne" || op == "jle" || op == "jlt" {
+		if jf == "" {
+			return ins, "", "", errors.Errorf("%s requires both targets", op)
+		}
+		jt, jf = jf, jt
+	}
+
+	if strings.TrimPrefix(operand, "%") == "x" {
+		ins.Code |= srcX
+		return ins, jt, jf, nil
+	}
+	if !strings.HasPrefix(operand, "#") {
+		return ins, "", "", errors.Errorf("invalid operand '%s'", operand)
+	}
+	k, err := parseK(operand[1:])
+	ins.K = k
+	return ins, jt, jf, err
+}
+
+func parseK(s string) (uint32, error) {
+	k, err := strconv.ParseUint(strings.TrimSpace(s), 0, 32)
+	if err != nil {
+		// negative values such as ancillary offsets
+		v, err := strconv.ParseInt(strings.TrimSpace(s), 0, 32)
+		if err != nil {
+			return 0, errors.Errorf("invalid number '%s'", s)
+		}
+		return uint32(v), nil
+	}
+	return uint32(k), nil
+}
+
+func parseMem(s string) (uint32, error) {
+	if !strings.HasPrefix(s, "M[") || !strings.HasSuffix(s, "]") {
+		return 0, errors.Errorf("invalid memory slot '%s'", s)
+	}
+	k, err := parseK(s[2 : len(s)-1])
+	if err != nil || k >= MemWords {
+		return 0, errors.Errorf("invalid memory slot '%s'", s)
+	}
+	return k, nil
+}
+
+// numericFields splits the line of "tcpdump -dd" or "tcpdump -ddd" output into numbers.
+func numericFields(line string) ([]uint32, bool) {
+	line = strings.NewReplacer("{", " ", "}", " ", ",", " ").Replace(line)
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	values := make([]uint32, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 0, 32)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = uint32(v)
+	}
+	return values, true
+}
+
+func isNumeric(line string) bool {
+	_, ok := numericFields(line)
+	return ok
+}
+
+// assembleNumeric assembles lines of "code jt jf k". The first line may be the number of instructions.
+func assembleNumeric(lines []string, numbers []int) (Program, error) {
+	prog := make(Program, 0, len(lines))
+	for i, line := range lines {
+		values, ok := numericFields(line)
+		if i == 0 && ok && len(values) == 1 {
+			if int(values[0]) != len(lines)-1 {
+				return nil, errors.Errorf("line %d: program has %d instructions, but got %d", numbers[i], values[0], len(lines)-1)
+			}
+			continue
+		}
+		if !ok || len(values) != 4 || values[0] > 0xffff || values[1] > 0xff || values[2] > 0xff {
+			return nil, errors.Errorf("line %d: invalid instruction '%s'", numbers[i], line)
+		}
+		prog = append(prog, unix.SockFilter{Code: uint16(values[0]), Jt: uint8(values[1]), Jf: uint8(values[2]), K: values[3]})
+	}
+	if err := prog.Validate(); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
@@ -0,0 +1,194 @@
+package bpf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+)
+
+func TestCompile(t *testing.T) {
+	arpFrame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06,
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00,
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x02,
+	}
+	// IPv4 frames have 4 bytes of options, so the transport header is not at the fixed offset
+	tcpFrame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x46, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00, 0x40, 0x06, 0xad, 0x20, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x01, 0x01, 0x01, 0x00, 0x9c, 0x40, 0x00, 0x50, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x50, 0x02, 0x00, 0x00, 0x48, 0xa7, 0x00, 0x00,
+	}
+	udpFrame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x46, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x40, 0x11, 0xad, 0x21, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x01, 0x01, 0x01, 0x00, 0x14, 0xe9, 0x00, 0x35, 0x00, 0x08, 0x20, 0x15,
+	}
+	fragment := []byte{ // fragment offset 800
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x46, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x64, 0x40, 0x11, 0xac, 0xbd, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x01, 0x01, 0x01, 0x00, 0x14, 0xe9, 0x00, 0x35, 0x00, 0x08, 0x20, 0x15,
+	}
+	icmpFrame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x46, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x40, 0x01, 0xad, 0x31, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x01, 0x01, 0x01, 0x00, 0x08, 0x00, 0xf7, 0xfe, 0x00, 0x01, 0x00, 0x00,
+	}
+	tagged := append(append(append([]byte{}, arpFrame[:12]...), 0x81, 0x00, 0x00, 0x0a), arpFrame[12:]...)
+
+	tests := []struct {
+		expr  string
+		match [][]byte
+		miss  [][]byte
+	}{
+		{"", [][]byte{arpFrame, tcpFrame}, nil},
+		{"arp", [][]byte{arpFrame}, [][]byte{tcpFrame}},
+		{"ip and not arp", [][]byte{tcpFrame, udpFrame}, [][]byte{arpFrame}},
+		{"ether src 02:00:00:00:00:01", [][]byte{arpFrame}, nil},
+		{"ether dst 02:00:00:00:00:01", nil, [][]byte{arpFrame}},
+		{"ether broadcast && ether proto arp", [][]byte{arpFrame}, [][]byte{tcpFrame}},
+		{"host 192.168.0.2", [][]byte{arpFrame, tcpFrame}, nil},
+		{"ip host 192.168.0.2", [][]byte{tcpFrame}, [][]byte{arpFrame}},
+		{"src host 192.168.0.2", nil, [][]byte{arpFrame, tcpFrame}},
+		{"arp dst host 192.168.0.2", [][]byte{arpFrame}, [][]byte{tcpFrame}},
+		{"src net 10.0.0.0/8", [][]byte{tcpFrame}, [][]byte{arpFrame}},
+		{"dst net 10.0.0.0/8", nil, [][]byte{tcpFrame}},
+		{"tcp", [][]byte{tcpFrame}, [][]byte{udpFrame, icmpFrame}},
+		{"proto udp", [][]byte{udpFrame, fragment}, [][]byte{tcpFrame}},
+		{"port 53", [][]byte{udpFrame}, [][]byte{tcpFrame, fragment, arpFrame}},
+		{"tcp dst port 80", [][]byte{tcpFrame}, [][]byte{udpFrame}},
+		{"src port 80", nil, [][]byte{tcpFrame}},
+		{"udp port 80 or (tcp and port 80)", [][]byte{tcpFrame}, [][]byte{udpFrame}},
+		{"icmp type icmp-echo", [][]byte{icmpFrame}, [][]byte{tcpFrame}},
+		{"icmp type 0", nil, [][]byte{icmpFrame}},
+		{"greater 50", [][]byte{tcpFrame}, [][]byte{arpFrame}},
+		{"less 42", [][]byte{arpFrame}, [][]byte{tcpFrame}},
+		{"vlan 10", [][]byte{tagged}, [][]byte{arpFrame}},
+		{"vlan", [][]byte{tagged}, [][]byte{tcpFrame}},
+		{"!vlan 11", [][]byte{tagged, arpFrame}, nil},
+	}
+	for _, tt := range tests {
+		prog, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q) returns error: %v\n", tt.expr, err)
+			continue
+		}
+		for _, f := range tt.match {
+			if prog.Run(f) != AcceptLen {
+				t.Errorf("%q should match %x\n%s", tt.expr, f, prog)
+			}
+		}
+		for _, f := range tt.miss {
+			if prog.Run(f) != 0 {
+				t.Errorf("%q should not match %x\n%s", tt.expr, f, prog)
+			}
+		}
+	}
+}
+
+var compileErrorTests = []string{
+	"foo",
+	"host",
+	"host 2001:db8::1",
+	"net 10.0.0.0/33",
+	"ether host 10.0.0.1",
+	"port 65536",
+	"arp port 80",
+	"(tcp",
+	"tcp)",
+	"tcp and",
+	"icmp type echo",
+}
+
+func TestCompileError(t *testing.T) {
+	for _, expr := range compileErrorTests {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Compile(%q) should return error\n", expr)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	want := Program{
+		{Code: classLD | sizeH | modeABS, K: 12},
+		{Code: classJMP | jmpJEQ, Jt: 0, Jf: 4, K: ethernet.TypeIPv4},
+		{Code: classLDX | sizeB | modeMSH, K: 14},
+		{Code: classLD | sizeH | modeIND, K: 16},
+		{Code: classJMP | jmpJGE, Jt: 1, Jf: 0, K: 1024},
+		{Code: classRET | retK, K: AcceptLen},
+		{Code: classRET | retK, K: 0},
+	}
+	srcs := []string{
+		`
+		; IPv4 to well-known ports
+		        ldh [12]
+		        jne #0x800, drop, ip
+		ip:     ldxb 4*([14]&0xf)
+		        ldh [x + 16]
+		        jlt #1024, accept, drop  // reversed
+		accept: ret #262144
+		drop:   ret #0
+		`,
+		"7\n40 0 0 12\n21 0 4 2048\n177 0 0 14\n72 0 0 16\n53 1 0 1024\n6 0 0 262144\n6 0 0 0\n",
+		"{ 0x28, 0, 0, 0x0000000c },\n{ 0x15, 0, 4, 0x00000800 },\n{ 0xb1, 0, 0, 0x0000000e },\n" +
+			"{ 0x48, 0, 0, 0x00000010 },\n{ 0x35, 1, 0, 0x00000400 },\n{ 0x6, 0, 0, 0x00040000 },\n{ 0x6, 0, 0, 0x00000000 },\n",
+		want.String(),
+	}
+	for _, src := range srcs {
+		prog, err := Assemble(src)
+		if err != nil {
+			t.Errorf("Assemble() returns error: %v\n%s", err, src)
+			continue
+		}
+		if !reflect.DeepEqual(prog, want) {
+			t.Errorf("Assemble() = \n%s, but got \n%s", want, prog)
+		}
+	}
+
+	// every instruction survives disassembly
+	all, err := Compile("vlan 1 or tcp port 80 or icmp type 3 or less 100 or net 10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Compile() returns error: %v\n", err)
+	}
+	all = append(Program{
+		{Code: classLD | modeIMM, K: 1}, {Code: classST, K: 1}, {Code: classLDX | modeMEM, K: 1},
+		{Code: classALU | aluADD | srcX}, {Code: classALU | aluNEG}, {Code: classMISC | miscTAX},
+		{Code: classJMP | jmpJA, K: 0}, {Code: classMISC | miscTXA}, {Code: classSTX, K: 2},
+	}, all...)
+	if prog, err := Assemble(all.String()); err != nil || !reflect.DeepEqual(prog, all) {
+		t.Errorf("Assemble(String()) = \n%s, but got \n%s (%v)", all, prog, err)
+	}
+}
+
+var assembleErrorTests = []string{
+	"",
+	"ldh [12]",                       // no ret
+	"ja back\nback: ret #0\nja back", // backward jump
+	"jeq #1, none\nret #0",
+	"foo #1\nret #0",
+	"ld M[16]\nret #0",
+	"div #0\nret a",
+	"2\n6 0 0 0\n",
+}
+
+func TestAssembleError(t *testing.T) {
+	for _, src := range assembleErrorTests {
+		if _, err := Assemble(src); err == nil {
+			t.Errorf("Assemble(%q) should return error\n", src)
+		}
+	}
+}
+
+func TestRunOutOfFrame(t *testing.T) {
+	prog := Program{
+		{Code: classLD | sizeW | modeABS, K: 100},
+		{Code: classRET | retK, K: 1},
+	}
+	if prog.Run(make([]byte, 60)) != 0 {
+		t.Errorf("load out of frame should reject it\n")
+	}
+	if prog.Run(make([]byte, 104)) != 1 {
+		t.Errorf("load in frame should not reject it\n")
+	}
+}
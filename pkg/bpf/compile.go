@@ -0,0 +1,517 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Compile compiles a tcpdump-like filter expression into a program
+// which returns AcceptLen for matching frames and zero for others.
+//
+// Supported primitives are "ether host|src|dst MAC", "ether proto N|ip|arp|vlan",
+// "ether broadcast", "vlan [ID]", "ip", "arp", "icmp", "tcp", "udp",
+// "[ip|arp] [src|dst] host ADDR", "[src|dst] net PREFIX", "[ip] proto N|icmp|tcp|udp",
+// "[tcp|udp] [src|dst] port N", "icmp type N|NAME", "greater N" and "less N".
+// Primitives are combined with and (&&), or (||), not (!) and parentheses.
+// Fields after the VLAN tag are loaded without offset, since the kernel
+// removes tags from received frames.
+func Compile(expr string) (Program, error) {
+	p := &exprParser{words: tokenizeExpr(expr)}
+	if len(p.words) == 0 { // empty expression accepts everything
+		return Program{{Code: classRET | retK, K: AcceptLen}}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if w := p.peek(); w != "" {
+		return nil, errors.Errorf("unexpected '%s'", w)
+	}
+	return generate(root)
+}
+
+// node of the expression. It is one of andNode, orNode, notNode and test.
+type node interface{}
+
+type andNode struct{ left, right node }
+
+type orNode struct{ left, right node }
+
+type notNode struct{ n node }
+
+// test loads a value into A with load and compares it with k by jmp.
+type test struct {
+	load []unix.SockFilter
+	jmp  uint16
+	k    uint32
+}
+
+func tokenizeExpr(expr string) []string {
+	r := strings.NewReplacer("(", " ( ", ")", " ) ", "&&", " and ", "||", " or ", "!", " not ")
+	return strings.Fields(r.Replace(expr))
+}
+
+type exprParser struct {
+	words []string
+	pos   int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.words) {
+		return ""
+	}
+	return p.words[p.pos]
+}
+
+func (p *exprParser) next() string {
+	w := p.peek()
+	if w != "" {
+		p.pos++
+	}
+	return w
+}
+
+func (p *exprParser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (node, error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	case "(":
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if w := p.next(); w != ")" {
+			return nil, errors.Errorf("expected ')' but got '%s'", w)
+		}
+		return n, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *exprParser) parsePrimitive() (node, error) {
+	w := p.next()
+	switch w {
+	case "":
+		return nil, errors.New("unexpected end of expression")
+	case "ether":
+		return p.parseEther()
+	case "vlan":
+		if id, ok := p.number(); ok {
+			if id > vlanIDMask {
+				return nil, errors.Errorf("invalid VLAN ID %d", id)
+			}
+			return vlan(&id), nil
+		}
+		return vlan(nil), nil
+	case "broadcast":
+		return etherHost(etherDstOff, ethernet.Broadcast), nil
+	case "greater":
+		n, ok := p.number()
+		if !ok {
+			return nil, errors.New("greater requires length")
+		}
+		return &test{load: ldLen(), jmp: jmpJGE, k: n}, nil
+	case "less":
+		n, ok := p.number()
+		if !ok {
+			return nil, errors.New("less requires length")
+		}
+		return &notNode{&test{load: ldLen(), jmp: jmpJGT, k: n}}, nil
+	case "ip", "arp":
+		switch p.peek() {
+		case "host", "src", "dst":
+			return p.parseHost(w)
+		case "proto":
+			if w == "ip" {
+				p.next()
+				return p.parseIPProto()
+			}
+		}
+		if w == "ip" {
+			return etherType(ethernet.TypeIPv4), nil
+		}
+		return etherType(ethernet.TypeARP), nil
+	case "icmp":
+		if p.peek() == "type" {
+			p.next()
+			t, err := p.value(icmpTypes)
+			if err != nil {
+				return nil, err
+			}
+			return icmpType(t), nil
+		}
+		return ipProto(ipv4.ProtoICMP), nil
+	case "tcp", "udp":
+		switch p.peek() {
+		case "port", "src", "dst":
+			return p.parsePort(w)
+		}
+		return ipProto(map[string]uint32{"tcp": ipv4.ProtoTCP, "udp": ipv4.ProtoUDP}[w]), nil
+	case "proto":
+		return p.parseIPProto()
+	case "host", "net", "port", "src", "dst":
+		p.pos--
+		return p.parseHost("")
+	}
+	return nil, errors.Errorf("unknown primitive '%s'", w)
+}
+
+// parseHost parses [src|dst] host|net|port and address. proto is "ip", "arp" or empty for both.
+func (p *exprParser) parseHost(proto string) (node, error) {
+	dir := ""
+	if w := p.peek(); w == "src" || w == "dst" {
+		dir = p.next()
+	}
+	kind := "host"
+	switch p.peek() {
+	case "host", "net":
+		kind = p.next()
+	case "port":
+		if proto != "" {
+			return nil, errors.Errorf("port cannot be used with %s", proto)
+		}
+		if dir != "" { // parse "src port N" again
+			p.pos--
+		}
+		return p.parsePort("")
+	}
+
+	w := p.next()
+	if kind == "net" || strings.Contains(w, "/") {
+		if proto == "arp" {
+			return nil, errors.New("net cannot be used with arp")
+		}
+		_, prefix, err := net.ParseCIDR(w)
+		if err != nil || prefix.IP.To4() == nil {
+			return nil, errors.Errorf("invalid IPv4 prefix '%s'", w)
+		}
+		return ipNet(dir, prefix), nil
+	}
+	ip := net.ParseIP(w).To4()
+	if ip == nil {
+		return nil, errors.Errorf("invalid IPv4 address '%s'", w)
+	}
+	addr := binary.BigEndian.Uint32(ip)
+	switch proto {
+	case "ip":
+		return ipHost(dir, addr), nil
+	case "arp":
+		return arpHost(dir, addr), nil
+	}
+	return &orNode{ipHost(dir, addr), arpHost(dir, addr)}, nil
+}
+
+// parsePort parses [src|dst] port N. proto is "tcp", "udp" or empty for both.
+func (p *exprParser) parsePort(proto string) (node, error) {
+	dir := ""
+	if w := p.peek(); w == "src" || w == "dst" {
+		dir = p.next()
+	}
+	if w := p.next(); w != "port" {
+		return nil, errors.Errorf("expected port but got '%s'", w)
+	}
+	port, ok := p.number()
+	if !ok || port > 0xffff {
+		return nil, errors.New("port requires port number")
+	}
+	var protoTest node
+	switch proto {
+	case "tcp":
+		protoTest = ipProto(ipv4.ProtoTCP)
+	case "udp":
+		protoTest = ipProto(ipv4.ProtoUDP)
+	default:
+		protoTest = &orNode{ipProto(ipv4.ProtoTCP), ipProto(ipv4.ProtoUDP)}
+	}
+	src := transport(sizeH, transportSrcOff, jmpJEQ, port)
+	dst := transport(sizeH, transportDstOff, jmpJEQ, port)
+	var portTest node = &orNode{src, dst}
+	switch dir {
+	case "src":
+		portTest = src
+	case "dst":
+		portTest = dst
+	}
+	return &andNode{&andNode{protoTest, notFragment()}, portTest}, nil
+}
+
+func (p *exprParser) parseIPProto() (node, error) {
+	proto, err := p.value(map[string]uint32{"icmp": ipv4.ProtoICMP, "tcp": ipv4.ProtoTCP, "udp": ipv4.ProtoUDP})
+	if err != nil {
+		return nil, err
+	}
+	if proto > 0xff {
+		return nil, errors.Errorf("invalid protocol number %d", proto)
+	}
+	return ipProto(proto), nil
+}
+
+func (p *exprParser) parseEther() (node, error) {
+	switch w := p.next(); w {
+	case "host", "src", "dst":
+		if w != "host" && p.peek() == "host" {
+			p.next()
+		}
+		s := p.next()
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != ethernet.EtherLen {
+			return nil, errors.Errorf("invalid MAC address '%s'", s)
+		}
+		switch w {
+		case "src":
+			return etherHost(etherSrcOff, mac), nil
+		case "dst":
+			return etherHost(etherDstOff, mac), nil
+		}
+		return &orNode{etherHost(etherSrcOff, mac), etherHost(etherDstOff, mac)}, nil
+	case "proto":
+		t, err := p.value(map[string]uint32{"ip": ethernet.TypeIPv4, "arp": ethernet.TypeARP, "vlan": ethernet.TypeVLAN})
+		if err != nil {
+			return nil, err
+		}
+		return etherType(t), nil
+	case "broadcast":
+		return etherHost(etherDstOff, ethernet.Broadcast), nil
+	default:
+		return nil, errors.Errorf("unknown ether qualifier '%s'", w)
+	}
+}
+
+// number consumes the next word if it is a number.
+func (p *exprParser) number() (uint32, bool) {
+	v, err := strconv.ParseUint(p.peek(), 0, 32)
+	if err != nil {
+		return 0, false
+	}
+	p.next()
+	return uint32(v), true
+}
+
+// value consumes a number or a name in names.
+func (p *exprParser) value(names map[string]uint32) (uint32, error) {
+	if v, ok := p.number(); ok {
+		return v, nil
+	}
+	w := p.next()
+	if v, ok := names[w]; ok {
+		return v, nil
+	}
+	return 0, errors.Errorf("unknown value '%s'", w)
+}
+
+var icmpTypes = map[string]uint32{
+	"icmp-echoreply":    icmp.TypeEchoReply,
+	"icmp-unreach":      icmp.TypeDestinationUnreachable,
+	"icmp-sourcequench": icmp.TypeSourceQuench,
+	"icmp-redirect":     icmp.TypeRedirect,
+	"icmp-echo":         icmp.TypeEcho,
+	"icmp-timxceed":     icmp.TypeTimeExceeded,
+	"icmp-paramprob":    icmp.TypeParameterProblem,
+}
+
+func ld(size uint16, off uint32) []unix.SockFilter {
+	return []unix.SockFilter{{Code: classLD | size | modeABS, K: off}}
+}
+
+func ldLen() []unix.SockFilter {
+	return []unix.SockFilter{{Code: classLD | sizeW | modeLEN}}
+}
+
+// masked appends AND of mask to load.
+func masked(load []unix.SockFilter, mask uint32) []unix.SockFilter {
+	return append(load, unix.SockFilter{Code: classALU | aluAND | srcK, K: mask})
+}
+
+func etherType(t uint32) node {
+	return &test{load: ld(sizeH, etherTypeOff), jmp: jmpJEQ, k: t}
+}
+
+func etherHost(off uint32, mac net.HardwareAddr) node {
+	return &andNode{
+		&test{load: ld(sizeW, off+2), jmp: jmpJEQ, k: binary.BigEndian.Uint32(mac[2:])},
+		&test{load: ld(sizeH, off), jmp: jmpJEQ, k: uint32(binary.BigEndian.Uint16(mac))},
+	}
+}
+
+// vlan matches frames with VLAN tag. The tag is looked up in the ancillary data and in the frame.
+func vlan(id *uint32) node {
+	if id == nil {
+		return &orNode{
+			&test{load: ld(sizeW, adVLANTagPresent), jmp: jmpJEQ, k: 1},
+			etherType(ethernet.TypeVLAN),
+		}
+	}
+	return &orNode{
+		&andNode{
+			&test{load: ld(sizeW, adVLANTagPresent), jmp: jmpJEQ, k: 1},
+			&test{load: masked(ld(sizeW, adVLANTag), vlanIDMask), jmp: jmpJEQ, k: *id},
+		},
+		&andNode{
+			etherType(ethernet.TypeVLAN),
+			&test{load: masked(ld(sizeH, vlanTCIOff), vlanIDMask), jmp: jmpJEQ, k: *id},
+		},
+	}
+}
+
+func ipProto(proto uint32) node {
+	return &andNode{etherType(ethernet.TypeIPv4), &test{load: ld(sizeB, ipv4ProtocolOff), jmp: jmpJEQ, k: proto}}
+}
+
+func ipHost(dir string, addr uint32) node {
+	src := &test{load: ld(sizeW, ipv4SrcOff), jmp: jmpJEQ, k: addr}
+	dst := &test{load: ld(sizeW, ipv4DstOff), jmp: jmpJEQ, k: addr}
+	return &andNode{etherType(ethernet.TypeIPv4), either(dir, src, dst)}
+}
+
+func arpHost(dir string, addr uint32) node {
+	src := &test{load: ld(sizeW, arpSrcPAddrOff), jmp: jmpJEQ, k: addr}
+	dst := &test{load: ld(sizeW, arpDstPAddrOff), jmp: jmpJEQ, k: addr}
+	return &andNode{etherType(ethernet.TypeARP), either(dir, src, dst)}
+}
+
+func ipNet(dir string, prefix *net.IPNet) node {
+	mask := binary.BigEndian.Uint32(prefix.Mask)
+	addr := binary.BigEndian.Uint32(prefix.IP.To4())
+	src := &test{load: masked(ld(sizeW, ipv4SrcOff), mask), jmp: jmpJEQ, k: addr}
+	dst := &test{load: masked(ld(sizeW, ipv4DstOff), mask), jmp: jmpJEQ, k: addr}
+	return &andNode{etherType(ethernet.TypeIPv4), either(dir, src, dst)}
+}
+
+func either(dir string, src, dst node) node {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	}
+	return &orNode{src, dst}
+}
+
+// notFragment matches the first fragment or non-fragmented packets, which have transport headers.
+func notFragment() node {
+	return &notNode{&test{load: ld(sizeH, ipv4FlagsOff), jmp: jmpJSET, k: ipv4FragmentMask}}
+}
+
+// transport tests the field at off from the end of IPv4 header.
+func transport(size uint16, off uint32, jmp uint16, k uint32) node {
+	return &test{
+		load: []unix.SockFilter{
+			{Code: classLDX | sizeB | modeMSH, K: ipv4HeaderOff},
+			{Code: classLD | size | modeIND, K: ipv4HeaderOff + off},
+		},
+		jmp: jmp,
+		k:   k,
+	}
+}
+
+func icmpType(t uint32) node {
+	return &andNode{&andNode{ipProto(ipv4.ProtoICMP), notFragment()}, transport(sizeB, icmpTypeOff, jmpJEQ, t)}
+}
+
+// generator emits instructions whose jump targets are labels.
+type generator struct {
+	code   []unix.SockFilter
+	jumps  map[int][2]int // index of jump instruction to labels of true and false
+	labels []int          // position of each label
+}
+
+func (g *generator) newLabel() int {
+	g.labels = append(g.labels, -1)
+	return len(g.labels) - 1
+}
+
+func (g *generator) place(label int) {
+	g.labels[label] = len(g.code)
+}
+
+// emit emits n which jumps to label t when true and f when false.
+func (g *generator) emit(n node, t, f int) {
+	switch n := n.(type) {
+	case *andNode:
+		mid := g.newLabel()
+		g.emit(n.left, mid, f)
+		g.place(mid)
+		g.emit(n.right, t, f)
+	case *orNode:
+		mid := g.newLabel()
+		g.emit(n.left, t, mid)
+		g.place(mid)
+		g.emit(n.right, t, f)
+	case *notNode:
+		g.emit(n.n, f, t)
+	case *test:
+		g.code = append(g.code, n.load...)
+		g.jumps[len(g.code)] = [2]int{t, f}
+		g.code = append(g.code, unix.SockFilter{Code: classJMP | n.jmp | srcK, K: n.k})
+	}
+}
+
+func generate(root node) (Program, error) {
+	g := &generator{jumps: map[int][2]int{}}
+	accept, reject := g.newLabel(), g.newLabel()
+	g.emit(root, accept, reject)
+	g.place(accept)
+	g.code = append(g.code, unix.SockFilter{Code: classRET | retK, K: AcceptLen})
+	g.place(reject)
+	g.code = append(g.code, unix.SockFilter{Code: classRET | retK, K: 0})
+
+	for pc, labels := range g.jumps {
+		jt := g.labels[labels[0]] - pc - 1
+		jf := g.labels[labels[1]] - pc - 1
+		if jt > 0xff || jf > 0xff {
+			return nil, errors.New("expression is too complex")
+		}
+		g.code[pc].Jt, g.code[pc].Jf = uint8(jt), uint8(jf)
+	}
+	prog := Program(g.code)
+	if err := prog.Validate(); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
@@ -0,0 +1,87 @@
+package bpf
+
+const (
+	// AcceptLen is the value returned by compiled programs for matching frames.
+	// The whole frame is passed to the socket unless it is longer than this.
+	AcceptLen = 262144
+
+	// MaxInstructions is the maximum number of instructions the kernel accepts.
+	MaxInstructions = 4096
+	// MemWords is the number of scratch memory slots.
+	MemWords = 16
+)
+
+// fields of the opcode
+const (
+	classLD   = 0x00
+	classLDX  = 0x01
+	classST   = 0x02
+	classSTX  = 0x03
+	classALU  = 0x04
+	classJMP  = 0x05
+	classRET  = 0x06
+	classMISC = 0x07
+
+	sizeW = 0x00
+	sizeH = 0x08
+	sizeB = 0x10
+
+	modeIMM = 0x00
+	modeABS = 0x20
+	modeIND = 0x40
+	modeMEM = 0x60
+	modeLEN = 0x80
+	modeMSH = 0xa0
+
+	srcK = 0x00
+	srcX = 0x08
+
+	aluADD = 0x00
+	aluSUB = 0x10
+	aluMUL = 0x20
+	aluDIV = 0x30
+	aluOR  = 0x40
+	aluAND = 0x50
+	aluLSH = 0x60
+	aluRSH = 0x70
+	aluNEG = 0x80
+	aluMOD = 0x90
+	aluXOR = 0xa0
+
+	jmpJA   = 0x00
+	jmpJEQ  = 0x10
+	jmpJGT  = 0x20
+	jmpJGE  = 0x30
+	jmpJSET = 0x40
+
+	retK = 0x00
+	retX = 0x08
+	retA = 0x10
+
+	miscTAX = 0x00
+	miscTXA = 0x80
+)
+
+// Linux ancillary data which can be loaded with absolute loads.
+// VLAN tags are removed from received frames by the kernel, so they are only available here.
+const (
+	adOffset         = 0xfffff000 // -0x1000
+	adVLANTag        = adOffset + 44
+	adVLANTagPresent = adOffset + 48
+	vlanIDMask       = 0x0fff
+	ipv4FragmentMask = 0x1fff
+	ipv4ProtocolOff  = 23
+	ipv4SrcOff       = 26
+	ipv4DstOff       = 30
+	ipv4FlagsOff     = 20
+	ipv4HeaderOff    = 14
+	arpSrcPAddrOff   = 28
+	arpDstPAddrOff   = 38
+	etherTypeOff     = 12
+	vlanTCIOff       = 14
+	etherSrcOff      = 6
+	etherDstOff      = 0
+	icmpTypeOff      = 0 // relative to the end of IPv4 header
+	transportSrcOff  = 0 // relative to the end of IPv4 header
+	transportDstOff  = 2 // relative to the end of IPv4 header
+)
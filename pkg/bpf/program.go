@@ -0,0 +1,146 @@
+package bpf
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Program is a classic BPF program which can be attached to sockets.
+type Program []unix.SockFilter
+
+// Validate checks the program like the kernel does.
+// Jumps must go forward inside the program and the last instruction must be ret.
+func (p Program) Validate() error {
+	if len(p) == 0 || len(p) > MaxInstructions {
+		return errors.Errorf("program must have 1 to %d instructions, but has %d", MaxInstructions, len(p))
+	}
+	for i, ins := range p {
+		switch ins.Code & 0x07 {
+		case classLD, classLDX:
+			mode := ins.Code & 0xe0
+			if mode == modeMEM && ins.K >= MemWords {
+				return errors.Errorf("%d: invalid memory slot %d", i, ins.K)
+			}
+		case classST, classSTX:
+			if ins.K >= MemWords {
+				return errors.Errorf("%d: invalid memory slot %d", i, ins.K)
+			}
+		case classALU:
+			op := ins.Code & 0xf0
+			if (op == aluDIV || op == aluMOD) && ins.Code&srcX == srcK && ins.K == 0 {
+				return errors.Errorf("%d: division by zero", i)
+			}
+		case classJMP:
+			if ins.Code&0xf0 == jmpJA {
+				if uint64(i)+1+uint64(ins.K) >= uint64(len(p)) {
+					return errors.Errorf("%d: jump out of program", i)
+				}
+			} else if i+1+int(ins.Jt) >= len(p) || i+1+int(ins.Jf) >= len(p) {
+				return errors.Errorf("%d: jump out of program", i)
+			}
+		}
+	}
+	if p[len(p)-1].Code&0x07 != classRET {
+		return errors.New("program must end with ret")
+	}
+	return nil
+}
+
+// String returns the disassembly of the program like "tcpdump -d".
+// The result can be assembled again with Assemble.
+func (p Program) String() string {
+	var b bytes.Buffer
+	for i, ins := range p {
+		fmt.Fprintf(&b, "(%03d) %s\n", i, disassemble(ins, i))
+	}
+	return b.String()
+}
+
+func disassemble(ins unix.SockFilter, pc int) string {
+	code := ins.Code
+	switch code & 0x07 {
+	case classLD:
+		name := map[uint16]string{sizeW: "ld", sizeH: "ldh", sizeB: "ldb"}[code&0x18]
+		switch code & 0xe0 {
+		case modeIMM:
+			return fmt.Sprintf("%-8s #0x%x", "ld", ins.K)
+		case modeABS:
+			return fmt.Sprintf("%-8s [%d]", name, ins.K)
+		case modeIND:
+			return fmt.Sprintf("%-8s [x + %d]", name, ins.K)
+		case modeMEM:
+			return fmt.Sprintf("%-8s M[%d]", "ld", ins.K)
+		case modeLEN:
+			return fmt.Sprintf("%-8s #len", "ld")
+		}
+	case classLDX:
+		switch code & 0xe0 {
+		case modeIMM:
+			return fmt.Sprintf("%-8s #0x%x", "ldx", ins.K)
+		case modeMEM:
+			return fmt.Sprintf("%-8s M[%d]", "ldx", ins.K)
+		case modeLEN:
+			return fmt.Sprintf("%-8s #len", "ldx")
+		case modeMSH:
+			return fmt.Sprintf("%-8s 4*([%d]&0xf)", "ldxb", ins.K)
+		}
+	case classST:
+		return fmt.Sprintf("%-8s M[%d]", "st", ins.K)
+	case classSTX:
+		return fmt.Sprintf("%-8s M[%d]", "stx", ins.K)
+	case classALU:
+		name, ok := aluNames[code&0xf0]
+		if !ok {
+			break
+		}
+		if code&0xf0 == aluNEG {
+			return name
+		}
+		if code&srcX != 0 {
+			return fmt.Sprintf("%-8s x", name)
+		}
+		return fmt.Sprintf("%-8s #0x%x", name, ins.K)
+	case classJMP:
+		if code&0xf0 == jmpJA {
+			return fmt.Sprintf("%-8s %d", "ja", pc+1+int(ins.K))
+		}
+		name, ok := jmpNames[code&0xf0]
+		if !ok {
+			break
+		}
+		operand := fmt.Sprintf("#0x%x", ins.K)
+		if code&srcX != 0 {
+			operand = "x"
+		}
+		return fmt.Sprintf("%-8s %-16s jt %d\tjf %d", name, operand, pc+1+int(ins.Jt), pc+1+int(ins.Jf))
+	case classRET:
+		switch code & 0x18 {
+		case retK:
+			return fmt.Sprintf("%-8s #%d", "ret", ins.K)
+		case retX:
+			return fmt.Sprintf("%-8s x", "ret")
+		case retA:
+			return fmt.Sprintf("%-8s a", "ret")
+		}
+	case classMISC:
+		switch code & 0xf8 {
+		case miscTAX:
+			return "tax"
+		case miscTXA:
+			return "txa"
+		}
+	}
+	return fmt.Sprintf("unknown  0x%02x %d %d 0x%x", code, ins.Jt, ins.Jf, ins.K)
+}
+
+var aluNames = map[uint16]string{
+	aluADD: "add", aluSUB: "sub", aluMUL: "mul", aluDIV: "div", aluOR: "or", aluAND: "and",
+	aluLSH: "lsh", aluRSH: "rsh", aluNEG: "neg", aluMOD: "mod", aluXOR: "xor",
+}
+
+var jmpNames = map[uint16]string{
+	jmpJEQ: "jeq", jmpJGT: "jgt", jmpJGE: "jge", jmpJSET: "jset",
+}
@@ -0,0 +1,153 @@
+package bpf
+
+import "encoding/binary"
+
+// Run executes the program on frame in user space and returns the number of bytes to accept.
+// Zero means the frame is rejected. As in the kernel, loads out of the frame reject it.
+// Ancillary data is not available, so VLAN tags can be seen only in the frame.
+// p must be validated with Validate.
+func (p Program) Run(frame []byte) uint32 {
+	var a, x uint32
+	var mem [MemWords]uint32
+
+	load := func(off uint32, size uint16) (uint32, bool) {
+		n := uint32(1)
+		switch size {
+		case sizeW:
+			n = 4
+		case sizeH:
+			n = 2
+		}
+		if off >= adOffset {
+			return 0, true // ancillary data is treated as zero
+		}
+		if uint64(off)+uint64(n) > uint64(len(frame)) {
+			return 0, false
+		}
+		switch size {
+		case sizeW:
+			return binary.BigEndian.Uint32(frame[off:]), true
+		case sizeH:
+			return uint32(binary.BigEndian.Uint16(frame[off:])), true
+		}
+		return uint32(frame[off]), true
+	}
+
+	for pc := 0; pc < len(p); pc++ {
+		ins := p[pc]
+		code := ins.Code
+		switch code & 0x07 {
+		case classLD:
+			switch code & 0xe0 {
+			case modeIMM:
+				a = ins.K
+			case modeABS, modeIND:
+				off := ins.K
+				if code&0xe0 == modeIND {
+					off += x
+				}
+				v, ok := load(off, code&0x18)
+				if !ok {
+					return 0
+				}
+				a = v
+			case modeMEM:
+				a = mem[ins.K]
+			case modeLEN:
+				a = uint32(len(frame))
+			}
+		case classLDX:
+			switch code & 0xe0 {
+			case modeIMM:
+				x = ins.K
+			case modeMEM:
+				x = mem[ins.K]
+			case modeLEN:
+				x = uint32(len(frame))
+			case modeMSH:
+				v, ok := load(ins.K, sizeB)
+				if !ok {
+					return 0
+				}
+				x = 4 * (v & 0xf)
+			}
+		case classST:
+			mem[ins.K] = a
+		case classSTX:
+			mem[ins.K] = x
+		case classALU:
+			operand := ins.K
+			if code&srcX != 0 {
+				operand = x
+			}
+			switch code & 0xf0 {
+			case aluADD:
+				a += operand
+			case aluSUB:
+				a -= operand
+			case aluMUL:
+				a *= operand
+			case aluDIV, aluMOD:
+				if operand == 0 {
+					return 0
+				}
+				if code&0xf0 == aluDIV {
+					a /= operand
+				} else {
+					a %= operand
+				}
+			case aluOR:
+				a |= operand
+			case aluAND:
+				a &= operand
+			case aluLSH:
+				a <<= operand
+			case aluRSH:
+				a >>= operand
+			case aluNEG:
+				a = -a
+			case aluXOR:
+				a ^= operand
+			}
+		case classJMP:
+			operand := ins.K
+			if code&srcX != 0 {
+				operand = x
+			}
+			var cond bool
+			switch code & 0xf0 {
+			case jmpJA:
+				pc += int(ins.K)
+				continue
+			case jmpJEQ:
+				cond = a == operand
+			case jmpJGT:
+				cond = a > operand
+			case jmpJGE:
+				cond = a >= operand
+			case jmpJSET:
+				cond = a&operand != 0
+			}
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case classRET:
+			switch code & 0x18 {
+			case retA:
+				return a
+			case retX:
+				return x
+			}
+			return ins.K
+		case classMISC:
+			if code&0xf8 == miscTXA {
+				a = x
+			} else {
+				x = a
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,200 @@
+package capture
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Config is the configuration of Capture.
+type Config struct {
+	Interface   string
+	Promiscuous bool
+	SnapLen     int                  // maximum number of bytes captured from each frame. zero means DefaultSnapLen
+	BPF         []unix.SockFilter    // if set, frames are filtered by the kernel with this program
+	Ring        *ethernet.RingConfig // if set, frames are received through the memory-mapped ring
+}
+
+// Frame is a captured frame.
+type Frame struct {
+	Time     time.Time // timestamp taken by the kernel
+	Data     []byte    // VLAN tag stripped by the NIC is restored
+	Length   int       // original length of the frame
+	Outbound bool      // sent by this host
+	Type     ethernet.PacketType
+}
+
+// Truncated reports whether f is shorter than the original frame.
+func (f *Frame) Truncated() bool {
+	return len(f.Data) < f.Length
+}
+
+// Stats represents the counters of Capture.
+type Stats struct {
+	Captured  uint64
+	Truncated uint64 // frames longer than SnapLen
+	Dropped   uint64 // frames dropped by the kernel because Capture could not keep up
+}
+
+// Capture receives every frame on the interface.
+type Capture struct {
+	cfg  Config
+	sock *ethernet.Socket
+	ring *ethernet.Ring // nil unless Config.Ring is set
+	done chan struct{}
+	once sync.Once
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New returns new Capture instance.
+func New(cfg Config) (*Capture, error) {
+	if cfg.SnapLen < 0 {
+		return nil, errors.Errorf("invalid snap length %d", cfg.SnapLen)
+	}
+	if cfg.SnapLen == 0 {
+		cfg.SnapLen = DefaultSnapLen
+	}
+
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeAll)
+	if err != nil {
+		return nil, err
+	}
+	if err := sock.SetSnapLen(cfg.SnapLen); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	if len(cfg.BPF) > 0 {
+		if err := sock.SetBPF(cfg.BPF); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+	if cfg.Promiscuous {
+		if err := sock.SetPromiscuous(true); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+	var ring *ethernet.Ring
+	if cfg.Ring != nil {
+		if ring, err = ethernet.NewRing(sock, *cfg.Ring); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+	// discard counters of frames received before bind
+	if _, err := sock.Stats(); err != nil {
+		sock.Close()
+		return nil, err
+	}
+
+	return &Capture{
+		cfg:  cfg,
+		sock: sock,
+		ring: ring,
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Serve receives frames until Close is called. fn is called for every frame.
+func (c *Capture) Serve(fn func(*Frame)) error {
+	for {
+		select {
+		case <-c.done:
+			return nil
+		default:
+		}
+
+		if err := c.sock.SetRecvTimeout(pollInterval); err != nil {
+			return err
+		}
+		rf, err := c.recv()
+		if err != nil {
+			if ethernet.IsTimeout(err) {
+				continue
+			}
+			if errors.Cause(err) == ethernet.ErrClosed { // Close was called
+				return nil
+			}
+			return err
+		}
+		f := &Frame{
+			Time:     rf.Time,
+			Data:     rf.Data,
+			Length:   rf.Length,
+			Outbound: rf.Outbound(),
+			Type:     rf.Type,
+		}
+
+		c.mu.Lock()
+		c.stats.Captured++
+		if f.Truncated() {
+			c.stats.Truncated++
+		}
+		c.mu.Unlock()
+		if fn != nil {
+			fn(f)
+		}
+	}
+}
+
+// recv receives a frame from the ring or the socket.
+func (c *Capture) recv() (*ethernet.ReceivedFrame, error) {
+	if c.ring == nil {
+		return c.sock.RecvFrame(0)
+	}
+	f, err := c.ring.Next()
+	if err != nil {
+		return nil, err
+	}
+	rf := f.Copy()
+	if len(rf.Data) > c.cfg.SnapLen {
+		rf.Data = rf.Data[:c.cfg.SnapLen]
+		rf.Truncated = true
+	}
+	return rf, nil
+}
+
+// Close stops Serve and closes the socket.
+// Calling Close more than once returns nil.
+func (c *Capture) Close() error {
+	var err error
+	c.once.Do(func() { err = c.close() })
+	return err
+}
+
+func (c *Capture) close() error {
+	close(c.done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collectDrops()
+	if c.ring != nil {
+		return c.ring.Close()
+	}
+	return c.sock.Close()
+}
+
+// Stats returns the copy of current counters.
+func (c *Capture) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done: // socket is already closed
+	default:
+		c.collectDrops()
+	}
+	return c.stats
+}
+
+// collectDrops adds the number of frames dropped by the kernel to stats.
+// c.mu must be held.
+func (c *Capture) collectDrops() {
+	if st, err := c.sock.Stats(); err == nil {
+		c.stats.Dropped += uint64(st.Dropped)
+	}
+}
@@ -0,0 +1,13 @@
+package capture
+
+import "time"
+
+const (
+	// DefaultSnapLen is the default maximum number of bytes captured from each frame.
+	DefaultSnapLen = 262144
+)
+
+const (
+	// interval to check whether Capture is closed
+	pollInterval = 500 * time.Millisecond
+)
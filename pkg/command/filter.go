@@ -0,0 +1,58 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mas9612/nwspeaker/pkg/decode"
+	"github.com/mas9612/nwspeaker/pkg/filter"
+)
+
+// filterHelp returns the help text of filter expressions shared by commands.
+func filterHelp() string {
+	names := make([]string, 0, 64)
+	for _, f := range filter.Fields() {
+		names = append(names, f.Name)
+	}
+	lines := make([]string, 0, 8)
+	line := " "
+	for _, n := range names {
+		if len(line)+len(n)+1 > 76 {
+			lines = append(lines, line)
+			line = " "
+		}
+		line += " " + n
+	}
+	lines = append(lines, line)
+	return fmt.Sprintf(`
+Filter:
+  Expressions like "arp.op == reply && eth.src != arp.sha" or
+  "ipv4.ttl < 5 and icmp.type == time-exceeded". Comparisons are joined with
+  &&, ||, ! (or and, or, not) and grouped with parentheses. A field alone
+  tests its presence. IPv4 fields can be compared with prefixes like 10.0.0.0/8.
+  Fields:
+%s
+`, strings.Join(lines, "\n"))
+}
+
+// packetFilter is the filter given with --filter option.
+type packetFilter struct {
+	f *filter.Filter
+}
+
+// newPacketFilter compiles expr. If expr is empty, the returned filter matches every frame.
+func newPacketFilter(expr string) (*packetFilter, error) {
+	if expr == "" {
+		return &packetFilter{}, nil
+	}
+	f, err := filter.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &packetFilter{f: f}, nil
+}
+
+// match reports whether the decoded frame d matches the filter.
+func (f *packetFilter) match(d *decode.Packet) bool {
+	return f.f == nil || f.f.Match(d)
+}
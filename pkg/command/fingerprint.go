@@ -0,0 +1,180 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/fingerprint"
+)
+
+// FingerprintCommand is a command to infer operating systems of hosts passively.
+type FingerprintCommand struct{}
+
+// Help returns long-form help text of FingerprintCommand.
+func (c *FingerprintCommand) Help() string {
+	helpText := `
+Usage: nwspeaker fingerprint [options]
+
+  Observe TCP SYN and SYN/ACK on the interface and infer the operating system
+  of senders from TTL, window size, MSS, option layout and DF bit.
+  Nothing is sent to the network. MAC addresses are learned from ARP and from
+  frames sent by hosts on the local segment.
+  When stopped, the inventory of observed hosts is printed.
+
+  Signatures are written in p0f v3 format, so p0f.fp can be loaded as it is.
+  Unknown fingerprints are printed in the same format to be added to a file.
+
+Options:
+  -i, --interface    Interface to listen on. Required unless --read is given.
+  -r, --read         Read frames from pcap or pcapng file instead of the interface.
+  -f, --signatures   Signature file. Can be specified multiple times.
+                     Signatures in files take precedence over built-in ones.
+  --no-builtin       Do not use built-in signatures.
+  -p, --promisc      Enable promiscuous mode.
+  -q, --quiet        Print only the inventory.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs FingerprintCommand and returns exit status.
+func (c *FingerprintCommand) Run(args []string) int {
+	var opts struct {
+		Interface  string   `short:"i" long:"interface"`
+		Read       string   `short:"r" long:"read"`
+		Signatures []string `short:"f" long:"signatures"`
+		NoBuiltin  bool     `long:"no-builtin"`
+		Promisc    bool     `short:"p" long:"promisc"`
+		Quiet      bool     `short:"q" long:"quiet"`
+	}
+	if _, err := flags.ParseArgs(&opts, args); err != nil {
+		return 1
+	}
+	if opts.Interface == "" && opts.Read == "" {
+		fmt.Fprintf(os.Stderr, "--interface or --read required\n")
+		return 1
+	}
+
+	sigs := make([]*fingerprint.Signature, 0)
+	for _, path := range opts.Signatures {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open signature file: %v\n", err)
+			return 1
+		}
+		s, err := fingerprint.ParseSignatures(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
+		}
+		sigs = append(sigs, s...)
+	}
+	if !opts.NoBuiltin {
+		sigs = append(sigs, fingerprint.LoadDefaultSignatures()...)
+	}
+
+	cfg := fingerprint.Config{
+		Interface:   opts.Interface,
+		Signatures:  sigs,
+		Promiscuous: opts.Promisc,
+	}
+	if opts.Read != "" {
+		a := fingerprint.NewOffline(cfg)
+		err := readCapture(opts.Read, func(frame []byte, t time.Time) {
+			if o := a.HandleFrame(frame, t); o != nil && !opts.Quiet {
+				printObservation(o)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		printInventory(a.Hosts())
+		return 0
+	}
+
+	a, err := fingerprint.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- a.Serve(func(o *fingerprint.Observation) {
+			if !opts.Quiet {
+				printObservation(o)
+			}
+		})
+	}()
+
+	status := 0
+	select {
+	case <-sig:
+	case err := <-errCh:
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		status = 1
+	}
+	a.Close()
+
+	printInventory(a.Hosts())
+	return status
+}
+
+func printObservation(o *fingerprint.Observation) {
+	kind := "SYN"
+	if o.Fingerprint.Direction == fingerprint.Response {
+		kind = "SYN/ACK"
+	}
+	name := "unknown"
+	if o.Label != nil {
+		name = o.Label.String()
+	}
+	mac := ""
+	if o.MAC != nil {
+		mac = " mac=" + o.MAC.String()
+	}
+	fmt.Printf("%s %s:%d -> %s:%d %s os=\"%s\" dist=%d%s sig=%s\n", o.Time.Format("15:04:05.000"),
+		o.Src, o.SrcPort, o.Dst, o.DstPort, kind, name, o.Fingerprint.Distance(), mac, o.Fingerprint)
+}
+
+func printInventory(hosts []fingerprint.Host) {
+	fmt.Printf("\n%d hosts observed\n", len(hosts))
+	if len(hosts) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "IP\tMAC\tOS\tDIST\tPORTS\tLAST SEEN\n")
+	for _, h := range hosts {
+		mac, name := "-", "unknown"
+		if h.MAC != nil {
+			mac = h.MAC.String()
+		}
+		if h.Label != nil {
+			name = h.Label.String()
+		}
+		ports := make([]string, len(h.Ports))
+		for i, p := range h.Ports {
+			ports[i] = fmt.Sprintf("%d", p)
+		}
+		if len(ports) == 0 {
+			ports = append(ports, "-")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", h.IP, mac, name, h.Distance, strings.Join(ports, ","),
+			h.LastSeen.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+// Synopsis returns one-line synopsis of FingerprintCommand.
+func (c *FingerprintCommand) Synopsis() string {
+	return "Infer operating systems passively from TCP SYN and SYN/ACK."
+}
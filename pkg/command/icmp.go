@@ -1,15 +1,19 @@
 package command
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
 	"github.com/mas9612/nwspeaker/pkg/icmp"
 	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/pkg/errors"
 )
 
 // ICMPCommand is a command to craft ICMP packet.
@@ -21,6 +25,10 @@ func (c *ICMPCommand) Help() string {
 Usage: craftpkt icmp [options]
 
   Craft ICMP packet.
+  Error messages (Destination Unreachable, Source Quench, Redirect,
+  Time Exceeded and Parameter Problem) quote the original datagram given
+  by --original, --original-file or --capture. If --dst-ip is omitted,
+  error messages are sent to the source of the original datagram.
 
 Options:
   -i, --interface   Output interface.
@@ -28,35 +36,45 @@ Options:
   --dst-mac         Destination MAC address.
   --src-ip          Source IP address.
   --dst-ip          Destination IP address.
-  -t, --type        ICMP type code.
+  -t, --type        ICMP type code. Default: 8 (Echo Request)
+  -c, --code        ICMP code.
   -l, --list-types  Print supported ICMP type codes and exit.
+  --mtu             Next-hop MTU of Fragmentation Needed.
+  --gateway         Gateway address of Redirect.
+  --pointer         Pointer of Parameter Problem.
+  --original        Original IPv4 datagram in hex.
+  --original-file   File which contains original IPv4 datagram.
+  --capture         Use the next IPv4 packet received on the interface
+                    as the original datagram.
+  --capture-from    Only capture the packet sent from this address.
+  --quote-len       Length of the original data to quote. Negative value
+                    quotes whole datagram. Default: 8
 `
 	return strings.TrimSpace(helpText)
 }
 
-func craftICMPEchoRequest() (*icmp.Message, error) {
-	header := icmp.Message{
-		Type:     icmp.TypeEcho,
-		Code:     0,
-		Checksum: 0,
-	}
-	payload := &icmp.Echo{}
-	_ = header
-	_ = payload
-	return &icmp.Message{}, nil
+type icmpOptions struct {
+	Interface    string `short:"i" long:"interface"`
+	SrcMac       string `long:"src-mac"`
+	DstMac       string `long:"dst-mac"`
+	SrcIP        string `long:"src-ip"`
+	DstIP        string `long:"dst-ip"`
+	Type         uint8  `short:"t" long:"type" default:"8"`
+	Code         uint8  `short:"c" long:"code"`
+	ListTypes    bool   `short:"l" long:"list-types"`
+	MTU          uint16 `long:"mtu"`
+	Gateway      string `long:"gateway"`
+	Pointer      uint8  `long:"pointer"`
+	Original     string `long:"original"`
+	OriginalFile string `long:"original-file"`
+	Capture      bool   `long:"capture"`
+	CaptureFrom  string `long:"capture-from"`
+	QuoteLen     int    `long:"quote-len" default:"8"`
 }
 
 // Run runs ICMPCommand and returns exit status.
 func (c *ICMPCommand) Run(args []string) int {
-	var opts struct {
-		Interface string `short:"i" long:"interface"`
-		SrcMac    string `long:"src-mac"`
-		DstMac    string `long:"dst-mac"`
-		SrcIP     string `long:"src-ip"`
-		DstIP     string `long:"dst-ip"`
-		Type      int    `short:"t" long:"type"`
-		ListTypes bool   `short:"l" long:"list-types"`
-	}
+	var opts icmpOptions
 	if _, err := flags.ParseArgs(&opts, args); err != nil {
 		return 1
 	}
@@ -65,54 +83,212 @@ func (c *ICMPCommand) Run(args []string) int {
 		printSupportedTypes()
 		return 0
 	}
+	t := lookupCraftableType(opts.Type)
+	if t == nil {
+		switch opts.Type {
+		case icmp.TypeTimestamp, icmp.TypeTimestampReply, icmp.TypeAddressMaskRequest, icmp.TypeAddressMaskReply:
+			fmt.Fprintf(os.Stderr, "ICMP type %d is sent by icmp-query command\n", opts.Type)
+		case icmp.TypeRouterAdvertisement, icmp.TypeRouterSolicitation:
+			fmt.Fprintf(os.Stderr, "ICMP type %d is sent by rdisc command\n", opts.Type)
+		default:
+			fmt.Fprintf(os.Stderr, "unsupported ICMP type %d. see --list-types\n", opts.Type)
+		}
+		return 1
+	}
+	isError := t.isError
 
 	lacked := make([]string, 0, 10)
 	if opts.Interface == "" {
 		lacked = append(lacked, "--interface")
 	}
-	if opts.DstIP == "" {
+	if opts.DstIP == "" && !isError {
 		lacked = append(lacked, "--dst-ip")
 	}
 	if opts.DstMac == "" {
 		lacked = append(lacked, "--dst-mac")
 	}
+	if isError && opts.Original == "" && opts.OriginalFile == "" && !opts.Capture {
+		lacked = append(lacked, "--original, --original-file or --capture")
+	}
 	if len(lacked) > 0 {
 		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
 		return 1
 	}
 
-	// TODO: change to be able to reflect --type option
-	echo, err := icmp.NewEcho(opts.Interface, opts.DstIP, opts.DstMac)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to create ICMP Echo packet: %v\n", err)
-		return 1
+	var msg *icmp.Message
+	var original *ipv4.Packet
+	if isError {
+		b, err := loadOriginal(&opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get original datagram: %v\n", err)
+			return 1
+		}
+		if original = ipv4.Parse(b); original == nil {
+			fmt.Fprintf(os.Stderr, "original datagram is not a valid IPv4 packet\n")
+			return 1
+		}
+		if msg, err = craftICMPError(&opts, b); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create ICMP packet: %v\n", err)
+			return 1
+		}
+	} else {
+		var err error
+		msg, err = icmp.NewEcho(opts.Interface, opts.DstIP, opts.DstMac)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create ICMP Echo packet: %v\n", err)
+			return 1
+		}
+		msg.Type = opts.Type
+		msg.Code = opts.Code
 	}
 
-	dstIP := net.ParseIP(opts.DstIP)
-	if dstIP == nil {
-		fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
-		return 1
+	var dstIP net.IP
+	if opts.DstIP != "" {
+		dstIP = net.ParseIP(opts.DstIP)
+		if dstIP == nil {
+			fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+			return 1
+		}
+	} else {
+		dstIP = original.SrcAddress
 	}
 	dstMac, err := net.ParseMAC(opts.DstMac)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
 		return 1
 	}
-	if err := ipv4.Send(opts.Interface, dstIP, echo.Encode(), ipv4.ProtoICMP, ipv4.SetDstMac(dstMac)); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to send ICMP Echo: %v\n", err)
+	ipOpts := []ipv4.Option{ipv4.SetDstMac(dstMac)}
+	if opts.SrcIP != "" {
+		srcIP := net.ParseIP(opts.SrcIP)
+		if srcIP == nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source IP address\n")
+			return 1
+		}
+		ipOpts = append(ipOpts, ipv4.SetSrcIP(srcIP))
+	}
+	if opts.SrcMac != "" {
+		srcMac, err := net.ParseMAC(opts.SrcMac)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source MAC address\n")
+			return 1
+		}
+		ipOpts = append(ipOpts, ipv4.SetSrcMac(srcMac))
+	}
+
+	if err := ipv4.Send(opts.Interface, dstIP, msg.Encode(), ipv4.ProtoICMP, ipOpts...); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send ICMP packet: %v\n", err)
 		return 1
 	}
 
 	return 0
 }
 
+// craftICMPError creates ICMP error message which quotes original.
+func craftICMPError(opts *icmpOptions, original []byte) (*icmp.Message, error) {
+	quoteLen := icmp.SetQuoteLen(opts.QuoteLen)
+	switch opts.Type {
+	case icmp.TypeDestinationUnreachable:
+		return icmp.NewDestinationUnreachable(opts.Code, original, quoteLen, icmp.SetNextHopMTU(opts.MTU))
+	case icmp.TypeSourceQuench:
+		return icmp.NewSourceQuench(original, quoteLen)
+	case icmp.TypeRedirect:
+		gateway := net.ParseIP(opts.Gateway)
+		if gateway == nil {
+			return nil, errors.New("--gateway is required for Redirect")
+		}
+		return icmp.NewRedirect(opts.Code, gateway, original, quoteLen)
+	case icmp.TypeTimeExceeded:
+		return icmp.NewTimeExceeded(opts.Code, original, quoteLen)
+	case icmp.TypeParameterProblem:
+		return icmp.NewParameterProblem(opts.Code, opts.Pointer, original, quoteLen)
+	}
+	return nil, errors.Errorf("type %d is not an error message", opts.Type)
+}
+
+// loadOriginal returns the original datagram specified by options.
+func loadOriginal(opts *icmpOptions) ([]byte, error) {
+	switch {
+	case opts.Original != "":
+		return hex.DecodeString(opts.Original)
+	case opts.OriginalFile != "":
+		return ioutil.ReadFile(opts.OriginalFile)
+	}
+	return captureOriginal(opts.Interface, opts.CaptureFrom)
+}
+
+// captureOriginal waits for an IPv4 packet on ifname and returns it.
+// If from is not empty, only the packet sent from it is captured.
+func captureOriginal(ifname, from string) ([]byte, error) {
+	var src net.IP
+	if from != "" {
+		if src = net.ParseIP(from); src == nil {
+			return nil, errors.Errorf("invalid IPv4 address '%s'", from)
+		}
+	}
+
+	sock, err := openIPv4Socket(ifname)
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	for {
+		b, err := sock.Recv(0)
+		if err != nil {
+			return nil, err
+		}
+		raw := b[ethernet.HeaderLen:]
+		pkt := ipv4.Parse(raw)
+		if pkt == nil || (src != nil && !pkt.SrcAddress.Equal(src)) {
+			continue
+		}
+		if int(pkt.TotalLength) < len(raw) {
+			raw = raw[:pkt.TotalLength]
+		}
+		return raw, nil // keep original bytes including header checksum
+	}
+}
+
+// craftableType is an ICMP type which can be crafted by ICMPCommand.
+type craftableType struct {
+	typ     uint8
+	name    string
+	codes   string
+	isError bool // quotes the original datagram
+}
+
+// craftableTypes are the types crafted by ICMPCommand. Query messages other than Echo
+// are sent by icmp-query and rdisc commands, which fill their own fields.
+var craftableTypes = []craftableType{
+	{icmp.TypeEcho, "Echo Request", "", false},
+	{icmp.TypeEchoReply, "Echo Reply", "", false},
+	{icmp.TypeDestinationUnreachable, "Destination Unreachable", "0-15 (4: Fragmentation Needed, use --mtu)", true},
+	{icmp.TypeSourceQuench, "Source Quench", "0", true},
+	{icmp.TypeRedirect, "Redirect", "0-3 (use --gateway)", true},
+	{icmp.TypeTimeExceeded, "Time Exceeded", "0-1", true},
+	{icmp.TypeParameterProblem, "Parameter Problem", "0-2 (0: use --pointer)", true},
+}
+
+// lookupCraftableType returns the craftable type whose number is typ, or nil.
+func lookupCraftableType(typ uint8) *craftableType {
+	for i := range craftableTypes {
+		if craftableTypes[i].typ == typ {
+			return &craftableTypes[i]
+		}
+	}
+	return nil
+}
+
 func printSupportedTypes() {
 	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 	defer writer.Flush()
 
-	fmt.Fprintf(writer, "TypeCode\tMessage\n")
-	fmt.Fprintf(writer, "8\tEcho Request\n")
-	fmt.Fprintf(writer, "0\tEcho Reply\n")
+	fmt.Fprintf(writer, "TypeCode\tMessage\tCodes\n")
+	for _, t := range craftableTypes {
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", t.typ, t.name, t.codes)
+	}
+	fmt.Fprintf(writer, "9, 10\tRouter Advertisement, Solicitation\tuse rdisc command\n")
+	fmt.Fprintf(writer, "13-18\tTimestamp, Address Mask\tuse icmp-query command\n")
 }
 
 // Synopsis returns one-line synopsis of ICMPCommand.
@@ -0,0 +1,206 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/rdisc"
+)
+
+// ICMPQueryCommand is a command to send ICMP query message and print the reply.
+type ICMPQueryCommand struct{}
+
+// Help returns long-form help text of ICMPQueryCommand.
+func (c *ICMPQueryCommand) Help() string {
+	helpText := `
+Usage: nwspeaker icmp-query [options] [DST]
+
+  Send ICMP query message and print the reply.
+
+  timestamp  Send Timestamp to DST and print clock offset and round trip time.
+  mask       Send Address Mask Request to DST and print the address mask.
+  solicit    Send Router Solicitation to 224.0.0.2 and print received
+             Router Advertisements. DST is not used.
+
+Options:
+  -i, --interface   Output interface. Required.
+  --dst-mac         Destination MAC address. Required except solicit.
+  -q, --query       Query type. "timestamp", "mask" or "solicit".
+                    Default: "timestamp"
+  -w, --wait        Seconds to wait for replies. Default: 3
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs ICMPQueryCommand and returns exit status.
+func (c *ICMPQueryCommand) Run(args []string) int {
+	var opts struct {
+		Interface string  `short:"i" long:"interface"`
+		DstMac    string  `long:"dst-mac"`
+		Query     string  `short:"q" long:"query" default:"timestamp"`
+		Wait      float64 `short:"w" long:"wait" default:"3"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 1
+	}
+	if opts.Query != "timestamp" && opts.Query != "mask" && opts.Query != "solicit" {
+		fmt.Fprintln(os.Stderr, "invalid query type. valid type: \"timestamp\", \"mask\", \"solicit\"")
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if opts.Query != "solicit" {
+		if opts.DstMac == "" {
+			lacked = append(lacked, "--dst-mac")
+		}
+		if len(rest) != 1 {
+			lacked = append(lacked, "DST")
+		}
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	var dst net.IP
+	var dstMac net.HardwareAddr
+	var msg *icmp.Message
+	switch opts.Query {
+	case "timestamp":
+		msg = icmp.NewTimestamp()
+	case "mask":
+		msg = icmp.NewAddressMaskRequest()
+	case "solicit":
+		msg = icmp.NewRouterSolicitation()
+		dst = rdisc.AllRouters
+		dstMac = ethernet.IPv4Multicast(rdisc.AllRouters)
+	}
+	if dst == nil {
+		if dst = net.ParseIP(rest[0]); dst == nil {
+			fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+			return 1
+		}
+		if dstMac, err = net.ParseMAC(opts.DstMac); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
+			return 1
+		}
+	}
+
+	sock, err := openIPv4Socket(opts.Interface)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer sock.Close()
+
+	ipOpts := []ipv4.Option{ipv4.SetDstMac(dstMac)}
+	if opts.Query == "solicit" {
+		ipOpts = append(ipOpts, ipv4.SetTTL(1)) // RFC 1256 requires TTL 1 for solicitations
+	}
+	if err := ipv4.Send(opts.Interface, dst, msg.Encode(), ipv4.ProtoICMP, ipOpts...); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send ICMP packet: %v\n", err)
+		return 1
+	}
+
+	received := 0
+	deadline := time.Now().Add(time.Duration(opts.Wait * float64(time.Second)))
+	for {
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			break
+		}
+		if err := sock.SetRecvTimeout(remain); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		b, err := sock.Recv(0)
+		if err != nil {
+			if ethernet.IsTimeout(err) {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		arrival := icmp.MillisecondsSinceMidnight(time.Now())
+
+		pkt := ipv4.Parse(b[ethernet.HeaderLen:])
+		if pkt == nil || pkt.Protocol != ipv4.ProtoICMP {
+			continue
+		}
+		reply := icmp.Parse(pkt.Data)
+		if reply == nil {
+			continue
+		}
+		if printQueryReply(msg, reply, pkt.SrcAddress, arrival) {
+			received++
+			if opts.Query != "solicit" {
+				break
+			}
+		}
+	}
+	if received == 0 {
+		fmt.Fprintln(os.Stderr, "no reply received")
+		return 1
+	}
+	return 0
+}
+
+// printQueryReply prints reply if it is the answer of query and returns true.
+func printQueryReply(query, reply *icmp.Message, from net.IP, arrival uint32) bool {
+	switch q := query.Data.(type) {
+	case *icmp.Timestamp:
+		r, ok := reply.Data.(*icmp.Timestamp)
+		if !ok || reply.Type != icmp.TypeTimestampReply || r.Identifier != q.Identifier {
+			return false
+		}
+		if !r.Standard() {
+			fmt.Printf("timestamp reply from %s: non-standard time (receive %d, transmit %d)\n", from, r.Receive, r.Transmit)
+			return true
+		}
+		offset, rtt := r.ClockOffset(arrival)
+		fmt.Printf("timestamp reply from %s: clock offset %s, rtt %s\n", from, offset, rtt)
+	case *icmp.AddressMask:
+		r, ok := reply.Data.(*icmp.AddressMask)
+		if !ok || reply.Type != icmp.TypeAddressMaskReply || r.Identifier != q.Identifier {
+			return false
+		}
+		fmt.Printf("address mask reply from %s: %s\n", from, net.IP(r.Mask))
+	case *icmp.RouterSolicitation:
+		r, ok := reply.Data.(*icmp.RouterAdvertisement)
+		if !ok {
+			return false
+		}
+		fmt.Printf("router advertisement from %s: lifetime %ds\n", from, r.Lifetime)
+		for _, e := range r.Entries {
+			fmt.Printf("  %s preference %d\n", e.Addr, e.Preference)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// openIPv4Socket returns ethernet socket which receives IPv4 packets on ifname.
+func openIPv4Socket(ifname string) (*ethernet.Socket, error) {
+	sock, err := ethernet.Listen(ifname, ethernet.TypeIPv4)
+	if err != nil {
+		return nil, err
+	}
+	return sock, nil
+}
+
+// Synopsis returns one-line synopsis of ICMPQueryCommand.
+func (c *ICMPQueryCommand) Synopsis() string {
+	return "Send ICMP Timestamp, Address Mask or Router Solicitation and print the reply."
+}
@@ -0,0 +1,243 @@
+package command
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/bpf"
+	"github.com/mas9612/nwspeaker/pkg/capture"
+	"github.com/mas9612/nwspeaker/pkg/decode"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/pkg/errors"
+)
+
+// ListenCommand is a command to capture frames and print them.
+type ListenCommand struct{}
+
+// Help returns long-form help text of ListenCommand.
+func (c *ListenCommand) Help() string {
+	helpText := `
+Usage: nwspeaker listen [options]
+
+  Capture frames on the interface and print one-line summary of each frame
+  like tcpdump. Frames sent by this host are marked with "Out".
+  On Ctrl-C, the number of printed frames per protocol is printed.
+
+Options:
+  -i, --interface   Interface to capture. Required.
+  -c, --count       Exit after capturing given number of frames.
+  -v, --verbose     Print every layer and checksum status as a tree.
+  -x, --hex         Print each frame in hex.
+  -e, --link        Print MAC addresses and the packet type given by the kernel
+                    (host, broadcast, multicast, otherhost or outgoing).
+  -p, --promisc     Put the interface into promiscuous mode.
+  -s, --snaplen     Maximum number of bytes captured from each frame. Default: 262144
+  -f, --filter      Print only frames matching the filter expression.
+  -b, --bpf         Filter frames in the kernel with tcpdump-like expression
+                    such as "arp or (tcp and port 80)".
+  --bpf-file        Filter frames in the kernel with raw BPF program in FILE.
+                    Assembly like "tcpdump -d" or numbers like "tcpdump -ddd".
+  -d, --dump-bpf    Print the BPF program and exit.
+  --ring            Receive frames through the memory-mapped ring (TPACKET_V3)
+                    to keep up with heavy traffic.
+  --ring-blocks     Number of blocks of the ring. Default: 8
+  --ring-block-size Size of each block of the ring in bytes. Default: 1048576
+` + filterHelp()
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs ListenCommand and returns exit status.
+func (c *ListenCommand) Run(args []string) int {
+	var opts struct {
+		Interface     string `short:"i" long:"interface"`
+		Count         int    `short:"c" long:"count"`
+		Verbose       bool   `short:"v" long:"verbose"`
+		Hex           bool   `short:"x" long:"hex"`
+		Link          bool   `short:"e" long:"link"`
+		Promisc       bool   `short:"p" long:"promisc"`
+		SnapLen       int    `short:"s" long:"snaplen" default:"262144"`
+		Filter        string `short:"f" long:"filter"`
+		BPF           string `short:"b" long:"bpf"`
+		BPFFile       string `long:"bpf-file"`
+		DumpBPF       bool   `short:"d" long:"dump-bpf"`
+		Ring          bool   `long:"ring"`
+		RingBlocks    int    `long:"ring-blocks"`
+		RingBlockSize int    `long:"ring-block-size"`
+	}
+	if _, err := flags.ParseArgs(&opts, args); err != nil {
+		return 1
+	}
+	prog, err := loadBPF(opts.BPF, opts.BPFFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if opts.DumpBPF {
+		fmt.Print(prog)
+		return 0
+	}
+	if opts.Interface == "" {
+		fmt.Fprintf(os.Stderr, "--interface required\n")
+		return 1
+	}
+
+	pf, err := newPacketFilter(opts.Filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cfg := capture.Config{
+		Interface:   opts.Interface,
+		Promiscuous: opts.Promisc,
+		SnapLen:     opts.SnapLen,
+		BPF:         prog,
+	}
+	if opts.Ring {
+		cfg.Ring = &ethernet.RingConfig{
+			BlockSize:  opts.RingBlockSize,
+			BlockCount: opts.RingBlocks,
+		}
+	}
+	sniffer, err := capture.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	counts := &protocolCounts{}
+	enough := make(chan struct{})
+	n := 0
+	handle := func(f *capture.Frame) {
+		if opts.Count > 0 && n >= opts.Count {
+			return
+		}
+		d := decode.Frame(f.Data)
+		if !pf.match(d) {
+			return
+		}
+		n++
+
+		line := f.Time.Format("15:04:05.000000") + " "
+		if f.Outbound {
+			line += "Out "
+		} else {
+			line += "In  "
+		}
+		counts.add(d)
+		if d == nil {
+			line += fmt.Sprintf("truncated frame, length %d", len(f.Data))
+		} else {
+			if opts.Link {
+				line += fmt.Sprintf("%s > %s, %s, ", d.Ethernet.SrcAddr, d.Ethernet.DstAddr, f.Type)
+			}
+			line += d.String()
+		}
+		if f.Truncated() {
+			line += fmt.Sprintf(" [|%d]", f.Length)
+		}
+		fmt.Println(line)
+		if opts.Verbose && d != nil {
+			for _, l := range strings.Split(strings.TrimSuffix(d.Tree(), "\n"), "\n") {
+				fmt.Println("    " + l)
+			}
+		}
+		if opts.Hex {
+			fmt.Print(hex.Dump(f.Data))
+		}
+		if opts.Count > 0 && n == opts.Count {
+			close(enough)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sniffer.Serve(handle)
+	}()
+
+	status := 0
+	served := false
+	select {
+	case <-sig:
+	case <-enough:
+	case err := <-errCh:
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		status = 1
+		served = true
+	}
+	sniffer.Close()
+	if !served { // wait for the handler to finish before reading counts
+		<-errCh
+	}
+	stats := sniffer.Stats()
+
+	fmt.Fprintf(os.Stderr, "\n%d frames captured, %d truncated, %d dropped by kernel\n",
+		stats.Captured, stats.Truncated, stats.Dropped)
+	if opts.Filter != "" {
+		fmt.Fprintf(os.Stderr, "%d frames matched the filter\n", n)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", counts)
+	return status
+}
+
+// loadBPF compiles expr or assembles the program in path.
+// If neither is given, nil is returned.
+func loadBPF(expr, path string) (bpf.Program, error) {
+	switch {
+	case expr != "" && path != "":
+		return nil, errors.New("--bpf and --bpf-file are exclusive")
+	case expr != "":
+		return bpf.Compile(expr)
+	case path != "":
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read BPF program")
+		}
+		return bpf.Assemble(string(src))
+	}
+	return nil, nil
+}
+
+// protocolCounts counts frames per protocol.
+type protocolCounts struct {
+	arp, ipv4, icmp, udp, tcp, other uint64
+}
+
+func (c *protocolCounts) add(p *decode.Packet) {
+	switch {
+	case p == nil:
+		c.other++
+	case p.ARP != nil:
+		c.arp++
+	case p.IPv4 != nil:
+		c.ipv4++
+		switch {
+		case p.ICMP != nil:
+			c.icmp++
+		case p.UDP != nil:
+			c.udp++
+		case p.TCP != nil:
+			c.tcp++
+		}
+	default:
+		c.other++
+	}
+}
+
+func (c *protocolCounts) String() string {
+	return fmt.Sprintf("ARP %d, IPv4 %d (ICMP %d, UDP %d, TCP %d), other %d",
+		c.arp, c.ipv4, c.icmp, c.udp, c.tcp, c.other)
+}
+
+// Synopsis returns one-line synopsis of ListenCommand.
+func (c *ListenCommand) Synopsis() string {
+	return "Capture frames and print decoded summary."
+}
@@ -0,0 +1,130 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/pmtu"
+)
+
+// PMTUCommand is a command to discover the path MTU to the destination.
+type PMTUCommand struct{}
+
+// Help returns long-form help text of PMTUCommand.
+func (c *PMTUCommand) Help() string {
+	helpText := `
+Usage: nwspeaker pmtu [options] DST
+
+  Discover the path MTU to DST with ICMP Echo Request which has DF flag.
+  Probe size is searched with binary search. When Fragmentation Needed
+  is received, the next-hop MTU in it is probed next.
+  If large probes are dropped without any ICMP message, PMTU black hole
+  is reported.
+
+Options:
+  -i, --interface   Output interface. Required.
+  --dst-mac         MAC address of the next hop. Required.
+  --src-ip          Source IP address.
+  --min             Smallest probe size in bytes. Default: 68
+  --max             Largest probe size in bytes. Default: MTU of the interface
+  -w, --wait        Seconds to wait for each reply. Default: 1
+  -r, --retries     Number of probes sent for each size. Default: 3
+  -v, --verbose     Print the result of each probe.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs PMTUCommand and returns exit status.
+func (c *PMTUCommand) Run(args []string) int {
+	var opts struct {
+		Interface string  `short:"i" long:"interface"`
+		DstMac    string  `long:"dst-mac"`
+		SrcIP     string  `long:"src-ip"`
+		Min       int     `long:"min"`
+		Max       int     `long:"max"`
+		Wait      float64 `short:"w" long:"wait" default:"1"`
+		Retries   int     `short:"r" long:"retries" default:"3"`
+		Verbose   bool    `short:"v" long:"verbose"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if opts.DstMac == "" {
+		lacked = append(lacked, "--dst-mac")
+	}
+	if len(rest) != 1 {
+		lacked = append(lacked, "DST")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	cfg := pmtu.Config{
+		Interface: opts.Interface,
+		MinMTU:    opts.Min,
+		MaxMTU:    opts.Max,
+		Timeout:   time.Duration(opts.Wait * float64(time.Second)),
+		Retries:   opts.Retries,
+	}
+	if cfg.Dst = net.ParseIP(rest[0]); cfg.Dst == nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+		return 1
+	}
+	if cfg.DstMac, err = net.ParseMAC(opts.DstMac); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
+		return 1
+	}
+	if opts.SrcIP != "" {
+		if cfg.SrcIP = net.ParseIP(opts.SrcIP); cfg.SrcIP == nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source IP address\n")
+			return 1
+		}
+	}
+
+	prober, err := pmtu.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer prober.Close()
+
+	res, err := prober.Discover()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if opts.Verbose {
+		for _, p := range res.Probes {
+			switch {
+			case p.OK:
+				fmt.Printf("%5d bytes: ok\n", p.Size)
+			case p.FragNeeded:
+				fmt.Printf("%5d bytes: fragmentation needed from %s, next-hop MTU %d\n", p.Size, p.From, p.NextHopMTU)
+			default:
+				fmt.Printf("%5d bytes: no reply\n", p.Size)
+			}
+		}
+	}
+	fmt.Printf("path MTU to %s is %d bytes\n", cfg.Dst, res.PMTU)
+	if res.BlackHole {
+		fmt.Printf("PMTU black hole detected: larger probes were dropped without Fragmentation Needed\n")
+	}
+	return 0
+}
+
+// Synopsis returns one-line synopsis of PMTUCommand.
+func (c *PMTUCommand) Synopsis() string {
+	return "Discover the path MTU to the destination."
+}
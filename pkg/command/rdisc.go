@@ -0,0 +1,113 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/rdisc"
+)
+
+// RdiscCommand is a command to run ICMP Router Discovery advertiser.
+type RdiscCommand struct{}
+
+// Help returns long-form help text of RdiscCommand.
+func (c *RdiscCommand) Help() string {
+	helpText := `
+Usage: nwspeaker rdisc [options]
+
+  Send ICMP Router Advertisement periodically and answer Router Solicitation
+  (RFC 1256). On exit, advertisement with zero lifetime is sent.
+
+Options:
+  -i, --interface     Interface to advertise on. Required.
+  -a, --addr          Router address to advertise in "ADDR[,PREFERENCE]" form.
+                      Can be specified multiple times.
+                      Default: the address of the interface
+  --max-interval      Maximum interval between advertisements. Default: 10m
+  --min-interval      Minimum interval between advertisements.
+                      Default: 0.75 * max interval
+  --lifetime          Lifetime of advertised addresses. Default: 3 * max interval
+  -b, --broadcast     Send advertisements to 255.255.255.255 instead of 224.0.0.1.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs RdiscCommand and returns exit status.
+func (c *RdiscCommand) Run(args []string) int {
+	var opts struct {
+		Interface   string        `short:"i" long:"interface"`
+		Addrs       []string      `short:"a" long:"addr"`
+		MaxInterval time.Duration `long:"max-interval"`
+		MinInterval time.Duration `long:"min-interval"`
+		Lifetime    time.Duration `long:"lifetime"`
+		Broadcast   bool          `short:"b" long:"broadcast"`
+	}
+	if _, err := flags.ParseArgs(&opts, args); err != nil {
+		return 1
+	}
+	if opts.Interface == "" {
+		fmt.Fprintln(os.Stderr, "--interface required")
+		return 1
+	}
+
+	cfg := rdisc.Config{
+		Interface:   opts.Interface,
+		MaxInterval: opts.MaxInterval,
+		MinInterval: opts.MinInterval,
+		Lifetime:    opts.Lifetime,
+		Broadcast:   opts.Broadcast,
+	}
+	for _, a := range opts.Addrs {
+		var e icmp.RouterEntry
+		fields := strings.SplitN(a, ",", 2)
+		if e.Addr = net.ParseIP(fields[0]); e.Addr == nil {
+			fmt.Fprintf(os.Stderr, "invalid IPv4 address '%s'\n", fields[0])
+			return 1
+		}
+		if len(fields) == 2 {
+			if _, err := fmt.Sscanf(fields[1], "%d", &e.Preference); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid preference '%s'\n", fields[1])
+				return 1
+			}
+		}
+		cfg.Entries = append(cfg.Entries, e)
+	}
+
+	adv, err := rdisc.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- adv.Serve()
+	}()
+
+	status := 0
+	select {
+	case <-sig:
+	case err := <-errCh:
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		status = 1
+	}
+	if err := adv.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		status = 1
+	}
+	return status
+}
+
+// Synopsis returns one-line synopsis of RdiscCommand.
+func (c *RdiscCommand) Synopsis() string {
+	return "Run ICMP Router Discovery advertiser."
+}
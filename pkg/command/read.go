@@ -0,0 +1,238 @@
+package command
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/decode"
+	"github.com/mas9612/nwspeaker/pkg/pcap"
+	"github.com/mas9612/nwspeaker/pkg/pcapng"
+	"github.com/pkg/errors"
+)
+
+// ReadCommand is a command to decode frames in pcap file.
+type ReadCommand struct{}
+
+// Help returns long-form help text of ReadCommand.
+func (c *ReadCommand) Help() string {
+	helpText := `
+Usage: nwspeaker read [options] FILE
+
+  Decode frames in pcap or pcapng FILE and print one-line summary of each frame.
+  Both byte orders and both microsecond and nanosecond timestamps are supported.
+  Only Ethernet link type is supported.
+  For pcapng, the interface, the direction and comments of frames are printed.
+
+Options:
+  -c, --count   Exit after reading given number of frames.
+  -x, --hex     Print each frame in hex.
+  -e, --link    Print MAC addresses.
+  -f, --filter  Print only frames matching the filter expression.
+  -b, --bpf     Print only frames matching tcpdump-like expression.
+                The expression is compiled into BPF and run in user space.
+` + filterHelp()
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs ReadCommand and returns exit status.
+func (c *ReadCommand) Run(args []string) int {
+	var opts struct {
+		Count  int    `short:"c" long:"count"`
+		Hex    bool   `short:"x" long:"hex"`
+		Link   bool   `short:"e" long:"link"`
+		Filter string `short:"f" long:"filter"`
+		BPF    string `short:"b" long:"bpf"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 1
+	}
+	if len(rest) != 1 {
+		fmt.Fprintf(os.Stderr, "FILE required\n")
+		return 1
+	}
+
+	pf, err := newPacketFilter(opts.Filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	prog, err := loadBPF(opts.BPF, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	r, err := openCapture(rest[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer r.Close()
+
+	for n := 0; opts.Count <= 0 || n < opts.Count; {
+		f, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		var d *decode.Packet
+		if f.linkType == pcap.LinkTypeEthernet {
+			d = decode.Frame(f.data)
+		}
+		if !pf.match(d) || (prog != nil && prog.Run(f.data) == 0) {
+			continue
+		}
+		n++
+
+		line := f.time.Format("15:04:05.000000") + " "
+		if f.iface != "" {
+			line += f.iface + " "
+		}
+		if f.direction != "" {
+			line += f.direction + " "
+		}
+		switch {
+		case f.linkType != pcap.LinkTypeEthernet:
+			line += fmt.Sprintf("link type %d, length %d", f.linkType, len(f.data))
+		case d == nil:
+			line += fmt.Sprintf("truncated frame, length %d", len(f.data))
+		default:
+			if opts.Link {
+				line += fmt.Sprintf("%s > %s, ", d.Ethernet.SrcAddr, d.Ethernet.DstAddr)
+			}
+			line += d.String()
+		}
+		if len(f.data) < f.origLen {
+			line += fmt.Sprintf(" [|%d]", f.origLen)
+		}
+		fmt.Println(line)
+		for _, c := range f.comments {
+			fmt.Printf("    # %s\n", c)
+		}
+		if opts.Hex {
+			fmt.Print(hex.Dump(f.data))
+		}
+	}
+	return 0
+}
+
+// capturedFrame is a frame read from pcap or pcapng file.
+type capturedFrame struct {
+	time      time.Time
+	data      []byte
+	origLen   int
+	linkType  uint32
+	iface     string // name of the interface. only available in pcapng
+	direction string // "In" or "Out". only available in pcapng
+	comments  []string
+}
+
+// captureReader reads frames from pcap or pcapng file.
+type captureReader struct {
+	pcap   *pcap.Reader
+	pcapng *pcapng.Reader
+}
+
+// openCapture opens pcap or pcapng file. The format is detected from the first bytes.
+func openCapture(path string) (*captureReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open capture file")
+	}
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read capture file")
+	}
+
+	if binary.LittleEndian.Uint32(magic) == pcapng.BlockSectionHeader {
+		r, err := pcapng.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &captureReader{pcapng: r}, nil
+	}
+	r, err := pcap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &captureReader{pcap: r}, nil
+}
+
+// next reads the next frame. At the end of the file, io.EOF is returned.
+func (r *captureReader) next() (*capturedFrame, error) {
+	if r.pcap != nil {
+		p, err := r.pcap.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		return &capturedFrame{time: p.Time, data: p.Data, origLen: p.OrigLen, linkType: r.pcap.LinkType()}, nil
+	}
+
+	p, err := r.pcapng.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	ifc := r.pcapng.Interfaces()[p.Interface]
+	f := &capturedFrame{
+		time:     p.Time,
+		data:     p.Data,
+		origLen:  p.OrigLen,
+		linkType: uint32(ifc.LinkType),
+		iface:    ifc.Name,
+		comments: p.Comments,
+	}
+	if f.iface == "" {
+		f.iface = fmt.Sprintf("if%d", p.Interface)
+	}
+	if p.Inbound() {
+		f.direction = "In"
+	} else if p.Outbound() {
+		f.direction = "Out"
+	}
+	return f, nil
+}
+
+// Close closes the file.
+func (r *captureReader) Close() error {
+	if r.pcap != nil {
+		return r.pcap.Close()
+	}
+	return r.pcapng.Close()
+}
+
+// readCapture calls fn for each Ethernet frame in pcap or pcapng file.
+func readCapture(path string, fn func(frame []byte, t time.Time)) error {
+	r, err := openCapture(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		f, err := r.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if f.linkType == pcap.LinkTypeEthernet {
+			fn(f.data, f.time)
+		}
+	}
+}
+
+// Synopsis returns one-line synopsis of ReadCommand.
+func (c *ReadCommand) Synopsis() string {
+	return "Decode frames in pcap file."
+}
@@ -0,0 +1,201 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/decode"
+	"github.com/mas9612/nwspeaker/pkg/replay"
+)
+
+// ReplayCommand is a command to send frames in capture file again.
+type ReplayCommand struct{}
+
+// Help returns long-form help text of ReplayCommand.
+func (c *ReplayCommand) Help() string {
+	helpText := `
+Usage: nwspeaker replay [options] FILE
+
+  Send Ethernet frames in pcap or pcapng FILE through the interface.
+  By default, frames are sent with the original inter-packet timing.
+  When addresses are rewritten, checksums of IPv4, ICMP, UDP and TCP are
+  recomputed. Addresses quoted in ICMP error messages are rewritten too.
+  Upper layer checksums of fragments and truncated frames are left as they are.
+
+Options:
+  -i, --interface     Output interface. Required.
+  -s, --speed         Multiplier of the original timing. Default: 1
+  -r, --rate          Send frames at fixed rate in frames per second.
+  -t, --topspeed      Send frames as fast as possible.
+  -l, --loop          Number of times to replay. 0 means forever. Default: 1
+  --batch             With --topspeed, send this many frames per system call
+                      through the transmit ring (PACKET_TX_RING).
+  --qdisc-bypass      With --batch, pass frames to the driver directly,
+                      skipping traffic control of the interface.
+  --src-mac           Replace all source MAC addresses.
+  --dst-mac           Replace all destination MAC addresses.
+  --mac-map OLD=NEW   Replace MAC address OLD with NEW. Can be specified multiple times.
+  --ip-map FROM=TO    Replace IPv4 prefix FROM with TO like "10.0.0.0/24=192.168.1.0/24".
+                      Host parts are kept. Can be specified multiple times.
+  --vlan              Tag frames with VLAN ID. Existing tags are replaced.
+  --vlan-priority     Priority code point of the VLAN tag. Default: 0
+  --strip-vlan        Remove VLAN tags.
+  --fix-checksums     Recompute checksums of all IPv4 packets even if not rewritten.
+  -f, --filter        Replay only frames matching the filter expression.
+                      The filter is applied before rewriting.
+` + filterHelp()
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs ReplayCommand and returns exit status.
+func (c *ReplayCommand) Run(args []string) int {
+	var opts struct {
+		Interface    string   `short:"i" long:"interface"`
+		Speed        float64  `short:"s" long:"speed" default:"1"`
+		Rate         float64  `short:"r" long:"rate"`
+		TopSpeed     bool     `short:"t" long:"topspeed"`
+		Loop         int      `short:"l" long:"loop" default:"1"`
+		Batch        int      `long:"batch"`
+		QdiscBypass  bool     `long:"qdisc-bypass"`
+		SrcMac       string   `long:"src-mac"`
+		DstMac       string   `long:"dst-mac"`
+		MACMap       []string `long:"mac-map"`
+		IPMap        []string `long:"ip-map"`
+		VLAN         int      `long:"vlan"`
+		Priority     uint8    `long:"vlan-priority"`
+		StripVLAN    bool     `long:"strip-vlan"`
+		FixChecksums bool     `long:"fix-checksums"`
+		Filter       string   `short:"f" long:"filter"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if len(rest) != 1 {
+		lacked = append(lacked, "FILE")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+	if opts.VLAN != 0 && opts.StripVLAN {
+		fmt.Fprintf(os.Stderr, "--vlan and --strip-vlan are exclusive\n")
+		return 1
+	}
+
+	rw := &replay.Rewriter{
+		VLAN:         opts.VLAN,
+		Priority:     opts.Priority,
+		StripVLAN:    opts.StripVLAN,
+		FixChecksums: opts.FixChecksums,
+	}
+	if opts.SrcMac != "" {
+		if rw.SrcMac, err = net.ParseMAC(opts.SrcMac); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source MAC address\n")
+			return 1
+		}
+	}
+	if opts.DstMac != "" {
+		if rw.DstMac, err = net.ParseMAC(opts.DstMac); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
+			return 1
+		}
+	}
+	for _, s := range opts.MACMap {
+		m, err := replay.ParseMACMap(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		rw.MACMap = append(rw.MACMap, m)
+	}
+	for _, s := range opts.IPMap {
+		m, err := replay.ParseIPMap(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		rw.IPMap = append(rw.IPMap, m)
+	}
+
+	pf, err := newPacketFilter(opts.Filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	frames := make([]replay.Frame, 0, 1024)
+	err = readCapture(rest[0], func(frame []byte, t time.Time) {
+		if !pf.match(decode.Frame(frame)) {
+			return
+		}
+		frames = append(frames, replay.Frame{Time: t, Data: frame})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(frames) == 0 {
+		fmt.Fprintf(os.Stderr, "no Ethernet frame to replay in %s\n", rest[0])
+		return 1
+	}
+
+	p, err := replay.New(replay.Config{
+		Interface: opts.Interface,
+		Speed:     opts.Speed,
+		Rate:      opts.Rate,
+		TopSpeed:  opts.TopSpeed,
+		Loops:     opts.Loop,
+		Rewriter:  rw,
+
+		Batch:       opts.Batch,
+		QdiscBypass: opts.QdiscBypass,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := p.RingError(); err != nil {
+		fmt.Fprintf(os.Stderr, "transmit ring is not available, using sendmmsg: %v\n", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		errCh <- p.Play(frames)
+	}()
+
+	status := 0
+	select {
+	case <-sig:
+	case err := <-errCh:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			status = 1
+		}
+	}
+	p.Close()
+
+	stats := p.Stats()
+	elapsed := time.Since(start)
+	fmt.Printf("%d frames (%d bytes) sent in %s, %d failed, %d loops completed\n",
+		stats.Frames, stats.Bytes, elapsed.Round(time.Millisecond), stats.Failed, stats.Loops)
+	return status
+}
+
+// Synopsis returns one-line synopsis of ReplayCommand.
+func (c *ReplayCommand) Synopsis() string {
+	return "Replay frames in capture file with rewriting."
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/responder"
+)
+
+// RespondCommand is a command to answer ARP and ICMP Echo Request for emulated hosts.
+type RespondCommand struct{}
+
+// Help returns long-form help text of RespondCommand.
+func (c *RespondCommand) Help() string {
+	helpText := `
+Usage: nwspeaker respond [options]
+
+  Answer ARP Request and ICMP Echo Request sent to given addresses.
+  Addresses do not need to be assigned to the interface,
+  so other devices can ping hosts which exist only in nwspeaker.
+  Delay, loss and rate limit are applied only to ICMP.
+
+Options:
+  -i, --interface   Interface to listen on. Required.
+  -a, --addr        IPv4 address to answer. Can be specified multiple times.
+                    Required.
+  --mac             MAC address used in replies. Default: MAC address of the interface
+  --delay           Delay before sending Echo Reply (e.g. "100ms").
+  --loss            Probability to drop Echo Request (0 to 1).
+  --rate            Maximum number of Echo Reply per second.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs RespondCommand and returns exit status.
+func (c *RespondCommand) Run(args []string) int {
+	var opts struct {
+		Interface string        `short:"i" long:"interface"`
+		Addrs     []string      `short:"a" long:"addr"`
+		MAC       string        `long:"mac"`
+		Delay     time.Duration `long:"delay"`
+		Loss      float64       `long:"loss"`
+		Rate      int           `long:"rate"`
+	}
+	if _, err := flags.ParseArgs(&opts, args); err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if len(opts.Addrs) == 0 {
+		lacked = append(lacked, "--addr")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	cfg := responder.Config{
+		Interface: opts.Interface,
+		Delay:     opts.Delay,
+		Loss:      opts.Loss,
+		Rate:      opts.Rate,
+	}
+	for _, a := range opts.Addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			fmt.Fprintf(os.Stderr, "invalid IPv4 address '%s'\n", a)
+			return 1
+		}
+		cfg.Addrs = append(cfg.Addrs, ip)
+	}
+	if opts.MAC != "" {
+		mac, err := net.ParseMAC(opts.MAC)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid MAC address '%s'\n", opts.MAC)
+			return 1
+		}
+		cfg.MAC = mac
+	}
+
+	r, err := responder.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.Serve()
+	}()
+
+	status := 0
+	select {
+	case <-sig:
+	case err := <-errCh:
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		status = 1
+	}
+	r.Close()
+
+	stats := r.Stats()
+	fmt.Printf("%d ARP replies, %d echo replies, %d lost, %d rate limited, %d send errors\n",
+		stats.ARPReplies, stats.EchoReplies, stats.Lost, stats.RateLimited, stats.SendErrors)
+	return status
+}
+
+// Synopsis returns one-line synopsis of RespondCommand.
+func (c *RespondCommand) Synopsis() string {
+	return "Answer ARP and ICMP Echo Request for emulated hosts."
+}
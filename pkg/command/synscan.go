@@ -0,0 +1,163 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/synscan"
+)
+
+// SynScanCommand is a command to scan TCP ports with SYN.
+type SynScanCommand struct{}
+
+// Help returns long-form help text of SynScanCommand.
+func (c *SynScanCommand) Help() string {
+	helpText := `
+Usage: nwspeaker synscan [options] DST
+
+  Scan TCP ports of DST by sending SYN.
+  Ports answered with SYN/ACK are open and reset immediately, ports answered
+  with RST are closed, and ports answered with ICMP Destination Unreachable
+  or not answered at all are filtered.
+
+Options:
+  -i, --interface   Output interface. Required.
+  --dst-mac         MAC address of the next hop. Required.
+  -p, --ports       Ports to scan like "22,80,8000-8100". Default: "1-1024"
+  --src-ip          Source IP address.
+  --src-port        Source port. Default: 40000
+  -r, --rate        SYN sent per second. Default: 100
+  --retries         Number of retransmissions to ports without response. Default: 1
+  -w, --wait        Seconds to wait for responses after the last SYN. Default: 2
+  -a, --all         Show closed and filtered ports too.
+  --json            Output results in JSON.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs SynScanCommand and returns exit status.
+func (c *SynScanCommand) Run(args []string) int {
+	var opts struct {
+		Interface string  `short:"i" long:"interface"`
+		DstMac    string  `long:"dst-mac"`
+		Ports     string  `short:"p" long:"ports" default:"1-1024"`
+		SrcIP     string  `long:"src-ip"`
+		SrcPort   uint16  `long:"src-port" default:"40000"`
+		Rate      int     `short:"r" long:"rate" default:"100"`
+		Retries   int     `long:"retries" default:"1"`
+		Wait      float64 `short:"w" long:"wait" default:"2"`
+		All       bool    `short:"a" long:"all"`
+		JSON      bool    `long:"json"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if opts.DstMac == "" {
+		lacked = append(lacked, "--dst-mac")
+	}
+	if len(rest) != 1 {
+		lacked = append(lacked, "DST")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	cfg := synscan.Config{
+		Interface: opts.Interface,
+		SrcPort:   opts.SrcPort,
+		Rate:      opts.Rate,
+		Retries:   opts.Retries,
+		Timeout:   time.Duration(opts.Wait * float64(time.Second)),
+	}
+	if cfg.Dst = net.ParseIP(rest[0]); cfg.Dst == nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+		return 1
+	}
+	if cfg.DstMac, err = net.ParseMAC(opts.DstMac); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
+		return 1
+	}
+	if opts.SrcIP != "" {
+		if cfg.SrcIP = net.ParseIP(opts.SrcIP); cfg.SrcIP == nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source IP address\n")
+			return 1
+		}
+	}
+	if cfg.Ports, err = synscan.ParsePorts(opts.Ports); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	scanner, err := synscan.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer scanner.Close()
+
+	start := time.Now()
+	results, err := scanner.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode results: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	printScanResults(cfg.Dst, results, opts.All, time.Since(start))
+	return 0
+}
+
+func printScanResults(dst net.IP, results []synscan.Result, all bool, elapsed time.Duration) {
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.State]++
+	}
+
+	fmt.Printf("synscan report for %s\n", dst)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "PORT\tSTATE\tREASON\tTTL\tRTT\n")
+	for _, r := range results {
+		if !all && r.State != synscan.StateOpen {
+			continue
+		}
+		reason := r.Reason
+		if r.From != nil {
+			reason = fmt.Sprintf("%s from %s (code %d)", reason, r.From, r.Code)
+		}
+		ttl, rtt := "-", "-"
+		if r.Reason != "no-response" {
+			ttl = fmt.Sprintf("%d", r.TTL)
+			rtt = fmt.Sprintf("%.3f ms", float64(r.RTT)/float64(time.Millisecond))
+		}
+		fmt.Fprintf(w, "%d/tcp\t%s\t%s\t%s\t%s\n", r.Port, r.State, reason, ttl, rtt)
+	}
+	w.Flush()
+	fmt.Printf("%d ports scanned in %.2fs: %d open, %d closed, %d filtered\n", len(results), elapsed.Seconds(),
+		counts[synscan.StateOpen], counts[synscan.StateClosed], counts[synscan.StateFiltered])
+}
+
+// Synopsis returns one-line synopsis of SynScanCommand.
+func (c *SynScanCommand) Synopsis() string {
+	return "Scan TCP ports of the destination with SYN."
+}
@@ -0,0 +1,260 @@
+package command
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/pkg/errors"
+)
+
+// TCPCommand is a command to craft TCP segment.
+type TCPCommand struct{}
+
+// Help returns long-form help text of TCPCommand.
+func (c *TCPCommand) Help() string {
+	helpText := `
+Usage: nwspeaker tcp [options]
+
+  Craft TCP segment.
+  Any combination of flags can be set, so segments which a normal TCP stack
+  never sends (e.g. SYN+FIN, out-of-window RST) can be crafted.
+  Options are encoded in the order of --mss, --sack-permitted,
+  --timestamp, --wscale, --sack, --md5 and --option-hex.
+
+Options:
+  -i, --interface     Output interface. Required.
+  --src-mac           Source MAC address.
+  --dst-mac           Destination MAC address. Required.
+  --src-ip            Source IP address.
+  --dst-ip            Destination IP address. Required.
+  -s, --src-port      Source port. Required.
+  -d, --dst-port      Destination port. Required.
+  -f, --flags         Comma separated flags. FIN, SYN, RST, PSH, ACK, URG,
+                      ECE, CWR and NS are available. Default: "SYN"
+  --seq               Sequence number.
+  --ack               Acknowledgment number.
+  --window            Window size. Default: 65535
+  --urgent            Urgent pointer.
+  --mss               Maximum Segment Size option.
+  --wscale            Window Scale option.
+  --sack-permitted    Add SACK-Permitted option.
+  --sack              SACK option. Comma separated blocks like "100:200,300:400".
+  --timestamp         Timestamps option like "TSVAL:TSECR".
+  --md5               Add MD5 Signature option with zero-filled digest.
+  --option-hex        Raw options in hex appended after other options.
+  --data              Payload string.
+  --data-hex          Payload in hex.
+  --data-file         File which contains payload.
+  --ttl               Time To Live. Default: 64
+  --bad-checksum      Send segment with invalid checksum.
+`
+	return strings.TrimSpace(helpText)
+}
+
+type tcpOptions struct {
+	Interface     string `short:"i" long:"interface"`
+	SrcMac        string `long:"src-mac"`
+	DstMac        string `long:"dst-mac"`
+	SrcIP         string `long:"src-ip"`
+	DstIP         string `long:"dst-ip"`
+	SrcPort       uint16 `short:"s" long:"src-port"`
+	DstPort       uint16 `short:"d" long:"dst-port"`
+	Flags         string `short:"f" long:"flags" default:"SYN"`
+	Seq           uint32 `long:"seq"`
+	Ack           uint32 `long:"ack"`
+	Window        uint16 `long:"window" default:"65535"`
+	Urgent        uint16 `long:"urgent"`
+	MSS           uint16 `long:"mss"`
+	WindowScale   int    `long:"wscale" default:"-1"`
+	SACKPermitted bool   `long:"sack-permitted"`
+	SACK          string `long:"sack"`
+	Timestamp     string `long:"timestamp"`
+	MD5           bool   `long:"md5"`
+	OptionHex     string `long:"option-hex"`
+	Data          string `long:"data"`
+	DataHex       string `long:"data-hex"`
+	DataFile      string `long:"data-file"`
+	TTL           uint8  `long:"ttl" default:"64"`
+	BadChecksum   bool   `long:"bad-checksum"`
+}
+
+// Run runs TCPCommand and returns exit status.
+func (c *TCPCommand) Run(args []string) int {
+	var opts tcpOptions
+	if _, err := flags.ParseArgs(&opts, args); err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if opts.DstMac == "" {
+		lacked = append(lacked, "--dst-mac")
+	}
+	if opts.DstIP == "" {
+		lacked = append(lacked, "--dst-ip")
+	}
+	if opts.SrcPort == 0 {
+		lacked = append(lacked, "--src-port")
+	}
+	if opts.DstPort == 0 {
+		lacked = append(lacked, "--dst-port")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	seg, err := craftTCPSegment(&opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create TCP segment: %v\n", err)
+		return 1
+	}
+	dstIP := net.ParseIP(opts.DstIP)
+	if dstIP == nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+		return 1
+	}
+	dstMac, err := net.ParseMAC(opts.DstMac)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
+		return 1
+	}
+	srcIP, err := sourceIPv4(opts.Interface, opts.SrcIP)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	ipOpts := []ipv4.Option{ipv4.SetDstMac(dstMac), ipv4.SetSrcIP(srcIP), ipv4.SetTTL(opts.TTL)}
+	if opts.SrcMac != "" {
+		srcMac, err := net.ParseMAC(opts.SrcMac)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source MAC address\n")
+			return 1
+		}
+		ipOpts = append(ipOpts, ipv4.SetSrcMac(srcMac))
+	}
+
+	if seg.Len() > tcp.MaxHeaderLen {
+		fmt.Fprintf(os.Stderr, "options are too long: header length %d exceeds %d\n", seg.Len(), tcp.MaxHeaderLen)
+		return 1
+	}
+	payload := seg.Encode(srcIP, dstIP)
+	if opts.BadChecksum {
+		payload[16] ^= 0xff
+	}
+	if err := ipv4.Send(opts.Interface, dstIP, payload, ipv4.ProtoTCP, ipOpts...); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send TCP segment: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// craftTCPSegment creates TCP segment from options except addresses.
+func craftTCPSegment(opts *tcpOptions) (*tcp.Segment, error) {
+	flags, ok := tcp.ParseFlags(opts.Flags)
+	if !ok {
+		return nil, errors.Errorf("invalid flags '%s'", opts.Flags)
+	}
+	data, err := loadPayload(opts.Data, opts.DataHex, opts.DataFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payload")
+	}
+
+	options := make([]tcp.Option, 0, 8)
+	if opts.MSS != 0 {
+		options = append(options, tcp.MSSOption(opts.MSS))
+	}
+	if opts.SACKPermitted {
+		options = append(options, tcp.SACKPermittedOption())
+	}
+	if opts.Timestamp != "" {
+		val, ecr, err := parseNumberPair(opts.Timestamp)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid --timestamp")
+		}
+		options = append(options, tcp.TimestampsOption(val, ecr))
+	}
+	if opts.WindowScale >= 0 {
+		if opts.WindowScale > 0xff {
+			return nil, errors.Errorf("invalid --wscale %d", opts.WindowScale)
+		}
+		options = append(options, tcp.NOPOption(), tcp.WindowScaleOption(uint8(opts.WindowScale)))
+	}
+	if opts.SACK != "" {
+		blocks := make([]tcp.SACKBlock, 0, 4)
+		for _, s := range strings.Split(opts.SACK, ",") {
+			left, right, err := parseNumberPair(s)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid --sack")
+			}
+			blocks = append(blocks, tcp.SACKBlock{Left: left, Right: right})
+		}
+		options = append(options, tcp.NOPOption(), tcp.NOPOption(), tcp.SACKOption(blocks...))
+	}
+	if opts.MD5 {
+		options = append(options, tcp.MD5Option(nil))
+	}
+	if opts.OptionHex != "" {
+		b, err := hex.DecodeString(opts.OptionHex)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid --option-hex")
+		}
+		raw, ok := tcp.ParseOptions(b)
+		if !ok {
+			return nil, errors.New("invalid --option-hex: malformed option length")
+		}
+		options = append(options, raw...)
+	}
+	length := 0
+	for _, o := range options {
+		length += o.Len()
+	}
+	if length > tcp.MaxHeaderLen-tcp.HeaderLen {
+		return nil, errors.Errorf("options are %d bytes, but must be at most %d bytes", length, tcp.MaxHeaderLen-tcp.HeaderLen)
+	}
+
+	return &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort:       opts.SrcPort,
+			DstPort:       opts.DstPort,
+			SeqNum:        opts.Seq,
+			AckNum:        opts.Ack,
+			Flags:         flags,
+			Window:        opts.Window,
+			UrgentPointer: opts.Urgent,
+			Options:       options,
+		},
+		Data: data,
+	}, nil
+}
+
+// parseNumberPair parses string like "100:200".
+func parseNumberPair(s string) (uint32, uint32, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 {
+		return 0, 0, errors.Errorf("'%s' is not a pair of numbers", s)
+	}
+	a, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(a), uint32(b), nil
+}
+
+// Synopsis returns one-line synopsis of TCPCommand.
+func (c *TCPCommand) Synopsis() string {
+	return "Craft arbitrary TCP segment."
+}
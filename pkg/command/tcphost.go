@@ -0,0 +1,159 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/tcpstack"
+)
+
+// TCPHostCommand is a command to open or accept TCP connection as an emulated host.
+type TCPHostCommand struct{}
+
+// Help returns long-form help text of TCPHostCommand.
+func (c *TCPHostCommand) Help() string {
+	helpText := `
+Usage: nwspeaker tcp-host [options] [DST PORT]
+
+  Open TCP connection to DST:PORT, or accept one with --listen, from an
+  emulated host whose address is not assigned to the interface.
+  Data read from stdin is sent to the peer and received data is written
+  to stdout, like netcat. The emulated host also answers ARP Request.
+
+Options:
+  -i, --interface   Interface to use. Required.
+  -a, --addr        IPv4 address of the emulated host. Required.
+  --mac             MAC address of the emulated host.
+                    Default: MAC address of the interface
+  --gateway-mac     Send all segments to this MAC address instead of
+                    resolving the peer with ARP.
+  -l, --listen      Accept a connection on this port.
+  -w, --wait        Seconds to wait for the handshake to complete. Default: 5
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs TCPHostCommand and returns exit status.
+func (c *TCPHostCommand) Run(args []string) int {
+	var opts struct {
+		Interface  string  `short:"i" long:"interface"`
+		Addr       string  `short:"a" long:"addr"`
+		MAC        string  `long:"mac"`
+		GatewayMac string  `long:"gateway-mac"`
+		Listen     uint16  `short:"l" long:"listen"`
+		Wait       float64 `short:"w" long:"wait" default:"5"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if opts.Addr == "" {
+		lacked = append(lacked, "--addr")
+	}
+	if opts.Listen == 0 && len(rest) != 2 {
+		lacked = append(lacked, "DST PORT or --listen")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	cfg := tcpstack.Config{Interface: opts.Interface}
+	if cfg.Addr = net.ParseIP(opts.Addr); cfg.Addr == nil {
+		fmt.Fprintf(os.Stderr, "invalid IPv4 address '%s'\n", opts.Addr)
+		return 1
+	}
+	if opts.MAC != "" {
+		if cfg.MAC, err = net.ParseMAC(opts.MAC); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid MAC address '%s'\n", opts.MAC)
+			return 1
+		}
+	}
+	if opts.GatewayMac != "" {
+		if cfg.Gateway, err = net.ParseMAC(opts.GatewayMac); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid MAC address '%s'\n", opts.GatewayMac)
+			return 1
+		}
+	}
+
+	s, err := tcpstack.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer s.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Serve()
+	}()
+
+	var conn net.Conn
+	if opts.Listen != 0 {
+		l, err := s.Listen(opts.Listen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		conn, err = l.Accept()
+		l.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	} else {
+		dst := net.ParseIP(rest[0])
+		if dst == nil {
+			fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+			return 1
+		}
+		port, err := strconv.ParseUint(rest[1], 10, 16)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid port '%s'\n", rest[1])
+			return 1
+		}
+		conn, err = s.Dial(dst, uint16(port), time.Duration(opts.Wait*float64(time.Second)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to connect: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Fprintf(os.Stderr, "connected: %s <-> %s\n", conn.LocalAddr(), conn.RemoteAddr())
+
+	// send stdin until EOF, then wait for the peer to close
+	go func() {
+		io.Copy(conn, os.Stdin)
+		conn.(*tcpstack.Conn).CloseWrite()
+	}()
+	status := 0
+	if _, err := io.Copy(os.Stdout, conn); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		status = 1
+	}
+	conn.Close()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			status = 1
+		}
+	default:
+	}
+	return status
+}
+
+// Synopsis returns one-line synopsis of TCPHostCommand.
+func (c *TCPHostCommand) Synopsis() string {
+	return "Open or accept TCP connection as an emulated host."
+}
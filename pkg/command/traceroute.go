@@ -0,0 +1,179 @@
+package command
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/traceroute"
+)
+
+// TracerouteCommand is a command to trace the path to the destination.
+type TracerouteCommand struct{}
+
+// Help returns long-form help text of TracerouteCommand.
+func (c *TracerouteCommand) Help() string {
+	helpText := `
+Usage: nwspeaker traceroute [options] DST
+
+  Trace the path to DST.
+  All probes share the same flow identifiers (Paris traceroute),
+  so hops answered by different addresses indicate the path changed.
+
+Options:
+  -i, --interface   Output interface. Required.
+  --dst-mac         MAC address of the next hop. Required.
+  --src-ip          Source IP address.
+  -m, --mode        Probe mode. "icmp", "udp" or "tcp". Default: "icmp"
+  -f, --first-ttl   TTL of the first hop. Default: 1
+  --max-ttl         Maximum TTL. Default: 30
+  -q, --probes      Number of probes per hop. Default: 3
+  -w, --wait        Seconds to wait for each reply. Default: 3
+  -p, --port        Destination port of UDP and TCP probes.
+  --src-port        Source port of UDP and TCP probes.
+  --tos             Type of Service (DSCP and ECN) of probes.
+  --ip-option       Raw IPv4 options in hex added to probes.
+  --data-len        Length of probe payload.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// Run runs TracerouteCommand and returns exit status.
+func (c *TracerouteCommand) Run(args []string) int {
+	var opts struct {
+		Interface string  `short:"i" long:"interface"`
+		DstMac    string  `long:"dst-mac"`
+		SrcIP     string  `long:"src-ip"`
+		Mode      string  `short:"m" long:"mode" default:"icmp"`
+		FirstTTL  uint8   `short:"f" long:"first-ttl" default:"1"`
+		MaxTTL    uint8   `long:"max-ttl" default:"30"`
+		Probes    int     `short:"q" long:"probes" default:"3"`
+		Wait      float64 `short:"w" long:"wait" default:"3"`
+		Port      uint16  `short:"p" long:"port"`
+		SrcPort   uint16  `long:"src-port"`
+		TOS       uint8   `long:"tos"`
+		IPOption  string  `long:"ip-option"`
+		DataLen   int     `long:"data-len"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if opts.DstMac == "" {
+		lacked = append(lacked, "--dst-mac")
+	}
+	if len(rest) != 1 {
+		lacked = append(lacked, "DST")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	cfg := traceroute.Config{
+		Interface: opts.Interface,
+		Mode:      opts.Mode,
+		FirstTTL:  opts.FirstTTL,
+		MaxTTL:    opts.MaxTTL,
+		Probes:    opts.Probes,
+		Timeout:   time.Duration(opts.Wait * float64(time.Second)),
+		SrcPort:   opts.SrcPort,
+		DstPort:   opts.Port,
+		TOS:       opts.TOS,
+		DataLen:   opts.DataLen,
+	}
+	if cfg.Dst = net.ParseIP(rest[0]); cfg.Dst == nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+		return 1
+	}
+	if cfg.DstMac, err = net.ParseMAC(opts.DstMac); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
+		return 1
+	}
+	if opts.SrcIP != "" {
+		if cfg.SrcIP = net.ParseIP(opts.SrcIP); cfg.SrcIP == nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source IP address\n")
+			return 1
+		}
+	}
+	if opts.IPOption != "" {
+		if cfg.Options, err = hex.DecodeString(opts.IPOption); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse IPv4 options: %v\n", err)
+			return 1
+		}
+	}
+
+	tracer, err := traceroute.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer tracer.Close()
+
+	fmt.Printf("traceroute to %s, %d hops max, %s probes\n", cfg.Dst, opts.MaxTTL, opts.Mode)
+	if err := tracer.Run(printHop); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printHop(hop *traceroute.Hop) {
+	fields := []string{fmt.Sprintf("%2d", hop.TTL)}
+	var last net.IP
+	for _, r := range hop.Replies {
+		if r.Timeout {
+			fields = append(fields, "*")
+			continue
+		}
+		if !r.Addr.Equal(last) {
+			fields = append(fields, r.Addr.String())
+			last = r.Addr
+		}
+		fields = append(fields, fmt.Sprintf("%.3f ms%s", float64(r.RTT)/float64(time.Millisecond), unreachableMark(&r)))
+		for _, l := range r.MPLS {
+			fields = append(fields, fmt.Sprintf("[MPLS: Lbl %d, TC %d, S %t, TTL %d]", l.Label, l.TrafficClass, l.BottomOfStack, l.TTL))
+		}
+	}
+	if hop.PathChanged() {
+		fields = append(fields, "(path changed)")
+	}
+	fmt.Println(strings.Join(fields, "  "))
+}
+
+// unreachableMark returns the annotation used by traceroute for Destination Unreachable.
+func unreachableMark(r *traceroute.Reply) string {
+	if r.Type != icmp.TypeDestinationUnreachable {
+		return ""
+	}
+	switch r.Code {
+	case icmp.CodeNetUnreachable:
+		return " !N"
+	case icmp.CodeHostUnreachable:
+		return " !H"
+	case icmp.CodeProtocolUnreachable:
+		return " !P"
+	case icmp.CodePortUnreachable:
+		return ""
+	case icmp.CodeFragmentationNeeded:
+		return " !F"
+	case icmp.CodeCommunicationProhibited:
+		return " !X"
+	}
+	return fmt.Sprintf(" !<%d>", r.Code)
+}
+
+// Synopsis returns one-line synopsis of TracerouteCommand.
+func (c *TracerouteCommand) Synopsis() string {
+	return "Trace the path to the destination with ICMP, UDP or TCP probes."
+}
@@ -0,0 +1,179 @@
+package command
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mas9612/nwspeaker/pkg/iface"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/udp"
+	"github.com/pkg/errors"
+)
+
+// UDPCommand is a command to craft UDP datagram.
+type UDPCommand struct{}
+
+// Help returns long-form help text of UDPCommand.
+func (c *UDPCommand) Help() string {
+	helpText := `
+Usage: nwspeaker udp [options]
+
+  Craft UDP datagram.
+  Payload is given by one of --data, --data-hex and --data-file.
+  If none of them is given, the datagram has no payload.
+
+Options:
+  -i, --interface   Output interface. Required.
+  --src-mac         Source MAC address.
+  --dst-mac         Destination MAC address. Required.
+  --src-ip          Source IP address.
+  --dst-ip          Destination IP address. Required.
+  -s, --src-port    Source port. Required.
+  -d, --dst-port    Destination port. Required.
+  --data            Payload string.
+  --data-hex        Payload in hex.
+  --data-file       File which contains payload.
+  --no-checksum     Send datagram without checksum (zero).
+`
+	return strings.TrimSpace(helpText)
+}
+
+type udpOptions struct {
+	Interface  string `short:"i" long:"interface"`
+	SrcMac     string `long:"src-mac"`
+	DstMac     string `long:"dst-mac"`
+	SrcIP      string `long:"src-ip"`
+	DstIP      string `long:"dst-ip"`
+	SrcPort    uint16 `short:"s" long:"src-port"`
+	DstPort    uint16 `short:"d" long:"dst-port"`
+	Data       string `long:"data"`
+	DataHex    string `long:"data-hex"`
+	DataFile   string `long:"data-file"`
+	NoChecksum bool   `long:"no-checksum"`
+}
+
+// Run runs UDPCommand and returns exit status.
+func (c *UDPCommand) Run(args []string) int {
+	var opts udpOptions
+	if _, err := flags.ParseArgs(&opts, args); err != nil {
+		return 1
+	}
+
+	lacked := make([]string, 0, 10)
+	if opts.Interface == "" {
+		lacked = append(lacked, "--interface")
+	}
+	if opts.DstMac == "" {
+		lacked = append(lacked, "--dst-mac")
+	}
+	if opts.DstIP == "" {
+		lacked = append(lacked, "--dst-ip")
+	}
+	if opts.SrcPort == 0 {
+		lacked = append(lacked, "--src-port")
+	}
+	if opts.DstPort == 0 {
+		lacked = append(lacked, "--dst-port")
+	}
+	if len(lacked) > 0 {
+		fmt.Fprintf(os.Stderr, "%s required\n", strings.Join(lacked, ", "))
+		return 1
+	}
+
+	data, err := loadPayload(opts.Data, opts.DataHex, opts.DataFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get payload: %v\n", err)
+		return 1
+	}
+	dstIP := net.ParseIP(opts.DstIP)
+	if dstIP == nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination IP address\n")
+		return 1
+	}
+	dstMac, err := net.ParseMAC(opts.DstMac)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse destination MAC address\n")
+		return 1
+	}
+	srcIP, err := sourceIPv4(opts.Interface, opts.SrcIP)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	ipOpts := []ipv4.Option{ipv4.SetDstMac(dstMac), ipv4.SetSrcIP(srcIP)}
+	if opts.SrcMac != "" {
+		srcMac, err := net.ParseMAC(opts.SrcMac)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse source MAC address\n")
+			return 1
+		}
+		ipOpts = append(ipOpts, ipv4.SetSrcMac(srcMac))
+	}
+
+	d := udp.New(opts.SrcPort, opts.DstPort, data)
+	var payload []byte
+	if opts.NoChecksum {
+		payload = d.EncodeNoChecksum()
+	} else {
+		payload = d.Encode(srcIP, dstIP)
+	}
+	if err := ipv4.Send(opts.Interface, dstIP, payload, ipv4.ProtoUDP, ipOpts...); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send UDP datagram: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// sourceIPv4 parses addr as the source IP address.
+// If addr is empty, the IPv4 address assigned to ifname is returned.
+func sourceIPv4(ifname, addr string) (net.IP, error) {
+	if addr != "" {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, errors.Errorf("invalid IPv4 address '%s'", addr)
+		}
+		return ip, nil
+	}
+	ip, err := iface.IPv4AddressByName(ifname)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get source IP address")
+	}
+	if ip == nil {
+		return nil, errors.Errorf("no IPv4 address is assigned to \"%s\"", ifname)
+	}
+	return ip, nil
+}
+
+// loadPayload returns payload given as string, hex or file.
+// At most one of them can be specified.
+func loadPayload(str, hexStr, file string) ([]byte, error) {
+	n := 0
+	for _, s := range []string{str, hexStr, file} {
+		if s != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, errors.New("only one of --data, --data-hex and --data-file can be specified")
+	}
+
+	switch {
+	case str != "":
+		return []byte(str), nil
+	case hexStr != "":
+		return hex.DecodeString(hexStr)
+	case file != "":
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// Synopsis returns one-line synopsis of UDPCommand.
+func (c *UDPCommand) Synopsis() string {
+	return "Craft arbitrary UDP datagram."
+}
@@ -0,0 +1,234 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/mas9612/nwspeaker/pkg/arp"
+	"github.com/mas9612/nwspeaker/pkg/checksum"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/mas9612/nwspeaker/pkg/udp"
+)
+
+// Packet holds the headers decoded from a frame.
+// Layers which are not present or failed to be decoded are nil.
+type Packet struct {
+	Ethernet *ethernet.Header
+	ARP      *arp.Packet
+	IPv4     *ipv4.Packet
+	ICMP     *icmp.Message
+	UDP      *udp.Datagram
+	TCP      *tcp.Segment
+	Payload  []byte // data of the innermost decoded layer
+	Length   int    // length of the frame
+}
+
+// Frame decodes given ethernet frame with the parsers of this project.
+// If the frame is shorter than ethernet header, nil is returned.
+// Fragmented IPv4 packets other than the first fragment are not decoded further.
+func Frame(frame []byte) *Packet {
+	if len(frame) < ethernet.HeaderLen {
+		return nil
+	}
+	p := &Packet{
+		Ethernet: ethernet.Parse(frame),
+		Payload:  frame[ethernet.HeaderLen:],
+		Length:   len(frame),
+	}
+
+	switch p.Ethernet.EtherType {
+	case ethernet.TypeARP:
+		p.ARP = arp.Parse(frame)
+		if p.ARP != nil {
+			p.Payload = nil
+		}
+	case ethernet.TypeIPv4:
+		p.IPv4 = ipv4.Parse(frame[ethernet.HeaderLen:])
+		if p.IPv4 == nil {
+			return p
+		}
+		p.Payload = p.IPv4.Data
+		if p.IPv4.FlagmentOffset != 0 {
+			return p
+		}
+		switch p.IPv4.Protocol {
+		case ipv4.ProtoICMP:
+			if p.ICMP = icmp.Parse(p.IPv4.Data); p.ICMP != nil {
+				p.Payload = nil
+			}
+		case ipv4.ProtoUDP:
+			if p.UDP = udp.Parse(p.IPv4.Data); p.UDP != nil {
+				p.Payload = p.UDP.Data
+			}
+		case ipv4.ProtoTCP:
+			if p.TCP = tcp.Parse(p.IPv4.Data); p.TCP != nil {
+				p.Payload = p.TCP.Data
+			}
+		}
+	}
+	return p
+}
+
+// String returns one-line summary of p like tcpdump.
+func (p *Packet) String() string {
+	switch {
+	case p.ARP != nil:
+		return arpSummary(p.ARP)
+	case p.IPv4 != nil:
+		return ipv4Summary(p)
+	}
+	return fmt.Sprintf("%s > %s, ethertype 0x%04x, length %d", p.Ethernet.SrcAddr, p.Ethernet.DstAddr,
+		p.Ethernet.EtherType, p.Length)
+}
+
+func arpSummary(a *arp.Packet) string {
+	switch a.Op {
+	case arp.OpRequest:
+		return fmt.Sprintf("ARP, Request who-has %s tell %s (%s)", a.DstPAddr, a.SrcPAddr, a.SrcHAddr)
+	case arp.OpReply:
+		return fmt.Sprintf("ARP, Reply %s is-at %s", a.SrcPAddr, a.SrcHAddr)
+	}
+	return fmt.Sprintf("ARP, op %d %s (%s) > %s", a.Op, a.SrcPAddr, a.SrcHAddr, a.DstPAddr)
+}
+
+func ipv4Summary(p *Packet) string {
+	ip := p.IPv4
+	hdr := fmt.Sprintf("IP ttl %d id %d", ip.TimeToLive, ip.Identification)
+	if ip.Flags&ipv4.FlagDontFragment != 0 {
+		hdr += " DF"
+	}
+	if ip.Flags&ipv4.FlagMoreFragment != 0 || ip.FlagmentOffset != 0 {
+		hdr += fmt.Sprintf(" frag offset %d", int(ip.FlagmentOffset)*8)
+		if ip.Flags&ipv4.FlagMoreFragment != 0 {
+			hdr += "+"
+		}
+	}
+
+	switch {
+	case p.TCP != nil:
+		s := p.TCP
+		info := fmt.Sprintf("Flags [%s], seq %d", tcp.FlagString(s.Flags), s.SeqNum)
+		if s.HasFlags(tcp.FlagACK) {
+			info += fmt.Sprintf(", ack %d", s.AckNum)
+		}
+		info += fmt.Sprintf(", win %d", s.Window)
+		if len(s.Options) > 0 {
+			opts := make([]string, len(s.Options))
+			for i, o := range s.Options {
+				opts[i] = o.String()
+			}
+			info += ", options [" + strings.Join(opts, ",") + "]"
+		}
+		return fmt.Sprintf("%s %s.%d > %s.%d: TCP %s, length %d", hdr, ip.SrcAddress, s.SrcPort,
+			ip.DstAddress, s.DstPort, info, len(s.Data))
+	case p.UDP != nil:
+		return fmt.Sprintf("%s %s.%d > %s.%d: UDP, length %d", hdr, ip.SrcAddress, p.UDP.SrcPort,
+			ip.DstAddress, p.UDP.DstPort, len(p.UDP.Data))
+	case p.ICMP != nil:
+		return fmt.Sprintf("%s %s > %s: ICMP %s", hdr, ip.SrcAddress, ip.DstAddress, icmpSummary(p.ICMP))
+	}
+	return fmt.Sprintf("%s %s > %s: proto %d, length %d", hdr, ip.SrcAddress, ip.DstAddress, ip.Protocol, len(ip.Data))
+}
+
+func icmpSummary(m *icmp.Message) string {
+	switch d := m.Data.(type) {
+	case *icmp.Echo:
+		name := "echo request"
+		if m.Type == icmp.TypeEchoReply {
+			name = "echo reply"
+		}
+		return fmt.Sprintf("%s, id %d, seq %d, length %d", name, d.Identifier, d.SequenceNumber, len(d.Data))
+	case *icmp.Error:
+		name := fmt.Sprintf("type %d", m.Type)
+		switch m.Type {
+		case icmp.TypeDestinationUnreachable:
+			name = "unreachable"
+		case icmp.TypeTimeExceeded:
+			name = "time exceeded"
+		case icmp.TypeSourceQuench:
+			name = "source quench"
+		}
+		s := fmt.Sprintf("%s, code %d", name, m.Code)
+		if orig := ipv4.Parse(d.Original); orig != nil {
+			s += fmt.Sprintf(", original %s > %s proto %d", orig.SrcAddress, orig.DstAddress, orig.Protocol)
+		}
+		if d.NextHopMTU != 0 {
+			s += fmt.Sprintf(", mtu %d", d.NextHopMTU)
+		}
+		return s
+	case *icmp.Redirect:
+		return fmt.Sprintf("redirect, code %d, gateway %s", m.Code, d.Gateway)
+	}
+	return fmt.Sprintf("type %d, code %d", m.Type, m.Code)
+}
+
+// Tree returns multi-line description of every decoded layer of p.
+// Each layer starts with its name and the details are indented.
+// Checksums are verified unless the packet is truncated or fragmented.
+func (p *Packet) Tree() string {
+	var b bytes.Buffer
+	e := p.Ethernet
+	fmt.Fprintf(&b, "Ethernet: %s > %s, type 0x%04x, length %d\n", e.SrcAddr, e.DstAddr, e.EtherType, p.Length)
+
+	if a := p.ARP; a != nil {
+		fmt.Fprintf(&b, "ARP: htype %d, ptype 0x%04x, hlen %d, plen %d, op %d\n", a.HType, a.PType, a.HLen, a.PLen, a.Op)
+		fmt.Fprintf(&b, "    sender %s %s\n", a.SrcHAddr, a.SrcPAddr)
+		fmt.Fprintf(&b, "    target %s %s\n", a.DstHAddr, a.DstPAddr)
+	}
+
+	if ip := p.IPv4; ip != nil {
+		// the transport checksum of the first fragment covers data of the other fragments
+		truncated := int(ip.TotalLength) > ip.Len()+len(ip.Data) || ip.Flags&ipv4.FlagMoreFragment != 0
+		valid := binary.BigEndian.Uint16(ip.Header.Encode()[10:]) == ip.HeaderChecksum
+		fmt.Fprintf(&b, "IPv4: version %d, ihl %d, tos 0x%02x, length %d, id %d, flags 0x%x, offset %d, ttl %d, proto %d, checksum 0x%04x%s\n",
+			ip.Version, ip.IHL, ip.TypeOfService, ip.TotalLength, ip.Identification, ip.Flags, int(ip.FlagmentOffset)*8,
+			ip.TimeToLive, ip.Protocol, ip.HeaderChecksum, checksumStatus(valid, false))
+		fmt.Fprintf(&b, "    %s > %s\n", ip.SrcAddress, ip.DstAddress)
+		if len(ip.Options) > 0 {
+			fmt.Fprintf(&b, "    options %x\n", ip.Options)
+		}
+
+		switch {
+		case p.TCP != nil:
+			s := p.TCP
+			fmt.Fprintf(&b, "TCP: %d > %d, seq %d, ack %d, offset %d, flags [%s], win %d, checksum 0x%04x%s, urg %d\n",
+				s.SrcPort, s.DstPort, s.SeqNum, s.AckNum, s.DataOffset, tcp.FlagString(s.Flags), s.Window, s.Checksum,
+				checksumStatus(s.VerifyChecksum(ip.SrcAddress, ip.DstAddress), truncated), s.UrgentPointer)
+			for _, o := range s.Options {
+				fmt.Fprintf(&b, "    option %s\n", o)
+			}
+		case p.UDP != nil:
+			d := p.UDP
+			valid := d.Checksum == 0 || d.VerifyChecksum(ip.SrcAddress, ip.DstAddress)
+			fmt.Fprintf(&b, "UDP: %d > %d, length %d, checksum 0x%04x%s\n", d.SrcPort, d.DstPort, d.Length, d.Checksum,
+				checksumStatus(valid, truncated))
+		case p.ICMP != nil:
+			m := p.ICMP
+			valid := bytes.Equal(checksum.SumOfOnesComplement16(ip.Data), []byte{0x00, 0x00})
+			fmt.Fprintf(&b, "ICMP: type %d, code %d, checksum 0x%04x%s\n", m.Type, m.Code, m.Checksum,
+				checksumStatus(valid, truncated))
+			fmt.Fprintf(&b, "    %s\n", icmpSummary(m))
+		}
+	}
+
+	if len(p.Payload) > 0 {
+		fmt.Fprintf(&b, "Payload: %d bytes\n", len(p.Payload))
+	}
+	return b.String()
+}
+
+// checksumStatus returns the note appended to checksum fields.
+func checksumStatus(valid, truncated bool) string {
+	switch {
+	case truncated:
+		return ""
+	case valid:
+		return " (correct)"
+	}
+	return " (incorrect)"
+}
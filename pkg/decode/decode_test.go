@@ -0,0 +1,135 @@
+package decode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	tests := []struct {
+		in      []byte
+		summary string
+		layer   func(p *Packet) bool
+	}{
+		{
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06,
+				0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0xc0, 0xa8,
+				0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x02,
+			},
+			summary: "ARP, Request who-has 192.168.0.2 tell 192.168.0.1 (02:00:00:00:00:01)",
+			layer:   func(p *Packet) bool { return p.ARP != nil },
+		},
+		{
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x20, 0x00, 0x01, 0x40, 0x00, 0x40, 0x01, 0xb9, 0x88, 0xc0, 0xa8, 0x00, 0x01,
+				0xc0, 0xa8, 0x00, 0x02, 0x08, 0x00, 0x19, 0x1f, 0x00, 0x10, 0x00, 0x00, 0x70, 0x69, 0x6e, 0x67,
+			},
+			summary: "IP ttl 64 id 1 DF 192.168.0.1 > 192.168.0.2: ICMP echo request, id 16, seq 0, length 4",
+			layer:   func(p *Packet) bool { return p.ICMP != nil },
+		},
+		{
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x21, 0x00, 0x01, 0x40, 0x00, 0x40, 0x11, 0xb9, 0x77, 0xc0, 0xa8, 0x00, 0x01,
+				0xc0, 0xa8, 0x00, 0x02, 0x14, 0xe9, 0x00, 0x35, 0x00, 0x0d, 0x19, 0x7a, 0x71, 0x75, 0x65, 0x72,
+				0x79,
+			},
+			summary: "IP ttl 64 id 1 DF 192.168.0.1.5353 > 192.168.0.2.53: UDP, length 5",
+			layer:   func(p *Packet) bool { return p.UDP != nil && string(p.Payload) == "query" },
+		},
+		{
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x2c, 0x00, 0x01, 0x40, 0x00, 0x40, 0x06, 0xb9, 0x77, 0xc0, 0xa8, 0x00, 0x01,
+				0xc0, 0xa8, 0x00, 0x02, 0x9c, 0x40, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+				0x60, 0x02, 0x04, 0x00, 0x76, 0x41, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4,
+			},
+			summary: "IP ttl 64 id 1 DF 192.168.0.1.40000 > 192.168.0.2.80: TCP Flags [SYN], seq 1, win 1024, options [mss 1460], length 0",
+			layer:   func(p *Packet) bool { return p.TCP != nil },
+		},
+		{
+			// first fragment: flags 0x20 0x00 sets MF only
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x2c, 0x00, 0x02, 0x20, 0x00, 0x40, 0x06, 0xd9, 0x76, 0xc0, 0xa8, 0x00, 0x01,
+				0xc0, 0xa8, 0x00, 0x02, 0x9c, 0x40, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+				0x60, 0x02, 0x04, 0x00, 0x0d, 0xd6, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4,
+			},
+			summary: "IP ttl 64 id 2 frag offset 0+ 192.168.0.1.40000 > 192.168.0.2.80: TCP Flags [SYN], seq 1, win 1024, options [mss 1460], length 0",
+			layer:   func(p *Packet) bool { return p.TCP != nil },
+		},
+		{
+			// last fragment at offset 24
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x16, 0x00, 0x02, 0x00, 0x03, 0x40, 0x06, 0xf9, 0x89, 0xc0, 0xa8, 0x00, 0x01,
+				0xc0, 0xa8, 0x00, 0x02, 0x68, 0x69,
+			},
+			summary: "IP ttl 64 id 2 frag offset 24 192.168.0.1 > 192.168.0.2: proto 6, length 2",
+			layer:   func(p *Packet) bool { return p.TCP == nil && string(p.Payload) == "hi" },
+		},
+		{
+			in: []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x86, 0xdd,
+				0x60,
+			},
+			summary: "02:00:00:00:00:01 > ff:ff:ff:ff:ff:ff, ethertype 0x86dd, length 15",
+			layer:   func(p *Packet) bool { return p.IPv4 == nil && len(p.Payload) == 1 },
+		},
+	}
+	for _, tt := range tests {
+		p := Frame(tt.in)
+		if p == nil {
+			t.Errorf("Frame() returns nil\n")
+			continue
+		}
+		if s := p.String(); s != tt.summary {
+			t.Errorf("String() = %q, but got %q\n", tt.summary, s)
+		}
+		if !tt.layer(p) {
+			t.Errorf("%s: unexpected layers\n", tt.summary)
+		}
+	}
+
+	if Frame([]byte{0x00}) != nil {
+		t.Errorf("Frame() with truncated frame should return nil\n")
+	}
+}
+
+func TestTree(t *testing.T) {
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x2e, 0x00, 0x01, 0x40, 0x00, 0x40, 0x06, 0xb9, 0x75, 0xc0, 0xa8, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x9c, 0x40, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x60, 0x02, 0x04, 0x00, 0x0d, 0xd6, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x68, 0x69,
+	}
+	tree := Frame(frame).Tree()
+	for _, want := range []string{
+		"Ethernet: 02:00:00:00:00:01 > ff:ff:ff:ff:ff:ff, type 0x0800, length 60\n",
+		"    192.168.0.1 > 192.168.0.2\n",
+		"TCP: 40000 > 80, seq 1, ack 0, offset 6, flags [SYN], win 1024",
+		"    option mss 1460\n",
+		"Payload: 2 bytes\n",
+	} {
+		if !strings.Contains(tree, want) {
+			t.Errorf("Tree() should contain %q, but got:\n%s", want, tree)
+		}
+	}
+	if strings.Count(tree, "(correct)") != 2 {
+		t.Errorf("checksums should be correct, but got:\n%s", tree)
+	}
+
+	frame[len(frame)-1] ^= 0xff // corrupt TCP payload
+	if tree := Frame(frame).Tree(); !strings.Contains(tree, "(incorrect)") {
+		t.Errorf("TCP checksum should be incorrect, but got:\n%s", tree)
+	}
+
+	// first fragment: TCP checksum covers the data in the other fragments
+	frame[20], frame[21] = 0x20, 0x00
+	frame[24], frame[25] = 0xd9, 0x75
+	if tree := Frame(frame).Tree(); strings.Contains(tree, "(incorrect)") {
+		t.Errorf("TCP checksum of the first fragment should not be verified, but got:\n%s", tree)
+	}
+}
@@ -8,3 +8,12 @@ var (
 	// Broadcast represents the ethernet broadcast address
 	Broadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
 )
+
+// IPv4Multicast returns the ethernet multicast address mapped from given IPv4 multicast address (RFC 1112).
+func IPv4Multicast(ip net.IP) net.HardwareAddr {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil
+	}
+	return net.HardwareAddr{0x01, 0x00, 0x5e, ip4[1] & 0x7f, ip4[2], ip4[3]}
+}
@@ -1,5 +1,7 @@
 package ethernet
 
+import "time"
+
 const (
 	// EtherLen is the length of Ethernet address
 	EtherLen = 6
@@ -11,7 +13,55 @@ const (
 	TypeIPv4 = 0x0800
 	// TypeARP is the type number of ARP
 	TypeARP = 0x0806
+	// TypeVLAN is the type number of IEEE 802.1Q VLAN tag.
+	TypeVLAN = 0x8100
+	// TypeAll is the protocol number used to receive frames of all types.
+	// It is not a valid type number in the ethernet header.
+	TypeAll = 0x0003
+
+	// VLANTagLen is the length of IEEE 802.1Q VLAN tag.
+	VLANTagLen = 4
 
 	// BufferLen is the length of buffer length which is used when receive data.
 	BufferLen = 1500
+	// MaxFrameLen is the default maximum number of bytes received by RecvFrame.
+	// It is enough for a frame with 64KiB IP packet.
+	MaxFrameLen = 65536 + HeaderLen + VLANTagLen
+)
+
+const (
+	// DefaultRingBlockSize is the default size of each block of Ring.
+	DefaultRingBlockSize = 1 << 20
+	// DefaultRingBlockCount is the default number of blocks of Ring.
+	DefaultRingBlockCount = 8
+	// DefaultRingTimeout is the default time after which the kernel passes
+	// a partially filled block to Ring.
+	DefaultRingTimeout = 100 * time.Millisecond
+
+	// ringFrameSize is the nominal frame size given to the kernel.
+	// TPACKET_V3 packs frames of any length into blocks, so it is only used for validation.
+	ringFrameSize = 2048
+)
+
+// offsets in struct tpacket_block_desc
+const (
+	blockStatusOffset      = 8
+	blockNumPacketsOffset  = 12
+	blockFirstPacketOffset = 16
+)
+
+const (
+	// DefaultTxFrameSize is the default size of each slot of the transmit ring.
+	// It holds a frame of 1500 bytes MTU with VLAN tag.
+	DefaultTxFrameSize = 2048
+	// DefaultTxFrameCount is the default number of slots of the transmit ring.
+	DefaultTxFrameCount = 512
+
+	// txDataOffset is the offset of the frame in a slot of the transmit ring,
+	// TPACKET_ALIGN(sizeof(struct tpacket2_hdr)).
+	txDataOffset = 32
+	// txLenOffset is the offset of tp_len in struct tpacket2_hdr.
+	txLenOffset = 4
+	// txRetryInterval is the interval to retry sending when the driver queue is full.
+	txRetryInterval = 100 * time.Microsecond
 )
@@ -1,8 +1,14 @@
 package ethernet
 
 import (
+	"context"
 	"encoding/binary"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/mas9612/nwspeaker/pkg/endian"
 	"github.com/pkg/errors"
@@ -37,33 +43,161 @@ func Parse(b []byte) *Header {
 	return h
 }
 
+// Frame returns ethernet frame which has given header fields and payload.
+func Frame(dst, src net.HardwareAddr, etherType uint16, payload []byte) []byte {
+	hdr := Header{
+		DstAddr:   dst,
+		SrcAddr:   src,
+		EtherType: etherType,
+	}
+	frame := make([]byte, HeaderLen+len(payload))
+	copy(frame, hdr.Encode())
+	copy(frame[HeaderLen:], payload)
+	return frame
+}
+
 // Payload represents the application data of ethernet packet.
 type Payload interface {
 	Encode() []byte
 }
 
+// ErrClosed is returned by the methods of Socket after Close is called.
+// Operations blocked in another goroutine also return it when the socket is closed.
+var ErrClosed = errors.New("use of closed socket")
+
+// aLongTimeAgo is a deadline in the past used to interrupt blocked operations.
+var aLongTimeAgo = time.Unix(1, 0)
+
 // Socket represents an ethernet socket used to send or receive data.
+// The socket is non-blocking and waits through the Go runtime poller, so it supports
+// deadlines, context and Close from another goroutine.
 type Socket struct {
-	fd    int
-	proto uint16
-	iface *net.Interface
+	file        *os.File
+	rc          syscall.RawConn
+	proto       uint16
+	iface       *net.Interface
+	recvTimeout time.Duration
+	closed      int32
+	closeOnce   sync.Once
+
+	// used by RecvFrame
+	auxdata bool
+	rbuf    []byte
+	oob     []byte
 }
 
 // Dial returns new Socket instance.
 func Dial(proto uint16) (*Socket, error) {
-	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(proto))
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(proto))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open raw socket")
 	}
+	file := os.NewFile(uintptr(fd), "packet")
+	rc, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to get raw connection")
+	}
 	return &Socket{
-		fd:    fd,
+		file:  file,
+		rc:    rc,
 		proto: proto,
 	}, nil
 }
 
+// control calls fn with the file descriptor of the socket.
+func (s *Socket) control(fn func(fd int) error) error {
+	var opErr error
+	if err := s.rc.Control(func(fd uintptr) {
+		opErr = fn(int(fd))
+	}); err != nil {
+		return s.closedErr(err)
+	}
+	return opErr
+}
+
+// read calls fn with the file descriptor of the socket, waiting until the socket is
+// readable while fn returns EAGAIN.
+func (s *Socket) read(fn func(fd int) error) error {
+	var opErr error
+	if err := s.rc.Read(func(fd uintptr) bool {
+		opErr = fn(int(fd))
+		return opErr != unix.EAGAIN
+	}); err != nil {
+		return s.closedErr(err)
+	}
+	return opErr
+}
+
+// write is the same as read but waits until the socket is writable.
+func (s *Socket) write(fn func(fd int) error) error {
+	var opErr error
+	if err := s.rc.Write(func(fd uintptr) bool {
+		opErr = fn(int(fd))
+		return opErr != unix.EAGAIN
+	}); err != nil {
+		return s.closedErr(err)
+	}
+	return opErr
+}
+
+// closedErr returns ErrClosed instead of err if the socket is closed.
+func (s *Socket) closedErr(err error) error {
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return ErrClosed
+	}
+	return err
+}
+
+// sendto sends frame to sa, waiting until the socket is writable.
+func (s *Socket) sendto(frame []byte, flags int, sa unix.Sockaddr) error {
+	return s.write(func(fd int) error {
+		return unix.Sendto(fd, frame, flags, sa)
+	})
+}
+
+// recvfrom receives a frame into b, waiting until the socket is readable.
+func (s *Socket) recvfrom(b []byte, flags int) (int, unix.Sockaddr, error) {
+	var (
+		n  int
+		sa unix.Sockaddr
+	)
+	err := s.read(func(fd int) error {
+		var err error
+		n, sa, err = unix.Recvfrom(fd, b, flags)
+		return err
+	})
+	return n, sa, err
+}
+
+// Listen returns Socket bound to ifname which sends and receives frames of proto.
+// proto is in host byte order. With TypeAll, frames of every type are received.
+func Listen(ifname string, proto uint16) (*Socket, error) {
+	oif, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface information")
+	}
+	s, err := Dial(endian.Htons(proto))
+	if err != nil {
+		return nil, err
+	}
+	addr := &unix.SockaddrLinklayer{
+		Protocol: endian.Htons(proto),
+		Ifindex:  oif.Index,
+		Halen:    EtherLen,
+	}
+	if err := s.Bind(addr); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
 // Bind binds interface to Socket instance.
 func (s *Socket) Bind(sa unix.Sockaddr) error {
-	if err := unix.Bind(s.fd, sa); err != nil {
+	if err := s.control(func(fd int) error {
+		return unix.Bind(fd, sa)
+	}); err != nil {
 		return errors.Wrap(err, "failed to bind interface to socket")
 	}
 	iface, err := net.InterfaceByIndex(sa.(*unix.SockaddrLinklayer).Ifindex)
@@ -98,76 +232,349 @@ func (s *Socket) Send(payload Payload, flags int, dst string) error {
 	}
 	copy(frame, hdr.Encode())
 
-	if err := unix.Sendto(s.fd, frame, flags, sa); err != nil {
+	if err := s.sendto(frame, flags, sa); err != nil {
 		return errors.Wrap(err, "send failed")
 	}
-	return nil
+	return record(frame, s.iface, true)
+}
+
+// SendFrame sends given frame as it is.
+// frame must include ethernet header.
+func (s *Socket) SendFrame(frame []byte, flags int) error {
+	if len(frame) < HeaderLen {
+		return errors.New("frame is shorter than ethernet header")
+	}
+	sa := &unix.SockaddrLinklayer{
+		Protocol: s.proto,
+		Ifindex:  s.iface.Index,
+		Halen:    EtherLen,
+	}
+	copy(sa.Addr[:], frame[0:EtherLen])
+
+	if err := s.sendto(frame, flags, sa); err != nil {
+		return errors.Wrap(err, "send failed")
+	}
+	return record(frame, s.iface, true)
+}
+
+// SendFrameContext is the same as SendFrame but gives up when ctx is done.
+// It uses the write deadline of the socket, which is cleared when it returns.
+func (s *Socket) SendFrameContext(ctx context.Context, frame []byte, flags int) error {
+	return withContext(ctx, s.SetWriteDeadline, func() error {
+		return s.SendFrame(frame, flags)
+	})
 }
 
-// Recv receives data from socket.
+// Recv receives a frame from socket and returns the received bytes.
+// Frames longer than the MTU of the bound interface are truncated.
+// Use RecvFrame to get the metadata of the frame too.
 func (s *Socket) Recv(flags int) ([]byte, error) {
-	buffer := make([]byte, BufferLen)
-	_, _, err := unix.Recvfrom(s.fd, buffer, flags)
+	if err := s.applyRecvTimeout(); err != nil {
+		return nil, err
+	}
+	return s.recv(flags)
+}
+
+// RecvContext is the same as Recv but gives up when ctx is done.
+// It uses the read deadline of the socket instead of the timeout set by SetRecvTimeout,
+// and the deadline is cleared when it returns.
+func (s *Socket) RecvContext(ctx context.Context, flags int) ([]byte, error) {
+	var b []byte
+	err := withContext(ctx, s.SetReadDeadline, func() error {
+		var err error
+		b, err = s.recv(flags)
+		return err
+	})
+	return b, err
+}
+
+func (s *Socket) recv(flags int) ([]byte, error) {
+	buffer := make([]byte, s.recvLen())
+	n, _, err := s.recvfrom(buffer, flags)
 	if err != nil {
 		return nil, errors.Wrap(err, "recv failed")
 	}
-	return buffer, nil
+	if n > len(buffer) { // MSG_TRUNC returns the real length
+		n = len(buffer)
+	}
+	if err := record(buffer[:n], s.iface, false); err != nil {
+		return nil, err
+	}
+	return buffer[:n], nil
 }
 
-// Close closes socket.
-func (s *Socket) Close() error {
-	return unix.Close(s.fd)
+// recvLen returns the buffer length for a frame of the bound interface.
+func (s *Socket) recvLen() int {
+	if s.iface == nil {
+		return MaxFrameLen
+	}
+	return s.iface.MTU + HeaderLen + VLANTagLen
 }
 
-// Option is option which is used to send ethernet frame.
-type Option func(*config)
+// RecvFrom receives a frame into b and returns the length of the frame and the address it came from.
+// The direction of the frame can be known from Pkttype of the address.
+// With unix.MSG_TRUNC in flags, n is the original length even if the frame is truncated to len(b).
+func (s *Socket) RecvFrom(b []byte, flags int) (int, *unix.SockaddrLinklayer, error) {
+	if err := s.applyRecvTimeout(); err != nil {
+		return 0, nil, err
+	}
+	n, sa, err := s.recvfrom(b, flags)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "recv failed")
+	}
+	from, ok := sa.(*unix.SockaddrLinklayer)
+	if !ok {
+		return 0, nil, errors.New("unexpected source address")
+	}
+	captured := n
+	if captured > len(b) {
+		captured = len(b)
+	}
+	if err := record(b[:captured], s.iface, from.Pkttype == unix.PACKET_OUTGOING); err != nil {
+		return 0, nil, err
+	}
+	return n, from, nil
+}
 
-type config struct {
-	srcMac net.HardwareAddr
+// SocketStats represents the counters kept by the kernel for the socket.
+type SocketStats struct {
+	Received uint32 // frames passed to the socket
+	Dropped  uint32 // frames dropped because the receive buffer was full
+	Frozen   uint32 // times the receive ring was full. only counted by Ring
 }
 
-// Send sends ethernet packet to given dst with given payload
-func Send(outIfname string, dst net.HardwareAddr, payload Payload, proto uint16, opts ...Option) error {
-	c := config{}
-	for _, o := range opts {
-		o(&c)
+// Stats returns the counters since the last call of Stats.
+// The kernel resets the counters every time they are read.
+func (s *Socket) Stats() (*SocketStats, error) {
+	var st *unix.TpacketStats
+	if err := s.control(func(fd int) error {
+		var err error
+		st, err = unix.GetsockoptTpacketStats(fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to get socket statistics")
 	}
+	// tp_packets includes dropped frames
+	return &SocketStats{Received: st.Packets - st.Drops, Dropped: st.Drops}, nil
+}
 
-	oif, err := net.InterfaceByName(outIfname)
-	if err != nil {
-		return errors.Wrap(err, "failed to get out iface info")
+// SetRecvTimeout sets the timeout of each call of Recv, RecvFrom and RecvFrame.
+// When it expires, they return an error for which IsTimeout returns true.
+// While it is set, the read deadline is overwritten on every call.
+// If d is zero, Recv blocks until data is received or the read deadline is reached.
+func (s *Socket) SetRecvTimeout(d time.Duration) error {
+	if d < 0 {
+		return errors.Errorf("invalid receive timeout %s", d)
 	}
-	if c.srcMac == nil {
-		c.srcMac = oif.HardwareAddr
+	s.recvTimeout = d
+	return nil
+}
+
+// applyRecvTimeout sets the read deadline from the timeout set by SetRecvTimeout.
+func (s *Socket) applyRecvTimeout() error {
+	if s.recvTimeout == 0 {
+		return nil
 	}
+	return s.SetReadDeadline(time.Now().Add(s.recvTimeout))
+}
 
-	header := Header{
-		SrcAddr:   c.srcMac,
-		DstAddr:   dst,
-		EtherType: proto,
+// SetDeadline sets both the read and write deadlines of the socket.
+// A zero value of t means no deadline.
+func (s *Socket) SetDeadline(t time.Time) error {
+	if err := s.file.SetDeadline(t); err != nil {
+		return errors.Wrap(s.closedErr(err), "failed to set deadline")
 	}
+	return nil
+}
 
-	rawPayload := payload.Encode()
-	packetLen := HeaderLen + len(rawPayload)
-	packet := make([]byte, packetLen)
-	copy(packet[0:], header.Encode())
-	copy(packet[HeaderLen:], payload.Encode())
+// SetReadDeadline sets the deadline of receiving. Recv blocked in another goroutine
+// returns a timeout error as soon as the deadline is reached.
+func (s *Socket) SetReadDeadline(t time.Time) error {
+	if err := s.file.SetReadDeadline(t); err != nil {
+		return errors.Wrap(s.closedErr(err), "failed to set read deadline")
+	}
+	return nil
+}
 
-	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(proto))
-	if err != nil {
-		return errors.Wrap(err, "failed to open socket")
+// SetWriteDeadline sets the deadline of sending.
+func (s *Socket) SetWriteDeadline(t time.Time) error {
+	if err := s.file.SetWriteDeadline(t); err != nil {
+		return errors.Wrap(s.closedErr(err), "failed to set write deadline")
 	}
-	addr := &unix.SockaddrLinklayer{
-		Protocol: proto,
-		Ifindex:  oif.Index,
-		Halen:    EtherLen,
+	return nil
+}
+
+// IsTimeout reports whether err is caused by a timeout of the socket,
+// the deadline or the context.
+func IsTimeout(err error) bool {
+	cause := errors.Cause(err)
+	if errno, ok := cause.(unix.Errno); ok {
+		return errno == unix.EAGAIN || errno == unix.EWOULDBLOCK
 	}
-	copy(addr.Addr[:], dst[:])
+	return os.IsTimeout(cause)
+}
 
-	err = unix.Sendto(fd, packet, 0, addr)
-	if err != nil {
-		return errors.Wrap(err, "failed to send data")
+// withContext calls fn with the deadline of ctx set by setDeadline, and interrupts fn
+// by moving the deadline to the past when ctx is canceled.
+func withContext(ctx context.Context, setDeadline func(time.Time) error, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if err := setDeadline(deadline); err != nil {
+		return err
+	}
+	defer setDeadline(time.Time{})
+
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			select {
+			case <-ctx.Done():
+				setDeadline(aLongTimeAgo)
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	err := fn()
+	if err != nil && IsTimeout(err) {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if hasDeadline { // poller fired slightly before ctx
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+// SetPromiscuous enables or disables promiscuous mode of the bound interface.
+// Promiscuous mode is disabled automatically when the socket is closed.
+func (s *Socket) SetPromiscuous(on bool) error {
+	if s.iface == nil {
+		return errors.New("socket is not bound to interface")
+	}
+	mreq := &unix.PacketMreq{
+		Ifindex: int32(s.iface.Index),
+		Type:    unix.PACKET_MR_PROMISC,
+	}
+	opt := unix.PACKET_ADD_MEMBERSHIP
+	if !on {
+		opt = unix.PACKET_DROP_MEMBERSHIP
+	}
+	if err := s.control(func(fd int) error {
+		return unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, opt, mreq)
+	}); err != nil {
+		return errors.Wrap(err, "failed to set promiscuous mode")
 	}
+	return nil
+}
+
+// JoinMulticast makes the bound interface receive frames sent to the multicast address mac.
+// The membership is dropped automatically when the socket is closed.
+func (s *Socket) JoinMulticast(mac net.HardwareAddr) error {
+	if s.iface == nil {
+		return errors.New("socket is not bound to interface")
+	}
+	if len(mac) != EtherLen {
+		return errors.Errorf("invalid MAC address %s", mac)
+	}
+	mreq := &unix.PacketMreq{
+		Ifindex: int32(s.iface.Index),
+		Type:    unix.PACKET_MR_MULTICAST,
+		Alen:    EtherLen,
+	}
+	copy(mreq.Address[:], mac)
+	if err := s.control(func(fd int) error {
+		return unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq)
+	}); err != nil {
+		return errors.Wrapf(err, "failed to join multicast group %s", mac)
+	}
+	return nil
+}
 
+// SetBPF attaches the classic BPF program to the socket, so that the kernel drops
+// frames for which the program returns zero. Frames already queued before the program
+// is attached are discarded, so Recv returns only frames accepted by the program.
+func (s *Socket) SetBPF(prog []unix.SockFilter) error {
+	if len(prog) == 0 {
+		return errors.New("empty BPF program")
+	}
+	// attach a program which rejects everything first, and drain the queue
+	drop := []unix.SockFilter{{Code: unix.BPF_RET | unix.BPF_K, K: 0}}
+	if err := s.attachBPF(drop); err != nil {
+		return err
+	}
+	buffer := make([]byte, 1)
+	s.control(func(fd int) error {
+		for {
+			if _, _, err := unix.Recvfrom(fd, buffer, unix.MSG_DONTWAIT|unix.MSG_TRUNC); err != nil {
+				return nil
+			}
+		}
+	})
+	return s.attachBPF(prog)
+}
+
+func (s *Socket) attachBPF(prog []unix.SockFilter) error {
+	fprog := &unix.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+	if err := s.control(func(fd int) error {
+		return unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, fprog)
+	}); err != nil {
+		return errors.Wrap(err, "failed to attach BPF program")
+	}
 	return nil
 }
+
+// DetachBPF removes the BPF program attached by SetBPF.
+func (s *Socket) DetachBPF() error {
+	if err := s.control(func(fd int) error {
+		return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DETACH_FILTER, 0)
+	}); err != nil {
+		return errors.Wrap(err, "failed to detach BPF program")
+	}
+	return nil
+}
+
+// Close closes socket. It is safe to call Close while another goroutine is blocked
+// in Recv or Send; they return ErrClosed. Calling Close twice returns ErrClosed.
+func (s *Socket) Close() error {
+	err := ErrClosed
+	s.closeOnce.Do(func() {
+		atomic.StoreInt32(&s.closed, 1)
+		err = s.file.Close()
+	})
+	return err
+}
+
+// Option is option which is used to send ethernet frame.
+type Option func(*config)
+
+type config struct {
+	srcMac net.HardwareAddr
+}
+
+// SetSrcMac sets the source MAC address.
+// If not set, the address of the output interface is used.
+func SetSrcMac(src net.HardwareAddr) Option {
+	return func(c *config) {
+		c.srcMac = src
+	}
+}
+
+// Send sends ethernet packet to given dst with given payload.
+// The socket for outIfname and proto is opened on the first call and reused
+// until CloseSockets is called.
+func Send(outIfname string, dst net.HardwareAddr, payload Payload, proto uint16, opts ...Option) error {
+	return defaultManager.Send(outIfname, dst, payload, proto, opts...)
+}
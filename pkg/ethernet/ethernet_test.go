@@ -2,9 +2,15 @@ package ethernet
 
 import (
 	"bytes"
+	"context"
 	"net"
+	"os"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 )
 
 var headerEncodeTests = []struct {
@@ -52,3 +58,162 @@ func TestHeaderParse(t *testing.T) {
 		}
 	}
 }
+
+var ipv4MulticastTests = []struct {
+	in  net.IP
+	out net.HardwareAddr
+}{
+	{net.IPv4(224, 0, 0, 1), net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01}},
+	{net.IPv4(239, 255, 1, 2), net.HardwareAddr{0x01, 0x00, 0x5e, 0x7f, 0x01, 0x02}},
+}
+
+func TestIPv4Multicast(t *testing.T) {
+	for _, tt := range ipv4MulticastTests {
+		mac := IPv4Multicast(tt.in)
+		if !bytes.Equal(mac, tt.out) {
+			t.Errorf("IPv4Multicast(%s) = %s, but got %s\n", tt.in, tt.out, mac)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	dst := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	src := net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}
+	b := Frame(dst, src, TypeARP, []byte{0xaa, 0xbb})
+	want := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x08, 0x06, 0xaa, 0xbb}
+	if !bytes.Equal(b, want) {
+		t.Errorf("Frame() = %x, but got %x\n", want, b)
+	}
+}
+
+func TestInsertVLANTag(t *testing.T) {
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66,
+		0x08, 0x06, 0xaa, 0xbb,
+	}
+	b := insertVLANTag(frame, TypeVLAN, 0x200a)
+	want := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66,
+		0x81, 0x00, 0x20, 0x0a, 0x08, 0x06, 0xaa, 0xbb,
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("insertVLANTag() = %x, but got %x\n", want, b)
+	}
+	if frame[12] != 0x08 {
+		t.Errorf("insertVLANTag() modified the original frame\n")
+	}
+}
+
+var packetTypeStringTests = []struct {
+	in  PacketType
+	out string
+}{
+	{PacketHost, "host"},
+	{PacketBroadcast, "broadcast"},
+	{PacketMulticast, "multicast"},
+	{PacketOtherHost, "otherhost"},
+	{PacketOutgoing, "outgoing"},
+	{PacketType(10), "unknown"},
+}
+
+func TestPacketTypeString(t *testing.T) {
+	for _, tt := range packetTypeStringTests {
+		if s := tt.in.String(); s != tt.out {
+			t.Errorf("PacketType(%d).String() = %s, but got %s\n", tt.in, tt.out, s)
+		}
+	}
+}
+
+// timeoutError imitates the error returned by the runtime poller when the deadline passes.
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+var isTimeoutTests = []struct {
+	in  error
+	out bool
+}{
+	{errors.Wrap(unix.EAGAIN, "recv failed"), true},
+	{errors.Wrap(&os.PathError{Op: "read", Path: "packet", Err: timeoutError{}}, "recv failed"), true},
+	{context.DeadlineExceeded, true},
+	{context.Canceled, false},
+	{errors.Wrap(ErrClosed, "recv failed"), false},
+	{unix.ENETDOWN, false},
+}
+
+func TestIsTimeout(t *testing.T) {
+	for _, tt := range isTimeoutTests {
+		if b := IsTimeout(tt.in); b != tt.out {
+			t.Errorf("IsTimeout(%v) = %v, but got %v\n", tt.in, tt.out, b)
+		}
+	}
+}
+
+// fakeDeadline imitates the deadline of the runtime poller.
+type fakeDeadline struct {
+	set chan time.Time
+}
+
+func (d *fakeDeadline) setDeadline(t time.Time) error {
+	d.set <- t
+	return nil
+}
+
+// wait blocks until the deadline is moved to the past like a blocked recv.
+func (d *fakeDeadline) wait() error {
+	for t := range d.set {
+		if !t.IsZero() && t.Before(time.Now()) {
+			return timeoutError{}
+		}
+	}
+	return nil
+}
+
+func TestWithContext(t *testing.T) {
+	d := &fakeDeadline{set: make(chan time.Time, 4)}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := withContext(ctx, d.setDeadline, d.wait)
+	if err != context.Canceled {
+		t.Errorf("withContext() = %v, but got %v\n", context.Canceled, err)
+	}
+	if last := <-d.set; !last.IsZero() {
+		t.Errorf("withContext() did not clear the deadline: %v\n", last)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := withContext(ctx, d.setDeadline, d.wait); err != context.Canceled {
+		t.Errorf("withContext() with canceled context = %v, but got %v\n", context.Canceled, err)
+	}
+}
+
+func TestRecv(t *testing.T) {
+	const testType = 0x88b5 // local experimental ethertype
+	s, err := Listen("lo", testType)
+	if err != nil {
+		t.Skipf("socket on lo is not available: %v", err)
+	}
+	defer s.Close()
+	if err := s.SetRecvTimeout(time.Second); err != nil {
+		t.Fatalf("SetRecvTimeout() returns error: %v\n", err)
+	}
+
+	for _, n := range []int{60, 3000} { // longer than BufferLen
+		frame := Frame(Broadcast, net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}, testType, make([]byte, n-HeaderLen))
+		if err := s.SendFrame(frame, 0); err != nil {
+			t.Fatalf("SendFrame() returns error: %v\n", err)
+		}
+		b, err := s.Recv(0)
+		if err != nil {
+			t.Fatalf("Recv() returns error: %v\n", err)
+		}
+		if !bytes.Equal(b, frame) {
+			t.Errorf("Recv() = %d bytes, but got %d bytes\n", len(frame), len(b))
+		}
+	}
+}
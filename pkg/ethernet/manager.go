@@ -0,0 +1,98 @@
+package ethernet
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Manager keeps one socket bound to each pair of interface and protocol, so that
+// sending many frames does not open a new socket for every frame.
+// It is safe for concurrent use.
+type Manager struct {
+	mu      sync.Mutex
+	sockets map[socketKey]*Socket
+}
+
+type socketKey struct {
+	ifname string
+	proto  uint16
+}
+
+// NewManager returns new Manager instance.
+func NewManager() *Manager {
+	return &Manager{
+		sockets: make(map[socketKey]*Socket),
+	}
+}
+
+// Socket returns the socket bound to ifname for proto, opening it on the first call.
+// proto is in host byte order. The socket is only for sending; received frames are
+// dropped by the kernel. It must not be closed by the caller.
+func (m *Manager) Socket(ifname string, proto uint16) (*Socket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := socketKey{ifname: ifname, proto: proto}
+	if s, ok := m.sockets[key]; ok {
+		return s, nil
+	}
+
+	s, err := Listen(ifname, proto)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is never read, so keep the kernel from queuing frames to it
+	drop := []unix.SockFilter{{Code: unix.BPF_RET | unix.BPF_K, K: 0}}
+	if err := s.attachBPF(drop); err != nil {
+		s.Close()
+		return nil, err
+	}
+	m.sockets[key] = s
+	return s, nil
+}
+
+// Send sends ethernet frame to dst with given payload through the cached socket.
+// proto is in host byte order.
+func (m *Manager) Send(outIfname string, dst net.HardwareAddr, payload Payload, proto uint16, opts ...Option) error {
+	c := config{}
+	for _, o := range opts {
+		o(&c)
+	}
+
+	s, err := m.Socket(outIfname, proto)
+	if err != nil {
+		return err
+	}
+	if c.srcMac == nil {
+		c.srcMac = s.iface.HardwareAddr
+	}
+	return s.SendFrame(Frame(dst, c.srcMac, proto, payload.Encode()), 0)
+}
+
+// Close closes every cached socket. Sends blocked in another goroutine return ErrClosed.
+// The Manager can be used again after Close; sockets are opened again on demand.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var first error
+	for key, s := range m.sockets {
+		if err := s.Close(); err != nil && first == nil {
+			first = errors.Wrapf(err, "failed to close socket for %s", key.ifname)
+		}
+		delete(m.sockets, key)
+	}
+	return first
+}
+
+// defaultManager is used by Send.
+var defaultManager = NewManager()
+
+// CloseSockets closes the sockets cached by Send.
+// It should be called when the program does not send frames anymore.
+func CloseSockets() error {
+	return defaultManager.Close()
+}
@@ -0,0 +1,118 @@
+package ethernet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mas9612/nwspeaker/pkg/endian"
+	"golang.org/x/sys/unix"
+)
+
+// benchPayload is a minimum sized payload used for benchmarks.
+type benchPayload []byte
+
+func (p benchPayload) Encode() []byte {
+	return p
+}
+
+// testManager returns Manager which can open a socket on the loopback interface,
+// or skips the test if raw sockets are not permitted.
+func testManager(tb testing.TB) *Manager {
+	m := NewManager()
+	if _, err := m.Socket("lo", TypeIPv4); err != nil {
+		tb.Skipf("raw socket is not available: %v", err)
+	}
+	return m
+}
+
+func TestManagerSocket(t *testing.T) {
+	m := testManager(t)
+	defer m.Close()
+
+	s1, _ := m.Socket("lo", TypeIPv4)
+	s2, err := m.Socket("lo", TypeIPv4)
+	if err != nil {
+		t.Fatalf("Socket() failed: %v", err)
+	}
+	if s1 != s2 {
+		t.Errorf("Socket() returned different sockets for the same interface and protocol\n")
+	}
+	s3, err := m.Socket("lo", TypeARP)
+	if err != nil {
+		t.Fatalf("Socket() failed: %v", err)
+	}
+	if s3 == s1 {
+		t.Errorf("Socket() returned the same socket for different protocols\n")
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if err := s1.SendFrame(make([]byte, HeaderLen), 0); err == nil {
+		t.Errorf("SendFrame() succeeded on the closed socket\n")
+	}
+	s4, err := m.Socket("lo", TypeIPv4)
+	if err != nil {
+		t.Fatalf("Socket() after Close() failed: %v", err)
+	}
+	if s4 == s1 {
+		t.Errorf("Socket() returned the closed socket\n")
+	}
+}
+
+var benchDst = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+
+// BenchmarkSendDial measures the former behavior of Send, which opened a new socket for every frame.
+func BenchmarkSendDial(b *testing.B) {
+	testManager(b).Close()
+	oif, err := net.InterfaceByName("lo")
+	if err != nil {
+		b.Fatal(err)
+	}
+	frame := Frame(benchDst, oif.HardwareAddr, TypeIPv4, make(benchPayload, 46))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s, err := Dial(endian.Htons(TypeIPv4))
+		if err != nil {
+			b.Fatal(err)
+		}
+		addr := &unix.SockaddrLinklayer{
+			Protocol: endian.Htons(TypeIPv4),
+			Ifindex:  oif.Index,
+			Halen:    EtherLen,
+		}
+		if err := s.Bind(addr); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.SendFrame(frame, 0); err != nil {
+			b.Fatal(err)
+		}
+		s.Close()
+	}
+}
+
+func BenchmarkSendManager(b *testing.B) {
+	m := testManager(b)
+	defer m.Close()
+	payload := make(benchPayload, 46)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.Send("lo", benchDst, payload, TypeIPv4); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendManagerParallel(b *testing.B) {
+	m := testManager(b)
+	defer m.Close()
+	payload := make(benchPayload, 46)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := m.Send("lo", benchDst, payload, TypeIPv4); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package ethernet
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Recorder records frames sent and received by this package.
+// pcap.Writer implements this interface.
+type Recorder interface {
+	WritePacket(t time.Time, frame []byte) error
+}
+
+// FrameInfo is the metadata of a recorded frame.
+type FrameInfo struct {
+	Time      time.Time
+	Interface *net.Interface // nil if unknown
+	Outbound  bool           // true if the frame was sent
+	Comment   string
+}
+
+// InfoRecorder is a Recorder which also keeps the metadata of frames.
+// If the recorder set by SetRecorder implements it, WriteFrame is used instead of WritePacket.
+// pcapng.Writer implements this interface.
+type InfoRecorder interface {
+	Recorder
+	WriteFrame(frame []byte, info *FrameInfo) error
+}
+
+var (
+	recorder      Recorder
+	recordComment string
+)
+
+// SetRecorder sets r to record every frame sent by Send, Socket.Send and Socket.SendFrame
+// and received by Socket.Recv. If r is nil, recording is disabled.
+// It must be called before any frame is sent or received.
+func SetRecorder(r Recorder) {
+	recorder = r
+}
+
+// SetRecordComment sets the comment attached to every recorded frame sent by this package.
+// It is used to annotate frames with what they were meant to test.
+// Comments are kept only by InfoRecorder.
+func SetRecordComment(comment string) {
+	recordComment = comment
+}
+
+// record passes frame to the recorder if it is set.
+func record(frame []byte, ifi *net.Interface, outbound bool) error {
+	if recorder == nil {
+		return nil
+	}
+	var err error
+	if r, ok := recorder.(InfoRecorder); ok {
+		info := &FrameInfo{
+			Time:      time.Now(),
+			Interface: ifi,
+			Outbound:  outbound,
+		}
+		if outbound {
+			info.Comment = recordComment
+		}
+		err = r.WriteFrame(frame, info)
+	} else {
+		err = recorder.WritePacket(time.Now(), frame)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to record frame")
+	}
+	return nil
+}
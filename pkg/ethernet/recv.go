@@ -0,0 +1,207 @@
+package ethernet
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// PacketType is the type of a received frame given by the kernel.
+type PacketType uint8
+
+const (
+	// PacketHost is a frame sent to this host.
+	PacketHost PacketType = unix.PACKET_HOST
+	// PacketBroadcast is a frame sent to the broadcast address.
+	PacketBroadcast PacketType = unix.PACKET_BROADCAST
+	// PacketMulticast is a frame sent to a multicast address.
+	PacketMulticast PacketType = unix.PACKET_MULTICAST
+	// PacketOtherHost is a frame sent to another host, received in promiscuous mode.
+	PacketOtherHost PacketType = unix.PACKET_OTHERHOST
+	// PacketOutgoing is a frame sent by this host.
+	PacketOutgoing PacketType = unix.PACKET_OUTGOING
+)
+
+const (
+	sizeofAuxdata  = int(unsafe.Sizeof(unix.TpacketAuxdata{}))
+	sizeofTimespec = int(unsafe.Sizeof(unix.Timespec{}))
+)
+
+var packetTypeNames = map[PacketType]string{
+	PacketHost:      "host",
+	PacketBroadcast: "broadcast",
+	PacketMulticast: "multicast",
+	PacketOtherHost: "otherhost",
+	PacketOutgoing:  "outgoing",
+}
+
+func (t PacketType) String() string {
+	if name, ok := packetTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ReceivedFrame is a frame received by RecvFrame with the metadata given by the kernel.
+type ReceivedFrame struct {
+	Data      []byte     // received bytes. VLAN tag stripped by the NIC is restored.
+	Interface int        // index of the interface the frame was received on
+	Type      PacketType // direction and destination of the frame
+	Time      time.Time  // timestamp taken by the kernel
+	Length    int        // original length of the frame
+	Truncated bool       // Data is shorter than Length
+
+	// ChecksumNotReady is set if the checksum of the frame is left to the NIC and
+	// has not been computed yet. It is common for outgoing frames.
+	ChecksumNotReady bool
+}
+
+// Outbound reports whether the frame was sent by this host.
+func (f *ReceivedFrame) Outbound() bool {
+	return f.Type == PacketOutgoing
+}
+
+// SetSnapLen sets the maximum number of bytes received by RecvFrame from each frame.
+// Longer frames are truncated. If n is zero, MaxFrameLen is used.
+func (s *Socket) SetSnapLen(n int) error {
+	if n < 0 {
+		return errors.Errorf("invalid snap length %d", n)
+	}
+	if n == 0 {
+		n = MaxFrameLen
+	}
+	s.rbuf = make([]byte, n)
+	return nil
+}
+
+// RecvFrame receives a frame with its metadata.
+// Unlike Recv, the frame is not truncated at the MTU unless SetSnapLen is called.
+// RecvFrame must not be called from multiple goroutines at the same time.
+func (s *Socket) RecvFrame(flags int) (*ReceivedFrame, error) {
+	if err := s.applyRecvTimeout(); err != nil {
+		return nil, err
+	}
+	return s.recvFrame(flags)
+}
+
+// RecvFrameContext is the same as RecvFrame but gives up when ctx is done.
+// It uses the read deadline of the socket instead of the timeout set by SetRecvTimeout,
+// and the deadline is cleared when it returns.
+func (s *Socket) RecvFrameContext(ctx context.Context, flags int) (*ReceivedFrame, error) {
+	var f *ReceivedFrame
+	err := withContext(ctx, s.SetReadDeadline, func() error {
+		var err error
+		f, err = s.recvFrame(flags)
+		return err
+	})
+	return f, err
+}
+
+func (s *Socket) recvFrame(flags int) (*ReceivedFrame, error) {
+	if !s.auxdata {
+		if err := s.enableAuxdata(); err != nil {
+			return nil, err
+		}
+	}
+	if s.rbuf == nil {
+		s.rbuf = make([]byte, MaxFrameLen)
+	}
+	var (
+		n, oobn int
+		sa      unix.Sockaddr
+	)
+	err := s.read(func(fd int) error {
+		var err error
+		n, oobn, _, sa, err = unix.Recvmsg(fd, s.rbuf, s.oob, flags|unix.MSG_TRUNC)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "recv failed")
+	}
+	from, ok := sa.(*unix.SockaddrLinklayer)
+	if !ok {
+		return nil, errors.New("unexpected source address")
+	}
+	captured := n
+	if captured > len(s.rbuf) {
+		captured = len(s.rbuf)
+	}
+
+	f := &ReceivedFrame{
+		Interface: from.Ifindex,
+		Type:      PacketType(from.Pkttype),
+		Time:      time.Now(), // replaced by the kernel timestamp below
+		Length:    n,
+	}
+	msgs, err := unix.ParseSocketControlMessage(s.oob[:oobn])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse control messages")
+	}
+	var aux *unix.TpacketAuxdata
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.SOL_PACKET && m.Header.Type == unix.PACKET_AUXDATA:
+			if len(m.Data) >= sizeofAuxdata {
+				aux = (*unix.TpacketAuxdata)(unsafe.Pointer(&m.Data[0]))
+			}
+		case m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SCM_TIMESTAMPNS:
+			if len(m.Data) >= sizeofTimespec {
+				ts := (*unix.Timespec)(unsafe.Pointer(&m.Data[0]))
+				f.Time = time.Unix(ts.Unix())
+			}
+		}
+	}
+
+	if aux != nil && aux.Status&unix.TP_STATUS_VLAN_VALID != 0 && captured >= EtherLen*2 {
+		tpid := uint16(TypeVLAN)
+		if aux.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+			tpid = aux.Vlan_tpid
+		}
+		f.Data = insertVLANTag(s.rbuf[:captured], tpid, aux.Vlan_tci)
+		f.Length += VLANTagLen
+	} else {
+		f.Data = make([]byte, captured)
+		copy(f.Data, s.rbuf)
+	}
+	if aux != nil {
+		f.ChecksumNotReady = aux.Status&unix.TP_STATUS_CSUMNOTREADY != 0
+	}
+	f.Truncated = len(f.Data) < f.Length
+
+	if err := record(f.Data, s.iface, f.Outbound()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// enableAuxdata asks the kernel to attach PACKET_AUXDATA and timestamp to every frame.
+func (s *Socket) enableAuxdata() error {
+	if err := s.control(func(fd int) error {
+		return unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_AUXDATA, 1)
+	}); err != nil {
+		return errors.Wrap(err, "failed to enable PACKET_AUXDATA")
+	}
+	if err := s.control(func(fd int) error {
+		return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1)
+	}); err != nil {
+		return errors.Wrap(err, "failed to enable SO_TIMESTAMPNS")
+	}
+	s.oob = make([]byte, unix.CmsgSpace(sizeofAuxdata)+unix.CmsgSpace(sizeofTimespec))
+	s.auxdata = true
+	return nil
+}
+
+// insertVLANTag returns a copy of frame with 802.1Q tag inserted after the MAC addresses.
+// frame must have at least both MAC addresses.
+func insertVLANTag(frame []byte, tpid, tci uint16) []byte {
+	b := make([]byte, len(frame)+VLANTagLen)
+	copy(b, frame[:EtherLen*2])
+	binary.BigEndian.PutUint16(b[EtherLen*2:], tpid)
+	binary.BigEndian.PutUint16(b[EtherLen*2+2:], tci)
+	copy(b[EtherLen*2+VLANTagLen:], frame[EtherLen*2:])
+	return b
+}
@@ -0,0 +1,240 @@
+package ethernet
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// RingConfig is the configuration of Ring.
+type RingConfig struct {
+	BlockSize  int           // size of each block. must be a multiple of the page size. zero means DefaultRingBlockSize
+	BlockCount int           // number of blocks. zero means DefaultRingBlockCount
+	Timeout    time.Duration // partially filled block is passed after this. zero means DefaultRingTimeout
+}
+
+// validate fills default values and checks c.
+func (c *RingConfig) validate() error {
+	if c.BlockSize == 0 {
+		c.BlockSize = DefaultRingBlockSize
+	}
+	if c.BlockCount == 0 {
+		c.BlockCount = DefaultRingBlockCount
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultRingTimeout
+	}
+	if c.BlockSize < 0 || c.BlockSize%os.Getpagesize() != 0 {
+		return errors.Errorf("block size %d is not a multiple of the page size %d", c.BlockSize, os.Getpagesize())
+	}
+	if c.BlockCount < 0 {
+		return errors.Errorf("invalid block count %d", c.BlockCount)
+	}
+	if c.Timeout < time.Millisecond {
+		return errors.Errorf("block timeout %s is shorter than 1ms", c.Timeout)
+	}
+	if int64(c.BlockSize)*int64(c.BlockCount) > 1<<32-1 {
+		return errors.New("ring is too large")
+	}
+	return nil
+}
+
+// RingFrame is a frame in the ring. Data refers to the memory shared with the kernel
+// and is valid only until the next call of Ring.Next. Use Copy to keep the frame.
+type RingFrame struct {
+	Data      []byte // VLAN tag stripped by the NIC is not included. See VLANValid.
+	Interface int
+	Type      PacketType
+	Time      time.Time
+	Length    int // original length of the frame without the stripped VLAN tag
+	Truncated bool
+
+	ChecksumNotReady bool
+	VLANValid        bool // the NIC stripped the VLAN tag given by VLANTPID and VLANTCI
+	VLANTPID         uint16
+	VLANTCI          uint16
+}
+
+// Outbound reports whether the frame was sent by this host.
+func (f *RingFrame) Outbound() bool {
+	return f.Type == PacketOutgoing
+}
+
+// Copy returns the copy of f with the stripped VLAN tag restored.
+func (f *RingFrame) Copy() *ReceivedFrame {
+	rf := &ReceivedFrame{
+		Interface:        f.Interface,
+		Type:             f.Type,
+		Time:             f.Time,
+		Length:           f.Length,
+		ChecksumNotReady: f.ChecksumNotReady,
+	}
+	if f.VLANValid && len(f.Data) >= EtherLen*2 {
+		rf.Data = insertVLANTag(f.Data, f.VLANTPID, f.VLANTCI)
+		rf.Length += VLANTagLen
+	} else {
+		rf.Data = make([]byte, len(f.Data))
+		copy(rf.Data, f.Data)
+	}
+	rf.Truncated = len(rf.Data) < rf.Length
+	return rf
+}
+
+// Ring receives frames through the TPACKET_V3 ring memory-mapped from the kernel.
+// The kernel fills blocks of frames without a system call for each frame.
+// Frames received by Ring are not recorded by the recorder set by SetRecorder.
+type Ring struct {
+	sock *Socket
+	cfg  RingConfig
+
+	mu        sync.Mutex
+	mem       []byte
+	index     int    // index of the current block
+	block     []byte // current block owned by user space, nil if not owned
+	remaining int    // frames left in the current block
+	offset    int    // offset of the next frame in the current block
+	frame     RingFrame
+}
+
+// NewRing sets up the receive ring on s and returns Ring reading it.
+// After NewRing, frames are received only by Ring, not by Recv or RecvFrame.
+// The deadline and the timeout of s apply to Ring.Next.
+func NewRing(s *Socket, cfg RingConfig) (*Ring, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	req := &unix.TpacketReq3{
+		Block_size:     uint32(cfg.BlockSize),
+		Block_nr:       uint32(cfg.BlockCount),
+		Frame_size:     ringFrameSize,
+		Frame_nr:       uint32(cfg.BlockSize / ringFrameSize * cfg.BlockCount),
+		Retire_blk_tov: uint32(cfg.Timeout / time.Millisecond),
+	}
+	var mem []byte
+	err := s.control(func(fd int) error {
+		if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+			return errors.Wrap(err, "failed to set TPACKET_V3")
+		}
+		if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, req); err != nil {
+			return errors.Wrap(err, "failed to set up receive ring")
+		}
+		var err error
+		mem, err = unix.Mmap(fd, 0, cfg.BlockSize*cfg.BlockCount, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+		if err != nil {
+			return errors.Wrap(err, "failed to map receive ring")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Ring{
+		sock: s,
+		cfg:  cfg,
+		mem:  mem,
+	}, nil
+}
+
+// Next returns the next frame in the ring, waiting until the kernel passes a block.
+// The returned frame is reused and is valid only until the next call of Next.
+// Next must not be called from multiple goroutines at the same time.
+func (r *Ring) Next() (*RingFrame, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mem == nil {
+		return nil, ErrClosed
+	}
+
+	if r.remaining == 0 {
+		if err := r.sock.applyRecvTimeout(); err != nil {
+			return nil, err
+		}
+	}
+	for r.remaining == 0 {
+		if r.block != nil { // all frames are read, give the block back to the kernel
+			atomic.StoreUint32(blockWord(r.block, blockStatusOffset), unix.TP_STATUS_KERNEL)
+			r.block = nil
+			r.index = (r.index + 1) % r.cfg.BlockCount
+		}
+		block := r.mem[r.index*r.cfg.BlockSize : (r.index+1)*r.cfg.BlockSize]
+		if err := r.sock.read(func(fd int) error {
+			if atomic.LoadUint32(blockWord(block, blockStatusOffset))&unix.TP_STATUS_USER == 0 {
+				return unix.EAGAIN
+			}
+			return nil
+		}); err != nil {
+			return nil, errors.Wrap(err, "recv failed")
+		}
+		r.block = block
+		r.remaining = int(*blockWord(block, blockNumPacketsOffset))
+		r.offset = int(*blockWord(block, blockFirstPacketOffset))
+	}
+
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&r.block[r.offset]))
+	sll := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&r.block[r.offset+unix.SizeofTpacket3Hdr]))
+	start := r.offset + int(hdr.Mac)
+	r.frame = RingFrame{
+		Data:             r.block[start : start+int(hdr.Snaplen)],
+		Interface:        int(sll.Ifindex),
+		Type:             PacketType(sll.Pkttype),
+		Time:             time.Unix(int64(hdr.Sec), int64(hdr.Nsec)),
+		Length:           int(hdr.Len),
+		Truncated:        hdr.Snaplen < hdr.Len,
+		ChecksumNotReady: hdr.Status&unix.TP_STATUS_CSUMNOTREADY != 0,
+	}
+	if hdr.Status&unix.TP_STATUS_VLAN_VALID != 0 {
+		r.frame.VLANValid = true
+		r.frame.VLANTCI = uint16(hdr.Hv1.Vlan_tci)
+		r.frame.VLANTPID = TypeVLAN
+		if hdr.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+			r.frame.VLANTPID = hdr.Hv1.Vlan_tpid
+		}
+	}
+	r.remaining--
+	r.offset += int(hdr.Next_offset)
+	return &r.frame, nil
+}
+
+// Stats returns the counters since the last call of Stats or Socket.Stats.
+// Frozen is the number of times the ring was full and the kernel dropped frames.
+func (r *Ring) Stats() (*SocketStats, error) {
+	var st *unix.TpacketStatsV3
+	if err := r.sock.control(func(fd int) error {
+		var err error
+		st, err = unix.GetsockoptTpacketStatsV3(fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to get socket statistics")
+	}
+	return &SocketStats{
+		Received: st.Packets - st.Drops,
+		Dropped:  st.Drops,
+		Frozen:   st.Freeze_q_cnt,
+	}, nil
+}
+
+// Close closes the socket and unmaps the ring. Next blocked in another goroutine
+// returns ErrClosed. Frames returned by Next must not be used after Close.
+func (r *Ring) Close() error {
+	err := r.sock.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mem != nil {
+		if uerr := unix.Munmap(r.mem); uerr != nil && err == nil {
+			err = errors.Wrap(uerr, "failed to unmap receive ring")
+		}
+		r.mem = nil
+		r.block = nil
+	}
+	return err
+}
+
+// blockWord returns the pointer to the field of the block descriptor at offset.
+func blockWord(block []byte, offset int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&block[offset]))
+}
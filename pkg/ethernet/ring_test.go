@@ -0,0 +1,109 @@
+package ethernet
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/endian"
+	"golang.org/x/sys/unix"
+)
+
+var ringConfigTests = []struct {
+	in RingConfig
+	ok bool
+}{
+	{RingConfig{}, true},
+	{RingConfig{BlockSize: os.Getpagesize() * 4, BlockCount: 2, Timeout: 10 * time.Millisecond}, true},
+	{RingConfig{BlockSize: os.Getpagesize() + 1}, false},
+	{RingConfig{BlockCount: -1}, false},
+	{RingConfig{Timeout: time.Microsecond}, false},
+	{RingConfig{BlockSize: 1 << 30, BlockCount: 8}, false},
+}
+
+func TestRingConfig(t *testing.T) {
+	for _, tt := range ringConfigTests {
+		c := tt.in
+		err := c.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v, but got %v\n", tt.in, tt.ok, err)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	m := testManager(t)
+	defer m.Close()
+
+	oif, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Dial(endian.Htons(TypeAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRing(s, RingConfig{BlockSize: os.Getpagesize() * 2, BlockCount: 8, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		s.Close()
+		t.Fatalf("NewRing() failed: %v", err)
+	}
+	defer r.Close()
+	addr := &unix.SockaddrLinklayer{
+		Protocol: endian.Htons(TypeAll),
+		Ifindex:  oif.Index,
+		Halen:    EtherLen,
+	}
+	if err := s.Bind(addr); err != nil {
+		t.Fatal(err)
+	}
+	s.SetRecvTimeout(2 * time.Second)
+
+	// more frames than one block can hold, so that blocks are given back to the kernel
+	payload := bytes.Repeat([]byte{0x5a}, 1000)
+	const sent = 16
+	for i := 0; i < sent; i++ {
+		payload[0] = byte(i)
+		if err := m.Send("lo", benchDst, benchPayload(payload), TypeIPv4); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lo receives every frame twice: outgoing and incoming
+	got := 0
+	for got < sent*2 {
+		f, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next() failed after %d frames: %v", got, err)
+		}
+		if len(f.Data) < HeaderLen+len(payload) || !bytes.Equal(f.Data[0:EtherLen], benchDst) {
+			continue // other traffic on lo
+		}
+		if want := byte(got / 2); f.Data[HeaderLen] != want {
+			t.Errorf("frame %d has sequence %d, but got %d\n", got, want, f.Data[HeaderLen])
+		}
+		if want := got%2 == 0; f.Outbound() != want {
+			t.Errorf("frame %d Outbound() = %v, but got %v\n", got, want, f.Outbound())
+		}
+		if f.Length != len(f.Data) || f.Truncated || f.Interface != oif.Index {
+			t.Errorf("frame %d has unexpected metadata %+v\n", got, f)
+		}
+		got++
+	}
+
+	st, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Dropped != 0 {
+		t.Errorf("Stats() reported %d dropped frames\n", st.Dropped)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != ErrClosed {
+		t.Errorf("Next() after Close() = %v, but got %v\n", ErrClosed, err)
+	}
+}
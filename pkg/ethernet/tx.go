@@ -0,0 +1,342 @@
+package ethernet
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// TxConfig is the configuration of Transmitter.
+type TxConfig struct {
+	FrameSize   int  // size of each slot of the ring. zero means DefaultTxFrameSize
+	FrameCount  int  // number of slots of the ring. zero means DefaultTxFrameCount
+	QdiscBypass bool // pass frames to the driver directly, skipping traffic control
+	Sendmmsg    bool // use sendmmsg instead of the ring
+}
+
+// validate fills default values and checks c.
+func (c *TxConfig) validate() error {
+	if c.FrameSize == 0 {
+		c.FrameSize = DefaultTxFrameSize
+	}
+	if c.FrameCount == 0 {
+		c.FrameCount = DefaultTxFrameCount
+	}
+	if c.FrameSize <= txDataOffset+HeaderLen || c.FrameSize%unix.TPACKET_ALIGNMENT != 0 {
+		return errors.Errorf("invalid frame size %d", c.FrameSize)
+	}
+	if c.FrameCount < 0 {
+		return errors.Errorf("invalid frame count %d", c.FrameCount)
+	}
+	return nil
+}
+
+// errRetry is returned inside Transmitter when the driver dropped frames and they
+// should be sent again later.
+var errRetry = errors.New("driver queue is full")
+
+// Transmitter sends batches of frames through PACKET_TX_RING, or sendmmsg if the ring
+// is not available or TxConfig.Sendmmsg is set. Either way one system call sends many
+// frames. Frames sent by Transmitter are not recorded by the recorder set by SetRecorder.
+type Transmitter struct {
+	sock   *Socket
+	cfg    TxConfig
+	maxLen int // longest frame accepted
+
+	mu       sync.Mutex
+	mem      []byte // nil if sendmmsg is used
+	ringErr  error  // why the ring could not be set up
+	perBlock int    // slots in a block
+	block    int    // size of a block
+	index    int    // next slot to fill
+	inflight []int  // slots requested to send but not taken by the kernel yet, in order
+}
+
+// NewTransmitter sets up the transmit ring on s and returns Transmitter using it.
+// s must be bound to an interface. The write deadline of s applies to SendBatch.
+func NewTransmitter(s *Socket, cfg TxConfig) (*Transmitter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if s.iface == nil {
+		return nil, errors.New("socket is not bound to interface")
+	}
+	t := &Transmitter{
+		sock:   s,
+		cfg:    cfg,
+		maxLen: s.iface.MTU + HeaderLen + VLANTagLen,
+	}
+	if cfg.QdiscBypass {
+		if err := s.control(func(fd int) error {
+			return unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_QDISC_BYPASS, 1)
+		}); err != nil {
+			return nil, errors.Wrap(err, "failed to enable PACKET_QDISC_BYPASS")
+		}
+	}
+	if !cfg.Sendmmsg {
+		if err := t.setupRing(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// setupRing maps the transmit ring. Slots are packed into blocks of pages.
+// If the kernel refuses the ring, t.mem is left nil, the reason is kept in t.ringErr
+// and sendmmsg is used instead. It returns error only when the socket is left with
+// a ring which cannot be used.
+func (t *Transmitter) setupRing() error {
+	pagesize := os.Getpagesize()
+	t.block = (t.cfg.FrameSize + pagesize - 1) / pagesize * pagesize
+	t.perBlock = t.block / t.cfg.FrameSize
+	blocks := (t.cfg.FrameCount + t.perBlock - 1) / t.perBlock
+	t.cfg.FrameCount = blocks * t.perBlock
+	req := &unix.TpacketReq{
+		Block_size: uint32(t.block),
+		Block_nr:   uint32(blocks),
+		Frame_size: uint32(t.cfg.FrameSize),
+		Frame_nr:   uint32(t.cfg.FrameCount),
+	}
+	return t.sock.control(func(fd int) error {
+		if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V2); err != nil {
+			t.ringErr = errors.Wrap(err, "failed to set PACKET_VERSION")
+			return nil
+		}
+		// skip malformed frames instead of stopping the ring at them
+		if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_LOSS, 1); err != nil {
+			t.ringErr = errors.Wrap(err, "failed to set PACKET_LOSS")
+			return nil
+		}
+		if err := unix.SetsockoptTpacketReq(fd, unix.SOL_PACKET, unix.PACKET_TX_RING, req); err != nil {
+			t.ringErr = errors.Wrap(err, "failed to set PACKET_TX_RING")
+			return nil
+		}
+		mem, err := unix.Mmap(fd, 0, t.block*blocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+		if err != nil {
+			// once the ring exists, send takes frames from it. release it so that sendmmsg works.
+			if rerr := unix.SetsockoptTpacketReq(fd, unix.SOL_PACKET, unix.PACKET_TX_RING, &unix.TpacketReq{}); rerr != nil {
+				return errors.Wrapf(rerr, "failed to release transmit ring which could not be mapped (%v)", err)
+			}
+			t.ringErr = errors.Wrap(err, "failed to map transmit ring")
+			return nil
+		}
+		t.mem = mem
+		if limit := t.cfg.FrameSize - txDataOffset; limit < t.maxLen {
+			t.maxLen = limit
+		}
+		return nil
+	})
+}
+
+// UsesRing reports whether frames are sent through the ring rather than sendmmsg.
+func (t *Transmitter) UsesRing() bool {
+	return t.mem != nil
+}
+
+// RingError returns the reason why the ring is not used.
+// It is nil if the ring is used or TxConfig.Sendmmsg is set.
+func (t *Transmitter) RingError() error {
+	return t.ringErr
+}
+
+// SendBatch sends frames and returns the number of frames taken by the kernel.
+// When the ring or the socket buffer is full, it waits until the kernel sends queued
+// frames or the write deadline of the socket is reached. It stops at the first frame
+// which cannot be sent, so frames[:n] are queued even if err is not nil.
+func (t *Transmitter) SendBatch(frames [][]byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var invalid error
+	for i, f := range frames {
+		if len(f) < HeaderLen || len(f) > t.maxLen {
+			invalid = errors.Errorf("frame %d has invalid length %d", i, len(f))
+			frames = frames[:i]
+			break
+		}
+	}
+	if len(frames) == 0 {
+		return 0, invalid
+	}
+
+	var (
+		n   int
+		err error
+	)
+	if t.mem != nil {
+		n, err = t.sendRing(frames)
+	} else {
+		n, err = t.sendmmsg(frames)
+	}
+	if err != nil {
+		return n, errors.Wrap(err, "send failed")
+	}
+	return n, invalid
+}
+
+// SendBatchContext is the same as SendBatch but gives up when ctx is done.
+// It uses the write deadline of the socket, which is cleared when it returns.
+func (t *Transmitter) SendBatchContext(ctx context.Context, frames [][]byte) (int, error) {
+	var n int
+	err := withContext(ctx, t.sock.SetWriteDeadline, func() error {
+		var err error
+		n, err = t.SendBatch(frames)
+		return err
+	})
+	return n, err
+}
+
+// sendRing copies frames to the ring and asks the kernel to send them.
+func (t *Transmitter) sendRing(frames [][]byte) (int, error) {
+	queued := 0
+	for _, f := range frames {
+		slot := t.slot(t.index)
+		if atomic.LoadUint32(slotStatus(slot)) != unix.TP_STATUS_AVAILABLE { // ring is full
+			n, err := t.flush(func() bool {
+				return atomic.LoadUint32(slotStatus(slot)) == unix.TP_STATUS_AVAILABLE
+			})
+			queued += n
+			if err != nil {
+				return queued, t.abort(err)
+			}
+		}
+		copy(slot[txDataOffset:], f)
+		*(*uint32)(unsafe.Pointer(&slot[txLenOffset])) = uint32(len(f))
+		atomic.StoreUint32(slotStatus(slot), unix.TP_STATUS_SEND_REQUEST)
+		t.inflight = append(t.inflight, t.index)
+		t.index = (t.index + 1) % t.cfg.FrameCount
+	}
+	n, err := t.flush(func() bool {
+		return len(t.inflight) == 0
+	})
+	queued += n
+	if err != nil {
+		return queued, t.abort(err)
+	}
+	return queued, nil
+}
+
+// flush asks the kernel to send requested frames until done returns true, waiting
+// for the socket to be writable. It returns the number of frames taken by the kernel.
+func (t *Transmitter) flush(done func() bool) (int, error) {
+	taken := 0
+	for {
+		err := t.sock.write(func(fd int) error {
+			if len(t.inflight) > 0 {
+				_, _, errno := unix.Syscall6(unix.SYS_SENDTO, uintptr(fd), 0, 0, unix.MSG_DONTWAIT, 0, 0)
+				// frames left in the ring are sent by the next call
+				if errno != 0 && errno != unix.EAGAIN && errno != unix.ENOBUFS {
+					return errno
+				}
+				taken += t.reap()
+				if errno == unix.ENOBUFS && !done() {
+					return errRetry
+				}
+			}
+			if done() {
+				return nil
+			}
+			return unix.EAGAIN
+		})
+		if err != errRetry {
+			return taken, err
+		}
+		time.Sleep(txRetryInterval)
+	}
+}
+
+// reap removes the slots taken by the kernel from inflight and returns the number of them.
+func (t *Transmitter) reap() int {
+	n := 0
+	for n < len(t.inflight) && atomic.LoadUint32(slotStatus(t.slot(t.inflight[n]))) != unix.TP_STATUS_SEND_REQUEST {
+		n++
+	}
+	t.inflight = t.inflight[n:]
+	return n
+}
+
+// abort withdraws frames not taken by the kernel so that they are not sent later
+// and are not counted as queued. The kernel waits at the first of them.
+func (t *Transmitter) abort(err error) error {
+	if len(t.inflight) > 0 {
+		t.index = t.inflight[0]
+		for _, i := range t.inflight {
+			atomic.StoreUint32(slotStatus(t.slot(i)), unix.TP_STATUS_AVAILABLE)
+		}
+		t.inflight = t.inflight[:0]
+	}
+	return err
+}
+
+// slot returns the memory of i-th slot of the ring.
+func (t *Transmitter) slot(i int) []byte {
+	off := i/t.perBlock*t.block + i%t.perBlock*t.cfg.FrameSize
+	return t.mem[off : off+t.cfg.FrameSize]
+}
+
+// mmsghdr is struct mmsghdr of sendmmsg(2).
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// sendmmsg sends frames with as few system calls as possible.
+func (t *Transmitter) sendmmsg(frames [][]byte) (int, error) {
+	iovs := make([]unix.Iovec, len(frames))
+	msgs := make([]mmsghdr, len(frames))
+	for i, f := range frames {
+		iovs[i].Base = &f[0]
+		iovs[i].SetLen(len(f))
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.Iovlen = 1
+	}
+	sent := 0
+	for sent < len(msgs) {
+		err := t.sock.write(func(fd int) error {
+			n, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd),
+				uintptr(unsafe.Pointer(&msgs[sent])), uintptr(len(msgs)-sent), 0, 0, 0)
+			if errno == unix.ENOBUFS {
+				return errRetry
+			}
+			if errno != 0 {
+				return errno
+			}
+			sent += int(n)
+			return nil
+		})
+		if err == errRetry {
+			time.Sleep(txRetryInterval)
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// Close closes the socket and unmaps the ring. SendBatch blocked in another goroutine
+// returns ErrClosed.
+func (t *Transmitter) Close() error {
+	err := t.sock.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mem != nil {
+		if uerr := unix.Munmap(t.mem); uerr != nil && err == nil {
+			err = errors.Wrap(uerr, "failed to unmap transmit ring")
+		}
+		t.mem = nil
+	}
+	return err
+}
+
+// slotStatus returns the pointer to tp_status of the slot.
+func slotStatus(slot []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&slot[0]))
+}
@@ -0,0 +1,194 @@
+package ethernet
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+var txConfigTests = []struct {
+	in TxConfig
+	ok bool
+}{
+	{TxConfig{}, true},
+	{TxConfig{FrameSize: 4096, FrameCount: 3}, true},
+	{TxConfig{FrameSize: 2050}, false},
+	{TxConfig{FrameSize: 32}, false},
+	{TxConfig{FrameCount: -1}, false},
+}
+
+func TestTxConfig(t *testing.T) {
+	for _, tt := range txConfigTests {
+		c := tt.in
+		err := c.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v, but got %v\n", tt.in, tt.ok, err)
+		}
+	}
+}
+
+// bindTestSocket returns the socket bound to ifname for every protocol,
+// or skips the test if raw sockets are not permitted.
+func bindTestSocket(tb testing.TB, ifname string) *Socket {
+	s, err := Listen(ifname, TypeAll)
+	if err != nil {
+		tb.Skipf("socket on %s is not available: %v", ifname, err)
+	}
+	return s
+}
+
+// testFrames returns n frames of length bytes, numbered in the first byte of the payload.
+func testFrames(n, length int) [][]byte {
+	frames := make([][]byte, n)
+	for i := range frames {
+		payload := make([]byte, length-HeaderLen)
+		payload[0] = byte(i)
+		frames[i] = Frame(benchDst, net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}, TypeIPv4, payload)
+	}
+	return frames
+}
+
+var transmitterTests = []TxConfig{
+	{FrameCount: 16}, // smaller than the batch to wait for the kernel
+	{FrameCount: 16, QdiscBypass: true},
+	{Sendmmsg: true},
+}
+
+func TestTransmitter(t *testing.T) {
+	for _, cfg := range transmitterTests {
+		recv := bindTestSocket(t, "lo")
+		recv.SetRecvTimeout(100 * time.Millisecond)
+		tx, err := NewTransmitter(bindTestSocket(t, "lo"), cfg)
+		if err != nil {
+			recv.Close()
+			t.Fatalf("NewTransmitter(%+v) failed: %v", cfg, err)
+		}
+		if tx.UsesRing() == cfg.Sendmmsg {
+			t.Errorf("NewTransmitter(%+v).UsesRing() = %v\n", cfg, !cfg.Sendmmsg)
+		}
+
+		frames := testFrames(40, 60)
+		n, err := tx.SendBatch(frames)
+		if n != len(frames) || err != nil {
+			t.Errorf("SendBatch() with %+v = (%d, nil), but got (%d, %v)\n", cfg, len(frames), n, err)
+		}
+		got := 0
+		for {
+			f, err := recv.RecvFrame(0)
+			if err != nil {
+				break
+			}
+			if f.Outbound() || !bytes.Equal(f.Data[0:EtherLen], benchDst) {
+				continue
+			}
+			if want := byte(got); f.Data[HeaderLen] != want {
+				t.Errorf("frame %d with %+v has sequence %d, but got %d\n", got, cfg, want, f.Data[HeaderLen])
+			}
+			got++
+		}
+		if got != len(frames) {
+			t.Errorf("%d frames received with %+v, but got %d\n", len(frames), cfg, got)
+		}
+
+		// sending stops at the invalid frame
+		frames = testFrames(4, 60)
+		frames[2] = make([]byte, 70000)
+		if n, err := tx.SendBatch(frames); n != 2 || err == nil {
+			t.Errorf("SendBatch() with invalid frame = (2, error), but got (%d, %v)\n", n, err)
+		}
+
+		tx.Close()
+		recv.Close()
+		if _, err := tx.SendBatch(frames[:1]); err == nil {
+			t.Errorf("SendBatch() after Close() succeeded\n")
+		}
+	}
+}
+
+func TestTransmitterRingError(t *testing.T) {
+	s := bindTestSocket(t, "lo")
+	first, err := NewTransmitter(s, TxConfig{})
+	if err != nil {
+		s.Close()
+		t.Fatalf("NewTransmitter() failed: %v", err)
+	}
+	defer first.Close()
+	if first.RingError() != nil {
+		t.Errorf("RingError() of the ring = nil, but got %v\n", first.RingError())
+	}
+
+	// the version cannot be changed once the socket has a ring
+	tx, err := NewTransmitter(s, TxConfig{})
+	if err != nil {
+		t.Fatalf("second NewTransmitter() failed: %v", err)
+	}
+	if tx.UsesRing() || tx.RingError() == nil {
+		t.Errorf("second NewTransmitter() should fall back to sendmmsg with the reason, but got %v, %v\n", tx.UsesRing(), tx.RingError())
+	}
+}
+
+// benchInterface returns the interface used for benchmarks of sending.
+// Set NWSPEAKER_BENCH_IFACE to one end of a veth pair, e.g.
+//
+//	ip link add veth0 type veth peer name veth1
+//	ip link set veth0 up && ip link set veth1 up
+//	NWSPEAKER_BENCH_IFACE=veth0 go test -run XXX -bench Tx ./pkg/ethernet
+func benchInterface(b *testing.B) string {
+	ifname := os.Getenv("NWSPEAKER_BENCH_IFACE")
+	if ifname == "" {
+		b.Skip("NWSPEAKER_BENCH_IFACE is not set")
+	}
+	return ifname
+}
+
+const benchBatch = 64
+
+// BenchmarkTxSendFrame measures sending with one system call per frame.
+func BenchmarkTxSendFrame(b *testing.B) {
+	s := bindTestSocket(b, benchInterface(b))
+	defer s.Close()
+	frames := testFrames(benchBatch, 64)
+	b.SetBytes(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.SendFrame(frames[i%benchBatch], 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkTransmitter(b *testing.B, cfg TxConfig) {
+	tx, err := NewTransmitter(bindTestSocket(b, benchInterface(b)), cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Close()
+	frames := testFrames(benchBatch, 64)
+	b.SetBytes(64)
+	b.ResetTimer()
+	for sent := 0; sent < b.N; {
+		batch := frames
+		if b.N-sent < len(batch) {
+			batch = batch[:b.N-sent]
+		}
+		n, err := tx.SendBatch(batch)
+		if err != nil {
+			b.Fatal(err)
+		}
+		sent += n
+	}
+}
+
+func BenchmarkTxSendmmsg(b *testing.B) {
+	benchmarkTransmitter(b, TxConfig{Sendmmsg: true})
+}
+
+func BenchmarkTxRing(b *testing.B) {
+	benchmarkTransmitter(b, TxConfig{})
+}
+
+func BenchmarkTxRingQdiscBypass(b *testing.B) {
+	benchmarkTransmitter(b, TxConfig{QdiscBypass: true})
+}
@@ -0,0 +1,29 @@
+package filter
+
+// Type is the type of field values.
+type Type int
+
+const (
+	// TypeBool is the type of protocol names and flags.
+	TypeBool Type = iota
+	// TypeInt is the type of numeric fields.
+	TypeInt
+	// TypeMAC is the type of MAC addresses.
+	TypeMAC
+	// TypeIPv4 is the type of IPv4 addresses. Literals can be prefixes like 10.0.0.0/8.
+	TypeIPv4
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeBool:
+		return "bool"
+	case TypeInt:
+		return "int"
+	case TypeMAC:
+		return "mac"
+	case TypeIPv4:
+		return "ipv4"
+	}
+	return "unknown"
+}
@@ -0,0 +1,181 @@
+package filter
+
+import (
+	"sort"
+
+	"github.com/mas9612/nwspeaker/pkg/arp"
+	"github.com/mas9612/nwspeaker/pkg/decode"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/mas9612/nwspeaker/pkg/udp"
+)
+
+// Field is a field of decoded packets which can be used in filters.
+type Field struct {
+	Name      string
+	Type      Type
+	Constants map[string]uint64 // names which can be used instead of numbers
+	get       func(p *decode.Packet) (interface{}, bool)
+}
+
+var fields = map[string]*Field{}
+
+func init() {
+	for _, f := range []*Field{
+		protocol("eth", func(p *decode.Packet) bool { return p.Ethernet != nil }),
+		{Name: "eth.src", Type: TypeMAC, get: eth(func(h *ethernet.Header) interface{} { return h.SrcAddr })},
+		{Name: "eth.dst", Type: TypeMAC, get: eth(func(h *ethernet.Header) interface{} { return h.DstAddr })},
+		{Name: "eth.type", Type: TypeInt, Constants: map[string]uint64{"ipv4": ethernet.TypeIPv4, "arp": ethernet.TypeARP},
+			get: eth(func(h *ethernet.Header) interface{} { return uint64(h.EtherType) })},
+		{Name: "frame.len", Type: TypeInt, get: func(p *decode.Packet) (interface{}, bool) { return uint64(p.Length), true }},
+
+		protocol("arp", func(p *decode.Packet) bool { return p.ARP != nil }),
+		{Name: "arp.op", Type: TypeInt, Constants: map[string]uint64{"request": arp.OpRequest, "reply": arp.OpReply},
+			get: arpField(func(a *arp.Packet) interface{} { return uint64(a.Op) })},
+		{Name: "arp.sha", Type: TypeMAC, get: arpField(func(a *arp.Packet) interface{} { return a.SrcHAddr })},
+		{Name: "arp.tha", Type: TypeMAC, get: arpField(func(a *arp.Packet) interface{} { return a.DstHAddr })},
+		{Name: "arp.spa", Type: TypeIPv4, get: arpField(func(a *arp.Packet) interface{} { return a.SrcPAddr })},
+		{Name: "arp.tpa", Type: TypeIPv4, get: arpField(func(a *arp.Packet) interface{} { return a.DstPAddr })},
+
+		protocol("ipv4", func(p *decode.Packet) bool { return p.IPv4 != nil }),
+		{Name: "ipv4.src", Type: TypeIPv4, get: ipField(func(h *ipv4.Packet) interface{} { return h.SrcAddress })},
+		{Name: "ipv4.dst", Type: TypeIPv4, get: ipField(func(h *ipv4.Packet) interface{} { return h.DstAddress })},
+		{Name: "ipv4.ttl", Type: TypeInt, get: ipField(func(h *ipv4.Packet) interface{} { return uint64(h.TimeToLive) })},
+		{Name: "ipv4.tos", Type: TypeInt, get: ipField(func(h *ipv4.Packet) interface{} { return uint64(h.TypeOfService) })},
+		{Name: "ipv4.id", Type: TypeInt, get: ipField(func(h *ipv4.Packet) interface{} { return uint64(h.Identification) })},
+		{Name: "ipv4.len", Type: TypeInt, get: ipField(func(h *ipv4.Packet) interface{} { return uint64(h.TotalLength) })},
+		{Name: "ipv4.offset", Type: TypeInt, get: ipField(func(h *ipv4.Packet) interface{} { return uint64(h.FlagmentOffset) * 8 })},
+		{Name: "ipv4.df", Type: TypeBool, get: ipField(func(h *ipv4.Packet) interface{} { return h.Flags&ipv4.FlagDontFragment != 0 })},
+		{Name: "ipv4.mf", Type: TypeBool, get: ipField(func(h *ipv4.Packet) interface{} { return h.Flags&ipv4.FlagMoreFragment != 0 })},
+		{Name: "ipv4.proto", Type: TypeInt, Constants: map[string]uint64{"icmp": ipv4.ProtoICMP, "tcp": ipv4.ProtoTCP, "udp": ipv4.ProtoUDP},
+			get: ipField(func(h *ipv4.Packet) interface{} { return uint64(h.Protocol) })},
+
+		protocol("icmp", func(p *decode.Packet) bool { return p.ICMP != nil }),
+		{Name: "icmp.type", Type: TypeInt, Constants: icmpTypes, get: icmpField(func(m *icmp.Message) (interface{}, bool) { return uint64(m.Type), true })},
+		{Name: "icmp.code", Type: TypeInt, get: icmpField(func(m *icmp.Message) (interface{}, bool) { return uint64(m.Code), true })},
+		{Name: "icmp.id", Type: TypeInt, get: icmpField(func(m *icmp.Message) (interface{}, bool) {
+			e, ok := m.Data.(*icmp.Echo)
+			if !ok {
+				return nil, false
+			}
+			return uint64(e.Identifier), true
+		})},
+		{Name: "icmp.seq", Type: TypeInt, get: icmpField(func(m *icmp.Message) (interface{}, bool) {
+			e, ok := m.Data.(*icmp.Echo)
+			if !ok {
+				return nil, false
+			}
+			return uint64(e.SequenceNumber), true
+		})},
+
+		protocol("udp", func(p *decode.Packet) bool { return p.UDP != nil }),
+		{Name: "udp.srcport", Type: TypeInt, get: udpField(func(d *udp.Datagram) interface{} { return uint64(d.SrcPort) })},
+		{Name: "udp.dstport", Type: TypeInt, get: udpField(func(d *udp.Datagram) interface{} { return uint64(d.DstPort) })},
+		{Name: "udp.len", Type: TypeInt, get: udpField(func(d *udp.Datagram) interface{} { return uint64(len(d.Data)) })},
+
+		protocol("tcp", func(p *decode.Packet) bool { return p.TCP != nil }),
+		{Name: "tcp.srcport", Type: TypeInt, get: tcpField(func(s *tcp.Segment) interface{} { return uint64(s.SrcPort) })},
+		{Name: "tcp.dstport", Type: TypeInt, get: tcpField(func(s *tcp.Segment) interface{} { return uint64(s.DstPort) })},
+		{Name: "tcp.seq", Type: TypeInt, get: tcpField(func(s *tcp.Segment) interface{} { return uint64(s.SeqNum) })},
+		{Name: "tcp.ack", Type: TypeInt, get: tcpField(func(s *tcp.Segment) interface{} { return uint64(s.AckNum) })},
+		{Name: "tcp.win", Type: TypeInt, get: tcpField(func(s *tcp.Segment) interface{} { return uint64(s.Window) })},
+		{Name: "tcp.len", Type: TypeInt, get: tcpField(func(s *tcp.Segment) interface{} { return uint64(len(s.Data)) })},
+		{Name: "tcp.flags", Type: TypeInt, get: tcpField(func(s *tcp.Segment) interface{} { return uint64(s.Flags) })},
+		tcpFlag("tcp.flags.fin", tcp.FlagFIN),
+		tcpFlag("tcp.flags.syn", tcp.FlagSYN),
+		tcpFlag("tcp.flags.rst", tcp.FlagRST),
+		tcpFlag("tcp.flags.psh", tcp.FlagPSH),
+		tcpFlag("tcp.flags.ack", tcp.FlagACK),
+		tcpFlag("tcp.flags.urg", tcp.FlagURG),
+	} {
+		fields[f.Name] = f
+	}
+}
+
+var icmpTypes = map[string]uint64{
+	"echo-reply":        icmp.TypeEchoReply,
+	"unreachable":       icmp.TypeDestinationUnreachable,
+	"source-quench":     icmp.TypeSourceQuench,
+	"redirect":          icmp.TypeRedirect,
+	"echo-request":      icmp.TypeEcho,
+	"time-exceeded":     icmp.TypeTimeExceeded,
+	"parameter-problem": icmp.TypeParameterProblem,
+}
+
+// Fields returns all fields sorted by name.
+func Fields() []Field {
+	list := make([]Field, 0, len(fields))
+	for _, f := range fields {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func protocol(name string, present func(p *decode.Packet) bool) *Field {
+	return &Field{Name: name, Type: TypeBool, get: func(p *decode.Packet) (interface{}, bool) {
+		return true, present(p)
+	}}
+}
+
+func eth(get func(h *ethernet.Header) interface{}) func(p *decode.Packet) (interface{}, bool) {
+	return func(p *decode.Packet) (interface{}, bool) {
+		if p.Ethernet == nil {
+			return nil, false
+		}
+		return get(p.Ethernet), true
+	}
+}
+
+func arpField(get func(a *arp.Packet) interface{}) func(p *decode.Packet) (interface{}, bool) {
+	return func(p *decode.Packet) (interface{}, bool) {
+		if p.ARP == nil {
+			return nil, false
+		}
+		return get(p.ARP), true
+	}
+}
+
+func ipField(get func(h *ipv4.Packet) interface{}) func(p *decode.Packet) (interface{}, bool) {
+	return func(p *decode.Packet) (interface{}, bool) {
+		if p.IPv4 == nil {
+			return nil, false
+		}
+		return get(p.IPv4), true
+	}
+}
+
+func icmpField(get func(m *icmp.Message) (interface{}, bool)) func(p *decode.Packet) (interface{}, bool) {
+	return func(p *decode.Packet) (interface{}, bool) {
+		if p.ICMP == nil {
+			return nil, false
+		}
+		return get(p.ICMP)
+	}
+}
+
+func udpField(get func(d *udp.Datagram) interface{}) func(p *decode.Packet) (interface{}, bool) {
+	return func(p *decode.Packet) (interface{}, bool) {
+		if p.UDP == nil {
+			return nil, false
+		}
+		return get(p.UDP), true
+	}
+}
+
+func tcpField(get func(s *tcp.Segment) interface{}) func(p *decode.Packet) (interface{}, bool) {
+	return func(p *decode.Packet) (interface{}, bool) {
+		if p.TCP == nil {
+			return nil, false
+		}
+		return get(p.TCP), true
+	}
+}
+
+func tcpFlag(name string, flag uint16) *Field {
+	return &Field{Name: name, Type: TypeBool, get: tcpField(func(s *tcp.Segment) interface{} {
+		return s.HasFlags(flag)
+	})}
+}
@@ -0,0 +1,403 @@
+package filter
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mas9612/nwspeaker/pkg/decode"
+	"github.com/pkg/errors"
+)
+
+// Filter is a compiled filter expression.
+//
+// The expression consists of comparisons like "ipv4.ttl < 5" joined with
+// "&&" (or "and"), "||" (or "or") and "!" (or "not"), and grouped with parentheses.
+// Both sides of a comparison may be fields, so cross-layer checks like
+// "eth.src != arp.sha" can be written. A field without comparison is true when
+// the field is present, and a boolean field such as "tcp.flags.syn" is true when set.
+// A comparison involving a field which is not present in the packet is false.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses expr and checks the types of fields and values.
+func Compile(expr string) (*Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, errors.Errorf("unexpected '%s' at %d", t.text, t.pos)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether p matches the filter.
+func (f *Filter) Match(p *decode.Packet) bool {
+	if p == nil {
+		return false
+	}
+	return f.root.eval(p)
+}
+
+// String returns the expression of the filter.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenOp     // comparison operator
+	tokenAnd    // && or and
+	tokenOr     // || or or
+	tokenNot    // ! or not
+	tokenLParen // (
+	tokenRParen // )
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits expr into tokens.
+// Words consist of any characters other than spaces, parentheses and operators,
+// so addresses like 02:00:00:00:00:01 and 10.0.0.0/8 are single words.
+func tokenize(expr string) ([]token, error) {
+	tokens := make([]token, 0, 16)
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case strings.HasPrefix(expr[i:], "&&"):
+			tokens = append(tokens, token{tokenAnd, "&&", i})
+			i += 2
+		case strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, token{tokenOr, "||", i})
+			i += 2
+		case strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="),
+			strings.HasPrefix(expr[i:], "<="), strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, token{tokenOp, expr[i : i+2], i})
+			i += 2
+		case c == '<' || c == '>':
+			tokens = append(tokens, token{tokenOp, expr[i : i+1], i})
+			i++
+		case c == '!':
+			tokens = append(tokens, token{tokenNot, "!", i})
+			i++
+		case c == '=' || c == '&' || c == '|':
+			return nil, errors.Errorf("unexpected '%c' at %d", c, i)
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t\n()=!<>&|", rune(expr[i])) {
+				i++
+			}
+			word := expr[start:i]
+			switch word {
+			case "and":
+				tokens = append(tokens, token{tokenAnd, word, start})
+			case "or":
+				tokens = append(tokens, token{tokenOr, word, start})
+			case "not":
+				tokens = append(tokens, token{tokenNot, word, start})
+			default:
+				tokens = append(tokens, token{tokenWord, word, start})
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "end of expression", len(expr)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().kind == tokenNot {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, errors.Errorf("expected ')' but got '%s' at %d", t.text, t.pos)
+		}
+		return n, nil
+	case tokenWord:
+	default:
+		return nil, errors.Errorf("expected field but got '%s' at %d", t.text, t.pos)
+	}
+
+	if p.peek().kind != tokenOp {
+		f, ok := fields[t.text]
+		if !ok {
+			return nil, errors.Errorf("unknown field '%s' at %d", t.text, t.pos)
+		}
+		return &fieldNode{f}, nil
+	}
+	op := p.next()
+	right := p.next()
+	if right.kind != tokenWord {
+		return nil, errors.Errorf("expected field or value but got '%s' at %d", right.text, right.pos)
+	}
+	return newCompare(t, op, right)
+}
+
+// newCompare resolves both sides of the comparison and checks their types.
+// A word is a constant of the field on the other side, a field, or a literal in this order.
+func newCompare(left, op, right token) (node, error) {
+	lf, rf := fields[left.text], fields[right.text]
+	if rf != nil && lf != nil {
+		if _, ok := lf.Constants[right.text]; ok {
+			rf = nil
+		} else if _, ok := rf.Constants[left.text]; ok {
+			lf = nil
+		}
+	}
+
+	n := &compareNode{op: op.text}
+	switch {
+	case lf != nil && rf != nil:
+		if lf.Type != rf.Type {
+			return nil, errors.Errorf("cannot compare %s (%s) with %s (%s) at %d", lf.Name, lf.Type, rf.Name, rf.Type, op.pos)
+		}
+		n.left, n.right, n.typ = lf, rf, lf.Type
+	case lf != nil:
+		v, err := parseValue(lf, right)
+		if err != nil {
+			return nil, err
+		}
+		n.left, n.right, n.typ = lf, v, lf.Type
+	case rf != nil:
+		v, err := parseValue(rf, left)
+		if err != nil {
+			return nil, err
+		}
+		n.left, n.right, n.typ = v, rf, rf.Type
+	default:
+		return nil, errors.Errorf("unknown field '%s' at %d", left.text, left.pos)
+	}
+
+	if n.op != "==" && n.op != "!=" && n.typ != TypeInt {
+		return nil, errors.Errorf("operator '%s' cannot be used with %s at %d", n.op, n.typ, op.pos)
+	}
+	return n, nil
+}
+
+// parseValue parses the word as a value of the type of f.
+func parseValue(f *Field, t token) (*literal, error) {
+	if v, ok := f.Constants[t.text]; ok {
+		return &literal{v}, nil
+	}
+	switch f.Type {
+	case TypeInt:
+		v, err := strconv.ParseUint(t.text, 0, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid number '%s' for %s at %d", t.text, f.Name, t.pos)
+		}
+		return &literal{v}, nil
+	case TypeBool:
+		v, err := strconv.ParseBool(t.text)
+		if err != nil {
+			return nil, errors.Errorf("invalid bool '%s' for %s at %d", t.text, f.Name, t.pos)
+		}
+		return &literal{v}, nil
+	case TypeMAC:
+		v, err := net.ParseMAC(t.text)
+		if err != nil {
+			return nil, errors.Errorf("invalid MAC address '%s' for %s at %d", t.text, f.Name, t.pos)
+		}
+		return &literal{v}, nil
+	case TypeIPv4:
+		if strings.Contains(t.text, "/") {
+			_, prefix, err := net.ParseCIDR(t.text)
+			if err != nil || prefix.IP.To4() == nil {
+				return nil, errors.Errorf("invalid IPv4 prefix '%s' for %s at %d", t.text, f.Name, t.pos)
+			}
+			return &literal{prefix}, nil
+		}
+		v := net.ParseIP(t.text)
+		if v == nil || v.To4() == nil {
+			return nil, errors.Errorf("invalid IPv4 address '%s' for %s at %d", t.text, f.Name, t.pos)
+		}
+		return &literal{v}, nil
+	}
+	return nil, errors.Errorf("unsupported type of %s", f.Name)
+}
+
+// node is a node of the syntax tree.
+type node interface {
+	eval(p *decode.Packet) bool
+}
+
+// operand is a side of comparisons.
+type operand interface {
+	value(p *decode.Packet) (interface{}, bool)
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) eval(p *decode.Packet) bool { return n.left.eval(p) && n.right.eval(p) }
+
+type orNode struct{ left, right node }
+
+func (n *orNode) eval(p *decode.Packet) bool { return n.left.eval(p) || n.right.eval(p) }
+
+type notNode struct{ n node }
+
+func (n *notNode) eval(p *decode.Packet) bool { return !n.n.eval(p) }
+
+// fieldNode is true when the field is present, and for boolean fields, set.
+type fieldNode struct{ f *Field }
+
+func (n *fieldNode) eval(p *decode.Packet) bool {
+	v, ok := n.f.get(p)
+	if !ok {
+		return false
+	}
+	if b, isBool := v.(bool); isBool {
+		return b
+	}
+	return true
+}
+
+type literal struct{ v interface{} }
+
+func (l *literal) value(p *decode.Packet) (interface{}, bool) { return l.v, true }
+
+func (f *Field) value(p *decode.Packet) (interface{}, bool) { return f.get(p) }
+
+type compareNode struct {
+	op          string
+	typ         Type
+	left, right operand
+}
+
+func (n *compareNode) eval(p *decode.Packet) bool {
+	l, ok := n.left.value(p)
+	if !ok {
+		return false
+	}
+	r, ok := n.right.value(p)
+	if !ok {
+		return false
+	}
+
+	if n.typ == TypeInt {
+		a, b := l.(uint64), r.(uint64)
+		switch n.op {
+		case "==":
+			return a == b
+		case "!=":
+			return a != b
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		case ">":
+			return a > b
+		case ">=":
+			return a >= b
+		}
+		return false
+	}
+
+	if prefix, ok := l.(*net.IPNet); ok { // keep prefix on the right side
+		l, r = r, prefix
+	}
+	var equal bool
+	switch a := l.(type) {
+	case bool:
+		equal = a == r.(bool)
+	case net.HardwareAddr:
+		equal = bytes.Equal(a, r.(net.HardwareAddr))
+	case net.IP:
+		switch b := r.(type) {
+		case net.IP:
+			equal = a.Equal(b)
+		case *net.IPNet:
+			equal = b.Contains(a)
+		}
+	}
+	if n.op == "!=" {
+		return !equal
+	}
+	return equal
+}
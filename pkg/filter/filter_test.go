@@ -0,0 +1,105 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/mas9612/nwspeaker/pkg/decode"
+)
+
+func TestMatch(t *testing.T) {
+	spoofedReply := decode.Frame([]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x66, 0x08, 0x06,
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00,
+		0x00, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xc0, 0xa8, 0x00, 0x02,
+	})
+	genuineReply := decode.Frame([]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06,
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00,
+		0x00, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xc0, 0xa8, 0x00, 0x02,
+	})
+	timeExceeded := decode.Frame([]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x38, 0x00, 0x00, 0x00, 0x00, 0x03, 0x01, 0xed, 0x1a, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x0b, 0x00, 0xaf, 0xff, 0x00, 0x00, 0x00, 0x00, 0x45, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	tcpSyn := decode.Frame([]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x00, 0x40, 0x06, 0xb0, 0x25, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x9c, 0x40, 0x01, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x50, 0x02, 0x00, 0x00, 0x47, 0x3c, 0x00, 0x00,
+	})
+	// first fragment of tcpSyn: flags 0x20 0x00 sets MF only
+	firstFragment := decode.Frame([]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x24, 0x00, 0x00, 0x20, 0x00, 0x40, 0x06, 0x90, 0x29, 0x0a, 0x00, 0x00, 0x01,
+		0xc0, 0xa8, 0x00, 0x02, 0x9c, 0x40, 0x01, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x50, 0x02, 0x00, 0x00,
+	})
+
+	tests := []struct {
+		expr string
+		p    *decode.Packet
+		out  bool
+	}{
+		{"arp.op == reply && eth.src != arp.sha", spoofedReply, true},
+		{"arp.op == reply && eth.src != arp.sha", genuineReply, false},
+		{"arp.op == request", genuineReply, false},
+		{"arp and not ipv4", genuineReply, true},
+		{"eth.type == arp", genuineReply, true},
+		{"eth.src == 02:00:00:00:00:66", spoofedReply, true},
+		{"arp.spa == 10.0.0.0/8", genuineReply, true},
+		{"arp.tpa == 10.0.0.0/8", genuineReply, false},
+		{"ipv4.ttl < 5 and icmp.type == 11", timeExceeded, true},
+		{"ipv4.ttl < 5 and icmp.type == time-exceeded", tcpSyn, false},
+		{"ipv4.ttl >= 64 || icmp", tcpSyn, true},
+		{"ipv4.ttl >= 64 || icmp", timeExceeded, true},
+		{"ipv4.ttl > 0x40", tcpSyn, false},
+		{"tcp.flags.syn && !tcp.flags.ack && tcp.dstport == 443", tcpSyn, true},
+		{"tcp.flags == 2", tcpSyn, true},
+		{"ipv4.proto == udp", tcpSyn, false},
+		{"ipv4.src != ipv4.dst", tcpSyn, true},
+		{"!(icmp.id == 1)", timeExceeded, true},
+		{"icmp.id != 1", timeExceeded, false}, // field not present
+		{"192.168.0.0/16 == ipv4.dst", tcpSyn, true},
+		{"frame.len == 54", tcpSyn, true},
+		{"ipv4.mf", firstFragment, true},
+		{"ipv4.mf || ipv4.df", tcpSyn, false},
+		{"ipv4.mf && ipv4.offset == 0", firstFragment, true},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q) returns error: %v\n", tt.expr, err)
+			continue
+		}
+		if out := f.Match(tt.p); out != tt.out {
+			t.Errorf("Match() of %q = %v, but got %v\n", tt.expr, tt.out, out)
+		}
+	}
+}
+
+var compileErrorTests = []string{
+	"",
+	"foo",
+	"ipv4.ttl ==",
+	"ipv4.ttl == foo",
+	"eth.src == 10.0.0.1",
+	"eth.src < eth.dst",
+	"eth.src == arp.spa",
+	"arp.spa == 10.0.0.0/33",
+	"(arp",
+	"arp)",
+	"arp = reply",
+	"arp &&",
+	"1 == 1",
+}
+
+func TestCompileError(t *testing.T) {
+	for _, expr := range compileErrorTests {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Compile(%q) should return error\n", expr)
+		}
+	}
+}
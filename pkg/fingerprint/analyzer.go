@@ -0,0 +1,235 @@
+package fingerprint
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/arp"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of Analyzer.
+type Config struct {
+	Interface   string
+	Signatures  []*Signature
+	Promiscuous bool // observe segments not destined to this host
+}
+
+// Observation is a SYN or SYN/ACK observed by Analyzer.
+type Observation struct {
+	Time        time.Time
+	Src         net.IP
+	SrcPort     uint16
+	Dst         net.IP
+	DstPort     uint16
+	MAC         net.HardwareAddr // MAC address of Src. nil if Src is not on the local segment and not learned by ARP.
+	Fingerprint *Fingerprint
+	Label       *Label // nil if no signature matches
+}
+
+// Host is an entry of passive inventory.
+type Host struct {
+	IP        net.IP
+	MAC       net.HardwareAddr
+	Label     *Label // the last matched label
+	Signature string // the last observed signature
+	Distance  int
+	Ports     []uint16 // ports on which the host answered with SYN/ACK
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Analyzer passively observes TCP SYN, SYN/ACK and ARP on an interface
+// and infers the operating system of hosts. It never sends any frame.
+type Analyzer struct {
+	cfg  Config
+	sock *ethernet.Socket
+	done chan struct{}
+
+	mu    sync.Mutex
+	hosts map[string]*Host
+	macs  map[string]net.HardwareAddr // learned by ARP
+}
+
+// New returns new Analyzer instance.
+func New(cfg Config) (*Analyzer, error) {
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeAll)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Promiscuous {
+		if err := sock.SetPromiscuous(true); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+	a := NewOffline(cfg)
+	a.sock = sock
+	return a, nil
+}
+
+// NewOffline returns Analyzer which does not open a socket.
+// Frames are given by HandleFrame, e.g. read from a pcap file. Interface and Promiscuous are ignored.
+func NewOffline(cfg Config) *Analyzer {
+	if cfg.Signatures == nil {
+		cfg.Signatures = LoadDefaultSignatures()
+	}
+	return &Analyzer{
+		cfg:   cfg,
+		done:  make(chan struct{}),
+		hosts: make(map[string]*Host),
+		macs:  make(map[string]net.HardwareAddr),
+	}
+}
+
+// Serve receives frames until Close is called. fn is called for every observed SYN and SYN/ACK.
+func (a *Analyzer) Serve(fn func(*Observation)) error {
+	for {
+		select {
+		case <-a.done:
+			return nil
+		default:
+		}
+
+		if err := a.sock.SetRecvTimeout(pollInterval); err != nil {
+			return err
+		}
+		b, err := a.sock.Recv(0)
+		if err != nil {
+			if ethernet.IsTimeout(err) {
+				continue
+			}
+			if errors.Cause(err) == ethernet.ErrClosed { // Close was called
+				return nil
+			}
+			return err
+		}
+		if o := a.HandleFrame(b, time.Now()); o != nil && fn != nil {
+			fn(o)
+		}
+	}
+}
+
+// Close stops Serve and closes the socket.
+func (a *Analyzer) Close() error {
+	close(a.done)
+	if a.sock == nil {
+		return nil
+	}
+	return a.sock.Close()
+}
+
+// Hosts returns the inventory of observed hosts sorted by IP address.
+func (a *Analyzer) Hosts() []Host {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	hosts := make([]Host, 0, len(a.hosts))
+	for _, h := range a.hosts {
+		c := *h
+		c.Ports = append([]uint16{}, h.Ports...)
+		hosts = append(hosts, c)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return string(hosts[i].IP.To16()) < string(hosts[j].IP.To16())
+	})
+	return hosts
+}
+
+// HandleFrame processes a frame captured at now and returns the observation if it is SYN or SYN/ACK.
+func (a *Analyzer) HandleFrame(frame []byte, now time.Time) *Observation {
+	if len(frame) < ethernet.HeaderLen {
+		return nil
+	}
+	hdr := ethernet.Parse(frame)
+	switch hdr.EtherType {
+	case ethernet.TypeARP:
+		a.handleARP(frame, now)
+		return nil
+	case ethernet.TypeIPv4:
+	default:
+		return nil
+	}
+
+	pkt := ipv4.Parse(frame[ethernet.HeaderLen:])
+	if pkt == nil || pkt.Protocol != ipv4.ProtoTCP || pkt.FlagmentOffset != 0 {
+		return nil
+	}
+	seg := tcp.Parse(pkt.Data)
+	if seg == nil {
+		return nil
+	}
+	fp := FromSegment(pkt, seg)
+	if fp == nil {
+		return nil
+	}
+
+	o := &Observation{
+		Time:        now,
+		Src:         pkt.SrcAddress,
+		SrcPort:     seg.SrcPort,
+		Dst:         pkt.DstAddress,
+		DstPort:     seg.DstPort,
+		Fingerprint: fp,
+		Label:       Match(a.cfg.Signatures, fp),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := pkt.SrcAddress.String()
+	h, ok := a.hosts[key]
+	if !ok {
+		h = &Host{IP: pkt.SrcAddress, FirstSeen: now}
+		a.hosts[key] = h
+	}
+	if mac, ok := a.macs[key]; ok {
+		h.MAC = mac
+	} else if fp.Distance() == 0 {
+		// sent directly by the host, not forwarded by a router
+		h.MAC = hdr.SrcAddr
+	}
+	if o.Label != nil || h.Label == nil {
+		h.Label = o.Label
+	}
+	h.Signature = fp.String()
+	h.Distance = fp.Distance()
+	h.LastSeen = now
+	if fp.Direction == Response {
+		h.addPort(seg.SrcPort)
+	}
+	o.MAC = h.MAC
+	return o
+}
+
+// handleARP learns MAC address of the sender.
+func (a *Analyzer) handleARP(frame []byte, now time.Time) {
+	p := arp.Parse(frame)
+	if p == nil || p.HType != arp.HardwareTypeEthernet || p.PType != arp.ProtocolTypeIPv4 {
+		return
+	}
+	if p.SrcPAddr.Equal(net.IPv4zero) {
+		return // ARP probe
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := p.SrcPAddr.String()
+	a.macs[key] = p.SrcHAddr
+	if h, ok := a.hosts[key]; ok {
+		h.MAC = p.SrcHAddr
+		h.LastSeen = now
+	}
+}
+
+func (h *Host) addPort(port uint16) {
+	for _, p := range h.Ports {
+		if p == port {
+			return
+		}
+	}
+	h.Ports = append(h.Ports, port)
+	sort.Slice(h.Ports, func(i, j int) bool { return h.Ports[i] < h.Ports[j] })
+}
@@ -0,0 +1,47 @@
+package fingerprint
+
+import "time"
+
+const (
+	// pollInterval is the interval to check whether Close is called.
+	pollInterval = 500 * time.Millisecond
+
+	// MaxDistance is the maximum number of hops between the initial TTL of a signature and observed TTL.
+	MaxDistance = 35
+)
+
+// Direction is the kind of segments a signature applies to.
+type Direction int
+
+const (
+	// Request represents SYN sent by clients.
+	Request Direction = iota
+	// Response represents SYN/ACK sent by servers.
+	Response
+)
+
+// String returns the section name of d in signature file.
+func (d Direction) String() string {
+	if d == Response {
+		return "tcp:response"
+	}
+	return "tcp:request"
+}
+
+// window size types in signatures
+const (
+	windowAny = iota
+	windowFixed
+	windowMSS // multiple of MSS
+	windowMTU // multiple of MTU
+	windowMod // multiple of constant
+)
+
+// mtuOverhead is the length of IPv4 and TCP headers used to derive MTU from MSS.
+const mtuOverhead = 40
+
+// quirkOrder is the canonical order of quirks in signatures.
+var quirkOrder = []string{
+	"df", "id+", "id-", "ecn", "0+", "flow", "seq-", "ack+", "ack-",
+	"uptr+", "urgf+", "pushf+", "ts1-", "ts2+", "opt+", "exws", "bad",
+}
@@ -0,0 +1,182 @@
+package fingerprint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+)
+
+// Fingerprint represents the characteristics of observed SYN or SYN/ACK.
+type Fingerprint struct {
+	Direction  Direction
+	Version    uint8
+	TTL        uint8
+	OptionsLen int // length of IPv4 options
+	MSS        int // zero if MSS option is not present
+	Window     uint16
+	Scale      int // zero if Window Scale option is not present
+	Layout     []string
+	Quirks     []string // sorted in canonical order
+	Payload    bool
+}
+
+// FromSegment returns the fingerprint of given SYN or SYN/ACK segment.
+// If seg is neither of them, nil is returned.
+func FromSegment(pkt *ipv4.Packet, seg *tcp.Segment) *Fingerprint {
+	if !seg.HasFlags(tcp.FlagSYN) || seg.HasFlags(tcp.FlagRST) || seg.HasFlags(tcp.FlagFIN) {
+		return nil
+	}
+	fp := &Fingerprint{
+		Direction:  Request,
+		Version:    pkt.Version,
+		TTL:        pkt.TimeToLive,
+		OptionsLen: len(pkt.Options),
+		Window:     seg.Window,
+		Payload:    len(seg.Data) > 0,
+	}
+	if seg.HasFlags(tcp.FlagACK) {
+		fp.Direction = Response
+	}
+
+	var tsVal, tsEcr uint32
+	hasTS := false
+	for _, o := range seg.Options {
+		switch o.Kind {
+		case tcp.OptEnd:
+			fp.Layout = append(fp.Layout, fmt.Sprintf("eol+%d", len(o.Data)))
+			for _, b := range o.Data {
+				if b != 0 {
+					fp.addQuirk("opt+")
+					break
+				}
+			}
+		case tcp.OptNOP:
+			fp.Layout = append(fp.Layout, "nop")
+		case tcp.OptMSS:
+			fp.Layout = append(fp.Layout, "mss")
+			if len(o.Data) == 2 {
+				fp.MSS = int(binary.BigEndian.Uint16(o.Data))
+			} else {
+				fp.addQuirk("bad")
+			}
+		case tcp.OptWindowScale:
+			fp.Layout = append(fp.Layout, "ws")
+			if len(o.Data) == 1 {
+				fp.Scale = int(o.Data[0])
+				if o.Data[0] > tcp.MaxWindowScale {
+					fp.addQuirk("exws")
+				}
+			} else {
+				fp.addQuirk("bad")
+			}
+		case tcp.OptSACKPermitted:
+			fp.Layout = append(fp.Layout, "sok")
+		case tcp.OptSACK:
+			fp.Layout = append(fp.Layout, "sack")
+		case tcp.OptTimestamps:
+			fp.Layout = append(fp.Layout, "ts")
+			if len(o.Data) == 8 {
+				tsVal, tsEcr = binary.BigEndian.Uint32(o.Data), binary.BigEndian.Uint32(o.Data[4:])
+				hasTS = true
+			} else {
+				fp.addQuirk("bad")
+			}
+		default:
+			fp.Layout = append(fp.Layout, "?"+strconv.Itoa(int(o.Kind)))
+		}
+	}
+
+	df := pkt.Flags&ipv4.FlagDontFragment != 0
+	if df {
+		fp.addQuirk("df")
+		if pkt.Identification != 0 {
+			fp.addQuirk("id+")
+		}
+	} else if pkt.Identification == 0 {
+		fp.addQuirk("id-")
+	}
+	if pkt.TypeOfService&0x03 != 0 || seg.HasFlags(tcp.FlagECE) || seg.HasFlags(tcp.FlagCWR) || seg.HasFlags(tcp.FlagNS) {
+		fp.addQuirk("ecn")
+	}
+	if pkt.Flags&ipv4.FlagUnused != 0 {
+		fp.addQuirk("0+")
+	}
+	if seg.SeqNum == 0 {
+		fp.addQuirk("seq-")
+	}
+	if seg.HasFlags(tcp.FlagACK) {
+		if seg.AckNum == 0 {
+			fp.addQuirk("ack-")
+		}
+	} else if seg.AckNum != 0 {
+		fp.addQuirk("ack+")
+	}
+	if seg.HasFlags(tcp.FlagURG) {
+		fp.addQuirk("urgf+")
+	} else if seg.UrgentPointer != 0 {
+		fp.addQuirk("uptr+")
+	}
+	if seg.HasFlags(tcp.FlagPSH) {
+		fp.addQuirk("pushf+")
+	}
+	if hasTS {
+		if tsVal == 0 {
+			fp.addQuirk("ts1-")
+		}
+		if tsEcr != 0 && fp.Direction == Request {
+			fp.addQuirk("ts2+")
+		}
+	}
+	sortQuirks(fp.Quirks)
+	return fp
+}
+
+func (fp *Fingerprint) addQuirk(q string) {
+	for _, e := range fp.Quirks {
+		if e == q {
+			return
+		}
+	}
+	fp.Quirks = append(fp.Quirks, q)
+}
+
+// InitialTTL returns the guessed initial TTL of the sender.
+func (fp *Fingerprint) InitialTTL() uint8 {
+	switch {
+	case fp.TTL <= 32:
+		return 32
+	case fp.TTL <= 64:
+		return 64
+	case fp.TTL <= 128:
+		return 128
+	}
+	return 255
+}
+
+// Distance returns the guessed number of hops to the sender.
+func (fp *Fingerprint) Distance() int {
+	return int(fp.InitialTTL() - fp.TTL)
+}
+
+// String returns fp in p0f signature format, so that it can be added to signature file.
+// Window size is written as a multiple of MSS if possible.
+func (fp *Fingerprint) String() string {
+	ttl := strconv.Itoa(int(fp.InitialTTL()))
+	if d := fp.Distance(); d > 0 {
+		ttl += "+" + strconv.Itoa(d)
+	}
+	win := strconv.Itoa(int(fp.Window))
+	if fp.MSS > 0 && fp.Window != 0 && int(fp.Window)%fp.MSS == 0 {
+		win = fmt.Sprintf("mss*%d", int(fp.Window)/fp.MSS)
+	}
+	payload := "0"
+	if fp.Payload {
+		payload = "+"
+	}
+	return fmt.Sprintf("%d:%s:%d:%d:%s,%d:%s:%s:%s", fp.Version, ttl, fp.OptionsLen, fp.MSS, win, fp.Scale,
+		strings.Join(fp.Layout, ","), strings.Join(fp.Quirks, ","), payload)
+}
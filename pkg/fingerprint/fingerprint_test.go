@@ -0,0 +1,259 @@
+package fingerprint
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/arp"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+)
+
+var (
+	testClient    = net.IPv4(192, 168, 0, 10)
+	testServer    = net.IPv4(192, 168, 0, 20)
+	testClientMac = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+	testServerMac = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x14}
+)
+
+// linuxSyn returns SYN sent by Linux 5.x.
+func linuxSyn(ttl uint8) (*ipv4.Packet, *tcp.Segment) {
+	seg := &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: 51000,
+			DstPort: 80,
+			SeqNum:  0x12345678,
+			Flags:   tcp.FlagSYN,
+			Window:  64240,
+			Options: []tcp.Option{
+				tcp.MSSOption(1460),
+				tcp.SACKPermittedOption(),
+				tcp.TimestampsOption(0xdeadbeef, 0),
+				tcp.NOPOption(),
+				tcp.WindowScaleOption(7),
+			},
+		},
+	}
+	pkt := ipv4.NewPacket(testClient, testServer, ipv4.ProtoTCP, seg.Encode(testClient, testServer),
+		ipv4.SetIdentification(0x1c46), ipv4.SetFlags(ipv4.FlagDontFragment), ipv4.SetTTL(ttl))
+	return pkt, seg
+}
+
+func TestParseSignature(t *testing.T) {
+	tests := []struct {
+		in    string
+		isErr bool
+	}{
+		{in: "*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0"},
+		{in: "4:128+3:0:1460:8192,0:mss,nop,nop,sok:df,id+:+"},
+		{in: "*:64-:4:*:%8192,*:mss,eol+1,?30::*"},
+		{in: "*:64:0:*:mtu*4,7:mss:id+,df:0"},
+		{in: "*:64:0:*:mss*20,10:mss", isErr: true},
+		{in: "6:64:0:*:mss*20,10:mss::0", isErr: true},
+		{in: "*:0:0:*:mss*20,10:mss::0", isErr: true},
+		{in: "*:64:0:*:mss*0,10:mss::0", isErr: true},
+		{in: "*:64:0:*:mss*20:mss::0", isErr: true},
+		{in: "*:64:0:*:mss*20,10:foo::0", isErr: true},
+		{in: "*:64:0:*:mss*20,10:mss:bar:0", isErr: true},
+		{in: "*:64:0:*:mss*20,10:mss::1", isErr: true},
+	}
+	for _, tt := range tests {
+		s, err := ParseSignature(tt.in)
+		if tt.isErr {
+			if err == nil {
+				t.Errorf("ParseSignature(%q) should return error\n", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSignature(%q) returns error: %v\n", tt.in, err)
+			continue
+		}
+		// String() returns canonical form which must be parsed again to the same signature
+		s2, err := ParseSignature(s.String())
+		if err != nil || s2.String() != s.String() {
+			t.Errorf("ParseSignature(%q).String() = %s is not stable\n", tt.in, s.String())
+		}
+	}
+
+	s, _ := ParseSignature("*:64:0:*:mtu*4,7:mss:id+,df:0")
+	if strings.Join(s.Quirks, ",") != "df,id+" {
+		t.Errorf("quirks must be sorted in canonical order, but got %v\n", s.Quirks)
+	}
+}
+
+func TestParseSignatures(t *testing.T) {
+	file := `
+classes = win,unix,other
+
+[mtu]
+label = Ethernet or modem
+sig   = 1500
+
+[tcp:request]
+label = s:unix:Test:1.0
+sig   = *:64:0:*:mss*44,7:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*44,7:mss,sok,ts,nop,ws:df:0
+
+[tcp:response]
+label = g:win:Test:
+sig   = *:128:0:*:*,*:mss:df,id+:0
+`
+	sigs, err := ParseSignatures(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParseSignatures() returns error: %v\n", err)
+	}
+	if len(sigs) != 3 {
+		t.Fatalf("ParseSignatures() returns %d signatures, but got %d\n", 3, len(sigs))
+	}
+	if sigs[0].Label.String() != "Test 1.0" || sigs[0].Direction != Request || sigs[0].Line != 10 {
+		t.Errorf("unexpected first signature: %+v\n", sigs[0])
+	}
+	if !sigs[2].Label.Generic || sigs[2].Direction != Response {
+		t.Errorf("unexpected last signature: %+v\n", sigs[2])
+	}
+
+	invalid := []string{
+		"[tcp:request]\nsig = *:64:0:*:*,*:mss:df:0\n",
+		"[tcp:request]\nlabel = x:unix:Test:\n",
+		"[tcp:request]\nlabel = s:unix:Test:\nfoo = bar\n",
+		"[tcp:request\n",
+	}
+	for _, in := range invalid {
+		if _, err := ParseSignatures(strings.NewReader(in)); err == nil {
+			t.Errorf("ParseSignatures(%q) should return error\n", in)
+		}
+	}
+
+	// built-in signatures must be valid
+	LoadDefaultSignatures()
+}
+
+func TestFromSegment(t *testing.T) {
+	pkt, seg := linuxSyn(57)
+	fp := FromSegment(pkt, seg)
+	if fp == nil {
+		t.Fatalf("FromSegment() returns nil\n")
+	}
+	want := "4:64+7:0:1460:mss*44,7:mss,sok,ts,nop,ws:df,id+:0"
+	if fp.String() != want {
+		t.Errorf("String() = %s, but got %s\n", want, fp.String())
+	}
+	if fp.Distance() != 7 {
+		t.Errorf("Distance() = 7, but got %d\n", fp.Distance())
+	}
+
+	l := Match(LoadDefaultSignatures(), fp)
+	if l == nil || l.Name != "Linux" || l.Generic {
+		t.Errorf("Match() = Linux, but got %v\n", l)
+	}
+
+	// observed signature can be used as it is
+	s, err := ParseSignature(fp.String())
+	if err != nil {
+		t.Fatalf("ParseSignature(%s) returns error: %v\n", fp.String(), err)
+	}
+	if !s.Match(fp) {
+		t.Errorf("signature made from fingerprint does not match it\n")
+	}
+	seg.Window = 1000
+	if fp := FromSegment(pkt, seg); s.Match(fp) {
+		t.Errorf("signature must not match different window size\n")
+	}
+
+	// quirks
+	seg.Window = 64240
+	seg.AckNum = 1
+	seg.Flags |= tcp.FlagECE | tcp.FlagCWR
+	pkt.Flags = 0
+	fp = FromSegment(pkt, seg)
+	if q := strings.Join(fp.Quirks, ","); q != "ecn,ack+" {
+		t.Errorf("Quirks = ecn,ack+, but got %s\n", q)
+	}
+	if Match(LoadDefaultSignatures(), fp) != nil {
+		t.Errorf("SYN with unusual quirks must not match\n")
+	}
+
+	seg.Flags = tcp.FlagRST
+	if FromSegment(pkt, seg) != nil {
+		t.Errorf("FromSegment() must return nil for RST\n")
+	}
+}
+
+func TestReservedFlag(t *testing.T) {
+	_, seg := linuxSyn(64)
+	tests := []struct {
+		flags byte // byte 6 of IPv4 header on the wire
+		quirk bool
+	}{
+		{0x80, true},  // reserved bit
+		{0x40, false}, // DF
+		{0x20, false}, // MF
+	}
+	for _, tt := range tests {
+		b := append([]byte{
+			0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, tt.flags, 0x00, 0x40, 0x06, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x0a,
+			0xc0, 0xa8, 0x00, 0x14,
+		}, seg.Encode(testClient, testServer)...)
+		fp := FromSegment(ipv4.Parse(b), seg)
+		if got := strings.Contains(strings.Join(fp.Quirks, ","), "0+"); got != tt.quirk {
+			t.Errorf("quirk 0+ with flags %#x = %v, but got %v (%s)\n", tt.flags, tt.quirk, got, fp)
+		}
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := NewOffline(Config{})
+	now := time.Now()
+
+	// SYN sent directly by the host
+	pkt, _ := linuxSyn(64)
+	frame := ethernet.Frame(testServerMac, testClientMac, ethernet.TypeIPv4, pkt.Encode())
+	o := a.HandleFrame(frame, now)
+	if o == nil || o.Label == nil || o.Label.Name != "Linux" {
+		t.Fatalf("HandleFrame() must return observation of Linux, but got %+v\n", o)
+	}
+	if o.MAC.String() != testClientMac.String() {
+		t.Errorf("MAC = %s, but got %s\n", testClientMac, o.MAC)
+	}
+
+	// SYN/ACK forwarded by a router. MAC is learned by ARP.
+	synack := &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: 443,
+			DstPort: 51000,
+			Flags:   tcp.FlagSYN | tcp.FlagACK,
+			SeqNum:  1,
+			AckNum:  0x12345679,
+			Window:  8192,
+			Options: []tcp.Option{tcp.MSSOption(1460)},
+		},
+	}
+	resp := ipv4.NewPacket(testServer, testClient, ipv4.ProtoTCP, synack.Encode(testServer, testClient),
+		ipv4.SetIdentification(1), ipv4.SetFlags(ipv4.FlagDontFragment), ipv4.SetTTL(126))
+	router := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0xfe}
+	o = a.HandleFrame(ethernet.Frame(testClientMac, router, ethernet.TypeIPv4, resp.Encode()), now)
+	if o == nil || o.Label == nil || o.Label.Name != "Windows" || o.MAC != nil {
+		t.Errorf("HandleFrame() must return observation of Windows without MAC, but got %+v\n", o)
+	}
+
+	reply, _ := arp.NewReply(testClientMac.String(), testClient.String())
+	reply.SrcHAddr, reply.SrcPAddr = testServerMac, testServer
+	a.HandleFrame(ethernet.Frame(testClientMac, testServerMac, ethernet.TypeARP, reply.Encode()), now)
+
+	hosts := a.Hosts()
+	if len(hosts) != 2 {
+		t.Fatalf("Hosts() returns %d hosts, but got %d\n", 2, len(hosts))
+	}
+	if !hosts[0].IP.Equal(testClient) || !hosts[1].IP.Equal(testServer) {
+		t.Errorf("Hosts() must be sorted by IP address\n")
+	}
+	server := hosts[1]
+	if server.MAC.String() != testServerMac.String() || server.Distance != 2 ||
+		len(server.Ports) != 1 || server.Ports[0] != 443 {
+		t.Errorf("unexpected host entry: %+v\n", server)
+	}
+}
@@ -0,0 +1,380 @@
+package fingerprint
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Label identifies the operating system or network stack of a signature.
+// The format is the same as p0f: "type:class:name:flavor".
+// Type is "s" for specific signatures and "g" for generic ones.
+type Label struct {
+	Generic bool
+	Class   string // e.g. "unix", "win"
+	Name    string // e.g. "Linux"
+	Flavor  string // e.g. "3.11 and newer"
+}
+
+// String returns human readable representation of l like "Linux 3.11 and newer".
+func (l *Label) String() string {
+	s := l.Name
+	if l.Flavor != "" {
+		s += " " + l.Flavor
+	}
+	if l.Generic {
+		s += " (generic)"
+	}
+	return s
+}
+
+// Signature is a fingerprint of TCP SYN or SYN/ACK sent by a specific stack.
+// It is written in p0f v3 format: "ver:ittl:olen:mss:wsize,scale:olayout:quirks:pclass".
+type Signature struct {
+	Label      *Label
+	Direction  Direction
+	Version    int // -1 means any
+	InitialTTL int
+	OptionsLen int // length of IPv4 options
+	MSS        int // -1 means any
+	windowType int
+	Window     int
+	Scale      int // -1 means any
+	Layout     []string
+	Quirks     []string // sorted in canonical order
+	Payload    int      // -1 means any, 0 means no payload and 1 means payload present
+	Line       int      // line number in signature file
+}
+
+// Match reports whether fp matches s.
+func (s *Signature) Match(fp *Fingerprint) bool {
+	if s.Direction != fp.Direction {
+		return false
+	}
+	if s.Version >= 0 && s.Version != int(fp.Version) {
+		return false
+	}
+	if s.InitialTTL < int(fp.TTL) || s.InitialTTL-int(fp.TTL) > MaxDistance {
+		return false
+	}
+	if s.OptionsLen != fp.OptionsLen {
+		return false
+	}
+	if s.MSS >= 0 && s.MSS != fp.MSS {
+		return false
+	}
+	if s.Scale >= 0 && s.Scale != fp.Scale {
+		return false
+	}
+	if s.Payload >= 0 && (s.Payload == 1) != fp.Payload {
+		return false
+	}
+	if strings.Join(s.Layout, ",") != strings.Join(fp.Layout, ",") {
+		return false
+	}
+	if strings.Join(s.Quirks, ",") != strings.Join(fp.Quirks, ",") {
+		return false
+	}
+
+	win := int(fp.Window)
+	switch s.windowType {
+	case windowFixed:
+		return win == s.Window
+	case windowMSS:
+		return fp.MSS > 0 && win == fp.MSS*s.Window
+	case windowMTU:
+		return fp.MSS > 0 && win == (fp.MSS+mtuOverhead)*s.Window
+	case windowMod:
+		return win%s.Window == 0
+	}
+	return true
+}
+
+// Match returns the label of the first signature which matches fp.
+// Specific signatures take precedence over generic ones. If nothing matches, nil is returned.
+func Match(sigs []*Signature, fp *Fingerprint) *Label {
+	var generic *Label
+	for _, s := range sigs {
+		if !s.Match(fp) {
+			continue
+		}
+		if !s.Label.Generic {
+			return s.Label
+		}
+		if generic == nil {
+			generic = s.Label
+		}
+	}
+	return generic
+}
+
+// ParseSignatures reads signatures in p0f v3 format from r.
+// Only [tcp:request] and [tcp:response] sections are used and other sections are skipped,
+// so p0f.fp can be loaded as it is.
+func ParseSignatures(r io.Reader) ([]*Signature, error) {
+	var (
+		sigs    []*Signature
+		label   *Label
+		dir     Direction
+		section bool // true in tcp sections
+		lineNum int
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, errors.Errorf("line %d: invalid section '%s'", lineNum, line)
+			}
+			label = nil
+			switch line[1 : len(line)-1] {
+			case Request.String():
+				dir, section = Request, true
+			case Response.String():
+				dir, section = Response, true
+			default:
+				section = false
+			}
+			continue
+		}
+		if !section {
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, errors.Errorf("line %d: '=' not found", lineNum)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "label":
+			l, err := parseLabel(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", lineNum)
+			}
+			label = l
+		case "sig":
+			if label == nil {
+				return nil, errors.Errorf("line %d: signature without label", lineNum)
+			}
+			s, err := ParseSignature(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", lineNum)
+			}
+			s.Label, s.Direction, s.Line = label, dir, lineNum
+			sigs = append(sigs, s)
+		case "sys":
+		default:
+			return nil, errors.Errorf("line %d: unknown key '%s'", lineNum, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read signatures")
+	}
+	return sigs, nil
+}
+
+func parseLabel(s string) (*Label, error) {
+	fields := strings.SplitN(s, ":", 4)
+	if len(fields) != 4 {
+		return nil, errors.Errorf("invalid label '%s'", s)
+	}
+	l := &Label{Class: fields[1], Name: fields[2], Flavor: fields[3]}
+	switch fields[0] {
+	case "s":
+	case "g":
+		l.Generic = true
+	default:
+		return nil, errors.Errorf("invalid label type '%s'", fields[0])
+	}
+	if l.Class == "!" { // p0f uses "!" for userland tools
+		l.Class = ""
+	}
+	return l, nil
+}
+
+// ParseSignature parses a signature like "*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0".
+// Label and Direction of returned Signature are not set.
+func ParseSignature(str string) (*Signature, error) {
+	fields := strings.Split(str, ":")
+	if len(fields) != 8 {
+		return nil, errors.Errorf("signature must have 8 fields, but got %d", len(fields))
+	}
+	s := &Signature{}
+	var err error
+
+	if s.Version, err = parseAny(fields[0], 4); err != nil || s.Version == 6 {
+		return nil, errors.Errorf("invalid version '%s'", fields[0])
+	}
+
+	// distance ("+N", "+?") and "-" for bad TTL are accepted but ignored
+	ttl := fields[1]
+	if i := strings.IndexAny(ttl, "+-"); i >= 0 {
+		ttl = ttl[:i]
+	}
+	if s.InitialTTL, err = strconv.Atoi(ttl); err != nil || s.InitialTTL <= 0 || s.InitialTTL > 255 {
+		return nil, errors.Errorf("invalid initial TTL '%s'", fields[1])
+	}
+
+	if s.OptionsLen, err = strconv.Atoi(fields[2]); err != nil || s.OptionsLen < 0 {
+		return nil, errors.Errorf("invalid options length '%s'", fields[2])
+	}
+	if s.MSS, err = parseAny(fields[3], 0xffff); err != nil {
+		return nil, errors.Errorf("invalid MSS '%s'", fields[3])
+	}
+
+	win := strings.Split(fields[4], ",")
+	if len(win) != 2 {
+		return nil, errors.Errorf("invalid window '%s'", fields[4])
+	}
+	if err := s.parseWindow(win[0]); err != nil {
+		return nil, err
+	}
+	if s.Scale, err = parseAny(win[1], 0xff); err != nil {
+		return nil, errors.Errorf("invalid window scale '%s'", win[1])
+	}
+
+	if s.Layout, err = parseLayout(fields[5]); err != nil {
+		return nil, err
+	}
+	if s.Quirks, err = parseQuirks(fields[6]); err != nil {
+		return nil, err
+	}
+
+	switch fields[7] {
+	case "*":
+		s.Payload = -1
+	case "0":
+		s.Payload = 0
+	case "+":
+		s.Payload = 1
+	default:
+		return nil, errors.Errorf("invalid payload class '%s'", fields[7])
+	}
+	return s, nil
+}
+
+func (s *Signature) parseWindow(str string) error {
+	var (
+		num string
+		err error
+	)
+	switch {
+	case str == "*":
+		s.windowType = windowAny
+		return nil
+	case strings.HasPrefix(str, "mss*"):
+		s.windowType, num = windowMSS, str[4:]
+	case strings.HasPrefix(str, "mtu*"):
+		s.windowType, num = windowMTU, str[4:]
+	case strings.HasPrefix(str, "%"):
+		s.windowType, num = windowMod, str[1:]
+	default:
+		s.windowType, num = windowFixed, str
+	}
+	if s.Window, err = strconv.Atoi(num); err != nil || s.Window < 0 || s.Window > 0xffff ||
+		s.windowType != windowFixed && s.Window == 0 {
+		return errors.Errorf("invalid window size '%s'", str)
+	}
+	return nil
+}
+
+// parseAny parses "*" as -1, otherwise a number up to max.
+func parseAny(s string, max int) (int, error) {
+	if s == "*" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > max {
+		return 0, errors.Errorf("invalid number '%s'", s)
+	}
+	return n, nil
+}
+
+func parseLayout(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	layout := strings.Split(s, ",")
+	for _, o := range layout {
+		switch {
+		case o == "mss", o == "nop", o == "ws", o == "sok", o == "sack", o == "ts":
+		case strings.HasPrefix(o, "eol+"):
+			if _, err := strconv.Atoi(o[4:]); err != nil {
+				return nil, errors.Errorf("invalid option '%s'", o)
+			}
+		case strings.HasPrefix(o, "?"):
+			if _, err := strconv.ParseUint(o[1:], 10, 8); err != nil {
+				return nil, errors.Errorf("invalid option '%s'", o)
+			}
+		default:
+			return nil, errors.Errorf("invalid option '%s'", o)
+		}
+	}
+	return layout, nil
+}
+
+func parseQuirks(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	quirks := strings.Split(s, ",")
+	for _, q := range quirks {
+		if quirkIndex(q) < 0 {
+			return nil, errors.Errorf("invalid quirk '%s'", q)
+		}
+	}
+	sortQuirks(quirks)
+	return quirks, nil
+}
+
+func quirkIndex(q string) int {
+	for i, name := range quirkOrder {
+		if q == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortQuirks(quirks []string) {
+	sort.Slice(quirks, func(i, j int) bool { return quirkIndex(quirks[i]) < quirkIndex(quirks[j]) })
+}
+
+// String returns s in p0f signature format.
+func (s *Signature) String() string {
+	wildcard := func(n int) string {
+		if n < 0 {
+			return "*"
+		}
+		return strconv.Itoa(n)
+	}
+	win := "*"
+	switch s.windowType {
+	case windowFixed:
+		win = strconv.Itoa(s.Window)
+	case windowMSS:
+		win = fmt.Sprintf("mss*%d", s.Window)
+	case windowMTU:
+		win = fmt.Sprintf("mtu*%d", s.Window)
+	case windowMod:
+		win = fmt.Sprintf("%%%d", s.Window)
+	}
+	payload := "*"
+	if s.Payload == 0 {
+		payload = "0"
+	} else if s.Payload == 1 {
+		payload = "+"
+	}
+	return fmt.Sprintf("%s:%d:%d:%s:%s,%s:%s:%s:%s", wildcard(s.Version), s.InitialTTL, s.OptionsLen, wildcard(s.MSS),
+		win, wildcard(s.Scale), strings.Join(s.Layout, ","), strings.Join(s.Quirks, ","), payload)
+}
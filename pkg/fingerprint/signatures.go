@@ -0,0 +1,118 @@
+package fingerprint
+
+import (
+	"strings"
+)
+
+// DefaultSignatures is the built-in signature database.
+// It covers common stacks only. Additional signatures can be loaded from a file in the same format.
+const DefaultSignatures = `
+; Built-in signatures in p0f v3 format.
+;
+; sig = ver:ittl:olen:mss:wsize,scale:olayout:quirks:pclass
+
+[tcp:request]
+
+label = s:unix:Linux:3.11 and newer
+sig   = *:64:0:*:mss*44,7:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*44,7:mss,sok,ts,nop,ws:df:0
+sig   = *:64:0:*:64240,7:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:64240,7:mss,sok,ts,nop,ws:df:0
+sig   = *:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:unix:Linux:3.1-3.10
+sig   = *:64:0:*:mss*10,4:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*10,5:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*10,6:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*10,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:unix:Linux:2.6.x
+sig   = *:64:0:*:mss*4,6:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*4,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:win:Windows:10 and newer
+sig   = *:128:0:*:64240,8:mss,nop,ws,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:65535,8:mss,nop,ws,nop,nop,sok:df,id+:0
+
+label = s:win:Windows:7 or 8
+sig   = *:128:0:*:8192,0:mss,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:8192,2:mss,nop,ws,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0
+
+label = s:win:Windows:XP
+sig   = *:128:0:*:16384,0:mss,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:65535,0:mss,nop,nop,sok:df,id+:0
+
+label = s:unix:Mac OS X:10.x and newer
+sig   = *:64:0:*:65535,1:mss,nop,ws,nop,nop,ts,sok,eol+1:df,id+:0
+sig   = *:64:0:*:65535,3:mss,nop,ws,nop,nop,ts,sok,eol+1:df,id+:0
+sig   = *:64:0:*:65535,4:mss,nop,ws,nop,nop,ts,sok,eol+1:df,id+:0
+sig   = *:64:0:*:65535,6:mss,nop,ws,nop,nop,ts,sok,eol+1:df,id+:0
+
+label = s:unix:FreeBSD:9.x and newer
+sig   = *:64:0:*:65535,6:mss,nop,ws,sok,ts:df,id+:0
+sig   = *:64:0:*:65535,6:mss,nop,ws,sok,ts:df:0
+
+label = s:unix:OpenBSD:5.x and newer
+sig   = *:64:0:*:16384,3:mss,nop,nop,sok,nop,ws,nop,nop,ts:df,id+:0
+sig   = *:64:0:*:16384,6:mss,nop,nop,sok,nop,ws,nop,nop,ts:df,id+:0
+
+label = s:!:nwspeaker:synscan
+sig   = 4:255:0:1460:1024,0:mss::0
+
+label = s:!:nwspeaker:tcp-host
+sig   = 4:64:0:*:65535,0:mss:df,id+:0
+
+label = g:unix:Linux:
+sig   = *:64:0:*:*,*:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:*,*:mss,sok,ts,nop,ws:df:0
+
+label = g:win:Windows:
+sig   = *:128:0:*:*,*:mss,nop,ws,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:*,*:mss,nop,nop,sok:df,id+:0
+
+[tcp:response]
+
+label = s:unix:Linux:3.x and newer
+sig   = *:64:0:*:65160,7:mss,sok,ts,nop,ws:df:0
+sig   = *:64:0:*:65160,7:mss,nop,nop,sok,nop,ws:df:0
+sig   = *:64:0:*:mss*10,*:mss,sok,ts,nop,ws:df:0
+sig   = *:64:0:*:mss*10,*:mss,nop,nop,sok,nop,ws:df:0
+sig   = *:64:0:*:mss*10,0:mss,sok,ts:df:0
+sig   = *:64:0:*:mss*10,0:mss:df:0
+
+label = s:win:Windows:7 and newer
+sig   = *:128:0:*:8192,8:mss,nop,ws,sok,ts:df,id+:0
+sig   = *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:65535,8:mss,nop,ws,sok,ts:df,id+:0
+sig   = *:128:0:*:65535,8:mss,nop,ws,nop,nop,sok:df,id+:0
+sig   = *:128:0:*:8192,0:mss:df,id+:0
+
+label = s:unix:FreeBSD:9.x and newer
+sig   = *:64:0:*:65535,6:mss,nop,ws,sok,ts:df,id+:0
+
+label = s:unix:Mac OS X:10.x and newer
+sig   = *:64:0:*:65535,*:mss,nop,ws,sok,ts,eol+1:df,id+:0
+sig   = *:64:0:*:65535,*:mss,nop,ws,nop,nop,ts,sok,eol+1:df,id+:0
+
+label = s:!:nwspeaker:tcp-host
+sig   = 4:64:0:*:65535,0:mss:df,id+:0
+
+label = g:unix:Linux:
+sig   = *:64:0:*:*,*:mss,sok,ts,nop,ws:df:0
+sig   = *:64:0:*:*,*:mss,nop,nop,sok,nop,ws:df:0
+
+label = g:win:Windows:
+sig   = *:128:0:*:*,*:mss,nop,ws,sok,ts:df,id+:0
+sig   = *:128:0:*:*,*:mss,nop,ws,nop,nop,sok:df,id+:0
+`
+
+// LoadDefaultSignatures returns the built-in signatures.
+func LoadDefaultSignatures() []*Signature {
+	sigs, err := ParseSignatures(strings.NewReader(DefaultSignatures))
+	if err != nil {
+		panic(err) // built-in signatures must be valid
+	}
+	return sigs
+}
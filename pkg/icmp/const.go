@@ -8,4 +8,142 @@ const (
 	TypeEcho = 8
 	// TypeEchoReply is the type number of ICMP Echo Reply message
 	TypeEchoReply = 0
+	// TypeDestinationUnreachable is the type number of ICMP Destination Unreachable message
+	TypeDestinationUnreachable = 3
+	// TypeSourceQuench is the type number of ICMP Source Quench message
+	TypeSourceQuench = 4
+	// TypeRedirect is the type number of ICMP Redirect message
+	TypeRedirect = 5
+	// TypeTimeExceeded is the type number of ICMP Time Exceeded message
+	TypeTimeExceeded = 11
+	// TypeParameterProblem is the type number of ICMP Parameter Problem message
+	TypeParameterProblem = 12
+	// TypeRouterAdvertisement is the type number of ICMP Router Advertisement message
+	TypeRouterAdvertisement = 9
+	// TypeRouterSolicitation is the type number of ICMP Router Solicitation message
+	TypeRouterSolicitation = 10
+	// TypeTimestamp is the type number of ICMP Timestamp message
+	TypeTimestamp = 13
+	// TypeTimestampReply is the type number of ICMP Timestamp Reply message
+	TypeTimestampReply = 14
+	// TypeAddressMaskRequest is the type number of ICMP Address Mask Request message
+	TypeAddressMaskRequest = 17
+	// TypeAddressMaskReply is the type number of ICMP Address Mask Reply message
+	TypeAddressMaskReply = 18
+)
+
+// Codes of Destination Unreachable message.
+const (
+	// CodeNetUnreachable represents the destination network is unreachable
+	CodeNetUnreachable = 0
+	// CodeHostUnreachable represents the destination host is unreachable
+	CodeHostUnreachable = 1
+	// CodeProtocolUnreachable represents the protocol of the datagram is not supported by the destination
+	CodeProtocolUnreachable = 2
+	// CodePortUnreachable represents the destination port is not listened
+	CodePortUnreachable = 3
+	// CodeFragmentationNeeded represents the datagram must be fragmented but DF flag is set
+	CodeFragmentationNeeded = 4
+	// CodeSourceRouteFailed represents the source route option could not be followed
+	CodeSourceRouteFailed = 5
+	// CodeDestinationNetworkUnknown represents the destination network is unknown
+	CodeDestinationNetworkUnknown = 6
+	// CodeDestinationHostUnknown represents the destination host is unknown
+	CodeDestinationHostUnknown = 7
+	// CodeSourceHostIsolated represents the source host is isolated
+	CodeSourceHostIsolated = 8
+	// CodeNetProhibited represents the communication with the destination network is administratively prohibited
+	CodeNetProhibited = 9
+	// CodeHostProhibited represents the communication with the destination host is administratively prohibited
+	CodeHostProhibited = 10
+	// CodeNetUnreachableForTOS represents the destination network is unreachable for the type of service
+	CodeNetUnreachableForTOS = 11
+	// CodeHostUnreachableForTOS represents the destination host is unreachable for the type of service
+	CodeHostUnreachableForTOS = 12
+	// CodeCommunicationProhibited represents the communication is administratively prohibited
+	CodeCommunicationProhibited = 13
+	// CodeHostPrecedenceViolation represents the requested precedence is not permitted
+	CodeHostPrecedenceViolation = 14
+	// CodePrecedenceCutoff represents the precedence of the datagram is below the cutoff level
+	CodePrecedenceCutoff = 15
+)
+
+// Codes of Time Exceeded message.
+const (
+	// CodeTTLExceeded represents the TTL was exceeded in transit
+	CodeTTLExceeded = 0
+	// CodeReassemblyTimeExceeded represents the fragment reassembly time was exceeded
+	CodeReassemblyTimeExceeded = 1
+)
+
+// Codes of Redirect message.
+const (
+	// CodeRedirectNet represents datagrams for the network should be redirected
+	CodeRedirectNet = 0
+	// CodeRedirectHost represents datagrams for the host should be redirected
+	CodeRedirectHost = 1
+	// CodeRedirectTOSNet represents datagrams for the type of service and network should be redirected
+	CodeRedirectTOSNet = 2
+	// CodeRedirectTOSHost represents datagrams for the type of service and host should be redirected
+	CodeRedirectTOSHost = 3
+)
+
+// Codes of Parameter Problem message.
+const (
+	// CodePointerIndicatesError represents the pointer indicates the octet where an error was detected
+	CodePointerIndicatesError = 0
+	// CodeMissingRequiredOption represents a required option is missing
+	CodeMissingRequiredOption = 1
+	// CodeBadLength represents the length of the datagram is bad
+	CodeBadLength = 2
+)
+
+// Constants of ICMP extension structure (RFC 4884, RFC 4950 and RFC 5837).
+const (
+	// ExtensionVersion is the version of ICMP extension structure
+	ExtensionVersion = 2
+	// ExtensionHeaderLen is the length of ICMP extension header
+	ExtensionHeaderLen = 4
+	// ExtensionObjectHeaderLen is the length of ICMP extension object header
+	ExtensionObjectHeaderLen = 4
+	// MinOriginalLen is the minimum length of original datagram field when extension is appended
+	MinOriginalLen = 128
+
+	// ClassMPLSLabelStack is the class number of MPLS Label Stack object
+	ClassMPLSLabelStack = 1
+	// CTypeIncomingLabelStack is the c-type of incoming MPLS label stack
+	CTypeIncomingLabelStack = 1
+	// ClassInterfaceInfo is the class number of Interface Information object
+	ClassInterfaceInfo = 2
+
+	// RoleIncoming represents the interface the datagram arrived
+	RoleIncoming = 0
+	// RoleSubIP represents the sub-IP component of the incoming interface
+	RoleSubIP = 1
+	// RoleOutgoing represents the interface the datagram would be forwarded
+	RoleOutgoing = 2
+	// RoleNextHop represents the IP next hop the datagram would be forwarded
+	RoleNextHop = 3
+
+	// AFIIPv4 is the address family identifier of IPv4
+	AFIIPv4 = 1
+	// AFIIPv6 is the address family identifier of IPv6
+	AFIIPv6 = 2
+)
+
+const (
+	ifInfoIfIndex = 0x1 << 3
+	ifInfoIPAddr  = 0x1 << 2
+	ifInfoName    = 0x1 << 1
+	ifInfoMTU     = 0x1
+
+	maxInterfaceNameLen = 63
+)
+
+const (
+	// RouterEntrySize is the number of 32-bit words per router address in Router Advertisement
+	RouterEntrySize = 2
+
+	// DefaultQuoteDataLen is the length of the original data quoted in ICMP error messages (RFC 792)
+	DefaultQuoteDataLen = 8
 )
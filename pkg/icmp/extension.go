@@ -0,0 +1,279 @@
+package icmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"github.com/mas9612/nwspeaker/pkg/checksum"
+	"github.com/pkg/errors"
+)
+
+// Extension represents ICMP extension structure appended to multi-part messages (RFC 4884).
+type Extension struct {
+	Version uint8
+	Objects []ExtensionObject
+}
+
+// ExtensionObject represents an object in the ICMP extension structure.
+type ExtensionObject struct {
+	ClassNum uint8
+	CType    uint8
+	Data     []byte
+}
+
+// Encode returns byte-encoded data of Extension with checksum.
+func (e *Extension) Encode() []byte {
+	length := ExtensionHeaderLen
+	for _, o := range e.Objects {
+		length += ExtensionObjectHeaderLen + len(o.Data)
+	}
+	buffer := make([]byte, length)
+	buffer[0] = e.Version << 4
+
+	offset := ExtensionHeaderLen
+	for _, o := range e.Objects {
+		binary.BigEndian.PutUint16(buffer[offset:], uint16(ExtensionObjectHeaderLen+len(o.Data)))
+		buffer[offset+2] = o.ClassNum
+		buffer[offset+3] = o.CType
+		copy(buffer[offset+ExtensionObjectHeaderLen:], o.Data)
+		offset += ExtensionObjectHeaderLen + len(o.Data)
+	}
+	copy(buffer[2:], checksum.SumOfOnesComplement16(buffer))
+	return buffer
+}
+
+// ParseExtension parses given ICMP extension structure.
+// It returns error if the checksum or the length of objects is invalid.
+func ParseExtension(b []byte) (*Extension, error) {
+	if len(b) < ExtensionHeaderLen {
+		return nil, errors.New("extension is shorter than its header")
+	}
+	if binary.BigEndian.Uint16(b[2:]) != 0 && !bytes.Equal(checksum.SumOfOnesComplement16(b), []byte{0x00, 0x00}) {
+		return nil, errors.New("invalid extension checksum")
+	}
+
+	e := &Extension{
+		Version: b[0] >> 4,
+	}
+	for b = b[ExtensionHeaderLen:]; len(b) > 0; {
+		if len(b) < ExtensionObjectHeaderLen {
+			return nil, errors.New("extension object is shorter than its header")
+		}
+		length := int(binary.BigEndian.Uint16(b))
+		if length < ExtensionObjectHeaderLen || length > len(b) {
+			return nil, errors.Errorf("invalid extension object length %d", length)
+		}
+		o := ExtensionObject{
+			ClassNum: b[2],
+			CType:    b[3],
+			Data:     make([]byte, length-ExtensionObjectHeaderLen),
+		}
+		copy(o.Data, b[ExtensionObjectHeaderLen:length])
+		e.Objects = append(e.Objects, o)
+		b = b[length:]
+	}
+	return e, nil
+}
+
+// encodeMultipart returns the original datagram field and its length in 32-bit words.
+// If ext is nil, original is returned as it is and the length is zero.
+// Otherwise original is zero-padded to 32-bit boundary and at least 128 bytes,
+// and the extension structure follows it (RFC 4884).
+func encodeMultipart(original []byte, ext *Extension) ([]byte, uint8) {
+	if ext == nil {
+		return original, 0
+	}
+	padded := (len(original) + 3) / 4 * 4
+	if padded < MinOriginalLen {
+		padded = MinOriginalLen
+	}
+	e := ext.Encode()
+	buffer := make([]byte, padded+len(e))
+	copy(buffer, original)
+	copy(buffer[padded:], e)
+	return buffer, uint8(padded / 4)
+}
+
+// parseMultipart splits the original datagram field and the extension structure.
+// length is the length field of the message in 32-bit words.
+// Messages from implementations before RFC 4884 do not have the length field and
+// append extension after 128 bytes of original datagram. Such extension is accepted
+// only when its checksum is valid.
+func parseMultipart(b []byte, length uint8) ([]byte, *Extension) {
+	split := int(length) * 4
+	if length == 0 {
+		if len(b) <= MinOriginalLen || b[MinOriginalLen]>>4 != ExtensionVersion {
+			return b, nil
+		}
+		split = MinOriginalLen
+		ext, err := ParseExtension(b[split:])
+		if err != nil || binary.BigEndian.Uint16(b[split+2:]) == 0 {
+			return b, nil
+		}
+		return b[:split], ext
+	}
+	if split >= len(b) {
+		return b, nil
+	}
+	ext, err := ParseExtension(b[split:])
+	if err != nil {
+		return b, nil
+	}
+	return b[:split], ext
+}
+
+// MPLSLabel represents an entry of MPLS label stack (RFC 4950).
+type MPLSLabel struct {
+	Label         uint32
+	TrafficClass  uint8
+	BottomOfStack bool
+	TTL           uint8
+}
+
+// NewMPLSLabelStackObject returns MPLS Label Stack extension object.
+func NewMPLSLabelStackObject(labels []MPLSLabel) ExtensionObject {
+	data := make([]byte, 4*len(labels))
+	for i, l := range labels {
+		entry := l.Label<<12 | uint32(l.TrafficClass&0x7)<<9 | uint32(l.TTL)
+		if l.BottomOfStack {
+			entry |= 0x1 << 8
+		}
+		binary.BigEndian.PutUint32(data[i*4:], entry)
+	}
+	return ExtensionObject{
+		ClassNum: ClassMPLSLabelStack,
+		CType:    CTypeIncomingLabelStack,
+		Data:     data,
+	}
+}
+
+// MPLSLabels decodes MPLS Label Stack object.
+func (o *ExtensionObject) MPLSLabels() ([]MPLSLabel, error) {
+	if o.ClassNum != ClassMPLSLabelStack || o.CType != CTypeIncomingLabelStack {
+		return nil, errors.Errorf("object (class %d, c-type %d) is not MPLS label stack", o.ClassNum, o.CType)
+	}
+	if len(o.Data)%4 != 0 {
+		return nil, errors.New("invalid length of MPLS label stack")
+	}
+	labels := make([]MPLSLabel, len(o.Data)/4)
+	for i := range labels {
+		entry := binary.BigEndian.Uint32(o.Data[i*4:])
+		labels[i] = MPLSLabel{
+			Label:         entry >> 12,
+			TrafficClass:  uint8(entry>>9) & 0x7,
+			BottomOfStack: entry&(0x1<<8) != 0,
+			TTL:           uint8(entry),
+		}
+	}
+	return labels, nil
+}
+
+// InterfaceInfo represents Interface Information object (RFC 5837).
+// Zero value fields are not included in the object.
+type InterfaceInfo struct {
+	Role    uint8 // one of RoleIncoming, RoleSubIP, RoleOutgoing and RoleNextHop
+	IfIndex uint32
+	Addr    net.IP
+	Name    string
+	MTU     uint32
+}
+
+// NewInterfaceInfoObject returns Interface Information extension object.
+func NewInterfaceInfoObject(info *InterfaceInfo) ExtensionObject {
+	ctype := info.Role << 6
+	data := make([]byte, 0, 64)
+	if info.IfIndex != 0 {
+		ctype |= ifInfoIfIndex
+		data = appendUint32(data, info.IfIndex)
+	}
+	if info.Addr != nil {
+		ctype |= ifInfoIPAddr
+		if ip4 := info.Addr.To4(); ip4 != nil {
+			data = append(data, 0x00, AFIIPv4, 0x00, 0x00)
+			data = append(data, ip4...)
+		} else {
+			data = append(data, 0x00, AFIIPv6, 0x00, 0x00)
+			data = append(data, info.Addr.To16()...)
+		}
+	}
+	if info.Name != "" {
+		ctype |= ifInfoName
+		name := info.Name
+		if len(name) > maxInterfaceNameLen {
+			name = name[:maxInterfaceNameLen]
+		}
+		length := (1 + len(name) + 3) / 4 * 4
+		sub := make([]byte, length)
+		sub[0] = uint8(length)
+		copy(sub[1:], name)
+		data = append(data, sub...)
+	}
+	if info.MTU != 0 {
+		ctype |= ifInfoMTU
+		data = appendUint32(data, info.MTU)
+	}
+	return ExtensionObject{
+		ClassNum: ClassInterfaceInfo,
+		CType:    ctype,
+		Data:     data,
+	}
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return append(b, buf...)
+}
+
+// InterfaceInfo decodes Interface Information object.
+func (o *ExtensionObject) InterfaceInfo() (*InterfaceInfo, error) {
+	if o.ClassNum != ClassInterfaceInfo {
+		return nil, errors.Errorf("object (class %d, c-type %d) is not interface information", o.ClassNum, o.CType)
+	}
+	info := &InterfaceInfo{
+		Role: o.CType >> 6,
+	}
+	b := o.Data
+	if o.CType&ifInfoIfIndex != 0 {
+		if len(b) < 4 {
+			return nil, errors.New("interface information is too short for ifIndex")
+		}
+		info.IfIndex = binary.BigEndian.Uint32(b)
+		b = b[4:]
+	}
+	if o.CType&ifInfoIPAddr != 0 {
+		if len(b) < 4 {
+			return nil, errors.New("interface information is too short for IP address")
+		}
+		addrLen := 0
+		switch binary.BigEndian.Uint16(b) {
+		case AFIIPv4:
+			addrLen = net.IPv4len
+		case AFIIPv6:
+			addrLen = net.IPv6len
+		default:
+			return nil, errors.Errorf("unknown address family %d", binary.BigEndian.Uint16(b))
+		}
+		if len(b) < 4+addrLen {
+			return nil, errors.New("interface information is too short for IP address")
+		}
+		info.Addr = make(net.IP, addrLen)
+		copy(info.Addr, b[4:])
+		b = b[4+addrLen:]
+	}
+	if o.CType&ifInfoName != 0 {
+		if len(b) < 1 || int(b[0]) > len(b) || b[0] == 0 || b[0]%4 != 0 {
+			return nil, errors.New("invalid interface name sub-object")
+		}
+		info.Name = string(bytes.TrimRight(b[1:b[0]], "\x00"))
+		b = b[b[0]:]
+	}
+	if o.CType&ifInfoMTU != 0 {
+		if len(b) < 4 {
+			return nil, errors.New("interface information is too short for MTU")
+		}
+		info.MTU = binary.BigEndian.Uint32(b)
+	}
+	return info, nil
+}
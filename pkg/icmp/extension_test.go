@@ -0,0 +1,143 @@
+package icmp
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMPLSLabels(t *testing.T) {
+	labels := []MPLSLabel{
+		{Label: 16005, TrafficClass: 0, BottomOfStack: false, TTL: 1},
+		{Label: 24001, TrafficClass: 5, BottomOfStack: true, TTL: 254},
+	}
+	o := NewMPLSLabelStackObject(labels)
+	want := []byte{0x03, 0xe8, 0x50, 0x01, 0x05, 0xdc, 0x1b, 0xfe}
+	if !bytes.Equal(o.Data, want) {
+		t.Errorf("NewMPLSLabelStackObject() = %x, but got %x\n", want, o.Data)
+	}
+	got, err := o.MPLSLabels()
+	if err != nil {
+		t.Fatalf("MPLSLabels() returns error: %v\n", err)
+	}
+	if !reflect.DeepEqual(got, labels) {
+		t.Errorf("MPLSLabels() = %v, but got %v\n", labels, got)
+	}
+
+	o.Data = o.Data[:3]
+	if _, err := o.MPLSLabels(); err == nil {
+		t.Errorf("MPLSLabels() with truncated data should return error\n")
+	}
+	o.ClassNum = ClassInterfaceInfo
+	if _, err := o.MPLSLabels(); err == nil {
+		t.Errorf("MPLSLabels() with other class should return error\n")
+	}
+}
+
+func TestInterfaceInfo(t *testing.T) {
+	tests := []*InterfaceInfo{
+		{
+			Role:    RoleIncoming,
+			IfIndex: 3,
+			Addr:    net.IPv4(192, 168, 0, 1).To4(),
+			Name:    "eth0",
+			MTU:     1500,
+		},
+		{
+			Role: RoleOutgoing,
+			Addr: net.ParseIP("2001:db8::1"),
+		},
+		{
+			Role: RoleNextHop,
+			Name: "GigabitEthernet0/0/0",
+		},
+	}
+	for _, tt := range tests {
+		o := NewInterfaceInfoObject(tt)
+		if len(o.Data)%4 != 0 {
+			t.Errorf("length of interface information object must be multiple of 4, but got %d\n", len(o.Data))
+		}
+		got, err := o.InterfaceInfo()
+		if err != nil {
+			t.Errorf("InterfaceInfo() returns error: %v\n", err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt) {
+			t.Errorf("InterfaceInfo() = %+v, but got %+v\n", tt, got)
+		}
+	}
+}
+
+func TestExtension(t *testing.T) {
+	ext := &Extension{
+		Version: ExtensionVersion,
+		Objects: []ExtensionObject{
+			NewMPLSLabelStackObject([]MPLSLabel{{Label: 100, BottomOfStack: true, TTL: 1}}),
+		},
+	}
+	b := ext.Encode()
+	want := []byte{0x20, 0x00, 0x9d, 0xef, 0x00, 0x08, 0x01, 0x01, 0x00, 0x06, 0x41, 0x01}
+	if !bytes.Equal(b, want) {
+		t.Errorf("Encode() = %x, but got %x\n", want, b)
+	}
+	got, err := ParseExtension(b)
+	if err != nil {
+		t.Fatalf("ParseExtension() returns error: %v\n", err)
+	}
+	if !reflect.DeepEqual(got, ext) {
+		t.Errorf("ParseExtension() = %v, but got %v\n", ext, got)
+	}
+
+	b[len(b)-1] ^= 0xff
+	if _, err := ParseExtension(b); err == nil {
+		t.Errorf("ParseExtension() with invalid checksum should return error\n")
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	ext := &Extension{
+		Version: ExtensionVersion,
+		Objects: []ExtensionObject{
+			NewMPLSLabelStackObject([]MPLSLabel{{Label: 100, BottomOfStack: true, TTL: 1}}),
+		},
+	}
+	msg, err := NewTimeExceeded(CodeTTLExceeded, testOriginal, SetExtension(ext))
+	if err != nil {
+		t.Fatalf("NewTimeExceeded() returns error: %v\n", err)
+	}
+	quote := msg.Data.(*Error).Original
+	b := msg.Encode()
+	if b[5] != MinOriginalLen/4 {
+		t.Errorf("length field = %d, but got %d\n", MinOriginalLen/4, b[5])
+	}
+	if len(b) != HeaderLen+4+MinOriginalLen+len(ext.Encode()) {
+		t.Errorf("length of message = %d, but got %d\n", HeaderLen+4+MinOriginalLen+len(ext.Encode()), len(b))
+	}
+
+	m := Parse(b)
+	if m == nil {
+		t.Fatalf("Parse() returns nil\n")
+	}
+	data := m.Data.(*Error)
+	if !bytes.Equal(data.Original[:len(quote)], quote) {
+		t.Errorf("Original = %x, but got %x\n", quote, data.Original)
+	}
+	if !reflect.DeepEqual(data.Extension, ext) {
+		t.Errorf("Extension = %v, but got %v\n", ext, data.Extension)
+	}
+
+	// implementations before RFC 4884 do not set length field
+	b[5] = 0
+	m = Parse(b)
+	if m == nil || !reflect.DeepEqual(m.Data.(*Error).Extension, ext) {
+		t.Errorf("extension without length field is not parsed\n")
+	}
+
+	// without extension, whole data is the original datagram
+	b = b[:HeaderLen+4+MinOriginalLen+1]
+	m = Parse(b)
+	if m == nil || m.Data.(*Error).Extension != nil || len(m.Data.(*Error).Original) != MinOriginalLen+1 {
+		t.Errorf("data without extension must be parsed as original datagram\n")
+	}
+}
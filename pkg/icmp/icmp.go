@@ -3,16 +3,11 @@ package icmp
 import (
 	"encoding/binary"
 	"math/rand"
+	"net"
 	"time"
 
 	"github.com/mas9612/nwspeaker/pkg/checksum"
-)
-
-var (
-	supported = []uint8{
-		TypeEcho,
-		TypeEchoReply,
-	}
+	"github.com/pkg/errors"
 )
 
 // Message represents the ICMP message.
@@ -45,9 +40,12 @@ type Payload interface {
 type Option func(*config)
 
 type config struct {
-	srcMac string
-	srcIP  string
-	data   []byte
+	srcMac     string
+	srcIP      string
+	data       []byte
+	nextHopMTU uint16
+	quoteLen   int
+	extension  *Extension
 }
 
 // Echo represents the data of ICMP Echo and Echo Reply message.
@@ -64,6 +62,30 @@ func SetData(data []byte) Option {
 	}
 }
 
+// SetNextHopMTU sets next-hop MTU for Destination Unreachable message with CodeFragmentationNeeded.
+func SetNextHopMTU(mtu uint16) Option {
+	return func(c *config) {
+		c.nextHopMTU = mtu
+	}
+}
+
+// SetQuoteLen sets the length of the original data quoted in ICMP error message.
+// The original IP header is always quoted. Default is DefaultQuoteDataLen.
+// If n is negative, whole original datagram is quoted.
+func SetQuoteLen(n int) Option {
+	return func(c *config) {
+		c.quoteLen = n
+	}
+}
+
+// SetExtension sets ICMP extension structure (RFC 4884) appended to
+// Destination Unreachable, Time Exceeded and Parameter Problem message.
+func SetExtension(ext *Extension) Option {
+	return func(c *config) {
+		c.extension = ext
+	}
+}
+
 // NewEcho creates ICMP Echo message and return it.
 func NewEcho(outIfname, dstIP, dstMac string, opts ...Option) (*Message, error) {
 	c := config{}
@@ -97,3 +119,254 @@ func (e *Echo) Encode() []byte {
 	copy(buffer[4:], e.Data)
 	return buffer
 }
+
+// Quote returns IP header and leading n bytes of data of the original datagram.
+// If n is negative or original is shorter, whole original datagram is returned.
+func Quote(original []byte, n int) ([]byte, error) {
+	if len(original) < 20 || original[0]>>4 != 4 {
+		return nil, errors.New("original datagram is not an IPv4 packet")
+	}
+	ihl := int(original[0]&0x0f) * 4
+	if ihl < 20 || len(original) < ihl {
+		return nil, errors.New("original datagram has invalid header length")
+	}
+	if n < 0 || ihl+n > len(original) {
+		n = len(original) - ihl
+	}
+	quote := make([]byte, ihl+n)
+	copy(quote, original)
+	return quote, nil
+}
+
+// quoteOriginal applies opts and returns the part of original datagram quoted in ICMP error message.
+func quoteOriginal(original []byte, opts []Option) (config, []byte, error) {
+	c := config{
+		quoteLen: DefaultQuoteDataLen,
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	quote, err := Quote(original, c.quoteLen)
+	return c, quote, err
+}
+
+// NewDestinationUnreachable creates ICMP Destination Unreachable message which quotes original datagram.
+// Use SetNextHopMTU to set next-hop MTU of CodeFragmentationNeeded.
+func NewDestinationUnreachable(code uint8, original []byte, opts ...Option) (*Message, error) {
+	c, quote, err := quoteOriginal(original, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Type: TypeDestinationUnreachable,
+		Code: code,
+		Data: &Error{
+			NextHopMTU: c.nextHopMTU,
+			Original:   quote,
+			Extension:  c.extension,
+		},
+	}, nil
+}
+
+// NewTimeExceeded creates ICMP Time Exceeded message which quotes original datagram.
+func NewTimeExceeded(code uint8, original []byte, opts ...Option) (*Message, error) {
+	c, quote, err := quoteOriginal(original, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Type: TypeTimeExceeded,
+		Code: code,
+		Data: &Error{
+			Original:  quote,
+			Extension: c.extension,
+		},
+	}, nil
+}
+
+// NewSourceQuench creates ICMP Source Quench message which quotes original datagram.
+func NewSourceQuench(original []byte, opts ...Option) (*Message, error) {
+	_, quote, err := quoteOriginal(original, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Type: TypeSourceQuench,
+		Data: &Error{Original: quote},
+	}, nil
+}
+
+// NewRedirect creates ICMP Redirect message which quotes original datagram.
+func NewRedirect(code uint8, gateway net.IP, original []byte, opts ...Option) (*Message, error) {
+	if gateway.To4() == nil {
+		return nil, errors.Errorf("gateway '%s' is not an IPv4 address", gateway)
+	}
+	_, quote, err := quoteOriginal(original, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Type: TypeRedirect,
+		Code: code,
+		Data: &Redirect{
+			Gateway:  gateway,
+			Original: quote,
+		},
+	}, nil
+}
+
+// NewParameterProblem creates ICMP Parameter Problem message which quotes original datagram.
+// pointer is the octet offset of the original datagram where an error was detected.
+func NewParameterProblem(code, pointer uint8, original []byte, opts ...Option) (*Message, error) {
+	c, quote, err := quoteOriginal(original, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Type: TypeParameterProblem,
+		Code: code,
+		Data: &ParameterProblem{
+			Pointer:   pointer,
+			Original:  quote,
+			Extension: c.extension,
+		},
+	}, nil
+}
+
+// Error represents the data of ICMP error message like Destination Unreachable and Time Exceeded.
+type Error struct {
+	NextHopMTU uint16     // only used by Destination Unreachable with CodeFragmentationNeeded
+	Original   []byte     // IP header and leading data of the original datagram
+	Extension  *Extension // not used by Source Quench
+}
+
+// Encode returns byte-encoded data of Error message.
+// If Extension is set, the original datagram is padded and the length field is set (RFC 4884).
+func (e *Error) Encode() []byte {
+	original, length := encodeMultipart(e.Original, e.Extension)
+	buffer := make([]byte, 4+len(original))
+	buffer[1] = length
+	binary.BigEndian.PutUint16(buffer[2:], e.NextHopMTU)
+	copy(buffer[4:], original)
+	return buffer
+}
+
+// Redirect represents the data of ICMP Redirect message.
+type Redirect struct {
+	Gateway  net.IP
+	Original []byte
+}
+
+// Encode returns byte-encoded data of Redirect message.
+func (r *Redirect) Encode() []byte {
+	buffer := make([]byte, 4+len(r.Original))
+	copy(buffer[0:], r.Gateway.To4())
+	copy(buffer[4:], r.Original)
+	return buffer
+}
+
+// ParameterProblem represents the data of ICMP Parameter Problem message.
+type ParameterProblem struct {
+	Pointer   uint8
+	Original  []byte
+	Extension *Extension
+}
+
+// Encode returns byte-encoded data of ParameterProblem message.
+// If Extension is set, the original datagram is padded and the length field is set (RFC 4884).
+func (p *ParameterProblem) Encode() []byte {
+	original, length := encodeMultipart(p.Original, p.Extension)
+	buffer := make([]byte, 4+len(original))
+	buffer[0] = p.Pointer
+	buffer[1] = length
+	copy(buffer[4:], original)
+	return buffer
+}
+
+// Raw represents the data of ICMP message whose type is not supported.
+type Raw []byte
+
+// Encode returns byte-encoded data of Raw message.
+func (r Raw) Encode() []byte {
+	return r
+}
+
+// Parse parses given ICMP message and returns a pointer to Message instance.
+// b must not include IPv4 header.
+func Parse(b []byte) *Message {
+	if len(b) < HeaderLen+4 { // incomplete message
+		return nil
+	}
+
+	m := &Message{
+		Type:     b[0],
+		Code:     b[1],
+		Checksum: binary.BigEndian.Uint16(b[2:]),
+	}
+	body := b[HeaderLen:]
+	switch m.Type {
+	case TypeEcho, TypeEchoReply:
+		echo := &Echo{
+			Identifier:     binary.BigEndian.Uint16(body[0:]),
+			SequenceNumber: binary.BigEndian.Uint16(body[2:]),
+			Data:           make([]byte, len(body)-4),
+		}
+		copy(echo.Data, body[4:])
+		m.Data = echo
+	case TypeTimestamp, TypeTimestampReply:
+		ts := parseTimestamp(body)
+		if ts == nil {
+			return nil
+		}
+		m.Data = ts
+	case TypeAddressMaskRequest, TypeAddressMaskReply:
+		am := parseAddressMask(body)
+		if am == nil {
+			return nil
+		}
+		m.Data = am
+	case TypeRouterAdvertisement:
+		ra := parseRouterAdvertisement(body)
+		if ra == nil {
+			return nil
+		}
+		m.Data = ra
+	case TypeRouterSolicitation:
+		m.Data = &RouterSolicitation{}
+	case TypeRedirect:
+		r := &Redirect{
+			Gateway:  net.IPv4(body[0], body[1], body[2], body[3]),
+			Original: make([]byte, len(body)-4),
+		}
+		copy(r.Original, body[4:])
+		m.Data = r
+	case TypeParameterProblem:
+		original, ext := parseMultipart(body[4:], body[1])
+		p := &ParameterProblem{
+			Pointer:   body[0],
+			Original:  make([]byte, len(original)),
+			Extension: ext,
+		}
+		copy(p.Original, original)
+		m.Data = p
+	case TypeDestinationUnreachable, TypeTimeExceeded, TypeSourceQuench:
+		original, ext := body[4:], (*Extension)(nil)
+		if m.Type != TypeSourceQuench {
+			original, ext = parseMultipart(original, body[1])
+		}
+		e := &Error{
+			Original:  make([]byte, len(original)),
+			Extension: ext,
+		}
+		if m.Type == TypeDestinationUnreachable && m.Code == CodeFragmentationNeeded {
+			e.NextHopMTU = binary.BigEndian.Uint16(body[2:])
+		}
+		copy(e.Original, original)
+		m.Data = e
+	default:
+		raw := make(Raw, len(body))
+		copy(raw, body)
+		m.Data = raw
+	}
+	return m
+}
@@ -0,0 +1,269 @@
+package icmp
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var encodeTests = []struct {
+	in  *Message
+	out []byte
+}{
+	{
+		in: &Message{
+			Type: TypeEcho,
+			Data: &Echo{
+				Identifier:     0x2fa1,
+				SequenceNumber: 0x0000,
+				Data:           []byte{0xff, 0xff},
+			},
+		},
+		out: []byte{0x08, 0x00, 0xc8, 0x5e, 0x2f, 0xa1, 0x00, 0x00, 0xff, 0xff},
+	},
+	{
+		in: &Message{
+			Type: TypeDestinationUnreachable,
+			Code: CodeFragmentationNeeded,
+			Data: &Error{
+				NextHopMTU: 1400,
+				Original:   []byte{0x45, 0x00},
+			},
+		},
+		out: []byte{0x03, 0x04, 0xb2, 0x83, 0x00, 0x00, 0x05, 0x78, 0x45, 0x00},
+	},
+}
+
+func TestEncode(t *testing.T) {
+	for _, tt := range encodeTests {
+		b := tt.in.Encode()
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("Encode() = %x, but got %x\n", tt.out, b)
+		}
+	}
+}
+
+var parseTests = []struct {
+	in  []byte
+	out *Message
+}{
+	{
+		in: []byte{0x00, 0x00, 0xd0, 0x5e, 0x2f, 0xa1, 0x00, 0x00, 0xff, 0xff},
+		out: &Message{
+			Type:     TypeEchoReply,
+			Checksum: 0xd05e,
+			Data: &Echo{
+				Identifier:     0x2fa1,
+				SequenceNumber: 0x0000,
+				Data:           []byte{0xff, 0xff},
+			},
+		},
+	},
+	{
+		in: []byte{0x03, 0x04, 0xb2, 0x83, 0x00, 0x00, 0x05, 0x78, 0x45, 0x00},
+		out: &Message{
+			Type:     TypeDestinationUnreachable,
+			Code:     CodeFragmentationNeeded,
+			Checksum: 0xb283,
+			Data: &Error{
+				NextHopMTU: 1400,
+				Original:   []byte{0x45, 0x00},
+			},
+		},
+	},
+	{
+		in: []byte{0x0b, 0x00, 0xf4, 0xff, 0x00, 0x00, 0x00, 0x00},
+		out: &Message{
+			Type:     TypeTimeExceeded,
+			Checksum: 0xf4ff,
+			Data: &Error{
+				Original: []byte{},
+			},
+		},
+	},
+	{
+		in:  []byte{0x08, 0x00, 0x00},
+		out: nil,
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		m := Parse(tt.in)
+		if !reflect.DeepEqual(m, tt.out) {
+			t.Errorf("Parse(%x) = %v, but got %v\n", tt.in, tt.out, m)
+		}
+	}
+}
+
+var testOriginal = []byte{
+	0x45, 0x00, 0x00, 0x24, 0x00, 0x01, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01,
+	0xc0, 0xa8, 0x00, 0x02, 0x82, 0x9a, 0x00, 0x35, 0x00, 0x10, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04,
+	0x05, 0x06, 0x07, 0x08,
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func() (*Message, error)
+		out  *Message
+	}{
+		{
+			name: "NewDestinationUnreachable",
+			new: func() (*Message, error) {
+				return NewDestinationUnreachable(CodeFragmentationNeeded, testOriginal, SetNextHopMTU(1400))
+			},
+			out: &Message{
+				Type: TypeDestinationUnreachable,
+				Code: CodeFragmentationNeeded,
+				Data: &Error{NextHopMTU: 1400, Original: testOriginal[:28]},
+			},
+		},
+		{
+			name: "NewTimeExceeded",
+			new: func() (*Message, error) {
+				return NewTimeExceeded(CodeTTLExceeded, testOriginal, SetQuoteLen(-1))
+			},
+			out: &Message{
+				Type: TypeTimeExceeded,
+				Code: CodeTTLExceeded,
+				Data: &Error{Original: testOriginal},
+			},
+		},
+		{
+			name: "NewRedirect",
+			new: func() (*Message, error) {
+				return NewRedirect(CodeRedirectHost, net.IPv4(192, 168, 0, 254), testOriginal, SetQuoteLen(0))
+			},
+			out: &Message{
+				Type: TypeRedirect,
+				Code: CodeRedirectHost,
+				Data: &Redirect{Gateway: net.IPv4(192, 168, 0, 254), Original: testOriginal[:20]},
+			},
+		},
+		{
+			name: "NewParameterProblem",
+			new: func() (*Message, error) {
+				return NewParameterProblem(CodePointerIndicatesError, 9, testOriginal)
+			},
+			out: &Message{
+				Type: TypeParameterProblem,
+				Data: &ParameterProblem{Pointer: 9, Original: testOriginal[:28]},
+			},
+		},
+		{
+			name: "NewSourceQuench",
+			new: func() (*Message, error) {
+				return NewSourceQuench(testOriginal)
+			},
+			out: &Message{
+				Type: TypeSourceQuench,
+				Data: &Error{Original: testOriginal[:28]},
+			},
+		},
+	}
+	for _, tt := range tests {
+		m, err := tt.new()
+		if err != nil {
+			t.Errorf("%s() should not return error, but got %v\n", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, tt.out) {
+			t.Errorf("%s() = %v, but got %v\n", tt.name, tt.out, m)
+		}
+		// parsed message must have the same data
+		parsed := Parse(m.Encode())
+		if !bytes.Equal(parsed.Data.Encode(), m.Data.Encode()) {
+			t.Errorf("Parse(%s().Encode()).Data = %x, but got %x\n", tt.name, m.Data.Encode(), parsed.Data.Encode())
+		}
+	}
+
+	if _, err := NewTimeExceeded(CodeTTLExceeded, []byte{0x60, 0x00}); err == nil {
+		t.Errorf("NewTimeExceeded() should return error for non IPv4 datagram\n")
+	}
+}
+
+var clockOffsetTests = []struct {
+	in      *Timestamp
+	arrival uint32
+	offset  time.Duration
+	rtt     time.Duration
+}{
+	{
+		// remote clock is 100ms ahead, 10ms each way
+		in:      &Timestamp{Originate: 1000, Receive: 1110, Transmit: 1112},
+		arrival: 1022,
+		offset:  100 * time.Millisecond,
+		rtt:     20 * time.Millisecond,
+	},
+	{
+		// wrap around midnight
+		in:      &Timestamp{Originate: msPerDay - 5, Receive: 5, Transmit: 5},
+		arrival: 15,
+		offset:  0,
+		rtt:     20 * time.Millisecond,
+	},
+}
+
+func TestClockOffset(t *testing.T) {
+	for _, tt := range clockOffsetTests {
+		offset, rtt := tt.in.ClockOffset(tt.arrival)
+		if offset != tt.offset || rtt != tt.rtt {
+			t.Errorf("ClockOffset(%d) = (%s, %s), but got (%s, %s)\n", tt.arrival, tt.offset, tt.rtt, offset, rtt)
+		}
+	}
+}
+
+var queryParseTests = []struct {
+	in  []byte
+	out *Message
+}{
+	{
+		in: []byte{
+			0x0e, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x01, 0x00, 0x00, 0x03, 0xe8, 0x00, 0x00, 0x04, 0x56,
+			0x00, 0x00, 0x04, 0x58,
+		},
+		out: &Message{
+			Type: TypeTimestampReply,
+			Data: &Timestamp{Identifier: 0x1234, SequenceNumber: 1, Originate: 1000, Receive: 1110, Transmit: 1112},
+		},
+	},
+	{
+		in: []byte{0x12, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x00, 0xff, 0xff, 0xff, 0x00},
+		out: &Message{
+			Type: TypeAddressMaskReply,
+			Data: &AddressMask{Identifier: 0x1234, Mask: net.IPv4Mask(255, 255, 255, 0)},
+		},
+	},
+	{
+		in: []byte{
+			0x09, 0x00, 0x00, 0x00, 0x01, 0x02, 0x07, 0x08, 0xc0, 0xa8, 0x00, 0xfe, 0xff, 0xff, 0xff, 0xff,
+		},
+		out: &Message{
+			Type: TypeRouterAdvertisement,
+			Data: &RouterAdvertisement{
+				Lifetime: 1800,
+				Entries:  []RouterEntry{{Addr: net.IPv4(192, 168, 0, 254), Preference: -1}},
+			},
+		},
+	},
+	{
+		in:  []byte{0x0e, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x01}, // truncated timestamp
+		out: nil,
+	},
+}
+
+func TestQueryParse(t *testing.T) {
+	for _, tt := range queryParseTests {
+		m := Parse(tt.in)
+		if !reflect.DeepEqual(m, tt.out) {
+			t.Errorf("Parse(%x) = %v, but got %v\n", tt.in, tt.out, m)
+			continue
+		}
+		if m != nil && !bytes.Equal(m.Data.Encode(), tt.in[HeaderLen:]) {
+			t.Errorf("Encode() = %x, but got %x\n", tt.in[HeaderLen:], m.Data.Encode())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	msPerDay = 24 * 60 * 60 * 1000
+
+	// nonStandardTime is set to the high-order bit of timestamp when it is not milliseconds since midnight UT.
+	nonStandardTime = 0x1 << 31
+)
+
+// Timestamp represents the data of ICMP Timestamp and Timestamp Reply message.
+// Each timestamp is milliseconds since midnight UT.
+type Timestamp struct {
+	Identifier     uint16
+	SequenceNumber uint16
+	Originate      uint32
+	Receive        uint32
+	Transmit       uint32
+}
+
+// NewTimestamp creates ICMP Timestamp message whose originate timestamp is current time.
+func NewTimestamp() *Message {
+	rand.Seed(time.Now().UnixNano())
+	return &Message{
+		Type: TypeTimestamp,
+		Data: &Timestamp{
+			Identifier: uint16(rand.Uint32()),
+			Originate:  MillisecondsSinceMidnight(time.Now()),
+		},
+	}
+}
+
+// MillisecondsSinceMidnight returns milliseconds since midnight UT of t, which is used in Timestamp message.
+func MillisecondsSinceMidnight(t time.Time) uint32 {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(t.Sub(midnight) / time.Millisecond)
+}
+
+// Standard reports whether all timestamps are milliseconds since midnight UT.
+// Hosts which cannot provide it set the high-order bit (RFC 792).
+func (t *Timestamp) Standard() bool {
+	return (t.Originate|t.Receive|t.Transmit)&nonStandardTime == 0
+}
+
+// ClockOffset calculates the clock offset of the remote host and round trip time
+// from Timestamp Reply and the time it arrived (milliseconds since midnight UT).
+// Positive offset means the remote clock is ahead of the local clock.
+func (t *Timestamp) ClockOffset(arrival uint32) (offset, rtt time.Duration) {
+	outbound := msDiff(t.Receive, t.Originate)
+	inbound := msDiff(t.Transmit, arrival)
+	offset = time.Duration((outbound+inbound)/2) * time.Millisecond
+	rtt = time.Duration(msDiff(arrival, t.Originate)-msDiff(t.Transmit, t.Receive)) * time.Millisecond
+	return offset, rtt
+}
+
+// msDiff returns a - b considering timestamps wrap around at midnight.
+func msDiff(a, b uint32) int64 {
+	d := (int64(a) - int64(b)) % msPerDay
+	if d >= msPerDay/2 {
+		d -= msPerDay
+	} else if d < -msPerDay/2 {
+		d += msPerDay
+	}
+	return d
+}
+
+// Encode returns byte-encoded data of Timestamp message.
+func (t *Timestamp) Encode() []byte {
+	buffer := make([]byte, 16)
+	binary.BigEndian.PutUint16(buffer[0:], t.Identifier)
+	binary.BigEndian.PutUint16(buffer[2:], t.SequenceNumber)
+	binary.BigEndian.PutUint32(buffer[4:], t.Originate)
+	binary.BigEndian.PutUint32(buffer[8:], t.Receive)
+	binary.BigEndian.PutUint32(buffer[12:], t.Transmit)
+	return buffer
+}
+
+func parseTimestamp(b []byte) *Timestamp {
+	if len(b) < 16 {
+		return nil
+	}
+	return &Timestamp{
+		Identifier:     binary.BigEndian.Uint16(b[0:]),
+		SequenceNumber: binary.BigEndian.Uint16(b[2:]),
+		Originate:      binary.BigEndian.Uint32(b[4:]),
+		Receive:        binary.BigEndian.Uint32(b[8:]),
+		Transmit:       binary.BigEndian.Uint32(b[12:]),
+	}
+}
+
+// AddressMask represents the data of ICMP Address Mask Request and Reply message (RFC 950).
+type AddressMask struct {
+	Identifier     uint16
+	SequenceNumber uint16
+	Mask           net.IPMask
+}
+
+// NewAddressMaskRequest creates ICMP Address Mask Request message.
+func NewAddressMaskRequest() *Message {
+	rand.Seed(time.Now().UnixNano())
+	return &Message{
+		Type: TypeAddressMaskRequest,
+		Data: &AddressMask{
+			Identifier: uint16(rand.Uint32()),
+			Mask:       net.IPv4Mask(0, 0, 0, 0),
+		},
+	}
+}
+
+// Encode returns byte-encoded data of AddressMask message.
+func (a *AddressMask) Encode() []byte {
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint16(buffer[0:], a.Identifier)
+	binary.BigEndian.PutUint16(buffer[2:], a.SequenceNumber)
+	copy(buffer[4:], a.Mask)
+	return buffer
+}
+
+func parseAddressMask(b []byte) *AddressMask {
+	if len(b) < 8 {
+		return nil
+	}
+	return &AddressMask{
+		Identifier:     binary.BigEndian.Uint16(b[0:]),
+		SequenceNumber: binary.BigEndian.Uint16(b[2:]),
+		Mask:           net.IPv4Mask(b[4], b[5], b[6], b[7]),
+	}
+}
@@ -0,0 +1,79 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// RouterEntry represents a router address advertised in Router Advertisement.
+type RouterEntry struct {
+	Addr       net.IP
+	Preference int32 // higher is preferred. 0x80000000 means the address must not be used as default router.
+}
+
+// RouterAdvertisement represents the data of ICMP Router Advertisement message (RFC 1256).
+type RouterAdvertisement struct {
+	Lifetime uint16 // seconds the addresses are considered valid
+	Entries  []RouterEntry
+}
+
+// NewRouterAdvertisement creates ICMP Router Advertisement message.
+func NewRouterAdvertisement(lifetime uint16, entries []RouterEntry) *Message {
+	return &Message{
+		Type: TypeRouterAdvertisement,
+		Data: &RouterAdvertisement{
+			Lifetime: lifetime,
+			Entries:  entries,
+		},
+	}
+}
+
+// Encode returns byte-encoded data of RouterAdvertisement message.
+func (r *RouterAdvertisement) Encode() []byte {
+	buffer := make([]byte, 4+len(r.Entries)*RouterEntrySize*4)
+	buffer[0] = uint8(len(r.Entries))
+	buffer[1] = RouterEntrySize
+	binary.BigEndian.PutUint16(buffer[2:], r.Lifetime)
+	for i, e := range r.Entries {
+		offset := 4 + i*RouterEntrySize*4
+		copy(buffer[offset:], e.Addr.To4())
+		binary.BigEndian.PutUint32(buffer[offset+4:], uint32(e.Preference))
+	}
+	return buffer
+}
+
+func parseRouterAdvertisement(b []byte) *RouterAdvertisement {
+	num := int(b[0])
+	size := int(b[1]) * 4
+	if size < RouterEntrySize*4 || len(b) < 4+num*size {
+		return nil
+	}
+	r := &RouterAdvertisement{
+		Lifetime: binary.BigEndian.Uint16(b[2:]),
+		Entries:  make([]RouterEntry, num),
+	}
+	for i := range r.Entries {
+		e := b[4+i*size:]
+		r.Entries[i] = RouterEntry{
+			Addr:       net.IPv4(e[0], e[1], e[2], e[3]),
+			Preference: int32(binary.BigEndian.Uint32(e[4:])),
+		}
+	}
+	return r
+}
+
+// RouterSolicitation represents the data of ICMP Router Solicitation message (RFC 1256).
+type RouterSolicitation struct{}
+
+// NewRouterSolicitation creates ICMP Router Solicitation message.
+func NewRouterSolicitation() *Message {
+	return &Message{
+		Type: TypeRouterSolicitation,
+		Data: &RouterSolicitation{},
+	}
+}
+
+// Encode returns byte-encoded data of RouterSolicitation message.
+func (r *RouterSolicitation) Encode() []byte {
+	return make([]byte, 4) // reserved
+}
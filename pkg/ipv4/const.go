@@ -9,12 +9,12 @@ const (
 	// Version6 is the version number of IPv6.
 	Version6 = 6
 
-	// FlagUnused is the flag which is not used.
-	FlagUnused = 0x1
+	// FlagMoreFragment is the flag which shows more fragments follow this packet.
+	FlagMoreFragment = 0x1
 	// FlagDontFragment is the flag which shows this packet must not be fragmented.
 	FlagDontFragment = 0x1 << 1
-	// FlagMoreFragment is the flag which shows this packet is the last one of fragmented packets.
-	FlagMoreFragment = 0x1 << 2
+	// FlagUnused is the reserved flag which must be zero.
+	FlagUnused = 0x1 << 2
 
 	// ProtoICMP is the protocol nunber of ICMP.
 	ProtoICMP = 1
@@ -26,3 +26,7 @@ const (
 	// DefaultTTL is the default Time To Live.
 	DefaultTTL = 255
 )
+
+const (
+	pseudoHeaderLen = 12
+)
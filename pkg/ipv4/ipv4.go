@@ -24,13 +24,18 @@ type Header struct {
 	HeaderChecksum uint16
 	SrcAddress     net.IP
 	DstAddress     net.IP
+	Options        []byte // raw option bytes. padded with zero to 4-bytes boundary when encoded.
 }
 
-// TODO: support IPv4 option
+// Len returns the length of encoded header including options.
+func (h *Header) Len() int {
+	return HeaderLen + (len(h.Options)+3)/4*4
+}
 
 // Encode returns byte-encoded data of IPv4 header.
+// IHL is encoded as it is, so caller must set it properly when options are used.
 func (h *Header) Encode() []byte {
-	buffer := make([]byte, HeaderLen)
+	buffer := make([]byte, h.Len())
 
 	buffer[0] = (h.Version << 4) | h.IHL
 	buffer[1] = h.TypeOfService
@@ -47,6 +52,7 @@ func (h *Header) Encode() []byte {
 
 	copy(buffer[12:], h.SrcAddress.To4())
 	copy(buffer[16:], h.DstAddress.To4())
+	copy(buffer[HeaderLen:], h.Options)
 
 	checksum := checksum.SumOfOnesComplement16(buffer)
 	copy(buffer[10:], checksum)
@@ -69,6 +75,90 @@ func (p *Packet) Encode() []byte {
 	return buffer
 }
 
+// NewPacket returns IPv4 packet which carries data of proto from src to dst.
+// IHL and TotalLength are calculated from options and data.
+// TTL, TOS, Identification, Flags, FragmentOffset and IPv4 options can be set with opts.
+// Options for the ethernet header and SrcIP are ignored.
+func NewPacket(src, dst net.IP, proto uint8, data []byte, opts ...Option) *Packet {
+	c := config{
+		TTL: DefaultTTL,
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	return newPacket(src, dst, proto, data, &c)
+}
+
+func newPacket(src, dst net.IP, proto uint8, data []byte, c *config) *Packet {
+	hdr := Header{
+		Version:        Version4,
+		TypeOfService:  c.TOS,
+		Identification: c.ID,
+		Flags:          c.Flags,
+		FlagmentOffset: c.FragOffset,
+		TimeToLive:     c.TTL,
+		Protocol:       proto,
+		SrcAddress:     src,
+		DstAddress:     dst,
+		Options:        c.Options,
+	}
+	hdr.IHL = uint8(hdr.Len() / 4)
+	hdr.TotalLength = uint16(hdr.Len() + len(data))
+	return &Packet{
+		Header: hdr,
+		Data:   data,
+	}
+}
+
+// Parse parses given IPv4 packet and returns a pointer to Packet instance.
+// b must not include ethernet header.
+// Data is truncated to the length indicated by TotalLength.
+func Parse(b []byte) *Packet {
+	if len(b) < HeaderLen { // incomplete packet
+		return nil
+	}
+	ihl := int(b[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(b[2:]))
+	if ihl < HeaderLen || len(b) < ihl || totalLen < ihl {
+		return nil
+	}
+	if totalLen > len(b) { // truncated packet. use all remaining data
+		totalLen = len(b)
+	}
+
+	p := &Packet{}
+	p.Version = b[0] >> 4
+	p.IHL = b[0] & 0x0f
+	p.TypeOfService = b[1]
+	p.TotalLength = binary.BigEndian.Uint16(b[2:])
+	p.Identification = binary.BigEndian.Uint16(b[4:])
+	p.Flags = b[6] >> 5
+	p.FlagmentOffset = binary.BigEndian.Uint16(b[6:]) & 0x1fff
+	p.TimeToLive = b[8]
+	p.Protocol = b[9]
+	p.HeaderChecksum = binary.BigEndian.Uint16(b[10:])
+	p.SrcAddress = net.IPv4(b[12], b[13], b[14], b[15])
+	p.DstAddress = net.IPv4(b[16], b[17], b[18], b[19])
+	if ihl > HeaderLen {
+		p.Options = make([]byte, ihl-HeaderLen)
+		copy(p.Options, b[HeaderLen:ihl])
+	}
+	p.Data = make([]byte, totalLen-ihl)
+	copy(p.Data, b[ihl:totalLen])
+	return p
+}
+
+// PseudoHeader returns the pseudo header which is used to calculate checksum of
+// upper layer protocols like UDP and TCP. length is the length of upper layer data.
+func PseudoHeader(src, dst net.IP, proto uint8, length int) []byte {
+	buffer := make([]byte, pseudoHeaderLen)
+	copy(buffer[0:], src.To4())
+	copy(buffer[4:], dst.To4())
+	buffer[9] = proto
+	binary.BigEndian.PutUint16(buffer[10:], uint16(length))
+	return buffer
+}
+
 // Option is option which is used to send IP packet.
 type Option func(*config)
 
@@ -79,39 +169,100 @@ func SetDstMac(dst net.HardwareAddr) Option {
 	}
 }
 
+// SetSrcMac sets the source MAC address.
+func SetSrcMac(src net.HardwareAddr) Option {
+	return func(c *config) {
+		c.SrcMac = src
+	}
+}
+
+// SetSrcIP sets the source IP address.
+// If not set, the address assigned to the output interface is used.
+func SetSrcIP(src net.IP) Option {
+	return func(c *config) {
+		c.SrcIP = src
+	}
+}
+
+// SetTTL sets the Time To Live.
+func SetTTL(ttl uint8) Option {
+	return func(c *config) {
+		c.TTL = ttl
+	}
+}
+
+// SetTOS sets the Type of Service field (DSCP and ECN).
+func SetTOS(tos uint8) Option {
+	return func(c *config) {
+		c.TOS = tos
+	}
+}
+
+// SetIdentification sets the Identification field.
+func SetIdentification(id uint16) Option {
+	return func(c *config) {
+		c.ID = id
+	}
+}
+
+// SetFlags sets the flags like FlagDontFragment.
+func SetFlags(flags uint8) Option {
+	return func(c *config) {
+		c.Flags = flags
+	}
+}
+
+// SetFragmentOffset sets the fragment offset in units of 8 bytes.
+func SetFragmentOffset(offset uint16) Option {
+	return func(c *config) {
+		c.FragOffset = offset
+	}
+}
+
+// SetOptions sets the raw IPv4 options.
+func SetOptions(options []byte) Option {
+	return func(c *config) {
+		c.Options = options
+	}
+}
+
 type config struct {
-	DstMac net.HardwareAddr
+	DstMac     net.HardwareAddr
+	SrcMac     net.HardwareAddr
+	SrcIP      net.IP
+	TTL        uint8
+	TOS        uint8
+	ID         uint16
+	Flags      uint8
+	FragOffset uint16
+	Options    []byte
 }
 
 // Send sends given packet data to dst.
 // packet must not include IPv4 header.
 func Send(outIfname string, dst net.IP, payload []byte, proto uint8, opts ...Option) error {
-	c := config{}
+	c := config{
+		TTL: DefaultTTL,
+	}
 	for _, o := range opts {
 		o(&c)
 	}
 
-	src, err := iface.IPv4AddressByName(outIfname)
-	if err != nil {
-		return errors.Wrap(err, "failed to get source IP address")
+	src := c.SrcIP
+	if src == nil {
+		var err error
+		src, err = iface.IPv4AddressByName(outIfname)
+		if err != nil {
+			return errors.Wrap(err, "failed to get source IP address")
+		}
 	}
 
-	hdr := Header{
-		Version:     Version4,
-		IHL:         HeaderLen / 4,
-		TotalLength: uint16(HeaderLen + len(payload)),
-		// TODO: set identification properly
-		Identification: 0,
-		TimeToLive:     DefaultTTL,
-		Protocol:       ProtoICMP,
-		SrcAddress:     src,
-		DstAddress:     dst,
-	}
-	pkt := &Packet{
-		Header: hdr,
-		Data:   payload,
-	}
+	pkt := newPacket(src, dst, proto, payload, &c)
 
+	ethOpts := make([]ethernet.Option, 0, 1)
+	if c.SrcMac != nil {
+		ethOpts = append(ethOpts, ethernet.SetSrcMac(c.SrcMac))
+	}
 	// TODO: if DstMAC option is empty, resolve destination mac address with ARP
-	return ethernet.Send(outIfname, c.DstMac, pkt, ethernet.TypeIPv4)
+	return ethernet.Send(outIfname, c.DstMac, pkt, ethernet.TypeIPv4, ethOpts...)
 }
@@ -0,0 +1,134 @@
+package ipv4
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var headerEncodeTests = []struct {
+	in  *Header
+	out []byte
+}{
+	{
+		in: &Header{
+			Version:     Version4,
+			IHL:         5,
+			TotalLength: 0x73,
+			Flags:       FlagDontFragment,
+			TimeToLive:  64,
+			Protocol:    ProtoUDP,
+			SrcAddress:  net.IPv4(192, 168, 0, 1),
+			DstAddress:  net.IPv4(192, 168, 0, 199),
+		},
+		out: []byte{
+			0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0xb8, 0x61, 0xc0, 0xa8, 0x00, 0x01,
+			0xc0, 0xa8, 0x00, 0xc7,
+		},
+	},
+	{
+		in: &Header{
+			Version:     Version4,
+			IHL:         6,
+			TotalLength: 24,
+			TimeToLive:  1,
+			Protocol:    ProtoICMP,
+			SrcAddress:  net.IPv4(10, 0, 0, 1),
+			DstAddress:  net.IPv4(10, 0, 0, 2),
+			Options:     []byte{0x94, 0x04, 0x00}, // Router Alert, padded to 4 bytes
+		},
+		out: []byte{
+			0x46, 0x00, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x10, 0xdf, 0x0a, 0x00, 0x00, 0x01,
+			0x0a, 0x00, 0x00, 0x02, 0x94, 0x04, 0x00, 0x00,
+		},
+	},
+}
+
+func TestHeaderEncode(t *testing.T) {
+	for _, tt := range headerEncodeTests {
+		b := tt.in.Encode()
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("Encode() = %x, but got %x\n", tt.out, b)
+		}
+	}
+}
+
+var parseTests = []struct {
+	in  []byte
+	out *Packet
+}{
+	{
+		in: []byte{
+			0x46, 0x00, 0x00, 0x1a, 0x12, 0x34, 0x40, 0x00, 0x01, 0x01, 0x10, 0xdf, 0x0a, 0x00, 0x00, 0x01,
+			0x0a, 0x00, 0x00, 0x02, 0x94, 0x04, 0x00, 0x00, 0xaa, 0xbb, 0xcc, 0xdd, // trailing padding
+		},
+		out: &Packet{
+			Header: Header{
+				Version:        Version4,
+				IHL:            6,
+				TotalLength:    26,
+				Identification: 0x1234,
+				Flags:          FlagDontFragment,
+				TimeToLive:     1,
+				Protocol:       ProtoICMP,
+				HeaderChecksum: 0x10df,
+				SrcAddress:     net.IPv4(10, 0, 0, 1),
+				DstAddress:     net.IPv4(10, 0, 0, 2),
+				Options:        []byte{0x94, 0x04, 0x00, 0x00},
+			},
+			Data: []byte{0xaa, 0xbb},
+		},
+	},
+	{
+		in:  []byte{0x45, 0x00, 0x00, 0x1a},
+		out: nil,
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		p := Parse(tt.in)
+		if !reflect.DeepEqual(p, tt.out) {
+			t.Errorf("Parse(%x) = %v, but got %v\n", tt.in, tt.out, p)
+		}
+	}
+}
+
+func TestPseudoHeader(t *testing.T) {
+	b := PseudoHeader(net.IPv4(192, 168, 0, 1), net.IPv4(192, 168, 0, 199), ProtoUDP, 0x1f)
+	want := []byte{0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7, 0x00, 0x11, 0x00, 0x1f}
+	if !bytes.Equal(b, want) {
+		t.Errorf("PseudoHeader() = %x, but got %x\n", want, b)
+	}
+}
+
+func TestNewPacket(t *testing.T) {
+	tests := []struct {
+		pkt *Packet
+		out []byte
+	}{
+		{
+			pkt: NewPacket(net.IPv4(192, 168, 0, 1), net.IPv4(192, 168, 0, 199), ProtoUDP, make([]byte, 0x73-HeaderLen),
+				SetFlags(FlagDontFragment), SetTTL(64)),
+			out: headerEncodeTests[0].out,
+		},
+		{
+			pkt: NewPacket(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), ProtoICMP, nil,
+				SetTTL(1), SetOptions([]byte{0x94, 0x04, 0x00})),
+			out: headerEncodeTests[1].out,
+		},
+	}
+	for _, tt := range tests {
+		b := tt.pkt.Encode()
+		if !bytes.Equal(b[:len(tt.out)], tt.out) {
+			t.Errorf("header of NewPacket() = %x, but got %x\n", tt.out, b[:len(tt.out)])
+		}
+	}
+
+	p := NewPacket(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), ProtoTCP, []byte{1, 2, 3}, SetFragmentOffset(185))
+	if p.TimeToLive != DefaultTTL || p.FlagmentOffset != 185 || p.TotalLength != HeaderLen+3 {
+		t.Errorf("NewPacket() = TTL %d, offset 185, length %d, but got %d, %d, %d\n",
+			DefaultTTL, HeaderLen+3, p.TimeToLive, p.FlagmentOffset, p.TotalLength)
+	}
+}
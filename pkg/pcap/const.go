@@ -0,0 +1,24 @@
+package pcap
+
+const (
+	// MagicMicroseconds is the magic number of pcap files whose timestamps are in microseconds.
+	MagicMicroseconds = 0xa1b2c3d4
+	// MagicNanoseconds is the magic number of pcap files whose timestamps are in nanoseconds.
+	MagicNanoseconds = 0xa1b23c4d
+
+	// VersionMajor is the major version of pcap file format.
+	VersionMajor = 2
+	// VersionMinor is the minor version of pcap file format.
+	VersionMinor = 4
+
+	// LinkTypeEthernet is the link-layer header type of IEEE 802.3 Ethernet.
+	LinkTypeEthernet = 1
+
+	// DefaultSnapLen is the default maximum length of captured packets.
+	DefaultSnapLen = 262144
+
+	// FileHeaderLen is the length of pcap file header.
+	FileHeaderLen = 24
+	// RecordHeaderLen is the length of per-packet record header.
+	RecordHeaderLen = 16
+)
@@ -0,0 +1,137 @@
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestWriter(t *testing.T) {
+	ts := time.Unix(0x5f000000, 123456789)
+	tests := []struct {
+		opts []Option
+		out  []byte
+	}{
+		{
+			opts: nil,
+			out: []byte{
+				0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x5f, 0x40, 0xe2, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00,
+				0x01, 0x02, 0x03, 0x04,
+			},
+		},
+		{
+			opts: []Option{SetNanosecond(true), SetSnapLen(2)},
+			out: []byte{
+				0x4d, 0x3c, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x5f, 0x15, 0xcd, 0x5b, 0x07, 0x02, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00,
+				0x01, 0x02,
+			},
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, tt.opts...)
+		if err != nil {
+			t.Fatalf("NewWriter() returns error: %v\n", err)
+		}
+		if err := w.WritePacket(ts, []byte{0x01, 0x02, 0x03, 0x04}); err != nil {
+			t.Fatalf("WritePacket() returns error: %v\n", err)
+		}
+		if !bytes.Equal(buf.Bytes(), tt.out) {
+			t.Errorf("written data = %x, but got %x\n", tt.out, buf.Bytes())
+		}
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, SetSnapLen(0)); err == nil {
+		t.Errorf("NewWriter() with zero snapshot length should return error\n")
+	}
+}
+
+func TestReader(t *testing.T) {
+	ts := time.Unix(0x5f000000, 123456000)
+	tests := []struct {
+		name string
+		in   []byte
+		nano bool
+	}{
+		{
+			name: "little endian microseconds",
+			in: []byte{
+				0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x5f, 0x40, 0xe2, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00,
+				0x01, 0x02,
+			},
+		},
+		{
+			name: "big endian nanoseconds",
+			in: []byte{
+				0xa1, 0xb2, 0x3c, 0x4d, 0x00, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01,
+				0x5f, 0x00, 0x00, 0x00, 0x07, 0x5b, 0xca, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x04,
+				0x01, 0x02,
+			},
+			nano: true,
+		},
+	}
+	for _, tt := range tests {
+		r, err := NewReader(bytes.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("%s: NewReader() returns error: %v\n", tt.name, err)
+			continue
+		}
+		if r.Nanosecond() != tt.nano || r.SnapLen() != 2 || r.LinkType() != LinkTypeEthernet {
+			t.Errorf("%s: unexpected file header\n", tt.name)
+		}
+		p, err := r.ReadPacket()
+		if err != nil {
+			t.Errorf("%s: ReadPacket() returns error: %v\n", tt.name, err)
+			continue
+		}
+		if !p.Time.Equal(ts) || !bytes.Equal(p.Data, []byte{0x01, 0x02}) || p.OrigLen != 4 || !p.Truncated() {
+			t.Errorf("%s: ReadPacket() = {%s %x 4}, but got {%s %x %d}\n", tt.name, ts, []byte{0x01, 0x02}, p.Time, p.Data, p.OrigLen)
+		}
+		if _, err := r.ReadPacket(); err != io.EOF {
+			t.Errorf("%s: ReadPacket() at the end = EOF, but got %v\n", tt.name, err)
+		}
+
+		// file ends in the middle of a record
+		r, _ = NewReader(bytes.NewReader(tt.in[:len(tt.in)-1]))
+		if _, err := r.ReadPacket(); errors.Cause(err) != io.ErrUnexpectedEOF {
+			t.Errorf("%s: ReadPacket() of truncated record = %v, but got %v\n", tt.name, io.ErrUnexpectedEOF, err)
+		}
+	}
+
+	if _, err := NewReader(bytes.NewReader(make([]byte, FileHeaderLen))); err == nil {
+		t.Errorf("NewReader() with invalid magic should return error\n")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, SetNanosecond(true))
+	times := []time.Time{time.Unix(1, 1), time.Unix(2, 999999999)}
+	for i, ts := range times {
+		w.WritePacket(ts, bytes.Repeat([]byte{byte(i)}, 60+i))
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader() returns error: %v\n", err)
+	}
+	for i, ts := range times {
+		p, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket() returns error: %v\n", err)
+		}
+		if !p.Time.Equal(ts) || len(p.Data) != 60+i || p.Truncated() {
+			t.Errorf("packet %d = {%s %d}, but got {%s %d}\n", i, ts, 60+i, p.Time, len(p.Data))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Packet is a packet record read from pcap file.
+type Packet struct {
+	Time    time.Time
+	Data    []byte // captured data. may be shorter than OrigLen if truncated by the snapshot length.
+	OrigLen int    // length of the packet on the wire
+}
+
+// Truncated reports whether p was truncated when captured.
+func (p *Packet) Truncated() bool {
+	return len(p.Data) < p.OrigLen
+}
+
+// Reader reads packets from classic libpcap file.
+// Both byte orders and both timestamp resolutions are supported.
+type Reader struct {
+	r          io.Reader
+	closer     io.Closer // non-nil if Reader owns the file
+	order      binary.ByteOrder
+	nano       bool
+	snapLen    uint32
+	linkType   uint32
+	recordHdr  []byte
+	numPackets int
+}
+
+// NewReader reads the file header from r and returns new Reader instance.
+func NewReader(r io.Reader) (*Reader, error) {
+	hdr := make([]byte, FileHeaderLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, errors.Wrap(err, "failed to read pcap file header")
+	}
+
+	rd := &Reader{
+		r:         r,
+		recordHdr: make([]byte, RecordHeaderLen),
+	}
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == MagicMicroseconds:
+		rd.order = binary.LittleEndian
+	case binary.LittleEndian.Uint32(hdr) == MagicNanoseconds:
+		rd.order, rd.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr) == MagicMicroseconds:
+		rd.order = binary.BigEndian
+	case binary.BigEndian.Uint32(hdr) == MagicNanoseconds:
+		rd.order, rd.nano = binary.BigEndian, true
+	default:
+		return nil, errors.Errorf("unknown magic number %x", hdr[0:4])
+	}
+	if major := rd.order.Uint16(hdr[4:]); major != VersionMajor {
+		return nil, errors.Errorf("unsupported pcap version %d.%d", major, rd.order.Uint16(hdr[6:]))
+	}
+	rd.snapLen = rd.order.Uint32(hdr[16:])
+	// the upper bits may hold FCS information (see pcap-linktype(7))
+	rd.linkType = rd.order.Uint32(hdr[20:]) & 0x0fffffff
+	return rd, nil
+}
+
+// Open opens the pcap file at path. The file is closed by Close.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open pcap file")
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// LinkType returns the link-layer header type of the file.
+func (r *Reader) LinkType() uint32 {
+	return r.linkType
+}
+
+// SnapLen returns the snapshot length of the file.
+func (r *Reader) SnapLen() uint32 {
+	return r.snapLen
+}
+
+// Nanosecond reports whether timestamps of the file are in nanoseconds.
+func (r *Reader) Nanosecond() bool {
+	return r.nano
+}
+
+// ByteOrder returns the byte order of the file.
+func (r *Reader) ByteOrder() binary.ByteOrder {
+	return r.order
+}
+
+// ReadPacket reads the next packet. At the end of the file, io.EOF is returned.
+// If the file ends in the middle of a record, the cause of returned error is io.ErrUnexpectedEOF.
+func (r *Reader) ReadPacket() (*Packet, error) {
+	if _, err := io.ReadFull(r.r, r.recordHdr); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrapf(err, "failed to read header of packet %d", r.numPackets+1)
+	}
+	sec := r.order.Uint32(r.recordHdr[0:])
+	frac := r.order.Uint32(r.recordHdr[4:])
+	capLen := r.order.Uint32(r.recordHdr[8:])
+	origLen := r.order.Uint32(r.recordHdr[12:])
+
+	// some writers record packets longer than the snapshot length,
+	// so only unreasonably large records are rejected like libpcap
+	limit := r.snapLen
+	if limit < DefaultSnapLen {
+		limit = DefaultSnapLen
+	}
+	if capLen > limit {
+		return nil, errors.Errorf("packet %d has invalid length %d", r.numPackets+1, capLen)
+	}
+
+	p := &Packet{
+		Data:    make([]byte, capLen),
+		OrigLen: int(origLen),
+	}
+	if _, err := io.ReadFull(r.r, p.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, errors.Wrapf(err, "failed to read data of packet %d", r.numPackets+1)
+	}
+	if p.OrigLen < len(p.Data) {
+		p.OrigLen = len(p.Data)
+	}
+	nsec := int64(frac) * 1000
+	if r.nano {
+		nsec = int64(frac)
+	}
+	p.Time = time.Unix(int64(sec), nsec)
+	r.numPackets++
+	return p, nil
+}
+
+// Close closes the underlying file if Reader was created by Open.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
@@ -0,0 +1,145 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Writer writes packets in classic libpcap file format.
+// Files are written in little endian like libpcap on most platforms.
+// Writer is safe for concurrent use.
+type Writer struct {
+	w       io.Writer
+	closer  io.Closer // non-nil if Writer owns the file
+	nano    bool
+	snapLen uint32
+
+	mu sync.Mutex
+}
+
+// Option is option which is used to create Writer.
+type Option func(*config)
+
+type config struct {
+	nano     bool
+	snapLen  uint32
+	linkType uint32
+}
+
+// SetNanosecond sets whether timestamps are written in nanoseconds.
+// If not set, timestamps are written in microseconds.
+func SetNanosecond(nano bool) Option {
+	return func(c *config) {
+		c.nano = nano
+	}
+}
+
+// SetSnapLen sets the maximum length of each packet. Longer packets are truncated.
+// If not set, DefaultSnapLen is used.
+func SetSnapLen(snapLen uint32) Option {
+	return func(c *config) {
+		c.snapLen = snapLen
+	}
+}
+
+// SetLinkType sets the link-layer header type of the file.
+// If not set, LinkTypeEthernet is used.
+func SetLinkType(linkType uint32) Option {
+	return func(c *config) {
+		c.linkType = linkType
+	}
+}
+
+// NewWriter writes the file header to w and returns new Writer instance.
+func NewWriter(w io.Writer, opts ...Option) (*Writer, error) {
+	c := config{
+		snapLen:  DefaultSnapLen,
+		linkType: LinkTypeEthernet,
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	if c.snapLen == 0 {
+		return nil, errors.New("snapshot length must be positive")
+	}
+
+	hdr := make([]byte, FileHeaderLen)
+	magic := uint32(MagicMicroseconds)
+	if c.nano {
+		magic = MagicNanoseconds
+	}
+	binary.LittleEndian.PutUint32(hdr[0:], magic)
+	binary.LittleEndian.PutUint16(hdr[4:], VersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:], VersionMinor)
+	// thiszone and sigfigs are always zero
+	binary.LittleEndian.PutUint32(hdr[16:], c.snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], c.linkType)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, errors.Wrap(err, "failed to write pcap file header")
+	}
+
+	return &Writer{
+		w:       w,
+		nano:    c.nano,
+		snapLen: c.snapLen,
+	}, nil
+}
+
+// Create creates the file at path and returns Writer writing to it.
+// The file is closed by Close.
+func Create(path string, opts ...Option) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create pcap file")
+	}
+	w, err := NewWriter(f, opts...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// WritePacket writes a packet captured at t.
+// If data is longer than the snapshot length, it is truncated and the original length is recorded.
+func (w *Writer) WritePacket(t time.Time, data []byte) error {
+	capLen := len(data)
+	if capLen > int(w.snapLen) {
+		capLen = int(w.snapLen)
+	}
+	frac := t.Nanosecond() / 1000
+	if w.nano {
+		frac = t.Nanosecond()
+	}
+
+	// header and data are written at once so that a record is never split by concurrent writes
+	record := make([]byte, RecordHeaderLen+capLen)
+	binary.LittleEndian.PutUint32(record[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(frac))
+	binary.LittleEndian.PutUint32(record[8:], uint32(capLen))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(data)))
+	copy(record[RecordHeaderLen:], data[:capLen])
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(record); err != nil {
+		return errors.Wrap(err, "failed to write packet record")
+	}
+	return nil
+}
+
+// Close closes the underlying file if Writer was created by Create.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
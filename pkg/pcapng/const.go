@@ -0,0 +1,87 @@
+package pcapng
+
+// block types
+const (
+	// BlockSectionHeader is the type of Section Header Block.
+	BlockSectionHeader = 0x0a0d0d0a
+	// BlockInterfaceDescription is the type of Interface Description Block.
+	BlockInterfaceDescription = 0x00000001
+	// BlockSimplePacket is the type of Simple Packet Block.
+	BlockSimplePacket = 0x00000003
+	// BlockNameResolution is the type of Name Resolution Block.
+	BlockNameResolution = 0x00000004
+	// BlockInterfaceStatistics is the type of Interface Statistics Block.
+	BlockInterfaceStatistics = 0x00000005
+	// BlockEnhancedPacket is the type of Enhanced Packet Block.
+	BlockEnhancedPacket = 0x00000006
+)
+
+const (
+	// ByteOrderMagic is written in Section Header Block to detect the byte order of the section.
+	ByteOrderMagic = 0x1a2b3c4d
+
+	// VersionMajor is the major version of pcapng format.
+	VersionMajor = 1
+	// VersionMinor is the minor version of pcapng format.
+	VersionMinor = 0
+
+	// DefaultSnapLen is the default maximum length of captured packets. Zero means no limit.
+	DefaultSnapLen = 0
+)
+
+// common option codes
+const (
+	optEnd     = 0
+	optComment = 1
+)
+
+// option codes of Section Header Block
+const (
+	optSHBHardware = 2
+	optSHBOS       = 3
+	optSHBUserAppl = 4
+)
+
+// option codes of Interface Description Block
+const (
+	optIfName        = 2
+	optIfDescription = 3
+	optIfIPv4Addr    = 4
+	optIfMACAddr     = 6
+	optIfTSResol     = 9
+	optIfTSOffset    = 14
+)
+
+// option codes of Enhanced Packet Block
+const (
+	optEPBFlags = 2
+)
+
+// record types of Name Resolution Block
+const (
+	nrbRecordEnd  = 0
+	nrbRecordIPv4 = 1
+	nrbRecordIPv6 = 2
+)
+
+// Direction bits of the flags of Enhanced Packet Block.
+const (
+	// FlagInbound means the packet was received.
+	FlagInbound = 0x1
+	// FlagOutbound means the packet was sent.
+	FlagOutbound = 0x2
+	// flagDirectionMask is the mask of direction bits.
+	flagDirectionMask = 0x3
+)
+
+const (
+	blockHeaderLen  = 8 // type and total length
+	blockTrailerLen = 4
+	shbBodyLen      = 16
+	idbBodyLen      = 8
+	epbBodyLen      = 20
+	// writerTSResol is the timestamp resolution used by Writer (nanoseconds).
+	writerTSResol = 9
+	// maxBlockLen limits the length of blocks to avoid allocating huge buffer for corrupted files.
+	maxBlockLen = 16 * 1024 * 1024
+)
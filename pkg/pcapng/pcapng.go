@@ -0,0 +1,74 @@
+package pcapng
+
+import (
+	"net"
+	"time"
+)
+
+// Interface is the description of a capture interface written in Interface Description Block.
+type Interface struct {
+	LinkType    uint16
+	SnapLen     uint32 // zero means no limit
+	Name        string
+	Description string
+	IPv4        []net.IPNet
+	MAC         net.HardwareAddr
+	Comments    []string
+
+	tsUnits  uint64 // timestamp units per second. used by Reader
+	tsOffset int64  // seconds added to timestamps. used by Reader
+}
+
+// Packet is a packet written in Enhanced Packet Block.
+type Packet struct {
+	Interface int // index of the interface in the section
+	Time      time.Time
+	Data      []byte
+	OrigLen   int // if zero, the length of Data is used when written
+	Flags     uint32
+	Comments  []string
+}
+
+// Truncated reports whether p was truncated when captured.
+func (p *Packet) Truncated() bool {
+	return len(p.Data) < p.OrigLen
+}
+
+// Inbound reports whether p is flagged as received.
+func (p *Packet) Inbound() bool {
+	return p.Flags&flagDirectionMask == FlagInbound
+}
+
+// Outbound reports whether p is flagged as sent.
+func (p *Packet) Outbound() bool {
+	return p.Flags&flagDirectionMask == FlagOutbound
+}
+
+// NameRecord maps an address to names. It is written in Name Resolution Block.
+type NameRecord struct {
+	IP    net.IP
+	Names []string
+}
+
+// option is an option of a block.
+type option struct {
+	code  uint16
+	value []byte
+}
+
+// optionsLen returns the length of encoded options including End of Options.
+func optionsLen(opts []option) int {
+	if len(opts) == 0 {
+		return 0
+	}
+	n := 4
+	for _, o := range opts {
+		n += 4 + pad(len(o.value))
+	}
+	return n
+}
+
+// pad returns n rounded up to a multiple of 4.
+func pad(n int) int {
+	return (n + 3) / 4 * 4
+}
@@ -0,0 +1,144 @@
+package pcapng
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/pcap"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, SetComment("lab capture"))
+	if err != nil {
+		t.Fatalf("NewWriter() returns error: %v\n", err)
+	}
+	eth0 := &net.Interface{Index: 2, Name: "eth0", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}}
+	eth1 := &net.Interface{Index: 3, Name: "eth1", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}}
+	ts := time.Unix(1600000000, 123456789)
+	frames := []struct {
+		data []byte
+		info ethernet.FrameInfo
+	}{
+		{[]byte{0x01, 0x02, 0x03}, ethernet.FrameInfo{Time: ts, Interface: eth0, Outbound: true, Comment: "bad checksum"}},
+		{[]byte{0x04}, ethernet.FrameInfo{Time: ts.Add(time.Millisecond), Interface: eth1}},
+		{[]byte{0x05, 0x06, 0x07, 0x08}, ethernet.FrameInfo{Time: ts.Add(time.Second), Interface: eth0}},
+	}
+	for _, f := range frames {
+		if err := w.WriteFrame(f.data, &f.info); err != nil {
+			t.Fatalf("WriteFrame() returns error: %v\n", err)
+		}
+	}
+	names := []NameRecord{
+		{IP: net.IPv4(192, 168, 0, 1).To4(), Names: []string{"router", "gw.lab"}},
+		{IP: net.ParseIP("2001:db8::1"), Names: []string{"v6host"}},
+	}
+	if err := w.WriteNameResolution(names); err != nil {
+		t.Fatalf("WriteNameResolution() returns error: %v\n", err)
+	}
+	if buf.Len()%4 != 0 {
+		t.Errorf("length of file must be multiple of 4, but got %d\n", buf.Len())
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader() returns error: %v\n", err)
+	}
+	if !reflect.DeepEqual(r.Comments(), []string{"lab capture"}) || r.Application() != "nwspeaker" {
+		t.Errorf("unexpected section header: %v %s\n", r.Comments(), r.Application())
+	}
+	wantIface := []int{0, 1, 0}
+	for i, f := range frames {
+		p, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket() returns error: %v\n", err)
+		}
+		if p.Interface != wantIface[i] || !p.Time.Equal(f.info.Time) || !bytes.Equal(p.Data, f.data) {
+			t.Errorf("packet %d = {%d %s %x}, but got {%d %s %x}\n", i, wantIface[i], f.info.Time, f.data, p.Interface, p.Time, p.Data)
+		}
+		if p.Outbound() != f.info.Outbound || p.Inbound() == f.info.Outbound {
+			t.Errorf("packet %d has wrong direction flags %#x\n", i, p.Flags)
+		}
+		if f.info.Comment != "" && !reflect.DeepEqual(p.Comments, []string{f.info.Comment}) {
+			t.Errorf("packet %d comments = [%s], but got %v\n", i, f.info.Comment, p.Comments)
+		}
+	}
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("ReadPacket() at the end = EOF, but got %v\n", err)
+	}
+
+	ifaces := r.Interfaces()
+	if len(ifaces) != 2 || ifaces[0].Name != "eth0" || ifaces[1].MAC.String() != eth1.HardwareAddr.String() ||
+		ifaces[0].LinkType != pcap.LinkTypeEthernet {
+		t.Errorf("unexpected interfaces: %+v\n", ifaces)
+	}
+	if got := r.Names(); !reflect.DeepEqual(got, names) {
+		t.Errorf("Names() = %v, but got %v\n", names, got)
+	}
+}
+
+func TestBigEndian(t *testing.T) {
+	// Section Header, Interface Description with if_tsresol 2^-10 and Simple Packet, Enhanced Packet in big endian
+	in := []byte{
+		0x0a, 0x0d, 0x0d, 0x0a, 0x00, 0x00, 0x00, 0x1c, 0x1a, 0x2b, 0x3c, 0x4d, 0x00, 0x01, 0x00, 0x00,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x1c,
+
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x20, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x09, 0x00, 0x01, 0x8a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20,
+
+		0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x02, 0xaa, 0xbb, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x14,
+
+		0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xcc, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x24,
+	}
+	r, err := NewReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatalf("NewReader() returns error: %v\n", err)
+	}
+	p, err := r.ReadPacket()
+	if err != nil || !bytes.Equal(p.Data, []byte{0xaa, 0xbb}) {
+		t.Fatalf("ReadPacket() of Simple Packet Block = aabb, but got %v (%v)\n", p, err)
+	}
+	p, err = r.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket() returns error: %v\n", err)
+	}
+	// 0xc00 / 1024 = 3 seconds
+	if !p.Time.Equal(time.Unix(3, 0)) || !p.Truncated() || p.OrigLen != 5 {
+		t.Errorf("unexpected packet: %+v\n", p)
+	}
+
+	// broken trailing length
+	in[len(in)-1] = 0x20
+	r, _ = NewReader(bytes.NewReader(in))
+	r.ReadPacket()
+	if _, err := r.ReadPacket(); err == nil {
+		t.Errorf("ReadPacket() with broken block should return error\n")
+	}
+}
+
+var timestampTests = []struct {
+	units uint64
+	ts    uint64
+	out   time.Time
+}{
+	{1000000, 1500000000123456, time.Unix(1500000000, 123456000)},
+	{1 << 10, 10<<10 | 512, time.Unix(10, 500000000)},
+	{1000000000000, 5000000000000 + 123456789012, time.Unix(5, 123456789)}, // picoseconds
+}
+
+func TestTimestamp(t *testing.T) {
+	for _, tt := range timestampTests {
+		ifc := &Interface{tsUnits: tt.units}
+		if out := ifc.timestamp(tt.ts); !out.Equal(tt.out) {
+			t.Errorf("timestamp(%d) with %d units = %s, but got %s\n", tt.ts, tt.units, tt.out, out)
+		}
+	}
+}
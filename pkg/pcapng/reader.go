@@ -0,0 +1,385 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Reader reads packets from pcapng file.
+// Files with multiple sections and both byte orders are supported.
+// Interface Statistics Blocks and unknown blocks are skipped.
+type Reader struct {
+	r      io.Reader
+	closer io.Closer // non-nil if Reader owns the file
+	order  binary.ByteOrder
+
+	comments []string // comments of the current section
+	appl     string
+	ifaces   []Interface
+	names    []NameRecord
+}
+
+// NewReader reads Section Header Block from r and returns new Reader instance.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{r: r}
+	typ, body, err := rd.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if typ != BlockSectionHeader {
+		return nil, errors.New("file does not begin with Section Header Block")
+	}
+	if err := rd.parseSectionHeader(body); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// Open opens the pcapng file at path. The file is closed by Close.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open pcapng file")
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// Interfaces returns the interfaces of the current section read so far.
+func (r *Reader) Interfaces() []Interface {
+	return r.ifaces
+}
+
+// Names returns the name resolution records read so far.
+func (r *Reader) Names() []NameRecord {
+	return r.names
+}
+
+// Comments returns the comments of the current section.
+func (r *Reader) Comments() []string {
+	return r.comments
+}
+
+// Application returns the name of the application which wrote the current section.
+func (r *Reader) Application() string {
+	return r.appl
+}
+
+// ReadPacket reads blocks until the next Enhanced Packet Block or Simple Packet Block.
+// At the end of the file, io.EOF is returned.
+func (r *Reader) ReadPacket() (*Packet, error) {
+	for {
+		typ, body, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case BlockSectionHeader:
+			if err := r.parseSectionHeader(body); err != nil {
+				return nil, err
+			}
+		case BlockInterfaceDescription:
+			if err := r.parseInterface(body); err != nil {
+				return nil, err
+			}
+		case BlockNameResolution:
+			if err := r.parseNameResolution(body); err != nil {
+				return nil, err
+			}
+		case BlockEnhancedPacket:
+			return r.parseEnhancedPacket(body)
+		case BlockSimplePacket:
+			return r.parseSimplePacket(body)
+		}
+	}
+}
+
+// Close closes the underlying file if Reader was created by Open.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// readBlock reads a block and returns its type and body.
+// The byte order is detected when Section Header Block is read.
+func (r *Reader) readBlock() (uint32, []byte, error) {
+	hdr := make([]byte, blockHeaderLen)
+	if _, err := io.ReadFull(r.r, hdr); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, errors.Wrap(err, "failed to read block header")
+	}
+
+	// the type of Section Header Block is the same in both byte orders
+	if binary.LittleEndian.Uint32(hdr) == BlockSectionHeader {
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(r.r, magic); err != nil {
+			return 0, nil, errors.Wrap(noEOF(err), "failed to read byte-order magic")
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == ByteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == ByteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, errors.Errorf("invalid byte-order magic %x", magic)
+		}
+		hdr = append(hdr, magic...)
+	}
+	if r.order == nil {
+		return 0, nil, errors.New("block precedes Section Header Block")
+	}
+
+	typ := r.order.Uint32(hdr[0:])
+	total := r.order.Uint32(hdr[4:])
+	if total < blockHeaderLen+blockTrailerLen || total%4 != 0 || total > maxBlockLen || int(total) < len(hdr)+blockTrailerLen {
+		return 0, nil, errors.Errorf("block has invalid length %d", total)
+	}
+	b := make([]byte, total)
+	copy(b, hdr)
+	if _, err := io.ReadFull(r.r, b[len(hdr):]); err != nil {
+		return 0, nil, errors.Wrap(noEOF(err), "failed to read block")
+	}
+	if trailer := r.order.Uint32(b[total-blockTrailerLen:]); trailer != total {
+		return 0, nil, errors.Errorf("block length %d does not match trailing length %d", total, trailer)
+	}
+	return typ, b[blockHeaderLen : total-blockTrailerLen], nil
+}
+
+func (r *Reader) parseSectionHeader(body []byte) error {
+	if len(body) < shbBodyLen {
+		return errors.New("Section Header Block is too short")
+	}
+	if major := r.order.Uint16(body[4:]); major != VersionMajor {
+		return errors.Errorf("unsupported pcapng version %d.%d", major, r.order.Uint16(body[6:]))
+	}
+	options, err := r.parseOptions(body[shbBodyLen:])
+	if err != nil {
+		return err
+	}
+	// interfaces and names are scoped to the section
+	r.ifaces, r.names, r.comments, r.appl = nil, nil, nil, ""
+	for _, o := range options {
+		switch o.code {
+		case optComment:
+			r.comments = append(r.comments, string(o.value))
+		case optSHBUserAppl:
+			r.appl = string(o.value)
+		}
+	}
+	return nil
+}
+
+func (r *Reader) parseInterface(body []byte) error {
+	if len(body) < idbBodyLen {
+		return errors.New("Interface Description Block is too short")
+	}
+	ifc := Interface{
+		LinkType: r.order.Uint16(body[0:]),
+		SnapLen:  r.order.Uint32(body[4:]),
+		tsUnits:  1000000, // microseconds by default
+	}
+	options, err := r.parseOptions(body[idbBodyLen:])
+	if err != nil {
+		return err
+	}
+	for _, o := range options {
+		switch o.code {
+		case optComment:
+			ifc.Comments = append(ifc.Comments, string(o.value))
+		case optIfName:
+			ifc.Name = string(o.value)
+		case optIfDescription:
+			ifc.Description = string(o.value)
+		case optIfIPv4Addr:
+			if len(o.value) == 8 {
+				ifc.IPv4 = append(ifc.IPv4, net.IPNet{
+					IP:   net.IP(append([]byte{}, o.value[0:4]...)),
+					Mask: net.IPMask(append([]byte{}, o.value[4:8]...)),
+				})
+			}
+		case optIfMACAddr:
+			if len(o.value) == 6 {
+				ifc.MAC = net.HardwareAddr(append([]byte{}, o.value...))
+			}
+		case optIfTSResol:
+			if len(o.value) == 1 {
+				if ifc.tsUnits, err = tsResolution(o.value[0]); err != nil {
+					return err
+				}
+			}
+		case optIfTSOffset:
+			if len(o.value) == 8 {
+				ifc.tsOffset = int64(r.order.Uint64(o.value))
+			}
+		}
+	}
+	r.ifaces = append(r.ifaces, ifc)
+	return nil
+}
+
+// tsResolution returns the number of timestamp units per second described by if_tsresol option.
+func tsResolution(v byte) (uint64, error) {
+	exp := uint64(v & 0x7f)
+	if v&0x80 != 0 {
+		if exp > 63 {
+			return 0, errors.Errorf("unsupported timestamp resolution %#x", v)
+		}
+		return 1 << exp, nil
+	}
+	if exp > 19 {
+		return 0, errors.Errorf("unsupported timestamp resolution %#x", v)
+	}
+	units := uint64(1)
+	for i := uint64(0); i < exp; i++ {
+		units *= 10
+	}
+	return units, nil
+}
+
+func (r *Reader) parseEnhancedPacket(body []byte) (*Packet, error) {
+	if len(body) < epbBodyLen {
+		return nil, errors.New("Enhanced Packet Block is too short")
+	}
+	id := int(r.order.Uint32(body[0:]))
+	if id >= len(r.ifaces) {
+		return nil, errors.Errorf("packet refers to undefined interface %d", id)
+	}
+	ts := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+	capLen := int(r.order.Uint32(body[12:]))
+	if capLen > len(body)-epbBodyLen {
+		return nil, errors.Errorf("captured length %d exceeds block", capLen)
+	}
+	p := &Packet{
+		Interface: id,
+		Time:      r.ifaces[id].timestamp(ts),
+		Data:      append([]byte{}, body[epbBodyLen:epbBodyLen+capLen]...),
+		OrigLen:   int(r.order.Uint32(body[16:])),
+	}
+	if p.OrigLen < capLen {
+		p.OrigLen = capLen
+	}
+	options, err := r.parseOptions(body[epbBodyLen+pad(capLen):])
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range options {
+		switch o.code {
+		case optComment:
+			p.Comments = append(p.Comments, string(o.value))
+		case optEPBFlags:
+			if len(o.value) == 4 {
+				p.Flags = r.order.Uint32(o.value)
+			}
+		}
+	}
+	return p, nil
+}
+
+// parseSimplePacket parses Simple Packet Block, which always belongs to the first interface
+// and has no timestamp.
+func (r *Reader) parseSimplePacket(body []byte) (*Packet, error) {
+	if len(body) < 4 {
+		return nil, errors.New("Simple Packet Block is too short")
+	}
+	if len(r.ifaces) == 0 {
+		return nil, errors.New("packet refers to undefined interface 0")
+	}
+	origLen := int(r.order.Uint32(body[0:]))
+	capLen := len(body) - 4
+	if origLen < capLen {
+		capLen = origLen
+	}
+	if snap := int(r.ifaces[0].SnapLen); snap > 0 && capLen > snap {
+		capLen = snap
+	}
+	return &Packet{
+		Data:    append([]byte{}, body[4:4+capLen]...),
+		OrigLen: origLen,
+	}, nil
+}
+
+func (r *Reader) parseNameResolution(body []byte) error {
+	for len(body) >= 4 {
+		typ := r.order.Uint16(body[0:])
+		length := int(r.order.Uint16(body[2:]))
+		if typ == nrbRecordEnd {
+			return nil
+		}
+		if 4+pad(length) > len(body) {
+			return errors.New("name resolution record exceeds block")
+		}
+		v := body[4 : 4+length]
+		iplen := net.IPv4len
+		if typ == nrbRecordIPv6 {
+			iplen = net.IPv6len
+		}
+		if (typ == nrbRecordIPv4 || typ == nrbRecordIPv6) && len(v) > iplen {
+			rec := NameRecord{IP: net.IP(append([]byte{}, v[:iplen]...))}
+			for _, name := range bytes.Split(bytes.TrimRight(v[iplen:], "\x00"), []byte{0}) {
+				rec.Names = append(rec.Names, string(name))
+			}
+			r.names = append(r.names, rec)
+		}
+		body = body[4+pad(length):]
+	}
+	return nil
+}
+
+// parseOptions parses options until End of Options or the end of b.
+func (r *Reader) parseOptions(b []byte) ([]option, error) {
+	var options []option
+	for len(b) >= 4 {
+		code := r.order.Uint16(b[0:])
+		length := int(r.order.Uint16(b[2:]))
+		if code == optEnd {
+			break
+		}
+		if 4+length > len(b) {
+			return nil, errors.Errorf("option %d exceeds block", code)
+		}
+		options = append(options, option{code: code, value: b[4 : 4+length]})
+		if 4+pad(length) > len(b) {
+			break // padding of the last option is missing
+		}
+		b = b[4+pad(length):]
+	}
+	return options, nil
+}
+
+// timestamp converts a timestamp of the interface to time.
+// Fractions finer than a nanosecond are truncated.
+func (ifc *Interface) timestamp(ts uint64) time.Time {
+	sec := int64(ts/ifc.tsUnits) + ifc.tsOffset
+	frac := ts % ifc.tsUnits
+	if frac <= math.MaxUint64/uint64(time.Second) {
+		return time.Unix(sec, int64(frac*uint64(time.Second)/ifc.tsUnits))
+	}
+	// frac * 1e9 overflows with resolution finer than about 1e-10 second
+	nsec := new(big.Int).Mul(new(big.Int).SetUint64(frac), big.NewInt(int64(time.Second)))
+	nsec.Div(nsec, new(big.Int).SetUint64(ifc.tsUnits))
+	return time.Unix(sec, nsec.Int64())
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
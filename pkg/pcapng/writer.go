@@ -0,0 +1,314 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/pcap"
+	"github.com/pkg/errors"
+)
+
+// Writer writes blocks in pcapng format. Blocks are written in little endian.
+// Writer is safe for concurrent use.
+type Writer struct {
+	w      io.Writer
+	closer io.Closer // non-nil if Writer owns the file
+
+	mu      sync.Mutex
+	ifaces  int
+	byIndex map[int]int // interface index of the system to the index in the section
+}
+
+// Option is option which is used to create Writer.
+type Option func(*config)
+
+type config struct {
+	comment     string
+	application string
+}
+
+// SetComment sets the comment of the section.
+func SetComment(comment string) Option {
+	return func(c *config) {
+		c.comment = comment
+	}
+}
+
+// SetApplication sets the name of the application which writes the file.
+// If not set, "nwspeaker" is used.
+func SetApplication(name string) Option {
+	return func(c *config) {
+		c.application = name
+	}
+}
+
+// NewWriter writes Section Header Block to w and returns new Writer instance.
+func NewWriter(w io.Writer, opts ...Option) (*Writer, error) {
+	c := config{
+		application: "nwspeaker",
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+
+	body := make([]byte, shbBodyLen)
+	binary.LittleEndian.PutUint32(body[0:], ByteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:], VersionMajor)
+	binary.LittleEndian.PutUint16(body[6:], VersionMinor)
+	binary.LittleEndian.PutUint64(body[8:], 0xffffffffffffffff) // section length is not specified
+	options := []option{
+		{code: optSHBOS, value: []byte(runtime.GOOS)},
+		{code: optSHBUserAppl, value: []byte(c.application)},
+	}
+	if c.comment != "" {
+		options = append(options, option{code: optComment, value: []byte(c.comment)})
+	}
+
+	wr := &Writer{
+		w:       w,
+		byIndex: make(map[int]int),
+	}
+	if err := wr.writeBlock(BlockSectionHeader, body, options); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// Create creates the file at path and returns Writer writing to it.
+// The file is closed by Close.
+func Create(path string, opts ...Option) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create pcapng file")
+	}
+	w, err := NewWriter(f, opts...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// AddInterface writes Interface Description Block and returns the index of the interface,
+// which is used as Packet.Interface.
+func (w *Writer) AddInterface(ifc *Interface) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.addInterface(ifc)
+}
+
+func (w *Writer) addInterface(ifc *Interface) (int, error) {
+	body := make([]byte, idbBodyLen)
+	binary.LittleEndian.PutUint16(body[0:], ifc.LinkType)
+	binary.LittleEndian.PutUint32(body[4:], ifc.SnapLen)
+
+	options := []option{{code: optIfTSResol, value: []byte{writerTSResol}}}
+	if ifc.Name != "" {
+		options = append(options, option{code: optIfName, value: []byte(ifc.Name)})
+	}
+	if ifc.Description != "" {
+		options = append(options, option{code: optIfDescription, value: []byte(ifc.Description)})
+	}
+	for _, addr := range ifc.IPv4 {
+		v := make([]byte, 8)
+		copy(v[0:], addr.IP.To4())
+		copy(v[4:], addr.Mask)
+		options = append(options, option{code: optIfIPv4Addr, value: v})
+	}
+	if len(ifc.MAC) == ethernet.EtherLen {
+		options = append(options, option{code: optIfMACAddr, value: []byte(ifc.MAC)})
+	}
+	for _, c := range ifc.Comments {
+		options = append(options, option{code: optComment, value: []byte(c)})
+	}
+
+	if err := w.writeBlock(BlockInterfaceDescription, body, options); err != nil {
+		return 0, err
+	}
+	w.ifaces++
+	return w.ifaces - 1, nil
+}
+
+// WriteEnhancedPacket writes p in Enhanced Packet Block.
+// If SnapLen of the interface is set, Data must not exceed it.
+func (w *Writer) WriteEnhancedPacket(p *Packet) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writePacket(p)
+}
+
+func (w *Writer) writePacket(p *Packet) error {
+	if p.Interface < 0 || p.Interface >= w.ifaces {
+		return errors.Errorf("interface %d is not defined", p.Interface)
+	}
+	origLen := p.OrigLen
+	if origLen < len(p.Data) {
+		origLen = len(p.Data)
+	}
+	ts := uint64(p.Time.UnixNano())
+
+	body := make([]byte, epbBodyLen+pad(len(p.Data)))
+	binary.LittleEndian.PutUint32(body[0:], uint32(p.Interface))
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(p.Data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(origLen))
+	copy(body[epbBodyLen:], p.Data)
+
+	var options []option
+	if p.Flags != 0 {
+		v := make([]byte, 4)
+		binary.LittleEndian.PutUint32(v, p.Flags)
+		options = append(options, option{code: optEPBFlags, value: v})
+	}
+	for _, c := range p.Comments {
+		options = append(options, option{code: optComment, value: []byte(c)})
+	}
+	return w.writeBlock(BlockEnhancedPacket, body, options)
+}
+
+// WritePacket writes data captured at t on an unnamed Ethernet interface.
+// It makes Writer usable as ethernet.Recorder.
+func (w *Writer) WritePacket(t time.Time, data []byte) error {
+	return w.WriteFrame(data, &ethernet.FrameInfo{Time: t})
+}
+
+// WriteFrame writes an ethernet frame with its metadata. Interface Description Block
+// is written automatically when a frame of new interface is given.
+// It makes Writer usable as ethernet.InfoRecorder.
+func (w *Writer) WriteFrame(frame []byte, info *ethernet.FrameInfo) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	index := 0 // unknown interface
+	if info.Interface != nil {
+		index = info.Interface.Index
+	}
+	id, ok := w.byIndex[index]
+	if !ok {
+		ifc := &Interface{LinkType: pcap.LinkTypeEthernet}
+		if info.Interface != nil {
+			ifc.Name = info.Interface.Name
+			ifc.MAC = info.Interface.HardwareAddr
+			ifc.IPv4 = interfaceIPv4(info.Interface)
+		}
+		var err error
+		if id, err = w.addInterface(ifc); err != nil {
+			return err
+		}
+		w.byIndex[index] = id
+	}
+
+	p := &Packet{
+		Interface: id,
+		Time:      info.Time,
+		Data:      frame,
+		Flags:     FlagInbound,
+	}
+	if info.Outbound {
+		p.Flags = FlagOutbound
+	}
+	if info.Comment != "" {
+		p.Comments = []string{info.Comment}
+	}
+	return w.writePacket(p)
+}
+
+// WriteNameResolution writes records in Name Resolution Block.
+func (w *Writer) WriteNameResolution(records []NameRecord) error {
+	length := 4 // end of records
+	for _, r := range records {
+		length += 4 + pad(nameRecordLen(r))
+	}
+	body := make([]byte, length)
+	offset := 0
+	for _, r := range records {
+		typ, ip := uint16(nrbRecordIPv4), r.IP.To4()
+		if ip == nil {
+			typ, ip = nrbRecordIPv6, r.IP.To16()
+		}
+		binary.LittleEndian.PutUint16(body[offset:], typ)
+		binary.LittleEndian.PutUint16(body[offset+2:], uint16(nameRecordLen(r)))
+		v := body[offset+4:]
+		copy(v, ip)
+		v = v[len(ip):]
+		for _, name := range r.Names {
+			copy(v, name) // followed by zero
+			v = v[len(name)+1:]
+		}
+		offset += 4 + pad(nameRecordLen(r))
+	}
+	// the last 4 bytes are left zero as end of records
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeBlock(BlockNameResolution, body, nil)
+}
+
+func nameRecordLen(r NameRecord) int {
+	n := net.IPv4len
+	if r.IP.To4() == nil {
+		n = net.IPv6len
+	}
+	for _, name := range r.Names {
+		n += len(name) + 1
+	}
+	return n
+}
+
+// Close closes the underlying file if Writer was created by Create.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
+
+// writeBlock writes a block at once. body must be padded to 32-bit boundary.
+func (w *Writer) writeBlock(typ uint32, body []byte, options []option) error {
+	total := blockHeaderLen + len(body) + optionsLen(options) + blockTrailerLen
+	b := make([]byte, total)
+	binary.LittleEndian.PutUint32(b[0:], typ)
+	binary.LittleEndian.PutUint32(b[4:], uint32(total))
+	copy(b[blockHeaderLen:], body)
+	offset := blockHeaderLen + len(body)
+	if len(options) > 0 {
+		for _, o := range options {
+			binary.LittleEndian.PutUint16(b[offset:], o.code)
+			binary.LittleEndian.PutUint16(b[offset+2:], uint16(len(o.value)))
+			copy(b[offset+4:], o.value)
+			offset += 4 + pad(len(o.value))
+		}
+		offset += 4 // End of Options is all zero
+	}
+	binary.LittleEndian.PutUint32(b[offset:], uint32(total))
+
+	if _, err := w.w.Write(b); err != nil {
+		return errors.Wrap(err, "failed to write block")
+	}
+	return nil
+}
+
+// interfaceIPv4 returns IPv4 addresses assigned to ifi.
+func interfaceIPv4(ifi *net.Interface) []net.IPNet {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	var nets []net.IPNet
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			nets = append(nets, net.IPNet{IP: n.IP.To4(), Mask: n.Mask[len(n.Mask)-net.IPv4len:]})
+		}
+	}
+	return nets
+}
@@ -0,0 +1,23 @@
+package pmtu
+
+import (
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+)
+
+const (
+	// MinMTU is the minimum MTU every IPv4 host must accept (RFC 791).
+	MinMTU = 68
+
+	// DefaultTimeout is the default time to wait for each reply.
+	DefaultTimeout = time.Second
+	// DefaultRetries is the default number of probes sent for each size.
+	DefaultRetries = 3
+)
+
+const (
+	// length of IPv4 header and ICMP Echo header
+	probeOverhead = ipv4.HeaderLen + icmp.HeaderLen + 4
+)
@@ -0,0 +1,263 @@
+package pmtu
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/iface"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of Prober.
+type Config struct {
+	Interface string
+	Dst       net.IP
+	DstMac    net.HardwareAddr // MAC address of the next hop
+	SrcIP     net.IP           // if nil, the address of Interface is used
+	MinMTU    int              // smallest size to probe. must reach the destination.
+	MaxMTU    int              // largest size to probe. if zero, MTU of Interface is used.
+	Timeout   time.Duration
+	Retries   int // number of probes sent for each size before giving up
+}
+
+// Probe represents the result of probes sent with the same size.
+type Probe struct {
+	Size       int
+	OK         bool   // Echo Reply was received
+	FragNeeded bool   // Fragmentation Needed was received
+	NextHopMTU int    // next-hop MTU in Fragmentation Needed. old routers set zero (RFC 1191).
+	From       net.IP // address which sent Fragmentation Needed
+}
+
+// Result represents the result of path MTU discovery.
+type Result struct {
+	PMTU int
+	// BlackHole is true when probes larger than PMTU were silently dropped
+	// and no Fragmentation Needed was returned.
+	BlackHole bool
+	Probes    []Probe
+}
+
+// Prober discovers the path MTU to the destination.
+type Prober struct {
+	cfg   Config
+	src   net.IP
+	sock  *ethernet.Socket
+	probe func(size int) (*Probe, error) // sends probes of size and reports the result
+	id    uint16                         // ICMP identifier
+	seq   uint16
+	ipID  uint16
+}
+
+// New returns new Prober instance.
+func New(cfg Config) (*Prober, error) {
+	if cfg.Dst.To4() == nil {
+		return nil, errors.Errorf("destination '%s' is not an IPv4 address", cfg.Dst)
+	}
+	oif, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface information")
+	}
+	if cfg.MinMTU <= 0 {
+		cfg.MinMTU = MinMTU
+	}
+	if cfg.MaxMTU <= 0 {
+		cfg.MaxMTU = oif.MTU
+	}
+	if cfg.MinMTU < probeOverhead || cfg.MinMTU > cfg.MaxMTU {
+		return nil, errors.Errorf("invalid probe range %d-%d", cfg.MinMTU, cfg.MaxMTU)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = DefaultRetries
+	}
+
+	src := cfg.SrcIP
+	if src == nil {
+		src, err = iface.IPv4AddressByName(cfg.Interface)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get source IP address")
+		}
+		if src == nil {
+			return nil, errors.Errorf("no IPv4 address is assigned to \"%s\"", cfg.Interface)
+		}
+	}
+
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeIPv4)
+	if err != nil {
+		return nil, err
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	p := &Prober{
+		cfg:  cfg,
+		src:  src,
+		sock: sock,
+		id:   uint16(rand.Uint32()),
+		ipID: uint16(rand.Uint32()),
+	}
+	p.probe = p.sendProbes
+	return p, nil
+}
+
+// Close closes the socket used to receive replies.
+func (p *Prober) Close() error {
+	return p.sock.Close()
+}
+
+// Discover searches the path MTU with binary search.
+// When Fragmentation Needed is received, the next-hop MTU in it is probed next.
+func (p *Prober) Discover() (*Result, error) {
+	res := &Result{}
+	try := func(size int) (*Probe, error) {
+		pr, err := p.probe(size)
+		if err != nil {
+			return nil, err
+		}
+		res.Probes = append(res.Probes, *pr)
+		return pr, nil
+	}
+
+	pr, err := try(p.cfg.MinMTU)
+	if err != nil {
+		return nil, err
+	}
+	if !pr.OK {
+		return nil, errors.Errorf("no reply from %s even with %d bytes probe", p.cfg.Dst, p.cfg.MinMTU)
+	}
+
+	good, bad := p.cfg.MinMTU, p.cfg.MaxMTU+1 // good is reachable, bad is not
+	silent := false                           // a probe larger than good was dropped without ICMP
+	hinted := false                           // Fragmentation Needed was received
+	hint := 0                                 // size taken from Next-Hop MTU
+	size := p.cfg.MaxMTU
+	for good+1 < bad {
+		pr, err := try(size)
+		if err != nil {
+			return nil, err
+		}
+		next := 0
+		switch {
+		case pr.OK:
+			good = size
+			if size == hint { // larger probes cannot pass the router which told its MTU
+				bad = size + 1
+			}
+		case pr.FragNeeded:
+			hinted = true
+			bad = size
+			if pr.NextHopMTU > good && pr.NextHopMTU < size {
+				next = pr.NextHopMTU
+				hint = next
+			}
+		default:
+			silent = true
+			bad = size
+		}
+		if next == 0 {
+			next = (good + bad) / 2
+		}
+		size = next
+	}
+
+	res.PMTU = good
+	res.BlackHole = silent && !hinted
+	return res, nil
+}
+
+// sendProbes sends ICMP Echo Request whose total IPv4 length is size with DF flag.
+func (p *Prober) sendProbes(size int) (*Probe, error) {
+	res := &Probe{Size: size}
+	for i := 0; i < p.cfg.Retries; i++ {
+		ok, err := p.send(size, res)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+// send sends a probe and waits for the reply.
+// It returns true when Echo Reply or Fragmentation Needed was received.
+func (p *Prober) send(size int, res *Probe) (bool, error) {
+	data := make([]byte, size-probeOverhead)
+	for i := range data {
+		data[i] = byte('A' + i%26)
+	}
+	msg := &icmp.Message{
+		Type: icmp.TypeEcho,
+		Data: &icmp.Echo{
+			Identifier:     p.id,
+			SequenceNumber: p.seq,
+			Data:           data,
+		},
+	}
+	seq, id := p.seq, p.ipID
+	p.seq++
+	p.ipID++
+
+	err := ipv4.Send(p.cfg.Interface, p.cfg.Dst, msg.Encode(), ipv4.ProtoICMP,
+		ipv4.SetDstMac(p.cfg.DstMac),
+		ipv4.SetSrcIP(p.src),
+		ipv4.SetIdentification(id),
+		ipv4.SetFlags(ipv4.FlagDontFragment),
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to send probe")
+	}
+
+	deadline := time.Now().Add(p.cfg.Timeout)
+	for {
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return false, nil
+		}
+		if err := p.sock.SetRecvTimeout(remain); err != nil {
+			return false, err
+		}
+		b, err := p.sock.Recv(0)
+		if err != nil {
+			if ethernet.IsTimeout(err) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		pkt := ipv4.Parse(b[ethernet.HeaderLen:])
+		if pkt == nil || pkt.Protocol != ipv4.ProtoICMP || !pkt.DstAddress.Equal(p.src) {
+			continue
+		}
+		m := icmp.Parse(pkt.Data)
+		if m == nil {
+			continue
+		}
+		switch data := m.Data.(type) {
+		case *icmp.Echo:
+			if m.Type == icmp.TypeEchoReply && data.Identifier == p.id && data.SequenceNumber == seq {
+				res.OK = true
+				return true, nil
+			}
+		case *icmp.Error:
+			if m.Type != icmp.TypeDestinationUnreachable || m.Code != icmp.CodeFragmentationNeeded {
+				continue
+			}
+			orig := ipv4.Parse(data.Original)
+			if orig == nil || orig.Identification != id || !orig.DstAddress.Equal(p.cfg.Dst) {
+				continue
+			}
+			res.FragNeeded = true
+			res.NextHopMTU = int(data.NextHopMTU)
+			res.From = pkt.SrcAddress
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pmtu
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+var testRouter = net.IPv4(192, 168, 0, 254)
+
+// testPath returns the probe function of the path whose MTU is mtu.
+// Larger probes are answered with Fragmentation Needed carrying hint,
+// or silently dropped if silent is true.
+func testPath(mtu, hint int, silent bool) func(size int) (*Probe, error) {
+	return func(size int) (*Probe, error) {
+		pr := &Probe{Size: size}
+		switch {
+		case size <= mtu:
+			pr.OK = true
+		case !silent:
+			pr.FragNeeded = true
+			pr.NextHopMTU = hint
+			pr.From = testRouter
+		}
+		return pr, nil
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name      string
+		min, max  int
+		probe     func(size int) (*Probe, error)
+		pmtu      int
+		blackHole bool
+		sizes     []int // sizes probed in order. nil skips the check.
+	}{
+		{
+			name:  "hint in range",
+			min:   MinMTU,
+			max:   1500,
+			probe: testPath(1400, 1400, false),
+			pmtu:  1400,
+			sizes: []int{68, 1500, 1400},
+		},
+		{
+			name:  "no path limit",
+			min:   MinMTU,
+			max:   1500,
+			probe: testPath(1500, 0, false),
+			pmtu:  1500,
+			sizes: []int{68, 1500},
+		},
+		{
+			name:  "hint larger than probe",
+			min:   MinMTU,
+			max:   1500,
+			probe: testPath(1400, 9000, false),
+			pmtu:  1400,
+		},
+		{
+			name:  "hint smaller than reachable size",
+			min:   576,
+			max:   1500,
+			probe: testPath(1400, 100, false),
+			pmtu:  1400,
+		},
+		{
+			name:  "zero hint of old router",
+			min:   MinMTU,
+			max:   1500,
+			probe: testPath(1000, 0, false),
+			pmtu:  1000,
+		},
+		{
+			name:      "silent drop",
+			min:       MinMTU,
+			max:       1500,
+			probe:     testPath(1280, 0, true),
+			pmtu:      1280,
+			blackHole: true,
+		},
+		{
+			name:  "min equals max",
+			min:   576,
+			max:   576,
+			probe: testPath(1500, 0, false),
+			pmtu:  576,
+			sizes: []int{576},
+		},
+	}
+
+	for _, tt := range tests {
+		p := &Prober{
+			cfg:   Config{Dst: net.IPv4(192, 168, 1, 1), MinMTU: tt.min, MaxMTU: tt.max},
+			probe: tt.probe,
+		}
+		res, err := p.Discover()
+		if err != nil {
+			t.Errorf("%s: Discover() returns error: %v\n", tt.name, err)
+			continue
+		}
+		if res.PMTU != tt.pmtu || res.BlackHole != tt.blackHole {
+			t.Errorf("%s: PMTU, BlackHole = %d, %v, but got %d, %v\n", tt.name, tt.pmtu, tt.blackHole, res.PMTU, res.BlackHole)
+		}
+		if tt.sizes != nil {
+			var sizes []int
+			for _, pr := range res.Probes {
+				sizes = append(sizes, pr.Size)
+			}
+			if !reflect.DeepEqual(sizes, tt.sizes) {
+				t.Errorf("%s: probed sizes = %v, but got %v\n", tt.name, tt.sizes, sizes)
+			}
+		}
+	}
+}
+
+func TestDiscoverUnreachable(t *testing.T) {
+	p := &Prober{
+		cfg:   Config{Dst: net.IPv4(192, 168, 1, 1), MinMTU: MinMTU, MaxMTU: 1500},
+		probe: testPath(0, 0, true),
+	}
+	if _, err := p.Discover(); err == nil {
+		t.Errorf("Discover() without reply to the smallest probe should return error\n")
+	}
+}
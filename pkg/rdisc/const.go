@@ -0,0 +1,38 @@
+package rdisc
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// DefaultMaxInterval is the default maximum interval between advertisements.
+	DefaultMaxInterval = 600 * time.Second
+	// MinMaxInterval is the smallest value of maximum interval.
+	MinMaxInterval = 4 * time.Second
+	// MaxMaxInterval is the largest value of maximum interval.
+	MaxMaxInterval = 1800 * time.Second
+	// MinMinInterval is the smallest value of minimum interval.
+	MinMinInterval = 3 * time.Second
+	// MaxLifetime is the largest value of advertisement lifetime.
+	MaxLifetime = 9000 * time.Second
+
+	// MaxInitialAdvertInterval is the maximum interval of the first advertisements.
+	MaxInitialAdvertInterval = 16 * time.Second
+	// MaxInitialAdvertisements is the number of advertisements sent with MaxInitialAdvertInterval.
+	MaxInitialAdvertisements = 3
+	// MaxResponseDelay is the maximum delay before answering Router Solicitation.
+	MaxResponseDelay = 2 * time.Second
+)
+
+var (
+	// AllSystems is the all-systems multicast address.
+	AllSystems = net.IPv4(224, 0, 0, 1)
+	// AllRouters is the all-routers multicast address.
+	AllRouters = net.IPv4(224, 0, 0, 2)
+)
+
+const (
+	// interval to check whether Advertiser is closed
+	pollInterval = 500 * time.Millisecond
+)
@@ -0,0 +1,201 @@
+package rdisc
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/iface"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of Advertiser.
+type Config struct {
+	Interface   string
+	Entries     []icmp.RouterEntry // if empty, the address of Interface is advertised with preference 0
+	MaxInterval time.Duration      // maximum interval between advertisements
+	MinInterval time.Duration      // minimum interval between advertisements. default is 0.75 * MaxInterval.
+	Lifetime    time.Duration      // lifetime of advertised addresses. default is 3 * MaxInterval.
+	Broadcast   bool               // send advertisements to 255.255.255.255 instead of 224.0.0.1
+}
+
+// Advertiser periodically sends ICMP Router Advertisement and answers Router Solicitation (RFC 1256).
+type Advertiser struct {
+	cfg  Config
+	src  net.IP
+	sock *ethernet.Socket
+	done chan struct{}
+	once sync.Once
+	mu   sync.Mutex // orders wg.Add in Serve and close of done in Close
+	wg   sync.WaitGroup
+	sent int // number of advertisements sent
+}
+
+// New returns new Advertiser instance.
+func New(cfg Config) (*Advertiser, error) {
+	if cfg.MaxInterval == 0 {
+		cfg.MaxInterval = DefaultMaxInterval
+	}
+	if cfg.MaxInterval < MinMaxInterval || cfg.MaxInterval > MaxMaxInterval {
+		return nil, errors.Errorf("max interval must be between %s and %s", MinMaxInterval, MaxMaxInterval)
+	}
+	if cfg.MinInterval == 0 {
+		cfg.MinInterval = cfg.MaxInterval * 3 / 4
+	}
+	if cfg.MinInterval < MinMinInterval || cfg.MinInterval > cfg.MaxInterval {
+		return nil, errors.Errorf("min interval must be between %s and max interval", MinMinInterval)
+	}
+	if cfg.Lifetime == 0 {
+		cfg.Lifetime = cfg.MaxInterval * 3
+	}
+	if cfg.Lifetime < cfg.MaxInterval || cfg.Lifetime > MaxLifetime {
+		return nil, errors.Errorf("lifetime must be between max interval and %s", MaxLifetime)
+	}
+
+	src, err := iface.IPv4AddressByName(cfg.Interface)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get IPv4 address")
+	}
+	if src == nil {
+		return nil, errors.Errorf("no IPv4 address is assigned to \"%s\"", cfg.Interface)
+	}
+	if len(cfg.Entries) == 0 {
+		cfg.Entries = []icmp.RouterEntry{{Addr: src}}
+	}
+
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeIPv4)
+	if err != nil {
+		return nil, err
+	}
+	// solicitations are sent to all-routers group, which the kernel of a host does not join
+	if err := sock.JoinMulticast(ethernet.IPv4Multicast(AllRouters)); err != nil {
+		sock.Close()
+		return nil, err
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	return &Advertiser{
+		cfg:  cfg,
+		src:  src,
+		sock: sock,
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Serve sends advertisements until Close is called.
+// When Close is called, an advertisement with zero lifetime is sent to withdraw the addresses.
+// Serve returns nil without sending anything if Close was already called.
+func (a *Advertiser) Serve() error {
+	a.mu.Lock()
+	select {
+	case <-a.done:
+		a.mu.Unlock()
+		return nil
+	default:
+	}
+	a.wg.Add(1)
+	a.mu.Unlock()
+	defer a.wg.Done()
+
+	next := time.Now()
+	for {
+		select {
+		case <-a.done:
+			return a.advertise(0)
+		default:
+		}
+
+		if !time.Now().Before(next) {
+			if err := a.advertise(uint16(a.cfg.Lifetime / time.Second)); err != nil {
+				return err
+			}
+			next = time.Now().Add(a.interval())
+		}
+
+		wait := time.Until(next)
+		if wait > pollInterval {
+			wait = pollInterval
+		}
+		if err := a.sock.SetRecvTimeout(wait); err != nil {
+			return err
+		}
+		b, err := a.sock.Recv(0)
+		if err != nil {
+			if ethernet.IsTimeout(err) {
+				continue
+			}
+			return err
+		}
+		if a.isSolicitation(b[ethernet.HeaderLen:]) {
+			// answer after random delay so that routers on the link do not respond at the same time
+			at := time.Now().Add(time.Duration(rand.Int63n(int64(MaxResponseDelay))))
+			if at.Before(next) {
+				next = at
+			}
+		}
+	}
+}
+
+// Close stops Serve, waits the final advertisement and closes the socket.
+// Calling Close more than once returns nil.
+func (a *Advertiser) Close() error {
+	var err error
+	a.once.Do(func() {
+		a.mu.Lock()
+		close(a.done)
+		a.mu.Unlock()
+		a.wg.Wait()
+		err = a.sock.Close()
+	})
+	return err
+}
+
+// interval returns the time until the next advertisement.
+func (a *Advertiser) interval() time.Duration {
+	d := a.cfg.MinInterval
+	if a.cfg.MaxInterval > a.cfg.MinInterval {
+		d += time.Duration(rand.Int63n(int64(a.cfg.MaxInterval - a.cfg.MinInterval)))
+	}
+	if a.sent <= MaxInitialAdvertisements && d > MaxInitialAdvertInterval {
+		d = MaxInitialAdvertInterval
+	}
+	return d
+}
+
+func (a *Advertiser) advertise(lifetime uint16) error {
+	dst := AllSystems
+	dstMac := ethernet.IPv4Multicast(AllSystems)
+	if a.cfg.Broadcast {
+		dst = net.IPv4bcast
+		dstMac = ethernet.Broadcast
+	}
+
+	msg := icmp.NewRouterAdvertisement(lifetime, a.cfg.Entries)
+	err := ipv4.Send(a.cfg.Interface, dst, msg.Encode(), ipv4.ProtoICMP,
+		ipv4.SetDstMac(dstMac),
+		ipv4.SetSrcIP(a.src),
+		ipv4.SetTTL(1),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to send router advertisement")
+	}
+	a.sent++
+	return nil
+}
+
+// isSolicitation reports whether given IPv4 packet is Router Solicitation.
+func (a *Advertiser) isSolicitation(b []byte) bool {
+	pkt := ipv4.Parse(b)
+	if pkt == nil || pkt.Protocol != ipv4.ProtoICMP || pkt.SrcAddress.Equal(a.src) {
+		return false
+	}
+	if !pkt.DstAddress.Equal(AllRouters) && !pkt.DstAddress.Equal(net.IPv4bcast) && !pkt.DstAddress.Equal(a.src) {
+		return false
+	}
+	msg := icmp.Parse(pkt.Data)
+	return msg != nil && msg.Type == icmp.TypeRouterSolicitation
+}
@@ -0,0 +1,98 @@
+package rdisc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+)
+
+var (
+	testSrc  = net.IPv4(192, 168, 0, 1)
+	testHost = net.IPv4(192, 168, 0, 10)
+)
+
+func TestIsSolicitation(t *testing.T) {
+	solicitation := icmp.NewRouterSolicitation().Encode()
+	advertisement := icmp.NewRouterAdvertisement(1800, []icmp.RouterEntry{{Addr: testSrc}}).Encode()
+	tests := []struct {
+		name string
+		in   []byte
+		want bool
+	}{
+		{"all-routers", ipv4.NewPacket(testHost, AllRouters, ipv4.ProtoICMP, solicitation).Encode(), true},
+		{"broadcast", ipv4.NewPacket(testHost, net.IPv4bcast, ipv4.ProtoICMP, solicitation).Encode(), true},
+		{"unicast to us", ipv4.NewPacket(testHost, testSrc, ipv4.ProtoICMP, solicitation).Encode(), true},
+		{"unicast to other", ipv4.NewPacket(testHost, net.IPv4(192, 168, 0, 2), ipv4.ProtoICMP, solicitation).Encode(), false},
+		{"all-systems", ipv4.NewPacket(testHost, AllSystems, ipv4.ProtoICMP, solicitation).Encode(), false},
+		{"sent by ourselves", ipv4.NewPacket(testSrc, AllRouters, ipv4.ProtoICMP, solicitation).Encode(), false},
+		{"advertisement", ipv4.NewPacket(testHost, AllRouters, ipv4.ProtoICMP, advertisement).Encode(), false},
+		{"udp", ipv4.NewPacket(testHost, AllRouters, ipv4.ProtoUDP, solicitation).Encode(), false},
+		{"no icmp header", ipv4.NewPacket(testHost, AllRouters, ipv4.ProtoICMP, nil).Encode(), false},
+		{"truncated", ipv4.NewPacket(testHost, AllRouters, ipv4.ProtoICMP, solicitation).Encode()[:ipv4.HeaderLen-1], false},
+	}
+
+	a := &Advertiser{src: testSrc}
+	for _, tt := range tests {
+		if got := a.isSolicitation(tt.in); got != tt.want {
+			t.Errorf("%s: isSolicitation() = %v, but got %v\n", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		min, max time.Duration
+		sent     int
+		lo, hi   time.Duration // expected range [lo, hi]
+	}{
+		// first advertisements are limited to MaxInitialAdvertInterval
+		{450 * time.Second, DefaultMaxInterval, 0, MaxInitialAdvertInterval, MaxInitialAdvertInterval},
+		{450 * time.Second, DefaultMaxInterval, MaxInitialAdvertisements, MaxInitialAdvertInterval, MaxInitialAdvertInterval},
+		{450 * time.Second, DefaultMaxInterval, MaxInitialAdvertisements + 1, 450 * time.Second, DefaultMaxInterval - 1},
+		// intervals shorter than MaxInitialAdvertInterval are not changed
+		{MinMinInterval, MinMaxInterval, 0, MinMinInterval, MinMaxInterval - 1},
+		{10 * time.Second, 10 * time.Second, 0, 10 * time.Second, 10 * time.Second},
+		{30 * time.Second, 30 * time.Second, 10, 30 * time.Second, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		a := &Advertiser{
+			cfg:  Config{MinInterval: tt.min, MaxInterval: tt.max},
+			sent: tt.sent,
+		}
+		for i := 0; i < 100; i++ {
+			if got := a.interval(); got < tt.lo || got > tt.hi {
+				t.Errorf("interval() with min %s, max %s and %d sent = [%s, %s], but got %s\n", tt.min, tt.max, tt.sent, tt.lo, tt.hi, got)
+				break
+			}
+		}
+	}
+}
+
+func TestServeAfterClose(t *testing.T) {
+	a := &Advertiser{done: make(chan struct{})}
+	close(a.done)
+	// must return without touching the socket, which is already closed
+	if err := a.Serve(); err != nil {
+		t.Errorf("Serve() after Close = nil, but got %v\n", err)
+	}
+	a.wg.Wait()
+}
+
+func TestCloseTwice(t *testing.T) {
+	sock, err := ethernet.Listen("lo", ethernet.TypeIPv4)
+	if err != nil {
+		t.Skipf("socket on lo is not available: %v", err)
+	}
+	a := &Advertiser{sock: sock, done: make(chan struct{})}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() returns error: %v\n", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("second Close() returns error: %v\n", err)
+	}
+}
@@ -0,0 +1,55 @@
+package reassembly
+
+import "time"
+
+const (
+	// DefaultMaxBuffered is the default maximum bytes of out-of-order data kept per direction.
+	DefaultMaxBuffered = 1 << 20
+	// DefaultTimeout is the default idle time after which a flow is flushed by FlushIdle.
+	DefaultTimeout = 2 * time.Minute
+)
+
+// Direction is the direction of data in a flow.
+type Direction int
+
+const (
+	// ClientToServer is the direction from the endpoint which sent SYN.
+	ClientToServer Direction = iota
+	// ServerToClient is the direction from the endpoint which sent SYN/ACK.
+	ServerToClient
+)
+
+// String returns the short representation of d.
+func (d Direction) String() string {
+	if d == ServerToClient {
+		return "s2c"
+	}
+	return "c2s"
+}
+
+// EndReason describes why a flow ended.
+type EndReason int
+
+const (
+	// EndFIN means both directions are closed by FIN.
+	EndFIN EndReason = iota
+	// EndRST means the flow is reset.
+	EndRST
+	// EndTimeout means the flow is flushed because it was idle.
+	EndTimeout
+	// EndFlush means the flow is flushed by FlushAll.
+	EndFlush
+)
+
+// String returns the short representation of r.
+func (r EndReason) String() string {
+	switch r {
+	case EndFIN:
+		return "fin"
+	case EndRST:
+		return "rst"
+	case EndTimeout:
+		return "timeout"
+	}
+	return "flush"
+}
@@ -0,0 +1,310 @@
+package reassembly
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+)
+
+// Config is the configuration of Assembler.
+type Config struct {
+	MaxBuffered int           // maximum bytes of out-of-order data kept per direction. if zero, DefaultMaxBuffered is used
+	Timeout     time.Duration // idle time used by FlushIdle. if zero, DefaultTimeout is used
+}
+
+// Handler is the set of callbacks invoked by Assembler. Nil callbacks are ignored.
+// Data passed to Data must not be retained after it returns.
+type Handler struct {
+	Start func(f *Flow)
+	Data  func(f *Flow, dir Direction, data []byte)
+	Gap   func(f *Flow, dir Direction, size int) // size bytes were lost and skipped
+	End   func(f *Flow, reason EndReason)
+}
+
+// Endpoint is one side of a TCP flow.
+type Endpoint struct {
+	IP   net.IP
+	Port uint16
+}
+
+// String returns "ip:port" representation of e.
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s:%d", e.IP, e.Port)
+}
+
+// Flow is a bidirectional TCP flow.
+// Client is the sender of SYN. If SYN is not observed, the sender of the first segment is regarded as Client.
+type Flow struct {
+	Client    Endpoint
+	Server    Endpoint
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Bytes     [2]uint64 // bytes delivered per Direction
+	Lost      [2]uint64 // bytes skipped per Direction
+
+	half [2]halfStream
+}
+
+// String returns "client -> server" representation of f.
+func (f *Flow) String() string {
+	return fmt.Sprintf("%s -> %s", f.Client, f.Server)
+}
+
+// halfStream is the state of one direction.
+type halfStream struct {
+	started  bool   // next is valid
+	next     uint32 // next sequence number to deliver
+	pending  []segment
+	buffered int
+	fin      bool
+	finSeq   uint32
+	closed   bool
+}
+
+// segment is out-of-order data waiting for the preceding data.
+type segment struct {
+	seq  uint32
+	data []byte
+}
+
+// Assembler reassembles TCP streams from captured segments.
+// Segments are ordered by sequence number. Retransmitted and overlapped data
+// is delivered only once, and the data received first takes precedence.
+// Assembler is not safe for concurrent use.
+type Assembler struct {
+	cfg     Config
+	handler Handler
+	flows   map[string]*Flow
+}
+
+// New returns new Assembler instance.
+func New(cfg Config, handler Handler) *Assembler {
+	if cfg.MaxBuffered <= 0 {
+		cfg.MaxBuffered = DefaultMaxBuffered
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	return &Assembler{
+		cfg:     cfg,
+		handler: handler,
+		flows:   make(map[string]*Flow),
+	}
+}
+
+// Frame processes an ethernet frame. Frames other than TCP over IPv4 are ignored.
+// t is the time the frame was captured.
+func (a *Assembler) Frame(frame []byte, t time.Time) {
+	if len(frame) < ethernet.HeaderLen {
+		return
+	}
+	if hdr := ethernet.Parse(frame); hdr.EtherType != ethernet.TypeIPv4 {
+		return
+	}
+	if pkt := ipv4.Parse(frame[ethernet.HeaderLen:]); pkt != nil {
+		a.Packet(pkt, t)
+	}
+}
+
+// Packet processes an IPv4 packet. Fragments are ignored.
+func (a *Assembler) Packet(pkt *ipv4.Packet, t time.Time) {
+	if pkt.Protocol != ipv4.ProtoTCP || pkt.FlagmentOffset != 0 || pkt.Flags&ipv4.FlagMoreFragment != 0 {
+		return
+	}
+	if seg := tcp.Parse(pkt.Data); seg != nil {
+		a.Segment(pkt.SrcAddress, pkt.DstAddress, seg, t)
+	}
+}
+
+// Segment processes a TCP segment sent from src to dst.
+func (a *Assembler) Segment(src, dst net.IP, seg *tcp.Segment, t time.Time) {
+	from := Endpoint{IP: src, Port: seg.SrcPort}
+	to := Endpoint{IP: dst, Port: seg.DstPort}
+	key := flowKey(from, to)
+
+	f, ok := a.flows[key]
+	if !ok {
+		if seg.HasFlags(tcp.FlagRST) {
+			return // nothing to reassemble
+		}
+		f = &Flow{Client: from, Server: to, FirstSeen: t}
+		if seg.HasFlags(tcp.FlagSYN) && seg.HasFlags(tcp.FlagACK) { // SYN was missed
+			f.Client, f.Server = to, from
+		}
+		a.flows[key] = f
+		if a.handler.Start != nil {
+			a.handler.Start(f)
+		}
+	}
+	f.LastSeen = t
+
+	dir := ClientToServer
+	if f.Client.Port != from.Port || !f.Client.IP.Equal(from.IP) {
+		dir = ServerToClient
+	}
+
+	if seg.HasFlags(tcp.FlagRST) {
+		a.end(key, f, EndRST)
+		return
+	}
+
+	h := &f.half[dir]
+	seq := seg.SeqNum
+	if seg.HasFlags(tcp.FlagSYN) {
+		seq++ // SYN occupies a sequence number
+		if !h.started {
+			h.started, h.next = true, seq
+		}
+	}
+	if !h.started { // capture started in the middle of the flow
+		h.started, h.next = true, seq
+	}
+
+	if len(seg.Data) > 0 {
+		a.add(f, dir, seq, seg.Data)
+	}
+	if seg.HasFlags(tcp.FlagFIN) && !h.fin {
+		h.fin, h.finSeq = true, seq+uint32(len(seg.Data))
+	}
+	a.checkClosed(f, dir)
+	if f.half[ClientToServer].closed && f.half[ServerToClient].closed {
+		a.end(key, f, EndFIN)
+	}
+}
+
+// add delivers data or keeps it until the preceding data arrives.
+func (a *Assembler) add(f *Flow, dir Direction, seq uint32, data []byte) {
+	h := &f.half[dir]
+	end := seq + uint32(len(data))
+	if !seqLT(h.next, end) { // retransmission of delivered data
+		return
+	}
+	if seqLT(seq, h.next) { // overlaps delivered data
+		data = data[h.next-seq:]
+		seq = h.next
+	}
+
+	if seq != h.next {
+		buf := make([]byte, len(data))
+		copy(buf, data)
+		h.pending = append(h.pending, segment{seq: seq, data: buf})
+		sort.SliceStable(h.pending, func(i, j int) bool { return seqLT(h.pending[i].seq, h.pending[j].seq) })
+		h.buffered += len(buf)
+		for h.buffered > a.cfg.MaxBuffered {
+			a.skip(f, dir)
+		}
+		return
+	}
+
+	a.deliver(f, dir, data)
+	a.drain(f, dir)
+}
+
+// drain delivers pending data which became contiguous.
+func (a *Assembler) drain(f *Flow, dir Direction) {
+	h := &f.half[dir]
+	for len(h.pending) > 0 {
+		s := h.pending[0]
+		if seqLT(h.next, s.seq) {
+			return
+		}
+		h.pending = h.pending[1:]
+		h.buffered -= len(s.data)
+		end := s.seq + uint32(len(s.data))
+		if !seqLT(h.next, end) {
+			continue
+		}
+		a.deliver(f, dir, s.data[h.next-s.seq:])
+	}
+}
+
+// skip gives up waiting for missing data and delivers the next pending data.
+func (a *Assembler) skip(f *Flow, dir Direction) {
+	h := &f.half[dir]
+	if len(h.pending) == 0 {
+		return
+	}
+	size := int(h.pending[0].seq - h.next)
+	if size > 0 {
+		f.Lost[dir] += uint64(size)
+		if a.handler.Gap != nil {
+			a.handler.Gap(f, dir, size)
+		}
+	}
+	h.next = h.pending[0].seq
+	a.drain(f, dir)
+}
+
+func (a *Assembler) deliver(f *Flow, dir Direction, data []byte) {
+	h := &f.half[dir]
+	h.next += uint32(len(data))
+	f.Bytes[dir] += uint64(len(data))
+	if a.handler.Data != nil {
+		a.handler.Data(f, dir, data)
+	}
+}
+
+// checkClosed marks the direction closed when all data before FIN is delivered.
+func (a *Assembler) checkClosed(f *Flow, dir Direction) {
+	h := &f.half[dir]
+	if h.fin && !h.closed && h.next == h.finSeq {
+		h.closed = true
+	}
+}
+
+// end delivers the remaining data skipping gaps and removes the flow.
+func (a *Assembler) end(key string, f *Flow, reason EndReason) {
+	for dir := ClientToServer; dir <= ServerToClient; dir++ {
+		for len(f.half[dir].pending) > 0 {
+			a.skip(f, dir)
+		}
+	}
+	delete(a.flows, key)
+	if a.handler.End != nil {
+		a.handler.End(f, reason)
+	}
+}
+
+// FlushIdle ends flows which have not received segments since now - Timeout.
+// It returns the number of flows ended.
+func (a *Assembler) FlushIdle(now time.Time) int {
+	n := 0
+	for key, f := range a.flows {
+		if now.Sub(f.LastSeen) > a.cfg.Timeout {
+			a.end(key, f, EndTimeout)
+			n++
+		}
+	}
+	return n
+}
+
+// FlushAll ends all flows. It should be called when the capture is finished.
+func (a *Assembler) FlushAll() {
+	for key, f := range a.flows {
+		a.end(key, f, EndFlush)
+	}
+}
+
+// Flows returns the number of active flows.
+func (a *Assembler) Flows() int {
+	return len(a.flows)
+}
+
+// flowKey returns the key which is the same for both directions.
+func flowKey(a, b Endpoint) string {
+	x, y := a.String(), b.String()
+	if x > y {
+		x, y = y, x
+	}
+	return x + "-" + y
+}
+
+// seqLT reports whether a is less than b in sequence number space.
+func seqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
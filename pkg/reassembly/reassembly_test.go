@@ -0,0 +1,171 @@
+package reassembly
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+)
+
+var (
+	testClient = net.IPv4(10, 0, 0, 1)
+	testServer = net.IPv4(10, 0, 0, 2)
+)
+
+const (
+	clientISN = 1000
+	serverISN = 0xfffffff0 // wraps around in the middle of the stream
+)
+
+// recorder records events delivered by Assembler.
+type recorder struct {
+	data   [2]bytes.Buffer
+	events []string
+}
+
+func (r *recorder) handler() Handler {
+	return Handler{
+		Start: func(f *Flow) { r.events = append(r.events, "start "+f.String()) },
+		Data:  func(f *Flow, dir Direction, data []byte) { r.data[dir].Write(data) },
+		Gap: func(f *Flow, dir Direction, size int) {
+			r.events = append(r.events, fmt.Sprintf("gap %s %d", dir, size))
+			r.data[dir].WriteString(strings.Repeat("?", size))
+		},
+		End: func(f *Flow, reason EndReason) { r.events = append(r.events, "end "+reason.String()) },
+	}
+}
+
+func seg(dir Direction, flags uint16, off uint32, data string) *tcp.Segment {
+	s := &tcp.Segment{Header: tcp.Header{Flags: flags}, Data: []byte(data)}
+	if dir == ClientToServer {
+		s.SrcPort, s.DstPort, s.SeqNum = 40000, 80, clientISN+off
+	} else {
+		s.SrcPort, s.DstPort, s.SeqNum = 80, 40000, serverISN+off
+	}
+	return s
+}
+
+func feed(a *Assembler, segs ...*tcp.Segment) {
+	for _, s := range segs {
+		if s.DstPort == 80 {
+			a.Segment(testClient, testServer, s, time.Now())
+		} else {
+			a.Segment(testServer, testClient, s, time.Now())
+		}
+	}
+}
+
+func TestReassembly(t *testing.T) {
+	r := &recorder{}
+	a := New(Config{}, r.handler())
+	feed(a,
+		seg(ClientToServer, tcp.FlagSYN, 0, ""),
+		seg(ServerToClient, tcp.FlagSYN|tcp.FlagACK, 0, ""),
+		seg(ClientToServer, tcp.FlagACK, 1, "GET / "),
+		seg(ClientToServer, tcp.FlagACK, 12, "1.1\r\n"),     // out of order
+		seg(ClientToServer, tcp.FlagACK, 1, "GET / "),       // retransmission
+		seg(ClientToServer, tcp.FlagACK, 5, "/ HTTP/1.1\r"), // overlaps both sides
+		seg(ServerToClient, tcp.FlagACK, 1, "HTTP/1.1 "),    // wraps around sequence space
+		seg(ServerToClient, tcp.FlagACK, 10, "200 OK"),
+		seg(ServerToClient, tcp.FlagFIN|tcp.FlagACK, 16, ""),
+	)
+	if a.Flows() != 1 {
+		t.Errorf("flow must not end before client sends FIN\n")
+	}
+	feed(a, seg(ClientToServer, tcp.FlagFIN|tcp.FlagACK, 17, ""))
+
+	if got := r.data[ClientToServer].String(); got != "GET / HTTP/1.1\r\n" {
+		t.Errorf("client data = %q, but got %q\n", "GET / HTTP/1.1\r\n", got)
+	}
+	if got := r.data[ServerToClient].String(); got != "HTTP/1.1 200 OK" {
+		t.Errorf("server data = %q, but got %q\n", "HTTP/1.1 200 OK", got)
+	}
+	want := []string{"start 10.0.0.1:40000 -> 10.0.0.2:80", "end fin"}
+	if strings.Join(r.events, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %v, but got %v\n", want, r.events)
+	}
+	if a.Flows() != 0 {
+		t.Errorf("flow must be removed after both sides send FIN\n")
+	}
+}
+
+func TestGap(t *testing.T) {
+	r := &recorder{}
+	a := New(Config{MaxBuffered: 8}, r.handler())
+	feed(a,
+		seg(ClientToServer, tcp.FlagSYN, 0, ""),
+		seg(ClientToServer, tcp.FlagACK, 1, "abc"),
+		seg(ClientToServer, tcp.FlagACK, 7, "ghij"), // "def" is lost
+		seg(ClientToServer, tcp.FlagACK, 11, "klmno"),
+	)
+	if got := r.data[ClientToServer].String(); got != "abc???ghijklmno" {
+		t.Errorf("data = %q, but got %q\n", "abc???ghijklmno", got)
+	}
+
+	// remaining data is delivered when the flow is reset
+	feed(a,
+		seg(ClientToServer, tcp.FlagACK, 20, "tu"),
+		seg(ServerToClient, tcp.FlagRST, 0, ""),
+	)
+	if got := r.data[ClientToServer].String(); got != "abc???ghijklmno????tu" {
+		t.Errorf("data = %q, but got %q\n", "abc???ghijklmno????tu", got)
+	}
+	if last := r.events[len(r.events)-1]; last != "end rst" {
+		t.Errorf("last event = end rst, but got %s\n", last)
+	}
+}
+
+func TestMidstream(t *testing.T) {
+	r := &recorder{}
+	a := New(Config{Timeout: time.Minute}, r.handler())
+
+	// capture started after the handshake. server's SYN/ACK was not captured either.
+	s := seg(ServerToClient, tcp.FlagACK, 100, "hello")
+	pkt := ipv4.NewPacket(testServer, testClient, ipv4.ProtoTCP, s.Encode(testServer, testClient))
+	frame := ethernet.Frame(ethernet.Broadcast, ethernet.Zero, ethernet.TypeIPv4, pkt.Encode())
+	now := time.Now()
+	a.Frame(frame, now)
+	if got := r.data[ClientToServer].String(); got != "hello" {
+		t.Errorf("data of the first sender = %q, but got %q\n", "hello", got)
+	}
+
+	if n := a.FlushIdle(now.Add(30 * time.Second)); n != 0 {
+		t.Errorf("FlushIdle() before timeout = 0, but got %d\n", n)
+	}
+	if n := a.FlushIdle(now.Add(2 * time.Minute)); n != 1 {
+		t.Errorf("FlushIdle() after timeout = 1, but got %d\n", n)
+	}
+	if last := r.events[len(r.events)-1]; last != "end timeout" {
+		t.Errorf("last event = end timeout, but got %s\n", last)
+	}
+}
+
+func TestFragment(t *testing.T) {
+	s := seg(ServerToClient, tcp.FlagACK, 100, "hello")
+	tests := []struct {
+		frag []byte // bytes 6 and 7 of IPv4 header on the wire
+		data string
+	}{
+		{[]byte{0x40, 0x00}, "hello"}, // DF
+		{[]byte{0x20, 0x00}, ""},      // first fragment
+		{[]byte{0x00, 0x03}, ""},      // last fragment
+	}
+	for _, tt := range tests {
+		r := &recorder{}
+		a := New(Config{Timeout: time.Minute}, r.handler())
+		b := append([]byte{
+			0x45, 0x00, 0x00, 0x2d, 0x00, 0x01, tt.frag[0], tt.frag[1], 0x40, 0x06, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x02,
+			0x0a, 0x00, 0x00, 0x01,
+		}, s.Encode(testServer, testClient)...)
+		a.Packet(ipv4.Parse(b), time.Now())
+		if got := r.data[ClientToServer].String(); got != tt.data {
+			t.Errorf("data of packet with %x = %q, but got %q\n", tt.frag, tt.data, got)
+		}
+	}
+}
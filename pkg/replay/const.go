@@ -0,0 +1,16 @@
+package replay
+
+const (
+	// DefaultSpeed is the default multiplier of the original timing.
+	DefaultSpeed = 1.0
+	// DefaultLoops is the default number of times to replay frames.
+	DefaultLoops = 1
+
+	// MaxVLAN is the maximum VLAN ID.
+	MaxVLAN = 4094
+)
+
+const (
+	// offset of the original datagram in ICMP error messages
+	icmpQuoteOffset = 8
+)
@@ -0,0 +1,230 @@
+package replay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of Player.
+// Timing is chosen in the order of TopSpeed, Rate and Speed.
+type Config struct {
+	Interface string
+	Speed     float64   // multiplier of the original timing. zero means DefaultSpeed
+	Rate      float64   // frames per second. zero means the original timing is used
+	TopSpeed  bool      // send frames as fast as possible
+	Loops     int       // number of times to replay frames. zero means forever
+	Rewriter  *Rewriter // if nil, frames are sent as they are
+
+	// Batch is the number of frames sent at once through ethernet.Transmitter
+	// when TopSpeed is set. zero means frames are sent one by one.
+	Batch       int
+	QdiscBypass bool // with Batch, pass frames to the driver directly
+}
+
+// Frame is a frame to be replayed.
+type Frame struct {
+	Time time.Time
+	Data []byte
+}
+
+// Stats represents the counters of Player.
+type Stats struct {
+	Frames uint64 // successfully sent frames
+	Bytes  uint64
+	Failed uint64 // frames which could not be sent, such as longer than MTU
+	Loops  int    // completed loops
+}
+
+// Player sends frames through the socket with the timing of the capture.
+type Player struct {
+	cfg  Config
+	sock *ethernet.Socket
+	tx   *ethernet.Transmitter // nil unless frames are sent in batches
+	done chan struct{}
+	once sync.Once
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New returns new Player instance.
+func New(cfg Config) (*Player, error) {
+	if cfg.Speed < 0 || cfg.Rate < 0 || cfg.Loops < 0 || cfg.Batch < 0 {
+		return nil, errors.New("speed, rate, loops and batch must not be negative")
+	}
+	if cfg.Speed == 0 {
+		cfg.Speed = DefaultSpeed
+	}
+	if rw := cfg.Rewriter; rw != nil && (rw.VLAN < 0 || rw.VLAN > MaxVLAN || rw.Priority > 7) {
+		return nil, errors.Errorf("invalid VLAN %d priority %d", rw.VLAN, rw.Priority)
+	}
+
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeAll)
+	if err != nil {
+		return nil, err
+	}
+	var tx *ethernet.Transmitter
+	if cfg.TopSpeed && cfg.Batch > 0 {
+		if tx, err = ethernet.NewTransmitter(sock, ethernet.TxConfig{QdiscBypass: cfg.QdiscBypass}); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+	return &Player{
+		cfg:  cfg,
+		sock: sock,
+		tx:   tx,
+		done: make(chan struct{}),
+	}, nil
+}
+
+// RingError returns the reason why batches are sent with sendmmsg instead of the transmit ring.
+// It is nil if the ring is used or frames are not sent in batches.
+func (p *Player) RingError() error {
+	if p.tx == nil {
+		return nil
+	}
+	return p.tx.RingError()
+}
+
+// Play sends frames Loops times. It returns nil when all loops are finished or Close is called.
+// Frames which could not be sent are counted in Stats and skipped.
+func (p *Player) Play(frames []Frame) error {
+	offsets := schedule(frames, p.cfg)
+	start := time.Now()
+	for loop := 0; p.cfg.Loops == 0 || loop < p.cfg.Loops; loop++ {
+		if loop > 0 {
+			if p.cfg.Rate > 0 { // keep the rate across loops
+				start = start.Add(time.Duration(float64(len(frames)) / p.cfg.Rate * float64(time.Second)))
+			} else {
+				start = time.Now()
+			}
+		}
+		if p.tx != nil {
+			if stopped := p.sendBatches(frames); stopped {
+				return nil
+			}
+		} else {
+			for i, f := range frames {
+				if wait := time.Until(start.Add(offsets[i])); wait > 0 {
+					select {
+					case <-time.After(wait):
+					case <-p.done:
+						return nil
+					}
+				}
+				data := f.Data
+				if p.cfg.Rewriter != nil {
+					data = p.cfg.Rewriter.Rewrite(data)
+				}
+				if stopped := p.send(data); stopped {
+					return nil
+				}
+			}
+		}
+		p.mu.Lock()
+		p.stats.Loops++
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// send sends frame and reports whether Player is closed.
+func (p *Player) send(frame []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return true
+	default:
+	}
+	if err := p.sock.SendFrame(frame, 0); err != nil {
+		p.stats.Failed++
+		return false
+	}
+	p.stats.Frames++
+	p.stats.Bytes += uint64(len(frame))
+	return false
+}
+
+// sendBatches sends frames in batches through the transmitter and reports whether Player is closed.
+func (p *Player) sendBatches(frames []Frame) bool {
+	batch := make([][]byte, 0, p.cfg.Batch)
+	for i := 0; i < len(frames); i += p.cfg.Batch {
+		end := i + p.cfg.Batch
+		if end > len(frames) {
+			end = len(frames)
+		}
+		batch = batch[:0]
+		for _, f := range frames[i:end] {
+			data := f.Data
+			if p.cfg.Rewriter != nil {
+				data = p.cfg.Rewriter.Rewrite(data)
+			}
+			batch = append(batch, data)
+		}
+		for len(batch) > 0 {
+			select {
+			case <-p.done:
+				return true
+			default:
+			}
+			n, err := p.tx.SendBatch(batch)
+			p.mu.Lock()
+			p.stats.Frames += uint64(n)
+			for _, b := range batch[:n] {
+				p.stats.Bytes += uint64(len(b))
+			}
+			if err != nil && n < len(batch) { // skip the frame which could not be sent
+				p.stats.Failed++
+				n++
+			}
+			p.mu.Unlock()
+			if errors.Cause(err) == ethernet.ErrClosed {
+				return true
+			}
+			batch = batch[n:]
+		}
+	}
+	return false
+}
+
+// Close stops Play and closes the socket.
+func (p *Player) Close() error {
+	p.once.Do(func() { close(p.done) })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tx != nil {
+		return p.tx.Close()
+	}
+	return p.sock.Close()
+}
+
+// Stats returns the copy of current counters.
+func (p *Player) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// schedule returns the time to send each frame relative to the start of a loop.
+func schedule(frames []Frame, cfg Config) []time.Duration {
+	offsets := make([]time.Duration, len(frames))
+	if cfg.TopSpeed || len(frames) == 0 {
+		return offsets
+	}
+	for i := range frames {
+		if cfg.Rate > 0 {
+			offsets[i] = time.Duration(float64(i) / cfg.Rate * float64(time.Second))
+			continue
+		}
+		offsets[i] = time.Duration(float64(frames[i].Time.Sub(frames[0].Time)) / cfg.Speed)
+		if i > 0 && offsets[i] < offsets[i-1] { // frames whose timestamp goes backwards are sent immediately
+			offsets[i] = offsets[i-1]
+		}
+	}
+	return offsets
+}
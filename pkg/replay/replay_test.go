@@ -0,0 +1,228 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/mas9612/nwspeaker/pkg/udp"
+)
+
+func mustIPMap(s string) IPMap {
+	m, err := ParseIPMap(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func TestRewriteIPv4(t *testing.T) {
+	r := &Rewriter{IPMap: []IPMap{mustIPMap("10.0.0.0/24=192.168.0.0/24"), mustIPMap("10.0.1.2=172.16.0.9")}}
+	wantSrc, wantDst := net.IPv4(192, 168, 0, 1), net.IPv4(172, 16, 0, 9)
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{
+			name: "tcp",
+			frame: []byte{
+				0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x00, 0x40, 0x06, 0x65, 0xce, 0x0a, 0x00, 0x00, 0x01,
+				0x0a, 0x00, 0x01, 0x02, 0x04, 0xd2, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+				0x50, 0x02, 0x04, 0x00, 0x91, 0xbd, 0x00, 0x00,
+			},
+		},
+		{
+			name: "udp",
+			frame: []byte{
+				0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x21, 0x00, 0x00, 0x00, 0x00, 0x40, 0x11, 0x65, 0xca, 0x0a, 0x00, 0x00, 0x01,
+				0x0a, 0x00, 0x01, 0x02, 0x00, 0x35, 0x14, 0xe9, 0x00, 0x0d, 0x85, 0xcb, 0x71, 0x75, 0x65, 0x72,
+				0x79,
+			},
+		},
+		{
+			name: "icmp",
+			frame: []byte{
+				0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+				0x45, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x00, 0x00, 0x40, 0x01, 0x65, 0xdf, 0x0a, 0x00, 0x00, 0x01,
+				0x0a, 0x00, 0x01, 0x02, 0x08, 0x00, 0xf7, 0xfc, 0x00, 0x01, 0x00, 0x02,
+			},
+		},
+	}
+	for _, tt := range tests {
+		orig := append([]byte{}, tt.frame...)
+		out := r.Rewrite(tt.frame)
+		if !bytes.Equal(tt.frame, orig) {
+			t.Errorf("%s: Rewrite() must not modify the given frame\n", tt.name)
+		}
+		pkt := ipv4.Parse(out[ethernet.HeaderLen:])
+		if !pkt.SrcAddress.Equal(wantSrc) || !pkt.DstAddress.Equal(wantDst) {
+			t.Errorf("%s: addresses = %s > %s, but got %s > %s\n", tt.name, wantSrc, wantDst, pkt.SrcAddress, pkt.DstAddress)
+		}
+		if !bytes.Equal(ipv4ChecksumOf(out[ethernet.HeaderLen:ethernet.HeaderLen+ipv4.HeaderLen]), []byte{0, 0}) {
+			t.Errorf("%s: IPv4 header checksum is invalid\n", tt.name)
+		}
+		var ok bool
+		switch pkt.Protocol {
+		case ipv4.ProtoTCP:
+			ok = tcp.Parse(pkt.Data).VerifyChecksum(pkt.SrcAddress, pkt.DstAddress)
+		case ipv4.ProtoUDP:
+			ok = udp.Parse(pkt.Data).VerifyChecksum(pkt.SrcAddress, pkt.DstAddress)
+		case ipv4.ProtoICMP:
+			ok = bytes.Equal(ipv4ChecksumOf(pkt.Data), []byte{0, 0})
+		}
+		if !ok {
+			t.Errorf("%s: checksum is invalid after rewrite\n", tt.name)
+		}
+	}
+}
+
+// ipv4ChecksumOf returns zero when the checksum in b is valid.
+func ipv4ChecksumOf(b []byte) []byte {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	out := make([]byte, 2)
+	binary.BigEndian.PutUint16(out, ^uint16(sum))
+	return out
+}
+
+func TestRewriteICMPError(t *testing.T) {
+	// Time Exceeded quoting the UDP packet from 10.0.0.1 to 10.0.1.2
+	frame := []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x38, 0x00, 0x00, 0x00, 0x00, 0x40, 0x01, 0x65, 0xc3, 0x0a, 0x00, 0x00, 0x01,
+		0x0a, 0x00, 0x01, 0x02, 0x0b, 0x00, 0x0a, 0x1c, 0x00, 0x00, 0x00, 0x00, 0x45, 0x00, 0x00, 0x1c,
+		0x00, 0x00, 0x00, 0x00, 0x40, 0x11, 0x65, 0xcf, 0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x01, 0x02,
+		0x9c, 0x40, 0x82, 0x9a, 0x00, 0x08, 0xcc, 0x00,
+	}
+
+	r := &Rewriter{IPMap: []IPMap{mustIPMap("10.0.0.0/16=172.16.0.0/16")}}
+	pkt := ipv4.Parse(r.Rewrite(frame)[ethernet.HeaderLen:])
+	quote := ipv4.Parse(pkt.Data[icmpQuoteOffset:])
+	if !quote.SrcAddress.Equal(net.IPv4(172, 16, 0, 1)) || !quote.DstAddress.Equal(net.IPv4(172, 16, 1, 2)) {
+		t.Errorf("quoted addresses are not rewritten: %s > %s\n", quote.SrcAddress, quote.DstAddress)
+	}
+	if !bytes.Equal(ipv4ChecksumOf(pkt.Data), []byte{0, 0}) {
+		t.Errorf("ICMP checksum is invalid after rewrite\n")
+	}
+}
+
+func TestRewriteEthernet(t *testing.T) {
+	srcMac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	dstMac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	newMac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x99}
+	arp := ethernet.Frame(dstMac, srcMac, ethernet.TypeARP, []byte{0xaa, 0xbb})
+	tagged := []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x81, 0x00, 0x20, 0x0a, 0x08, 0x06, 0xaa, 0xbb,
+	}
+	tests := []struct {
+		name string
+		r    *Rewriter
+		in   []byte
+		out  []byte
+	}{
+		{
+			name: "mac map",
+			r:    &Rewriter{MACMap: []MACMap{{From: srcMac, To: newMac}}},
+			in:   arp,
+			out:  ethernet.Frame(dstMac, newMac, ethernet.TypeARP, []byte{0xaa, 0xbb}),
+		},
+		{
+			name: "dst mac",
+			r:    &Rewriter{DstMac: newMac, MACMap: []MACMap{{From: newMac, To: srcMac}}},
+			in:   arp,
+			out:  ethernet.Frame(newMac, srcMac, ethernet.TypeARP, []byte{0xaa, 0xbb}),
+		},
+		{
+			name: "add vlan",
+			r:    &Rewriter{VLAN: 10, Priority: 1},
+			in:   arp,
+			out:  tagged,
+		},
+		{
+			name: "replace vlan",
+			r:    &Rewriter{VLAN: 0xfff - 1},
+			in:   tagged,
+			out: []byte{
+				0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01,
+				0x81, 0x00, 0x0f, 0xfe, 0x08, 0x06, 0xaa, 0xbb,
+			},
+		},
+		{
+			name: "strip vlan",
+			r:    &Rewriter{StripVLAN: true},
+			in:   tagged,
+			out:  arp,
+		},
+	}
+	for _, tt := range tests {
+		out := tt.r.Rewrite(tt.in)
+		if !bytes.Equal(out, tt.out) {
+			t.Errorf("%s: Rewrite() = %x, but got %x\n", tt.name, tt.out, out)
+		}
+	}
+}
+
+var parseIPMapTests = []struct {
+	in   string
+	from string
+	to   string
+	ok   bool
+}{
+	{"10.0.0.0/8=192.168.0.0/8", "10.0.0.0/8", "192.0.0.0/8", true},
+	{"10.0.0.1=10.0.0.2", "10.0.0.1/32", "10.0.0.2/32", true},
+	{"10.0.0.0/24=192.168.0.0/16", "", "", false},
+	{"10.0.0.0/24", "", "", false},
+	{"2001:db8::/32=2001:db9::/32", "", "", false},
+}
+
+func TestParseIPMap(t *testing.T) {
+	for _, tt := range parseIPMapTests {
+		m, err := ParseIPMap(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseIPMap(%s) returns unexpected error: %v\n", tt.in, err)
+			continue
+		}
+		if tt.ok && (m.From.String() != tt.from || m.To.String() != tt.to) {
+			t.Errorf("ParseIPMap(%s) = %s=%s, but got %s=%s\n", tt.in, tt.from, tt.to, m.From, m.To)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	base := time.Unix(1600000000, 0)
+	frames := []Frame{
+		{Time: base},
+		{Time: base.Add(100 * time.Millisecond)},
+		{Time: base.Add(50 * time.Millisecond)}, // goes backwards
+		{Time: base.Add(time.Second)},
+	}
+	tests := []struct {
+		cfg Config
+		out []time.Duration
+	}{
+		{Config{Speed: 1}, []time.Duration{0, 100 * time.Millisecond, 100 * time.Millisecond, time.Second}},
+		{Config{Speed: 2}, []time.Duration{0, 50 * time.Millisecond, 50 * time.Millisecond, 500 * time.Millisecond}},
+		{Config{Speed: 1, Rate: 10}, []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}},
+		{Config{Speed: 1, Rate: 10, TopSpeed: true}, []time.Duration{0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		out := schedule(frames, tt.cfg)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("schedule(%+v) = %v, but got %v\n", tt.cfg, tt.out, out)
+		}
+	}
+}
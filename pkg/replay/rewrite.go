@@ -0,0 +1,256 @@
+package replay
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/mas9612/nwspeaker/pkg/checksum"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/mas9612/nwspeaker/pkg/udp"
+	"github.com/pkg/errors"
+)
+
+// MACMap replaces the MAC address From with To.
+type MACMap struct {
+	From net.HardwareAddr
+	To   net.HardwareAddr
+}
+
+// IPMap replaces the network part of addresses in From with To.
+// The host part is kept, so From and To must have the same prefix length.
+type IPMap struct {
+	From *net.IPNet
+	To   *net.IPNet
+}
+
+// Map returns the mapped address of ip, or nil if ip is not in From.
+func (m *IPMap) Map(ip net.IP) net.IP {
+	ip = ip.To4()
+	if ip == nil || !m.From.Contains(ip) {
+		return nil
+	}
+	to := m.To.IP.To4()
+	mapped := make(net.IP, net.IPv4len)
+	for i := range mapped {
+		mapped[i] = to[i]&m.To.Mask[i] | ip[i]&^m.To.Mask[i]
+	}
+	return mapped
+}
+
+// Rewriter rewrites frames before they are replayed.
+// Checksums of IPv4, ICMP, UDP and TCP are recomputed when addresses are rewritten.
+type Rewriter struct {
+	SrcMac       net.HardwareAddr // if set, all source MAC addresses are replaced
+	DstMac       net.HardwareAddr // if set, all destination MAC addresses are replaced
+	MACMap       []MACMap         // applied to both source and destination. the first match wins.
+	IPMap        []IPMap          // applied to both source and destination. the first match wins.
+	VLAN         int              // if not zero, frames are tagged with this VLAN ID
+	Priority     uint8            // priority code point used with VLAN
+	StripVLAN    bool             // remove VLAN tag
+	FixChecksums bool             // recompute checksums of all IPv4 packets even if not rewritten
+}
+
+// Rewrite returns the rewritten copy of frame. frame itself is not modified.
+func (r *Rewriter) Rewrite(frame []byte) []byte {
+	out := make([]byte, len(frame))
+	copy(out, frame)
+	if len(out) < ethernet.HeaderLen {
+		return out
+	}
+
+	r.rewriteMAC(out[0:ethernet.EtherLen], r.DstMac)
+	r.rewriteMAC(out[ethernet.EtherLen:ethernet.EtherLen*2], r.SrcMac)
+
+	typeOffset := ethernet.EtherLen * 2
+	tagged := len(out) >= ethernet.HeaderLen+ethernet.VLANTagLen &&
+		binary.BigEndian.Uint16(out[typeOffset:]) == ethernet.TypeVLAN
+	switch {
+	case r.StripVLAN && tagged:
+		out = append(out[:typeOffset], out[typeOffset+ethernet.VLANTagLen:]...)
+		tagged = false
+	case r.VLAN != 0 && tagged:
+		tci := uint16(r.Priority)<<13 | binary.BigEndian.Uint16(out[typeOffset+2:])&0x1000 | uint16(r.VLAN)
+		binary.BigEndian.PutUint16(out[typeOffset+2:], tci)
+	case r.VLAN != 0:
+		tag := make([]byte, ethernet.VLANTagLen)
+		binary.BigEndian.PutUint16(tag, ethernet.TypeVLAN)
+		binary.BigEndian.PutUint16(tag[2:], uint16(r.Priority)<<13|uint16(r.VLAN))
+		out = append(out[:typeOffset], append(tag, out[typeOffset:]...)...)
+		tagged = true
+	}
+
+	hdrLen := ethernet.HeaderLen
+	if tagged {
+		hdrLen += ethernet.VLANTagLen
+	}
+	if len(out) >= hdrLen && binary.BigEndian.Uint16(out[hdrLen-2:]) == ethernet.TypeIPv4 {
+		r.rewriteIPv4(out[hdrLen:])
+	}
+	return out
+}
+
+func (r *Rewriter) rewriteMAC(b []byte, replace net.HardwareAddr) {
+	if replace != nil {
+		copy(b, replace)
+		return
+	}
+	for _, m := range r.MACMap {
+		if m.From.String() == net.HardwareAddr(b).String() {
+			copy(b, m.To)
+			return
+		}
+	}
+}
+
+// rewriteIP replaces the address in b according to IPMap and reports whether it is changed.
+func (r *Rewriter) rewriteIP(b []byte) bool {
+	for _, m := range r.IPMap {
+		if mapped := m.Map(net.IP(b)); mapped != nil {
+			changed := !mapped.Equal(net.IP(b))
+			copy(b, mapped)
+			return changed
+		}
+	}
+	return false
+}
+
+// rewriteIPv4 rewrites addresses of IPv4 packet b in place and recomputes checksums.
+// Upper layer checksums are not recomputed for fragments and truncated packets,
+// because they cover data which is not in b.
+func (r *Rewriter) rewriteIPv4(b []byte) {
+	if len(b) < ipv4.HeaderLen || b[0]>>4 != ipv4.Version4 {
+		return
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if ihl < ipv4.HeaderLen || len(b) < ihl {
+		return
+	}
+	changed := r.rewriteIP(b[12:16])
+	changed = r.rewriteIP(b[16:20]) || changed
+	if !changed && !r.FixChecksums {
+		return
+	}
+	setChecksum(b[10:12], b[:ihl])
+
+	total := int(binary.BigEndian.Uint16(b[2:]))
+	fragmented := binary.BigEndian.Uint16(b[6:])&0x3fff != 0 // More Fragments flag or fragment offset
+	if total < ihl || total > len(b) || fragmented {
+		return
+	}
+	src, dst := net.IP(b[12:16]), net.IP(b[16:20])
+	data := b[ihl:total]
+	switch b[9] {
+	case ipv4.ProtoTCP:
+		if len(data) >= tcp.HeaderLen {
+			data[16], data[17] = 0, 0
+			copy(data[16:], tcp.Checksum(src, dst, data))
+		}
+	case ipv4.ProtoUDP:
+		if len(data) < udp.HeaderLen || binary.BigEndian.Uint16(data[6:]) == 0 { // checksum is not used
+			return
+		}
+		data[6], data[7] = 0, 0
+		copy(data[6:], udp.Checksum(src, dst, data))
+		if binary.BigEndian.Uint16(data[6:]) == 0 {
+			binary.BigEndian.PutUint16(data[6:], 0xffff)
+		}
+	case ipv4.ProtoICMP:
+		if len(data) < icmp.HeaderLen {
+			return
+		}
+		if isICMPError(data[0]) && len(data) >= icmpQuoteOffset+ipv4.HeaderLen {
+			r.rewriteQuote(data[icmpQuoteOffset:])
+		}
+		setChecksum(data[2:4], data)
+	}
+}
+
+// rewriteQuote rewrites addresses of the original datagram quoted in ICMP error message.
+func (r *Rewriter) rewriteQuote(b []byte) {
+	ihl := int(b[0]&0x0f) * 4
+	if b[0]>>4 != ipv4.Version4 || ihl < ipv4.HeaderLen || len(b) < ihl {
+		return
+	}
+	changed := r.rewriteIP(b[12:16])
+	if r.rewriteIP(b[16:20]) || changed {
+		setChecksum(b[10:12], b[:ihl])
+	}
+}
+
+// setChecksum stores the checksum of data into field. field must be the part of data.
+func setChecksum(field, data []byte) {
+	field[0], field[1] = 0, 0
+	copy(field, checksum.SumOfOnesComplement16(data))
+}
+
+func isICMPError(t uint8) bool {
+	switch t {
+	case icmp.TypeDestinationUnreachable, icmp.TypeSourceQuench, icmp.TypeRedirect,
+		icmp.TypeTimeExceeded, icmp.TypeParameterProblem:
+		return true
+	}
+	return false
+}
+
+// ParseMACMap parses the rule like "00:11:22:33:44:55=66:77:88:99:aa:bb".
+func ParseMACMap(s string) (MACMap, error) {
+	fields := strings.Split(s, "=")
+	if len(fields) != 2 {
+		return MACMap{}, errors.Errorf("invalid MAC map '%s'", s)
+	}
+	from, err := net.ParseMAC(fields[0])
+	if err != nil {
+		return MACMap{}, errors.Wrapf(err, "invalid MAC map '%s'", s)
+	}
+	to, err := net.ParseMAC(fields[1])
+	if err != nil {
+		return MACMap{}, errors.Wrapf(err, "invalid MAC map '%s'", s)
+	}
+	if len(from) != ethernet.EtherLen || len(to) != ethernet.EtherLen {
+		return MACMap{}, errors.Errorf("invalid MAC map '%s'", s)
+	}
+	return MACMap{From: from, To: to}, nil
+}
+
+// ParseIPMap parses the rule like "10.0.0.0/24=192.168.1.0/24" or "10.0.0.1=10.0.0.2".
+// Both sides must have the same prefix length.
+func ParseIPMap(s string) (IPMap, error) {
+	fields := strings.Split(s, "=")
+	if len(fields) != 2 {
+		return IPMap{}, errors.Errorf("invalid IP map '%s'", s)
+	}
+	from, err := parsePrefix(fields[0])
+	if err != nil {
+		return IPMap{}, errors.Wrapf(err, "invalid IP map '%s'", s)
+	}
+	to, err := parsePrefix(fields[1])
+	if err != nil {
+		return IPMap{}, errors.Wrapf(err, "invalid IP map '%s'", s)
+	}
+	fromLen, _ := from.Mask.Size()
+	toLen, _ := to.Mask.Size()
+	if fromLen != toLen {
+		return IPMap{}, errors.Errorf("invalid IP map '%s': prefix lengths differ", s)
+	}
+	return IPMap{From: from, To: to}, nil
+}
+
+// parsePrefix parses IPv4 prefix. An address without prefix length is treated as /32.
+func parsePrefix(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		s += "/32"
+	}
+	ip, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, errors.Errorf("'%s' is not an IPv4 prefix", s)
+	}
+	prefix.IP = prefix.IP.To4()
+	return prefix, nil
+}
@@ -0,0 +1,8 @@
+package responder
+
+import "time"
+
+const (
+	// interval to check whether Responder is closed
+	pollInterval = 500 * time.Millisecond
+)
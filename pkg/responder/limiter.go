@@ -0,0 +1,37 @@
+package responder
+
+import "time"
+
+// limiter is a token bucket which allows rate events per second.
+type limiter struct {
+	rate   int
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns new limiter. If rate is zero, all events are allowed.
+func newLimiter(rate int) *limiter {
+	return &limiter{
+		rate:   rate,
+		tokens: float64(rate),
+	}
+}
+
+// allow reports whether an event may happen at now.
+func (l *limiter) allow(now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
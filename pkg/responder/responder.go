@@ -0,0 +1,275 @@
+package responder
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/arp"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of Responder.
+type Config struct {
+	Interface string
+	Addrs     []net.IP         // addresses answered by Responder
+	MAC       net.HardwareAddr // if nil, MAC address of Interface is used. Other addresses enable promiscuous mode.
+	Delay     time.Duration    // delay before sending Echo Reply
+	Loss      float64          // probability to drop Echo Request. 0 to 1.
+	Rate      int              // maximum number of Echo Reply per second. zero means unlimited.
+}
+
+// Stats represents the counters of Responder.
+type Stats struct {
+	ARPReplies  uint64
+	EchoReplies uint64
+	Lost        uint64 // Echo Request dropped by Loss
+	RateLimited uint64 // Echo Request dropped by Rate
+	SendErrors  uint64 // replies which could not be sent
+}
+
+// Responder answers ARP Request and ICMP Echo Request sent to configured addresses.
+// Loss, Delay and Rate are only applied to ICMP so that ARP resolution always succeeds.
+type Responder struct {
+	cfg     Config
+	sock    *ethernet.Socket
+	rbuf    []byte // receive buffer large enough for a frame of Interface MTU
+	limiter *limiter
+	done    chan struct{}
+	once    sync.Once
+	closeMu sync.Mutex // orders wg.Add for delayed replies and close of done in Close
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New returns new Responder instance.
+func New(cfg Config) (*Responder, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("no address to answer")
+	}
+	for _, a := range cfg.Addrs {
+		if a.To4() == nil {
+			return nil, errors.Errorf("'%s' is not an IPv4 address", a)
+		}
+	}
+	if cfg.Loss < 0 || cfg.Loss > 1 {
+		return nil, errors.Errorf("invalid loss probability %f", cfg.Loss)
+	}
+
+	oif, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface information")
+	}
+	if cfg.MAC == nil {
+		cfg.MAC = oif.HardwareAddr
+	}
+
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeAll)
+	if err != nil {
+		return nil, err
+	}
+	// frames sent to the emulated MAC address are dropped by the NIC otherwise
+	if !bytes.Equal(cfg.MAC, oif.HardwareAddr) {
+		if err := sock.SetPromiscuous(true); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	return &Responder{
+		cfg:     cfg,
+		sock:    sock,
+		rbuf:    make([]byte, oif.MTU+ethernet.HeaderLen+ethernet.VLANTagLen),
+		limiter: newLimiter(cfg.Rate),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Serve receives frames and answers them until Close is called.
+func (r *Responder) Serve() error {
+	for {
+		select {
+		case <-r.done:
+			return nil
+		default:
+		}
+
+		if err := r.sock.SetRecvTimeout(pollInterval); err != nil {
+			return err
+		}
+		n, _, err := r.sock.RecvFrom(r.rbuf, 0)
+		if err != nil {
+			if ethernet.IsTimeout(err) {
+				continue
+			}
+			if errors.Cause(err) == ethernet.ErrClosed { // Close was called
+				return nil
+			}
+			return err
+		}
+		r.handle(r.rbuf[:n])
+	}
+}
+
+// Close stops Serve and closes the socket. Delayed replies not sent yet are dropped.
+// Calling Close more than once returns nil.
+func (r *Responder) Close() error {
+	var err error
+	r.once.Do(func() {
+		r.closeMu.Lock()
+		close(r.done)
+		r.closeMu.Unlock()
+		r.wg.Wait()
+		err = r.sock.Close()
+	})
+	return err
+}
+
+// Stats returns the copy of current counters.
+func (r *Responder) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// handle answers frame. Errors on sending are counted in Stats, so that
+// a transient failure does not stop Serve.
+func (r *Responder) handle(frame []byte) {
+	if len(frame) < ethernet.HeaderLen {
+		return
+	}
+	hdr := ethernet.Parse(frame)
+	if hdr.SrcAddr.String() == r.cfg.MAC.String() { // sent by ourselves
+		return
+	}
+
+	var reply []byte
+	switch hdr.EtherType {
+	case ethernet.TypeARP:
+		reply = r.arpReply(hdr, frame)
+		if reply != nil {
+			r.mu.Lock()
+			r.stats.ARPReplies++
+			r.mu.Unlock()
+		}
+	case ethernet.TypeIPv4:
+		reply = r.echoReply(hdr, frame)
+		if reply == nil {
+			return
+		}
+		if r.cfg.Loss > 0 && rand.Float64() < r.cfg.Loss {
+			r.mu.Lock()
+			r.stats.Lost++
+			r.mu.Unlock()
+			return
+		}
+		if !r.limiter.allow(time.Now()) {
+			r.mu.Lock()
+			r.stats.RateLimited++
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Lock()
+		r.stats.EchoReplies++
+		r.mu.Unlock()
+		if r.cfg.Delay > 0 {
+			r.closeMu.Lock()
+			defer r.closeMu.Unlock()
+			select {
+			case <-r.done: // Close was called
+				return
+			default:
+			}
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				select {
+				case <-time.After(r.cfg.Delay):
+					r.send(reply)
+				case <-r.done:
+				}
+			}()
+			return
+		}
+	}
+	if reply == nil {
+		return
+	}
+	r.send(reply)
+}
+
+// send sends reply and counts the failure.
+func (r *Responder) send(reply []byte) {
+	if err := r.sock.SendFrame(reply, 0); err != nil {
+		r.mu.Lock()
+		r.stats.SendErrors++
+		r.mu.Unlock()
+	}
+}
+
+// owns reports whether ip is one of the configured addresses.
+func (r *Responder) owns(ip net.IP) bool {
+	for _, a := range r.cfg.Addrs {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// arpReply returns the frame of ARP Reply if frame is ARP Request to our address.
+func (r *Responder) arpReply(hdr *ethernet.Header, frame []byte) []byte {
+	req := arp.Parse(frame)
+	if req == nil || req.Op != arp.OpRequest || !r.owns(req.DstPAddr) {
+		return nil
+	}
+	res := &arp.Packet{
+		HType:    arp.HardwareTypeEthernet,
+		PType:    arp.ProtocolTypeIPv4,
+		HLen:     ethernet.EtherLen,
+		PLen:     net.IPv4len,
+		Op:       arp.OpReply,
+		SrcHAddr: r.cfg.MAC,
+		SrcPAddr: req.DstPAddr,
+		DstHAddr: req.SrcHAddr,
+		DstPAddr: req.SrcPAddr,
+	}
+	return ethernet.Frame(hdr.SrcAddr, r.cfg.MAC, ethernet.TypeARP, res.Encode())
+}
+
+// echoReply returns the frame of ICMP Echo Reply if frame is Echo Request to our address.
+func (r *Responder) echoReply(hdr *ethernet.Header, frame []byte) []byte {
+	pkt := ipv4.Parse(frame[ethernet.HeaderLen:])
+	if pkt == nil || pkt.Protocol != ipv4.ProtoICMP || !r.owns(pkt.DstAddress) {
+		return nil
+	}
+	req := icmp.Parse(pkt.Data)
+	if req == nil || req.Type != icmp.TypeEcho {
+		return nil
+	}
+	echo, ok := req.Data.(*icmp.Echo)
+	if !ok {
+		return nil
+	}
+
+	msg := &icmp.Message{
+		Type: icmp.TypeEchoReply,
+		Data: &icmp.Echo{
+			Identifier:     echo.Identifier,
+			SequenceNumber: echo.SequenceNumber,
+			Data:           echo.Data,
+		},
+	}
+	data := msg.Encode()
+	res := ipv4.NewPacket(pkt.DstAddress, pkt.SrcAddress, ipv4.ProtoICMP, data,
+		ipv4.SetIdentification(uint16(rand.Uint32())))
+	return ethernet.Frame(hdr.SrcAddr, r.cfg.MAC, ethernet.TypeIPv4, res.Encode())
+}
@@ -0,0 +1,117 @@
+package responder
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+)
+
+var testResponder = &Responder{
+	cfg: Config{
+		Addrs: []net.IP{net.IPv4(192, 168, 0, 1)},
+		MAC:   net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66},
+	},
+}
+
+var arpReplyTests = []struct {
+	in  []byte
+	out []byte
+}{
+	{
+		// request for 192.168.0.1 from aa:bb:cc:dd:ee:ff (192.168.0.2)
+		in: []byte{
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x08, 0x06,
+			0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0xc0, 0xa8,
+			0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01,
+		},
+		out: []byte{
+			0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x08, 0x06,
+			0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x02, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0xc0, 0xa8,
+			0x00, 0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0xc0, 0xa8, 0x00, 0x02,
+		},
+	},
+	{
+		// request for other address
+		in: []byte{
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x08, 0x06,
+			0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0xc0, 0xa8,
+			0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x03,
+		},
+		out: nil,
+	},
+}
+
+func TestARPReply(t *testing.T) {
+	for _, tt := range arpReplyTests {
+		b := testResponder.arpReply(ethernet.Parse(tt.in), tt.in)
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("arpReply(%x) = %x, but got %x\n", tt.in, tt.out, b)
+		}
+	}
+}
+
+func TestEchoReply(t *testing.T) {
+	in := []byte{
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x1e, 0x00, 0x00, 0x00, 0x00, 0x40, 0x01, 0xf9, 0x7b, 0xc0, 0xa8, 0x00, 0x02,
+		0xc0, 0xa8, 0x00, 0x01, 0x08, 0x00, 0xc8, 0x5e, 0x2f, 0xa1, 0x00, 0x00, 0xff, 0xff,
+	}
+	b := testResponder.echoReply(ethernet.Parse(in), in)
+	if b == nil {
+		t.Fatalf("echoReply(%x) should return Echo Reply, but got nil\n", in)
+	}
+	// compare except Identification and checksum of IPv4 header
+	want := []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x08, 0x00}
+	if !bytes.Equal(b[:ethernet.HeaderLen], want) {
+		t.Errorf("ethernet header = %x, but got %x\n", want, b[:ethernet.HeaderLen])
+	}
+	want = []byte{0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0x02, 0x00, 0x00, 0xd0, 0x5e, 0x2f, 0xa1, 0x00, 0x00, 0xff, 0xff}
+	if !bytes.Equal(b[26:], want) {
+		t.Errorf("echoReply(%x)[26:] = %x, but got %x\n", in, want, b[26:])
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2)
+	now := time.Now()
+	results := []bool{
+		l.allow(now),
+		l.allow(now),
+		l.allow(now),
+		l.allow(now.Add(500 * time.Millisecond)),
+	}
+	want := []bool{true, true, false, true}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("allow() #%d = %v, but got %v\n", i, want[i], results[i])
+		}
+	}
+}
+
+func TestSendError(t *testing.T) {
+	sock, err := ethernet.Listen("lo", ethernet.TypeAll)
+	if err != nil {
+		t.Skipf("socket on lo is not available: %v", err)
+	}
+	r := &Responder{
+		cfg:     testResponder.cfg,
+		sock:    sock,
+		limiter: newLimiter(0),
+		done:    make(chan struct{}),
+	}
+	sock.Close() // every reply fails to be sent
+
+	r.handle(arpReplyTests[0].in)
+	r.handle(arpReplyTests[0].in)
+	if s := r.Stats(); s.ARPReplies != 2 || s.SendErrors != 2 {
+		t.Errorf("ARPReplies, SendErrors = 2, 2, but got %d, %d\n", s.ARPReplies, s.SendErrors)
+	}
+
+	r.Close()
+	if err := r.Close(); err != nil {
+		t.Errorf("second Close() returns error: %v\n", err)
+	}
+}
@@ -0,0 +1,25 @@
+package synscan
+
+import "time"
+
+const (
+	// StateOpen means SYN/ACK was received.
+	StateOpen = "open"
+	// StateClosed means RST was received.
+	StateClosed = "closed"
+	// StateFiltered means ICMP Destination Unreachable was received or no response.
+	StateFiltered = "filtered"
+
+	// DefaultRate is the default number of SYN sent per second.
+	DefaultRate = 100
+	// DefaultRetries is the default number of retransmissions to ports without response.
+	DefaultRetries = 1
+	// DefaultTimeout is the default time to wait for responses after the last SYN of each round.
+	DefaultTimeout = 2 * time.Second
+	// DefaultSrcPort is the default source port of SYN.
+	DefaultSrcPort = 40000
+)
+
+const (
+	synWindow = 1024
+)
@@ -0,0 +1,333 @@
+package synscan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/iface"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of Scanner.
+type Config struct {
+	Interface string
+	Dst       net.IP
+	DstMac    net.HardwareAddr // MAC address of the next hop
+	SrcIP     net.IP           // if nil, the address of Interface is used
+	SrcPort   uint16
+	Ports     []uint16
+	Rate      int // SYN per second
+	Retries   int // number of retransmissions to ports without response
+	Timeout   time.Duration
+}
+
+// Result represents the state of a port.
+type Result struct {
+	Port   uint16        `json:"port"`
+	State  string        `json:"state"`
+	Reason string        `json:"reason"` // "syn-ack", "rst", "icmp-unreach" or "no-response"
+	TTL    uint8         `json:"ttl,omitempty"`
+	RTT    time.Duration `json:"rtt,omitempty"`
+	From   net.IP        `json:"from,omitempty"` // sender of ICMP Destination Unreachable
+	Code   uint8         `json:"code,omitempty"` // code of ICMP Destination Unreachable
+}
+
+// Scanner scans TCP ports of the destination by sending SYN.
+// Open ports are reset immediately so that half-open connections do not remain on the target.
+type Scanner struct {
+	cfg    Config
+	src    net.IP
+	srcMac net.HardwareAddr
+	sock   *ethernet.Socket
+	secret uint32 // used to derive sequence numbers of probes
+	ipID   uint16
+}
+
+// New returns new Scanner instance.
+func New(cfg Config) (*Scanner, error) {
+	if cfg.Dst.To4() == nil {
+		return nil, errors.Errorf("destination '%s' is not an IPv4 address", cfg.Dst)
+	}
+	if len(cfg.Ports) == 0 {
+		return nil, errors.New("no port to scan")
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = DefaultRate
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = DefaultRetries
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.SrcPort == 0 {
+		cfg.SrcPort = DefaultSrcPort
+	}
+
+	src := cfg.SrcIP
+	if src == nil {
+		var err error
+		src, err = iface.IPv4AddressByName(cfg.Interface)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get source IP address")
+		}
+		if src == nil {
+			return nil, errors.Errorf("no IPv4 address is assigned to \"%s\"", cfg.Interface)
+		}
+	}
+
+	oif, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface information")
+	}
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeIPv4)
+	if err != nil {
+		return nil, err
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	return &Scanner{
+		cfg:    cfg,
+		src:    src,
+		srcMac: oif.HardwareAddr,
+		sock:   sock,
+		secret: rand.Uint32(),
+		ipID:   uint16(rand.Uint32()),
+	}, nil
+}
+
+// Close closes the socket.
+func (s *Scanner) Close() error {
+	return s.sock.Close()
+}
+
+// Run scans all ports and returns results sorted by port number.
+// Ports which do not respond are probed again up to Retries times.
+func (s *Scanner) Run() ([]Result, error) {
+	results := make(map[uint16]*Result, len(s.cfg.Ports))
+	sent := make(map[uint16]time.Time, len(s.cfg.Ports))
+	pending := append([]uint16{}, s.cfg.Ports...)
+	interval := time.Second / time.Duration(s.cfg.Rate)
+
+	for round := 0; round <= s.cfg.Retries && len(pending) > 0; round++ {
+		next := time.Now()
+		var deadline time.Time
+		for i := 0; ; {
+			now := time.Now()
+			if i < len(pending) && !now.Before(next) {
+				if err := s.sendSyn(pending[i]); err != nil {
+					return nil, err
+				}
+				sent[pending[i]] = now
+				i++
+				next = next.Add(interval)
+				if i == len(pending) {
+					deadline = now.Add(s.cfg.Timeout)
+				}
+				continue
+			}
+			if i == len(pending) && !now.Before(deadline) {
+				break
+			}
+
+			wait := time.Until(next)
+			if i == len(pending) {
+				wait = time.Until(deadline)
+			}
+			if err := s.receive(wait, results, sent); err != nil {
+				return nil, err
+			}
+		}
+
+		rest := pending[:0]
+		for _, p := range pending {
+			if _, ok := results[p]; !ok {
+				rest = append(rest, p)
+			}
+		}
+		pending = rest
+	}
+
+	list := make([]Result, 0, len(s.cfg.Ports))
+	for _, p := range s.cfg.Ports {
+		if r, ok := results[p]; ok {
+			list = append(list, *r)
+		} else {
+			list = append(list, Result{Port: p, State: StateFiltered, Reason: "no-response"})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Port < list[j].Port })
+	return list, nil
+}
+
+// receive waits for a response up to d and records it.
+func (s *Scanner) receive(d time.Duration, results map[uint16]*Result, sent map[uint16]time.Time) error {
+	if d <= 0 {
+		return nil
+	}
+	if err := s.sock.SetRecvTimeout(d); err != nil {
+		return err
+	}
+	b, err := s.sock.Recv(0)
+	if err != nil {
+		if ethernet.IsTimeout(err) {
+			return nil
+		}
+		return err
+	}
+	r := s.classify(b[ethernet.HeaderLen:])
+	if r == nil {
+		return nil
+	}
+	if prev, ok := results[r.Port]; ok && prev.State != StateFiltered {
+		return nil // keep the first definitive answer
+	}
+	r.RTT = time.Since(sent[r.Port])
+	results[r.Port] = r
+	if r.State == StateOpen {
+		return s.sendReset(r.Port, s.seq(r.Port)+1)
+	}
+	return nil
+}
+
+// seq returns the sequence number of SYN sent to port.
+// It is derived from the secret so that responses can be validated without state.
+func (s *Scanner) seq(port uint16) uint32 {
+	return s.secret ^ (uint32(port)<<16 | uint32(port))
+}
+
+func (s *Scanner) sendSyn(port uint16) error {
+	syn := &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: s.cfg.SrcPort,
+			DstPort: port,
+			SeqNum:  s.seq(port),
+			Flags:   tcp.FlagSYN,
+			Window:  synWindow,
+			Options: []tcp.Option{tcp.MSSOption(1460)},
+		},
+	}
+	return s.send(syn)
+}
+
+func (s *Scanner) sendReset(port uint16, seq uint32) error {
+	rst := &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: s.cfg.SrcPort,
+			DstPort: port,
+			SeqNum:  seq,
+			Flags:   tcp.FlagRST,
+		},
+	}
+	return s.send(rst)
+}
+
+func (s *Scanner) send(seg *tcp.Segment) error {
+	data := seg.Encode(s.src, s.cfg.Dst)
+	pkt := ipv4.NewPacket(s.src, s.cfg.Dst, ipv4.ProtoTCP, data, ipv4.SetIdentification(s.ipID))
+	s.ipID++
+	return s.sock.SendFrame(ethernet.Frame(s.cfg.DstMac, s.srcMac, ethernet.TypeIPv4, pkt.Encode()), 0)
+}
+
+// classify returns the result if given IPv4 packet is a response to our SYN.
+func (s *Scanner) classify(b []byte) *Result {
+	pkt := ipv4.Parse(b)
+	if pkt == nil || !pkt.DstAddress.Equal(s.src) {
+		return nil
+	}
+
+	switch pkt.Protocol {
+	case ipv4.ProtoTCP:
+		seg := tcp.Parse(pkt.Data)
+		if seg == nil || !pkt.SrcAddress.Equal(s.cfg.Dst) || seg.DstPort != s.cfg.SrcPort {
+			return nil
+		}
+		if !seg.HasFlags(tcp.FlagACK) || seg.AckNum != s.seq(seg.SrcPort)+1 {
+			return nil
+		}
+		r := &Result{Port: seg.SrcPort, TTL: pkt.TimeToLive}
+		switch {
+		case seg.HasFlags(tcp.FlagRST):
+			r.State, r.Reason = StateClosed, "rst"
+		case seg.HasFlags(tcp.FlagSYN):
+			r.State, r.Reason = StateOpen, "syn-ack"
+		default:
+			return nil
+		}
+		return r
+	case ipv4.ProtoICMP:
+		msg := icmp.Parse(pkt.Data)
+		if msg == nil || msg.Type != icmp.TypeDestinationUnreachable {
+			return nil
+		}
+		data, ok := msg.Data.(*icmp.Error)
+		if !ok {
+			return nil
+		}
+		orig := ipv4.Parse(data.Original)
+		// only the first 8 bytes of TCP header may be quoted
+		if orig == nil || orig.Protocol != ipv4.ProtoTCP || !orig.DstAddress.Equal(s.cfg.Dst) || len(orig.Data) < 8 {
+			return nil
+		}
+		srcPort := binary.BigEndian.Uint16(orig.Data[0:])
+		dstPort := binary.BigEndian.Uint16(orig.Data[2:])
+		seq := binary.BigEndian.Uint32(orig.Data[4:])
+		if srcPort != s.cfg.SrcPort || seq != s.seq(dstPort) {
+			return nil
+		}
+		return &Result{
+			Port:   dstPort,
+			State:  StateFiltered,
+			Reason: "icmp-unreach",
+			TTL:    pkt.TimeToLive,
+			From:   pkt.SrcAddress,
+			Code:   msg.Code,
+		}
+	}
+	return nil
+}
+
+// ParsePorts parses port list like "22,80,8000-8100".
+// Duplicated ports are removed and the result is sorted.
+func ParsePorts(s string) ([]uint16, error) {
+	seen := make(map[uint16]bool)
+	ports := make([]uint16, 0)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		lo, hi := field, field
+		if i := strings.Index(field, "-"); i >= 0 {
+			lo, hi = field[:i], field[i+1:]
+		}
+		start, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil || start == 0 {
+			return nil, errors.Errorf("invalid port '%s'", field)
+		}
+		end, err := strconv.ParseUint(hi, 10, 16)
+		if err != nil || end < start {
+			return nil, errors.Errorf("invalid port range '%s'", field)
+		}
+		for p := start; p <= end; p++ {
+			if !seen[uint16(p)] {
+				seen[uint16(p)] = true
+				ports = append(ports, uint16(p))
+			}
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports, nil
+}
+
+// String returns the summary of r like "80/tcp open (syn-ack)".
+func (r *Result) String() string {
+	return fmt.Sprintf("%d/tcp %s (%s)", r.Port, r.State, r.Reason)
+}
@@ -0,0 +1,114 @@
+package synscan
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		in    string
+		out   []uint16
+		isErr bool
+	}{
+		{in: "80", out: []uint16{80}},
+		{in: "443,22,80-82", out: []uint16{22, 80, 81, 82, 443}},
+		{in: "1-3,2", out: []uint16{1, 2, 3}},
+		{in: "0", isErr: true},
+		{in: "10-5", isErr: true},
+		{in: "65536", isErr: true},
+		{in: "http", isErr: true},
+		{in: "", isErr: true},
+	}
+	for _, tt := range tests {
+		ports, err := ParsePorts(tt.in)
+		if tt.isErr {
+			if err == nil {
+				t.Errorf("ParsePorts(%q) should return error\n", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePorts(%q) returns error: %v\n", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(ports, tt.out) {
+			t.Errorf("ParsePorts(%q) = %v, but got %v\n", tt.in, tt.out, ports)
+		}
+	}
+}
+
+var (
+	testSrc = net.IPv4(192, 168, 0, 1).To4()
+	testDst = net.IPv4(192, 168, 0, 2).To4()
+)
+
+func TestClassify(t *testing.T) {
+	s := &Scanner{
+		cfg:    Config{Dst: testDst, SrcPort: DefaultSrcPort},
+		src:    testSrc,
+		secret: 0x12345678,
+	}
+	reply := func(port uint16, flags uint16, ack uint32) []byte {
+		seg := &tcp.Segment{
+			Header: tcp.Header{
+				SrcPort: port,
+				DstPort: DefaultSrcPort,
+				AckNum:  ack,
+				Flags:   flags,
+			},
+		}
+		return ipv4.NewPacket(testDst, testSrc, ipv4.ProtoTCP, seg.Encode(testDst, testSrc)).Encode()
+	}
+
+	// original SYN quoted in ICMP Destination Unreachable
+	syn := &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: DefaultSrcPort,
+			DstPort: 25,
+			SeqNum:  s.seq(25),
+			Flags:   tcp.FlagSYN,
+		},
+	}
+	orig := ipv4.NewPacket(testSrc, testDst, ipv4.ProtoTCP, syn.Encode(testSrc, testDst)).Encode()
+	unreach, err := icmp.NewDestinationUnreachable(icmp.CodeCommunicationProhibited, orig)
+	if err != nil {
+		t.Fatalf("NewDestinationUnreachable() returns error: %v\n", err)
+	}
+	router := net.IPv4(192, 168, 0, 254).To4()
+
+	tests := []struct {
+		name  string
+		in    []byte
+		state string
+		port  uint16
+	}{
+		{"syn-ack", reply(80, tcp.FlagSYN|tcp.FlagACK, s.seq(80)+1), StateOpen, 80},
+		{"rst", reply(81, tcp.FlagRST|tcp.FlagACK, s.seq(81)+1), StateClosed, 81},
+		{"icmp", ipv4.NewPacket(router, testSrc, ipv4.ProtoICMP, unreach.Encode()).Encode(), StateFiltered, 25},
+		{"wrong ack", reply(80, tcp.FlagSYN|tcp.FlagACK, s.seq(80)), "", 0},
+		{"ack only", reply(80, tcp.FlagACK, s.seq(80)+1), "", 0},
+		{"other host", ipv4.NewPacket(router, testSrc, ipv4.ProtoTCP, nil).Encode(), "", 0},
+	}
+	for _, tt := range tests {
+		r := s.classify(tt.in)
+		if tt.state == "" {
+			if r != nil {
+				t.Errorf("%s: classify() = nil, but got %v\n", tt.name, r)
+			}
+			continue
+		}
+		if r == nil {
+			t.Errorf("%s: classify() returns nil\n", tt.name)
+			continue
+		}
+		if r.State != tt.state || r.Port != tt.port {
+			t.Errorf("%s: classify() = %d/%s, but got %d/%s\n", tt.name, tt.port, tt.state, r.Port, r.State)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package tcp
+
+const (
+	// HeaderLen is the length of TCP header which does not have any option.
+	HeaderLen = 20
+	// MaxHeaderLen is the maximum length of TCP header including options.
+	MaxHeaderLen = 60
+
+	// FlagFIN represents no more data from sender.
+	FlagFIN = 0x1
+	// FlagSYN represents synchronize sequence numbers.
+	FlagSYN = 0x1 << 1
+	// FlagRST represents reset the connection.
+	FlagRST = 0x1 << 2
+	// FlagPSH represents push function.
+	FlagPSH = 0x1 << 3
+	// FlagACK represents acknowledgment field is significant.
+	FlagACK = 0x1 << 4
+	// FlagURG represents urgent pointer field is significant.
+	FlagURG = 0x1 << 5
+	// FlagECE represents ECN-Echo (RFC 3168).
+	FlagECE = 0x1 << 6
+	// FlagCWR represents congestion window reduced (RFC 3168).
+	FlagCWR = 0x1 << 7
+	// FlagNS represents ECN-nonce concealment protection (RFC 3540).
+	FlagNS = 0x1 << 8
+)
+
+// Kinds of TCP options.
+const (
+	// OptEnd is the kind of End of Option List.
+	OptEnd = 0
+	// OptNOP is the kind of No-Operation.
+	OptNOP = 1
+	// OptMSS is the kind of Maximum Segment Size option.
+	OptMSS = 2
+	// OptWindowScale is the kind of Window Scale option (RFC 7323).
+	OptWindowScale = 3
+	// OptSACKPermitted is the kind of SACK-Permitted option (RFC 2018).
+	OptSACKPermitted = 4
+	// OptSACK is the kind of SACK option (RFC 2018).
+	OptSACK = 5
+	// OptTimestamps is the kind of Timestamps option (RFC 7323).
+	OptTimestamps = 8
+	// OptMD5 is the kind of MD5 Signature option (RFC 2385).
+	OptMD5 = 19
+	// OptAO is the kind of TCP Authentication Option (RFC 5925).
+	OptAO = 29
+)
+
+const (
+	// MaxWindowScale is the maximum shift count of Window Scale option.
+	MaxWindowScale = 14
+	// MD5DigestLen is the length of digest in MD5 Signature option.
+	MD5DigestLen = 16
+)
+
+const (
+	maxOptionsLen = MaxHeaderLen - HeaderLen
+	sackBlockLen  = 8
+)
@@ -0,0 +1,258 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Option represents TCP option.
+// Data does not include Kind and Length fields. Options whose kind is unknown
+// are kept as they are, so that parsed segment can be encoded again without change.
+// For OptEnd, Data holds the bytes following it until the end of header.
+type Option struct {
+	Kind uint8
+	Data []byte
+}
+
+// SACKBlock represents a block of SACK option.
+type SACKBlock struct {
+	Left  uint32
+	Right uint32
+}
+
+// Len returns the length of encoded option.
+func (o Option) Len() int {
+	switch o.Kind {
+	case OptEnd:
+		return 1 + len(o.Data)
+	case OptNOP:
+		return 1
+	}
+	return 2 + len(o.Data)
+}
+
+// String returns human readable representation of o.
+func (o Option) String() string {
+	switch o.Kind {
+	case OptEnd:
+		return "eol"
+	case OptNOP:
+		return "nop"
+	case OptMSS:
+		if len(o.Data) == 2 {
+			return fmt.Sprintf("mss %d", binary.BigEndian.Uint16(o.Data))
+		}
+	case OptWindowScale:
+		if len(o.Data) == 1 {
+			return fmt.Sprintf("wscale %d", o.Data[0])
+		}
+	case OptSACKPermitted:
+		return "sackOK"
+	case OptSACK:
+		if blocks, ok := parseSACKBlocks(o.Data); ok {
+			s := make([]string, len(blocks))
+			for i, b := range blocks {
+				s[i] = fmt.Sprintf("%d:%d", b.Left, b.Right)
+			}
+			return "sack " + strings.Join(s, " ")
+		}
+	case OptTimestamps:
+		if len(o.Data) == 8 {
+			return fmt.Sprintf("TS val %d ecr %d", binary.BigEndian.Uint32(o.Data), binary.BigEndian.Uint32(o.Data[4:]))
+		}
+	case OptMD5:
+		return fmt.Sprintf("md5 %x", o.Data)
+	case OptAO:
+		if len(o.Data) >= 2 {
+			return fmt.Sprintf("ao keyid %d rnextkeyid %d mac %x", o.Data[0], o.Data[1], o.Data[2:])
+		}
+	}
+	return fmt.Sprintf("unknown-%d %x", o.Kind, o.Data)
+}
+
+// NOPOption returns No-Operation option.
+func NOPOption() Option {
+	return Option{Kind: OptNOP}
+}
+
+// MSSOption returns Maximum Segment Size option.
+func MSSOption(mss uint16) Option {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, mss)
+	return Option{Kind: OptMSS, Data: data}
+}
+
+// WindowScaleOption returns Window Scale option.
+func WindowScaleOption(shift uint8) Option {
+	return Option{Kind: OptWindowScale, Data: []byte{shift}}
+}
+
+// SACKPermittedOption returns SACK-Permitted option.
+func SACKPermittedOption() Option {
+	return Option{Kind: OptSACKPermitted}
+}
+
+// SACKOption returns SACK option which has given blocks.
+func SACKOption(blocks ...SACKBlock) Option {
+	data := make([]byte, sackBlockLen*len(blocks))
+	for i, b := range blocks {
+		binary.BigEndian.PutUint32(data[i*sackBlockLen:], b.Left)
+		binary.BigEndian.PutUint32(data[i*sackBlockLen+4:], b.Right)
+	}
+	return Option{Kind: OptSACK, Data: data}
+}
+
+// TimestampsOption returns Timestamps option.
+func TimestampsOption(val, ecr uint32) Option {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data[0:], val)
+	binary.BigEndian.PutUint32(data[4:], ecr)
+	return Option{Kind: OptTimestamps, Data: data}
+}
+
+// MD5Option returns MD5 Signature option.
+// Digest is not calculated. If digest is nil, zero-filled placeholder is used.
+func MD5Option(digest []byte) Option {
+	data := make([]byte, MD5DigestLen)
+	copy(data, digest)
+	return Option{Kind: OptMD5, Data: data}
+}
+
+// AOOption returns TCP Authentication Option.
+// MAC is not calculated and given mac is used as it is.
+func AOOption(keyID, rnextKeyID uint8, mac []byte) Option {
+	data := make([]byte, 2+len(mac))
+	data[0] = keyID
+	data[1] = rnextKeyID
+	copy(data[2:], mac)
+	return Option{Kind: OptAO, Data: data}
+}
+
+// MSS returns the value of Maximum Segment Size option.
+func (h *Header) MSS() (uint16, bool) {
+	o := h.option(OptMSS)
+	if o == nil || len(o.Data) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(o.Data), true
+}
+
+// WindowScale returns the shift count of Window Scale option.
+func (h *Header) WindowScale() (uint8, bool) {
+	o := h.option(OptWindowScale)
+	if o == nil || len(o.Data) != 1 {
+		return 0, false
+	}
+	return o.Data[0], true
+}
+
+// SACKPermitted reports whether SACK-Permitted option is included.
+func (h *Header) SACKPermitted() bool {
+	return h.option(OptSACKPermitted) != nil
+}
+
+// SACKBlocks returns the blocks of SACK option.
+func (h *Header) SACKBlocks() []SACKBlock {
+	o := h.option(OptSACK)
+	if o == nil {
+		return nil
+	}
+	blocks, _ := parseSACKBlocks(o.Data)
+	return blocks
+}
+
+// Timestamps returns TSval and TSecr of Timestamps option.
+func (h *Header) Timestamps() (val, ecr uint32, ok bool) {
+	o := h.option(OptTimestamps)
+	if o == nil || len(o.Data) != 8 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(o.Data), binary.BigEndian.Uint32(o.Data[4:]), true
+}
+
+// option returns the first option of given kind. If not found, nil is returned.
+func (h *Header) option(kind uint8) *Option {
+	for i := range h.Options {
+		if h.Options[i].Kind == kind {
+			return &h.Options[i]
+		}
+	}
+	return nil
+}
+
+func parseSACKBlocks(b []byte) ([]SACKBlock, bool) {
+	if len(b)%sackBlockLen != 0 {
+		return nil, false
+	}
+	blocks := make([]SACKBlock, len(b)/sackBlockLen)
+	for i := range blocks {
+		blocks[i] = SACKBlock{
+			Left:  binary.BigEndian.Uint32(b[i*sackBlockLen:]),
+			Right: binary.BigEndian.Uint32(b[i*sackBlockLen+4:]),
+		}
+	}
+	return blocks, true
+}
+
+// optionsLen returns the total length of encoded options without padding.
+func optionsLen(options []Option) int {
+	length := 0
+	for _, o := range options {
+		length += o.Len()
+	}
+	return length
+}
+
+// encodeOptions writes options to b. The rest of b is left zero, which means End of Option List.
+// Options are written until one does not fit in b.
+func encodeOptions(b []byte, options []Option) {
+	offset := 0
+	for _, o := range options {
+		if offset+o.Len() > len(b) {
+			return
+		}
+		b[offset] = o.Kind
+		switch o.Kind {
+		case OptEnd:
+			copy(b[offset+1:], o.Data)
+		case OptNOP:
+		default:
+			b[offset+1] = uint8(2 + len(o.Data))
+			copy(b[offset+2:], o.Data)
+		}
+		offset += o.Len()
+	}
+}
+
+// ParseOptions parses options field of TCP header.
+// It returns false if an option has invalid length.
+func ParseOptions(b []byte) ([]Option, bool) {
+	var options []Option
+	for len(b) > 0 {
+		switch b[0] {
+		case OptEnd:
+			o := Option{Kind: OptEnd}
+			if len(b) > 1 {
+				o.Data = make([]byte, len(b)-1)
+				copy(o.Data, b[1:])
+			}
+			return append(options, o), true
+		case OptNOP:
+			options = append(options, Option{Kind: OptNOP})
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			return nil, false
+		}
+		o := Option{Kind: b[0]}
+		if b[1] > 2 {
+			o.Data = make([]byte, b[1]-2)
+			copy(o.Data, b[2:b[1]])
+		}
+		options = append(options, o)
+		b = b[b[1]:]
+	}
+	return options, true
+}
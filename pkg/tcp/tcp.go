@@ -0,0 +1,179 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/mas9612/nwspeaker/pkg/checksum"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+)
+
+var (
+	flagNames = []struct {
+		flag uint16
+		name string
+	}{
+		{FlagNS, "NS"},
+		{FlagCWR, "CWR"},
+		{FlagECE, "ECE"},
+		{FlagURG, "URG"},
+		{FlagACK, "ACK"},
+		{FlagPSH, "PSH"},
+		{FlagRST, "RST"},
+		{FlagSYN, "SYN"},
+		{FlagFIN, "FIN"},
+	}
+)
+
+// Header represents TCP header.
+type Header struct {
+	SrcPort       uint16
+	DstPort       uint16
+	SeqNum        uint32
+	AckNum        uint32
+	DataOffset    uint8 // header length in 32-bit words. calculated from Options if zero.
+	Flags         uint16
+	Window        uint16
+	Checksum      uint16
+	UrgentPointer uint16
+	Options       []Option
+}
+
+// Segment represents TCP segment.
+type Segment struct {
+	Header
+	Data []byte
+}
+
+// Len returns the length of encoded header including options.
+// It is at most MaxHeaderLen because options which do not fit are not encoded.
+func (h *Header) Len() int {
+	n := (optionsLen(h.Options) + 3) / 4 * 4
+	if n > maxOptionsLen {
+		n = maxOptionsLen
+	}
+	return HeaderLen + n
+}
+
+// HasFlags reports whether all of given flags are set.
+func (h *Header) HasFlags(flags uint16) bool {
+	return h.Flags&flags == flags
+}
+
+// FlagString returns the names of flags joined by comma like "SYN,ACK".
+func FlagString(flags uint16) string {
+	names := make([]string, 0, len(flagNames))
+	for i := len(flagNames) - 1; i >= 0; i-- {
+		if flags&flagNames[i].flag != 0 {
+			names = append(names, flagNames[i].name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseFlags parses comma separated flag names like "SYN,ACK".
+// It returns false if unknown name is included.
+func ParseFlags(s string) (uint16, bool) {
+	var flags uint16
+	if s == "" {
+		return 0, true
+	}
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, f := range flagNames {
+			if strings.EqualFold(strings.TrimSpace(name), f.name) {
+				flags |= f.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// Encode returns byte-encoded data of TCP segment.
+// If DataOffset is zero, the actual header length is encoded instead.
+// Options after the one exceeding MaxHeaderLen are dropped.
+// Checksum is calculated over the IPv4 pseudo header made from src and dst.
+func (s *Segment) Encode(src, dst net.IP) []byte {
+	offset := s.DataOffset
+	if offset == 0 {
+		offset = uint8(s.Len() / 4)
+	}
+	s.Checksum = 0
+	buffer := s.encode(offset)
+	copy(buffer[16:], Checksum(src, dst, buffer))
+	s.Checksum = binary.BigEndian.Uint16(buffer[16:])
+	return buffer
+}
+
+// encode encodes s with given data offset.
+// Options are padded with End of Option List to 32-bit boundary.
+func (s *Segment) encode(offset uint8) []byte {
+	hdrLen := s.Len()
+	buffer := make([]byte, hdrLen+len(s.Data))
+	binary.BigEndian.PutUint16(buffer[0:], s.SrcPort)
+	binary.BigEndian.PutUint16(buffer[2:], s.DstPort)
+	binary.BigEndian.PutUint32(buffer[4:], s.SeqNum)
+	binary.BigEndian.PutUint32(buffer[8:], s.AckNum)
+	buffer[12] = offset<<4 | uint8(s.Flags>>8)&0x1
+	buffer[13] = uint8(s.Flags)
+	binary.BigEndian.PutUint16(buffer[14:], s.Window)
+	binary.BigEndian.PutUint16(buffer[16:], s.Checksum)
+	binary.BigEndian.PutUint16(buffer[18:], s.UrgentPointer)
+	encodeOptions(buffer[HeaderLen:hdrLen], s.Options)
+	copy(buffer[hdrLen:], s.Data)
+	return buffer
+}
+
+// VerifyChecksum reports whether the checksum of s is valid.
+// s must be the result of Parse, and src and dst are the addresses of the IPv4 packet which contains s.
+func (s *Segment) VerifyChecksum(src, dst net.IP) bool {
+	return bytes.Equal(Checksum(src, dst, s.encode(s.DataOffset)), []byte{0x00, 0x00})
+}
+
+// Checksum calculates TCP checksum of b over the IPv4 pseudo header.
+// b is the whole TCP segment including header.
+func Checksum(src, dst net.IP, b []byte) []byte {
+	return checksum.SumOfOnesComplement16(append(ipv4.PseudoHeader(src, dst, ipv4.ProtoTCP, len(b)), b...))
+}
+
+// Parse parses given TCP segment and returns a pointer to Segment instance.
+// b must not include IPv4 header.
+// It returns nil if the data offset or options are malformed.
+func Parse(b []byte) *Segment {
+	if len(b) < HeaderLen { // incomplete segment
+		return nil
+	}
+	offset := int(b[12]>>4) * 4
+	if offset < HeaderLen || offset > len(b) {
+		return nil
+	}
+	options, ok := ParseOptions(b[HeaderLen:offset])
+	if !ok {
+		return nil
+	}
+
+	s := &Segment{
+		Header: Header{
+			SrcPort:       binary.BigEndian.Uint16(b[0:]),
+			DstPort:       binary.BigEndian.Uint16(b[2:]),
+			SeqNum:        binary.BigEndian.Uint32(b[4:]),
+			AckNum:        binary.BigEndian.Uint32(b[8:]),
+			DataOffset:    b[12] >> 4,
+			Flags:         uint16(b[12]&0x1)<<8 | uint16(b[13]),
+			Window:        binary.BigEndian.Uint16(b[14:]),
+			Checksum:      binary.BigEndian.Uint16(b[16:]),
+			UrgentPointer: binary.BigEndian.Uint16(b[18:]),
+			Options:       options,
+		},
+		Data: make([]byte, len(b)-offset),
+	}
+	copy(s.Data, b[offset:])
+	return s
+}
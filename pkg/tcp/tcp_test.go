@@ -0,0 +1,205 @@
+package tcp
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var (
+	testSrc = net.IPv4(192, 168, 0, 1)
+	testDst = net.IPv4(192, 168, 0, 2)
+)
+
+var testSyn = []byte{
+	0x9c, 0x40, 0x00, 0x50, 0x01, 0x02, 0x03, 0x04, 0x00, 0x00, 0x00, 0x00, 0xa0, 0x02, 0xfa, 0xf0,
+	0x2b, 0x24, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x04, 0x02, 0x08, 0x0a, 0x00, 0x00, 0x00, 0x01,
+	0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03, 0x07,
+}
+
+var testAck = []byte{
+	0x00, 0x50, 0x9c, 0x40, 0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00, 0xc8, 0x51, 0x98, 0x03, 0xe8,
+	0x22, 0xe9, 0x00, 0x00, 0x68, 0x69,
+}
+
+var encodeTests = []struct {
+	in  *Segment
+	out []byte
+}{
+	{
+		in: &Segment{
+			Header: Header{
+				SrcPort: 40000,
+				DstPort: 80,
+				SeqNum:  0x01020304,
+				Flags:   FlagSYN,
+				Window:  64240,
+				Options: []Option{
+					MSSOption(1460),
+					SACKPermittedOption(),
+					TimestampsOption(1, 0),
+					NOPOption(),
+					WindowScaleOption(7),
+				},
+			},
+		},
+		out: testSyn,
+	},
+	{
+		in: &Segment{
+			Header: Header{
+				SrcPort: 80,
+				DstPort: 40000,
+				SeqNum:  100,
+				AckNum:  200,
+				Flags:   FlagNS | FlagCWR | FlagACK | FlagPSH,
+				Window:  1000,
+			},
+			Data: []byte("hi"),
+		},
+		out: testAck,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	for _, tt := range encodeTests {
+		b := tt.in.Encode(testSrc, testDst)
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("Encode() = %x, but got %x\n", tt.out, b)
+		}
+	}
+
+	// options which do not fit in MaxHeaderLen are dropped
+	long := &Segment{Header: Header{Options: []Option{
+		TimestampsOption(1, 1), TimestampsOption(2, 2), TimestampsOption(3, 3), TimestampsOption(4, 4), TimestampsOption(5, 5),
+	}}}
+	b := long.Encode(testSrc, testDst)
+	if len(b) != MaxHeaderLen || b[12]>>4 != MaxHeaderLen/4 {
+		t.Errorf("Encode() with long options = %d bytes, offset %d, but got %d bytes, offset %d\n", MaxHeaderLen, MaxHeaderLen/4, len(b), b[12]>>4)
+	}
+	if s := Parse(b); s == nil || len(s.Options) != 4 {
+		t.Errorf("Encode() with long options should keep 4 options, but got %x\n", b)
+	}
+	if long.DataOffset != 0 {
+		t.Errorf("Encode() must not set DataOffset, but got %d\n", long.DataOffset)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range encodeTests {
+		s := Parse(tt.out)
+		if s == nil {
+			t.Errorf("Parse(%x) returns nil\n", tt.out)
+			continue
+		}
+		if !reflect.DeepEqual(s.Options, tt.in.Options) || s.Flags != tt.in.Flags || !bytes.Equal(s.Data, tt.in.Data) {
+			t.Errorf("Parse(%x) = %+v, but got %+v\n", tt.out, tt.in, s)
+		}
+		if b := s.encode(s.DataOffset); !bytes.Equal(b, tt.out) {
+			t.Errorf("encode() of parsed segment = %x, but got %x\n", tt.out, b)
+		}
+		if !s.VerifyChecksum(testSrc, testDst) {
+			t.Errorf("checksum of %x must be valid\n", tt.out)
+		}
+	}
+
+	invalid := [][]byte{
+		testSyn[:HeaderLen-1],                           // truncated header
+		append([]byte{}, testSyn[:HeaderLen+4]...),      // data offset exceeds segment
+		append(append([]byte{}, testAck[:12]...), 0x40), // data offset is shorter than header
+	}
+	for _, b := range invalid {
+		if s := Parse(b); s != nil {
+			t.Errorf("Parse(%x) = nil, but got %+v\n", b, s)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		out []Option
+		ok  bool
+	}{
+		{
+			in:  []byte{0x01, 0x01, 0xfe, 0x04, 0xaa, 0xbb, 0x00, 0x00},
+			out: []Option{NOPOption(), NOPOption(), {Kind: 0xfe, Data: []byte{0xaa, 0xbb}}, {Kind: OptEnd, Data: []byte{0x00}}},
+			ok:  true,
+		},
+		{
+			in:  []byte{0x05, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00},
+			out: []Option{SACKOption(SACKBlock{Left: 1, Right: 2}), {Kind: OptEnd, Data: []byte{0x00}}},
+			ok:  true,
+		},
+		{
+			in: []byte{0x02, 0x05, 0x05, 0xb4}, // length exceeds options
+			ok: false,
+		},
+		{
+			in: []byte{0x02, 0x01, 0x00, 0x00}, // length is shorter than kind and length fields
+			ok: false,
+		},
+	}
+	for _, tt := range tests {
+		options, ok := ParseOptions(tt.in)
+		if ok != tt.ok || !reflect.DeepEqual(options, tt.out) {
+			t.Errorf("ParseOptions(%x) = %v, %t, but got %v, %t\n", tt.in, tt.out, tt.ok, options, ok)
+		}
+		if !ok {
+			continue
+		}
+		b := make([]byte, optionsLen(options))
+		encodeOptions(b, options)
+		if !bytes.Equal(b, tt.in) {
+			t.Errorf("encodeOptions() = %x, but got %x\n", tt.in, b)
+		}
+	}
+}
+
+func TestOptionAccessors(t *testing.T) {
+	s := Parse(testSyn)
+	if mss, ok := s.MSS(); !ok || mss != 1460 {
+		t.Errorf("MSS() = 1460, true, but got %d, %t\n", mss, ok)
+	}
+	if shift, ok := s.WindowScale(); !ok || shift != 7 {
+		t.Errorf("WindowScale() = 7, true, but got %d, %t\n", shift, ok)
+	}
+	if !s.SACKPermitted() {
+		t.Errorf("SACKPermitted() = true, but got false\n")
+	}
+	if val, ecr, ok := s.Timestamps(); !ok || val != 1 || ecr != 0 {
+		t.Errorf("Timestamps() = 1, 0, true, but got %d, %d, %t\n", val, ecr, ok)
+	}
+	if blocks := s.SACKBlocks(); blocks != nil {
+		t.Errorf("SACKBlocks() = nil, but got %v\n", blocks)
+	}
+
+	s = Parse(testAck)
+	if _, ok := s.MSS(); ok {
+		t.Errorf("MSS() of segment without options must not be ok\n")
+	}
+}
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		flags uint16
+		str   string
+	}{
+		{FlagSYN, "SYN"},
+		{FlagSYN | FlagACK, "SYN,ACK"},
+		{FlagNS | FlagCWR | FlagACK | FlagPSH, "PSH,ACK,CWR,NS"},
+		{0, ""},
+	}
+	for _, tt := range tests {
+		if s := FlagString(tt.flags); s != tt.str {
+			t.Errorf("FlagString(%#x) = %s, but got %s\n", tt.flags, tt.str, s)
+		}
+		if flags, ok := ParseFlags(tt.str); !ok || flags != tt.flags {
+			t.Errorf("ParseFlags(%s) = %#x, but got %#x\n", tt.str, tt.flags, flags)
+		}
+	}
+	if _, ok := ParseFlags("SYN,FOO"); ok {
+		t.Errorf("ParseFlags() with unknown flag must not be ok\n")
+	}
+}
@@ -0,0 +1,855 @@
+package tcpstack
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+)
+
+var _ net.Conn = &Conn{}
+
+// Conn is a TCP connection of Stack. It implements net.Conn.
+type Conn struct {
+	stack     *Stack
+	id        connID
+	remoteMac net.HardwareAddr
+	listener  *Listener // set for passive open until the connection is accepted
+	state     state
+	err       error // reason why the connection was aborted
+	closed    bool  // Close was called
+
+	// send sequence space
+	iss       uint32
+	sndUna    uint32
+	sndNxt    uint32
+	sndMax    uint32 // highest sequence number sent. sndNxt goes back to sndUna on retransmission.
+	sndWnd    uint32
+	sndWl1    uint32
+	sndWl2    uint32
+	sndBuf    []byte // data not acknowledged yet. sndBuf[0] is at sndBufSeq.
+	sndBufSeq uint32
+	finQueued bool // FIN follows sndBuf
+	mss       int  // maximum segment size to send
+
+	// receive sequence space
+	irs         uint32
+	rcvNxt      uint32
+	rcvBuf      []byte // in-order data not read yet
+	ooo         []outOfOrder
+	finReceived bool
+	lastWnd     uint32 // window advertised last time
+
+	// retransmission (RFC 6298)
+	rto          time.Duration
+	srtt         time.Duration
+	rttvar       time.Duration
+	rtoExpire    time.Time // zero if the timer is stopped
+	retries      int
+	rttMeasuring bool
+	rttSeq       uint32
+	rttStart     time.Time
+	dupAcks      int
+	timeWaitEnd  time.Time
+	finWait2End  time.Time // zero unless the connection is orphaned in FIN-WAIT-2
+
+	ready         chan struct{} // closed when the handshake completes or fails
+	readyClosed   bool
+	readable      chan struct{}
+	writable      chan struct{}
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// outOfOrder is the data received before the preceding data.
+type outOfOrder struct {
+	seq  uint32
+	data []byte
+}
+
+// Read reads data received from the peer.
+// It returns io.EOF after the peer sent FIN and all data is read.
+func (c *Conn) Read(b []byte) (int, error) {
+	s := c.stack
+	for {
+		s.mu.Lock()
+		if c.closed {
+			s.mu.Unlock()
+			return 0, ErrClosed
+		}
+		if len(c.rcvBuf) > 0 {
+			n := copy(b, c.rcvBuf)
+			c.rcvBuf = c.rcvBuf[n:]
+			// tell the peer the window opened if it was too small to send a full segment
+			if c.lastWnd < uint32(c.stack.cfg.MSS) && c.window() >= uint32(c.stack.cfg.MSS) {
+				c.sendAck()
+			}
+			s.mu.Unlock()
+			return n, nil
+		}
+		if c.err != nil {
+			err := c.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		if c.finReceived {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := c.readDeadline
+		s.mu.Unlock()
+
+		if err := wait(c.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write queues b to be sent to the peer. It blocks while the send buffer is full.
+func (c *Conn) Write(b []byte) (int, error) {
+	s := c.stack
+	written := 0
+	for {
+		s.mu.Lock()
+		if c.closed {
+			s.mu.Unlock()
+			return written, ErrClosed
+		}
+		if c.err != nil {
+			err := c.err
+			s.mu.Unlock()
+			return written, err
+		}
+		if c.finQueued {
+			s.mu.Unlock()
+			return written, ErrClosed
+		}
+		if space := s.cfg.BufferSize - len(c.sndBuf); space > 0 {
+			n := len(b) - written
+			if n > space {
+				n = space
+			}
+			c.sndBuf = append(c.sndBuf, b[written:written+n]...)
+			written += n
+			c.output(time.Now())
+		}
+		if written == len(b) {
+			s.mu.Unlock()
+			return written, nil
+		}
+		deadline := c.writeDeadline
+		s.mu.Unlock()
+
+		if err := wait(c.writable, deadline); err != nil {
+			return written, err
+		}
+	}
+}
+
+// wait blocks until ch is signaled or deadline passes.
+func wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return ErrTimeout
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+// signal wakes up goroutine blocked on ch.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Close sends FIN after queued data. The connection stays until the peer acknowledges it.
+func (c *Conn) Close() error {
+	s := c.stack
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	signal(c.readable)
+	signal(c.writable)
+
+	if c.state == stateSynSent {
+		c.fail(ErrClosed)
+		return nil
+	}
+	now := time.Now()
+	c.shutdown(now)
+	c.armFinTimeout(now)
+	return nil
+}
+
+// CloseWrite sends FIN after queued data, but data from the peer can still be read.
+func (c *Conn) CloseWrite() error {
+	s := c.stack
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.shutdown(time.Now())
+	signal(c.writable)
+	return nil
+}
+
+// shutdown queues FIN. Caller must hold stack.mu.
+func (c *Conn) shutdown(now time.Time) {
+	switch c.state {
+	case stateSynReceived, stateEstablished:
+		c.finQueued = true
+		c.state = stateFinWait1
+	case stateCloseWait:
+		c.finQueued = true
+		c.state = stateLastAck
+	}
+	c.output(now)
+}
+
+// LocalAddr returns the local address.
+func (c *Conn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: c.stack.cfg.Addr, Port: int(c.id.localPort)}
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IP(c.id.remoteIP[:]), Port: int(c.id.remotePort)}
+}
+
+// SetDeadline sets both read and write deadline.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of Read.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.stack.mu.Lock()
+	c.readDeadline = t
+	c.stack.mu.Unlock()
+	signal(c.readable)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of Write.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.stack.mu.Lock()
+	c.writeDeadline = t
+	c.stack.mu.Unlock()
+	signal(c.writable)
+	return nil
+}
+
+// State returns the name of current state like "ESTABLISHED".
+func (c *Conn) State() string {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+	return c.state.String()
+}
+
+// The following methods must be called with stack.mu held.
+
+// synReceived records the initial sequence number of the peer from SYN.
+func (c *Conn) synReceived(seg *tcp.Segment) {
+	c.irs = seg.SeqNum
+	c.rcvNxt = seg.SeqNum + 1
+	c.sndWnd = uint32(seg.Window)
+	c.sndWl1 = seg.SeqNum
+	if mss, ok := seg.MSS(); ok && int(mss) < c.mss {
+		c.mss = int(mss)
+	}
+}
+
+// sendSyn sends SYN, or SYN+ACK in SYN-RECEIVED state, and starts the retransmission timer.
+func (c *Conn) sendSyn(now time.Time) {
+	seg := c.segment(c.iss, tcp.FlagSYN)
+	seg.Options = []tcp.Option{tcp.MSSOption(uint16(c.stack.cfg.MSS))}
+	if c.state == stateSynReceived {
+		seg.Flags |= tcp.FlagACK
+		seg.AckNum = c.rcvNxt
+	}
+	c.sndNxt = c.iss + 1
+	c.sndMax = c.sndNxt
+	c.transmit(seg)
+	if c.rtoExpire.IsZero() {
+		c.rtoExpire = now.Add(c.rto)
+	}
+}
+
+// segment returns a segment of this connection which has given sequence number and flags.
+func (c *Conn) segment(seq uint32, flags uint16) *tcp.Segment {
+	c.lastWnd = c.window()
+	wnd := c.lastWnd
+	if wnd > 0xffff {
+		wnd = 0xffff
+	}
+	return &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: c.id.localPort,
+			DstPort: c.id.remotePort,
+			SeqNum:  seq,
+			AckNum:  c.rcvNxt,
+			Flags:   flags,
+			Window:  uint16(wnd),
+		},
+	}
+}
+
+func (c *Conn) transmit(seg *tcp.Segment) {
+	c.stack.sendSegment(net.IP(c.id.remoteIP[:]), c.remoteMac, seg)
+}
+
+func (c *Conn) sendAck() {
+	c.transmit(c.segment(c.sndNxt, tcp.FlagACK))
+}
+
+func (c *Conn) sendReset() {
+	c.transmit(c.segment(c.sndNxt, tcp.FlagRST|tcp.FlagACK))
+}
+
+// window returns the receive window. Out-of-order data is stored inside the window.
+func (c *Conn) window() uint32 {
+	if len(c.rcvBuf) >= c.stack.cfg.BufferSize {
+		return 0
+	}
+	return uint32(c.stack.cfg.BufferSize - len(c.rcvBuf))
+}
+
+// finSeq returns the sequence number of FIN.
+func (c *Conn) finSeq() uint32 {
+	return c.sndBufSeq + uint32(len(c.sndBuf))
+}
+
+// output sends data and FIN allowed by the send window.
+func (c *Conn) output(now time.Time) {
+	switch c.state {
+	case stateEstablished, stateCloseWait, stateFinWait1, stateClosing, stateLastAck:
+	default:
+		return
+	}
+
+	wnd := c.sndWnd
+	if wnd == 0 && !c.rtoExpire.IsZero() && c.sndNxt == c.sndUna {
+		wnd = 1 // zero window probe after the timer expired
+	}
+	end := c.sndUna + wnd
+	for {
+		off := int(c.sndNxt - c.sndBufSeq)
+		if off < len(c.sndBuf) {
+			n := len(c.sndBuf) - off
+			if n > c.mss {
+				n = c.mss
+			}
+			if !seqLT(c.sndNxt, end) {
+				if c.sndWnd == 0 && c.sndNxt == c.sndUna {
+					c.armRTO(now) // persist timer to probe zero window
+				}
+				break
+			}
+			if usable := int(end - c.sndNxt); n > usable {
+				n = usable
+			}
+
+			flags := uint16(tcp.FlagACK)
+			fin := c.finQueued && off+n == len(c.sndBuf)
+			if off+n == len(c.sndBuf) {
+				flags |= tcp.FlagPSH
+			}
+			if fin {
+				flags |= tcp.FlagFIN
+			}
+			seg := c.segment(c.sndNxt, flags)
+			seg.Data = c.sndBuf[off : off+n]
+			c.startRTT(now, c.sndNxt)
+			c.transmit(seg)
+			c.sndNxt += uint32(n)
+			if fin {
+				c.sndNxt++
+			}
+			c.advanceMax()
+			c.armRTO(now)
+			continue
+		}
+		if c.finQueued && c.sndNxt == c.finSeq() {
+			c.transmit(c.segment(c.sndNxt, tcp.FlagFIN|tcp.FlagACK))
+			c.sndNxt++
+			c.advanceMax()
+			c.armRTO(now)
+		}
+		break
+	}
+}
+
+func (c *Conn) advanceMax() {
+	if seqLT(c.sndMax, c.sndNxt) {
+		c.sndMax = c.sndNxt
+	}
+}
+
+// retransmitFirst retransmits the first unacknowledged segment.
+func (c *Conn) retransmitFirst() {
+	off := int(c.sndUna - c.sndBufSeq)
+	if off >= len(c.sndBuf) {
+		if c.finQueued && c.sndUna == c.finSeq() {
+			c.transmit(c.segment(c.sndUna, tcp.FlagFIN|tcp.FlagACK))
+		}
+		return
+	}
+	n := len(c.sndBuf) - off
+	if n > c.mss {
+		n = c.mss
+	}
+	seg := c.segment(c.sndUna, tcp.FlagACK)
+	seg.Data = c.sndBuf[off : off+n]
+	c.transmit(seg)
+	c.rttMeasuring = false // Karn's algorithm
+}
+
+// startRTT starts measuring round trip time with the segment at seq.
+// Retransmitted segments are not measured (Karn's algorithm).
+func (c *Conn) startRTT(now time.Time, seq uint32) {
+	if c.rttMeasuring || seqLT(seq, c.sndMax) {
+		return
+	}
+	c.rttMeasuring = true
+	c.rttSeq = seq
+	c.rttStart = now
+}
+
+func (c *Conn) armRTO(now time.Time) {
+	if c.rtoExpire.IsZero() {
+		c.rtoExpire = now.Add(c.rto)
+	}
+}
+
+// updateRTO updates RTO from measured round trip time (RFC 6298).
+func (c *Conn) updateRTO(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		diff := c.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rttvar = (3*c.rttvar + diff) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < MinRTO {
+		c.rto = MinRTO
+	}
+	if c.rto > MaxRTO {
+		c.rto = MaxRTO
+	}
+}
+
+// tick handles expiration of timers.
+func (c *Conn) tick(now time.Time) {
+	if c.state == stateTimeWait {
+		if now.After(c.timeWaitEnd) {
+			c.remove()
+		}
+		return
+	}
+	if !c.finWait2End.IsZero() && now.After(c.finWait2End) { // the peer never sent FIN
+		c.remove()
+		return
+	}
+	if c.rtoExpire.IsZero() || now.Before(c.rtoExpire) {
+		return
+	}
+
+	c.retries++
+	if c.retries > MaxRetries {
+		c.fail(ErrConnTimeout)
+		return
+	}
+	c.rto *= 2
+	if c.rto > MaxRTO {
+		c.rto = MaxRTO
+	}
+	c.rttMeasuring = false // Karn's algorithm
+	c.rtoExpire = time.Time{}
+
+	switch c.state {
+	case stateSynSent, stateSynReceived:
+		c.sendSyn(now)
+	default:
+		// go back to the first unacknowledged byte
+		c.sndNxt = c.sndUna
+		c.rtoExpire = now.Add(c.rto) // keeps zero window probe enabled in output
+		c.output(now)
+	}
+}
+
+// handle processes a segment of this connection (RFC 793 section 3.9 and RFC 5961).
+func (c *Conn) handle(seg *tcp.Segment, now time.Time) {
+	if c.state == stateSynSent {
+		c.handleSynSent(seg, now)
+		return
+	}
+	if c.state == stateSynReceived && seg.HasFlags(tcp.FlagSYN) && !seg.HasFlags(tcp.FlagACK) && seg.SeqNum == c.irs {
+		c.sendSyn(now) // SYN+ACK was lost
+		return
+	}
+
+	if !c.acceptable(seg) {
+		if !seg.HasFlags(tcp.FlagRST) {
+			c.sendAck()
+		}
+		return
+	}
+	if seg.HasFlags(tcp.FlagRST) {
+		if seg.SeqNum != c.rcvNxt {
+			c.sendAck() // challenge ACK
+			return
+		}
+		if c.state == stateSynReceived && c.listener != nil {
+			c.remove() // return to LISTEN
+			return
+		}
+		c.fail(ErrConnReset)
+		return
+	}
+	if seg.HasFlags(tcp.FlagSYN) {
+		c.sendAck() // challenge ACK
+		return
+	}
+	if !seg.HasFlags(tcp.FlagACK) {
+		return
+	}
+
+	if c.state == stateSynReceived {
+		if !seqLT(c.sndUna, seg.AckNum) || seqLT(c.sndNxt, seg.AckNum) {
+			c.stack.sendReset(net.IP(c.id.remoteIP[:]), c.remoteMac, seg)
+			return
+		}
+		if c.established(seg); c.state != stateEstablished {
+			return
+		}
+	}
+	if !c.handleAck(seg, now) {
+		return
+	}
+	c.handleData(seg)
+	c.handleFin(seg, now)
+	c.output(now)
+}
+
+func (c *Conn) handleSynSent(seg *tcp.Segment, now time.Time) {
+	if seg.HasFlags(tcp.FlagACK) && seg.AckNum != c.iss+1 {
+		if !seg.HasFlags(tcp.FlagRST) {
+			c.stack.sendReset(net.IP(c.id.remoteIP[:]), c.remoteMac, seg)
+		}
+		return
+	}
+	if seg.HasFlags(tcp.FlagRST) {
+		if seg.HasFlags(tcp.FlagACK) {
+			c.fail(ErrConnRefused)
+		}
+		return
+	}
+	if !seg.HasFlags(tcp.FlagSYN) {
+		return
+	}
+
+	c.synReceived(seg)
+	if !seg.HasFlags(tcp.FlagACK) { // simultaneous open
+		c.state = stateSynReceived
+		c.sendSyn(now)
+		return
+	}
+	c.established(seg)
+	c.sndUna = seg.AckNum
+	c.rtoExpire = time.Time{}
+	c.retries = 0
+	c.sendAck()
+}
+
+// established moves the connection to ESTABLISHED state.
+func (c *Conn) established(seg *tcp.Segment) {
+	c.state = stateEstablished
+	c.sndWnd = uint32(seg.Window)
+	c.sndWl1 = seg.SeqNum
+	c.sndWl2 = seg.AckNum
+	if c.listener != nil {
+		select {
+		case <-c.listener.done:
+			c.abort()
+			return
+		default:
+		}
+		select {
+		case c.listener.accept <- c:
+		default: // backlog is full
+			c.sendReset()
+			c.remove()
+			return
+		}
+		c.listener = nil
+	}
+	c.notifyReady()
+}
+
+func (c *Conn) notifyReady() {
+	if !c.readyClosed {
+		close(c.ready)
+		c.readyClosed = true
+	}
+}
+
+// acceptable reports whether seg is in the receive window (RFC 793 section 3.3).
+func (c *Conn) acceptable(seg *tcp.Segment) bool {
+	length := segmentLen(seg)
+	wnd := c.window()
+	switch {
+	case length == 0 && wnd == 0:
+		return seg.SeqNum == c.rcvNxt
+	case length == 0:
+		return inWindow(seg.SeqNum, c.rcvNxt, wnd)
+	case wnd == 0:
+		return false
+	}
+	return inWindow(seg.SeqNum, c.rcvNxt, wnd) || inWindow(seg.SeqNum+length-1, c.rcvNxt, wnd)
+}
+
+// handleAck processes acknowledgment number and window of seg.
+// It returns false if seg must be dropped.
+func (c *Conn) handleAck(seg *tcp.Segment, now time.Time) bool {
+	if seqLT(c.sndMax, seg.AckNum) {
+		c.sendAck() // acknowledges data not sent yet
+		return false
+	}
+	if seg.AckNum == c.sndUna && c.sndUna != c.sndMax && len(seg.Data) == 0 &&
+		!seg.HasFlags(tcp.FlagFIN) && uint32(seg.Window) == c.sndWnd {
+		// fast retransmit after three duplicate ACKs (RFC 5681)
+		if c.dupAcks++; c.dupAcks == 3 {
+			c.retransmitFirst()
+		}
+	}
+	if seqLT(c.sndUna, seg.AckNum) {
+		c.dupAcks = 0
+		if seqLT(c.sndNxt, seg.AckNum) { // acknowledges data sent before going back
+			c.sndNxt = seg.AckNum
+		}
+		if c.rttMeasuring && seqLT(c.rttSeq, seg.AckNum) {
+			c.updateRTO(now.Sub(c.rttStart))
+			c.rttMeasuring = false
+		}
+		acked := int(seg.AckNum - c.sndBufSeq)
+		if acked > len(c.sndBuf) {
+			acked = len(c.sndBuf) // FIN is acknowledged
+		}
+		c.sndBuf = c.sndBuf[acked:]
+		c.sndBufSeq += uint32(acked)
+		c.sndUna = seg.AckNum
+		c.retries = 0
+		c.rtoExpire = time.Time{}
+		if c.sndUna != c.sndMax {
+			c.rtoExpire = now.Add(c.rto)
+		}
+		signal(c.writable)
+	}
+	if seqLT(c.sndWl1, seg.SeqNum) || (c.sndWl1 == seg.SeqNum && !seqLT(seg.AckNum, c.sndWl2)) {
+		c.sndWnd = uint32(seg.Window)
+		c.sndWl1 = seg.SeqNum
+		c.sndWl2 = seg.AckNum
+		if c.sndWnd == 0 {
+			c.retries = 0 // the peer is alive. keep probing.
+		}
+	}
+
+	finAcked := c.finQueued && c.sndUna == c.finSeq()+1
+	switch c.state {
+	case stateFinWait1:
+		if finAcked {
+			c.state = stateFinWait2
+			c.armFinTimeout(now)
+		}
+	case stateClosing:
+		if finAcked {
+			c.enterTimeWait(now)
+		}
+	case stateLastAck:
+		if finAcked {
+			c.remove()
+			return false
+		}
+	case stateTimeWait:
+		if seg.HasFlags(tcp.FlagFIN) {
+			c.sendAck()
+			c.enterTimeWait(now)
+		}
+		return false
+	}
+	return true
+}
+
+// handleData queues the data of seg in order.
+func (c *Conn) handleData(seg *tcp.Segment) {
+	switch c.state {
+	case stateEstablished, stateFinWait1, stateFinWait2:
+	default:
+		return
+	}
+	if len(seg.Data) == 0 {
+		return
+	}
+
+	seq, data := seg.SeqNum, seg.Data
+	if seqLT(seq, c.rcvNxt) { // trim data already received
+		data = data[c.rcvNxt-seq:]
+		seq = c.rcvNxt
+	}
+	if wnd := c.window(); uint32(len(data)) > wnd-(seq-c.rcvNxt) {
+		data = data[:wnd-(seq-c.rcvNxt)]
+	}
+
+	if seq == c.rcvNxt {
+		c.rcvBuf = append(c.rcvBuf, data...)
+		c.rcvNxt += uint32(len(data))
+		c.reassemble()
+		signal(c.readable)
+	} else if len(data) > 0 && !c.buffered(seq, len(data)) {
+		c.ooo = append(c.ooo, outOfOrder{seq: seq, data: append([]byte{}, data...)})
+	}
+	c.sendAck()
+}
+
+// buffered reports whether out-of-order data of given range is already stored.
+func (c *Conn) buffered(seq uint32, length int) bool {
+	for _, o := range c.ooo {
+		if o.seq == seq && len(o.data) >= length {
+			return true
+		}
+	}
+	return false
+}
+
+// reassemble moves out-of-order data which became in order to rcvBuf.
+func (c *Conn) reassemble() {
+	for merged := true; merged; {
+		merged = false
+		rest := c.ooo[:0]
+		for _, o := range c.ooo {
+			end := o.seq + uint32(len(o.data))
+			switch {
+			case !seqLT(c.rcvNxt, end): // already received
+				merged = true
+			case !seqLT(c.rcvNxt, o.seq):
+				c.rcvBuf = append(c.rcvBuf, o.data[c.rcvNxt-o.seq:]...)
+				c.rcvNxt = end
+				merged = true
+			default:
+				rest = append(rest, o)
+			}
+		}
+		c.ooo = rest
+	}
+}
+
+// handleFin processes FIN of seg if all preceding data is received.
+func (c *Conn) handleFin(seg *tcp.Segment, now time.Time) {
+	if !seg.HasFlags(tcp.FlagFIN) || c.finReceived || seg.SeqNum+uint32(len(seg.Data)) != c.rcvNxt {
+		return
+	}
+	c.finReceived = true
+	c.rcvNxt++
+	c.sendAck()
+	signal(c.readable)
+
+	switch c.state {
+	case stateEstablished:
+		c.state = stateCloseWait
+	case stateFinWait1:
+		if c.sndUna == c.finSeq()+1 {
+			c.enterTimeWait(now)
+		} else {
+			c.state = stateClosing
+		}
+	case stateFinWait2:
+		c.enterTimeWait(now)
+	}
+}
+
+// armFinTimeout starts the timer which removes the connection
+// if it is closed by the user and the peer does not send FIN in FIN-WAIT-2.
+func (c *Conn) armFinTimeout(now time.Time) {
+	if c.closed && c.state == stateFinWait2 && c.finWait2End.IsZero() {
+		c.finWait2End = now.Add(c.stack.cfg.FinTimeout)
+	}
+}
+
+func (c *Conn) enterTimeWait(now time.Time) {
+	c.state = stateTimeWait
+	c.rtoExpire = time.Time{}
+	c.timeWaitEnd = now.Add(c.stack.cfg.TimeWait)
+}
+
+// fail aborts the connection with err.
+func (c *Conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.remove()
+}
+
+// abort resets the connection which is not accepted yet.
+func (c *Conn) abort() {
+	if c.state != stateClosed && c.state != stateTimeWait {
+		c.sendReset()
+	}
+	c.fail(ErrClosed)
+}
+
+// remove deletes the connection from the stack and wakes up all waiting goroutines.
+func (c *Conn) remove() {
+	c.state = stateClosed
+	c.rtoExpire = time.Time{}
+	if c.stack.conns[c.id] == c {
+		delete(c.stack.conns, c.id)
+	}
+	c.notifyReady()
+	signal(c.readable)
+	signal(c.writable)
+}
+
+// segmentLen returns the length of seg in sequence space.
+func segmentLen(seg *tcp.Segment) uint32 {
+	length := uint32(len(seg.Data))
+	if seg.HasFlags(tcp.FlagSYN) {
+		length++
+	}
+	if seg.HasFlags(tcp.FlagFIN) {
+		length++
+	}
+	return length
+}
+
+// seqLT reports whether a is before b in sequence space.
+func seqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// inWindow reports whether seq is in [start, start+wnd).
+func inWindow(seq, start, wnd uint32) bool {
+	return seq-start < wnd
+}
@@ -0,0 +1,77 @@
+package tcpstack
+
+import "time"
+
+const (
+	// DefaultMSS is the default Maximum Segment Size advertised to peers.
+	DefaultMSS = 1460
+	// DefaultTimeWait is the default duration of TIME-WAIT state.
+	// It is much shorter than 2MSL of RFC 793 so that ports can be reused quickly in lab tests.
+	DefaultTimeWait = 10 * time.Second
+	// DefaultFinTimeout is the default duration an orphaned connection stays in FIN-WAIT-2 state.
+	// It is the same as tcp_fin_timeout of Linux.
+	DefaultFinTimeout = 60 * time.Second
+	// DefaultBufferSize is the default size of send and receive buffer of each connection.
+	DefaultBufferSize = 65535
+
+	// InitialRTO is the retransmission timeout used before RTT is measured (RFC 6298).
+	InitialRTO = 1 * time.Second
+	// MinRTO is the lower bound of retransmission timeout.
+	// RFC 6298 recommends 1 second, but 200ms is used like Linux.
+	MinRTO = 200 * time.Millisecond
+	// MaxRTO is the upper bound of retransmission timeout.
+	MaxRTO = 60 * time.Second
+	// MaxRetries is the number of retransmissions before the connection is aborted.
+	MaxRetries = 8
+)
+
+const (
+	// interval of retransmission timer and to check whether Stack is closed
+	tickInterval = 10 * time.Millisecond
+
+	// interval and number of ARP Request sent to resolve the peer
+	arpInterval = 500 * time.Millisecond
+	arpRetries  = 3
+
+	// number of connections which completed handshake but are not accepted yet
+	acceptBacklog = 128
+
+	// range of ephemeral ports used by Dial
+	ephemeralPortMin = 49152
+	ephemeralPortMax = 65535
+
+	defaultTTL = 64
+)
+
+// state is the state of TCP connection (RFC 793).
+type state int
+
+const (
+	stateClosed state = iota
+	stateSynSent
+	stateSynReceived
+	stateEstablished
+	stateFinWait1
+	stateFinWait2
+	stateCloseWait
+	stateClosing
+	stateLastAck
+	stateTimeWait
+)
+
+var stateNames = []string{
+	"CLOSED",
+	"SYN-SENT",
+	"SYN-RECEIVED",
+	"ESTABLISHED",
+	"FIN-WAIT-1",
+	"FIN-WAIT-2",
+	"CLOSE-WAIT",
+	"CLOSING",
+	"LAST-ACK",
+	"TIME-WAIT",
+}
+
+func (s state) String() string {
+	return stateNames[s]
+}
@@ -0,0 +1,66 @@
+package tcpstack
+
+import (
+	"net"
+)
+
+var _ net.Listener = &Listener{}
+
+// Listener accepts connections of Stack. It implements net.Listener.
+type Listener struct {
+	stack  *Stack
+	port   uint16
+	accept chan *Conn
+	done   chan struct{}
+}
+
+// Accept waits for the next connection which completed the handshake.
+func (l *Listener) Accept() (net.Conn, error) {
+	return l.AcceptTCP()
+}
+
+// AcceptTCP is the same as Accept but returns *Conn.
+func (l *Listener) AcceptTCP() (*Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, ErrClosed
+	}
+}
+
+// Close stops accepting connections. Connections already accepted are not closed,
+// but those still in the handshake or waiting for Accept are reset.
+func (l *Listener) Close() error {
+	l.stack.mu.Lock()
+	defer l.stack.mu.Unlock()
+	if l.stack.listeners[l.port] != l {
+		return ErrClosed
+	}
+	l.close()
+	return nil
+}
+
+// close must be called with stack.mu held.
+func (l *Listener) close() {
+	delete(l.stack.listeners, l.port)
+	close(l.done)
+	for queued := true; queued; {
+		select {
+		case c := <-l.accept:
+			c.abort()
+		default:
+			queued = false
+		}
+	}
+	for _, c := range l.stack.conns {
+		if c.listener == l {
+			c.abort()
+		}
+	}
+}
+
+// Addr returns the address the listener accepts connections on.
+func (l *Listener) Addr() net.Addr {
+	return &net.TCPAddr{IP: l.stack.cfg.Addr, Port: int(l.port)}
+}
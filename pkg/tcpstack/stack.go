@@ -0,0 +1,473 @@
+package tcpstack
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/arp"
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrConnRefused is returned by Dial when the peer answers SYN with RST.
+	ErrConnRefused = errors.New("connection refused")
+	// ErrConnReset is returned when the connection is reset by the peer.
+	ErrConnReset = errors.New("connection reset by peer")
+	// ErrConnTimeout is returned when the peer does not acknowledge retransmitted segments.
+	ErrConnTimeout = errors.New("connection timed out")
+	// ErrClosed is returned by the methods of Conn and Listener after they are closed.
+	ErrClosed = errors.New("use of closed connection")
+	// ErrTimeout is returned by Read and Write when the deadline passes.
+	// It implements net.Error and its Timeout method returns true.
+	ErrTimeout net.Error = timeoutError{}
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Config is the configuration of Stack.
+type Config struct {
+	Interface  string
+	Addr       net.IP           // address of the emulated host
+	MAC        net.HardwareAddr // if nil, MAC address of Interface is used. Other addresses enable promiscuous mode.
+	Gateway    net.HardwareAddr // if set, all segments are sent to this MAC address without ARP resolution
+	MSS        int              // if zero, DefaultMSS is used
+	TimeWait   time.Duration    // if zero, DefaultTimeWait is used
+	FinTimeout time.Duration    // if zero, DefaultFinTimeout is used
+	BufferSize int              // if zero, DefaultBufferSize is used
+}
+
+// connID identifies a connection by local port and remote endpoint.
+type connID struct {
+	localPort  uint16
+	remoteIP   [net.IPv4len]byte
+	remotePort uint16
+}
+
+func newConnID(localPort uint16, remoteIP net.IP, remotePort uint16) connID {
+	id := connID{
+		localPort:  localPort,
+		remotePort: remotePort,
+	}
+	copy(id.remoteIP[:], remoteIP.To4())
+	return id
+}
+
+// Stack is a minimal TCP implementation for an emulated host.
+// It answers ARP Request for Addr and handles TCP segments sent to Addr,
+// so the host can accept and open connections although the kernel does not know the address.
+// Serve must be running while connections are used.
+type Stack struct {
+	cfg  Config
+	sock *ethernet.Socket
+	rbuf []byte // receive buffer large enough for a frame of Interface MTU
+	send func(frame []byte) error
+	done chan struct{}
+	once sync.Once
+
+	mu        sync.Mutex
+	conns     map[connID]*Conn
+	listeners map[uint16]*Listener
+	neighbors map[string]net.HardwareAddr
+	resolving map[string]chan struct{} // closed when the address is resolved
+	lastTick  time.Time
+}
+
+// New returns new Stack instance.
+func New(cfg Config) (*Stack, error) {
+	if cfg.Addr.To4() == nil {
+		return nil, errors.Errorf("'%s' is not an IPv4 address", cfg.Addr)
+	}
+	oif, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface information")
+	}
+	if cfg.MAC == nil {
+		cfg.MAC = oif.HardwareAddr
+	}
+
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeAll)
+	if err != nil {
+		return nil, err
+	}
+	// frames sent to the emulated MAC address are dropped by the NIC otherwise
+	if !bytes.Equal(cfg.MAC, oif.HardwareAddr) {
+		if err := sock.SetPromiscuous(true); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+
+	s := newStack(cfg, func(frame []byte) error {
+		return sock.SendFrame(frame, 0)
+	})
+	s.sock = sock
+	s.rbuf = make([]byte, oif.MTU+ethernet.HeaderLen+ethernet.VLANTagLen)
+	return s, nil
+}
+
+// newStack returns Stack which sends frames with send. It does not have a socket.
+func newStack(cfg Config, send func(frame []byte) error) *Stack {
+	if cfg.MSS == 0 {
+		cfg.MSS = DefaultMSS
+	}
+	if cfg.TimeWait == 0 {
+		cfg.TimeWait = DefaultTimeWait
+	}
+	if cfg.FinTimeout == 0 {
+		cfg.FinTimeout = DefaultFinTimeout
+	}
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+	rand.Seed(time.Now().UnixNano())
+	return &Stack{
+		cfg:       cfg,
+		send:      send,
+		done:      make(chan struct{}),
+		conns:     make(map[connID]*Conn),
+		listeners: make(map[uint16]*Listener),
+		neighbors: make(map[string]net.HardwareAddr),
+		resolving: make(map[string]chan struct{}),
+	}
+}
+
+// Serve receives frames and drives timers until Close is called.
+func (s *Stack) Serve() error {
+	for {
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+
+		if err := s.sock.SetRecvTimeout(tickInterval); err != nil {
+			return err
+		}
+		n, _, err := s.sock.RecvFrom(s.rbuf, 0)
+		if err != nil {
+			if errors.Cause(err) == ethernet.ErrClosed { // Close was called
+				return nil
+			}
+			if !ethernet.IsTimeout(err) {
+				return err
+			}
+		} else {
+			s.handle(s.rbuf[:n])
+		}
+
+		now := time.Now()
+		if now.Sub(s.lastTick) >= tickInterval {
+			s.tick(now)
+			s.lastTick = now
+		}
+	}
+}
+
+// Close resets all connections, stops Serve and closes the socket.
+// Calling Close more than once returns nil.
+func (s *Stack) Close() error {
+	var err error
+	s.once.Do(func() { err = s.close() })
+	return err
+}
+
+func (s *Stack) close() error {
+	s.mu.Lock()
+	for _, c := range s.conns {
+		if c.state != stateTimeWait {
+			c.sendReset()
+		}
+		c.fail(ErrClosed)
+	}
+	for _, l := range s.listeners {
+		l.close()
+	}
+	s.mu.Unlock()
+
+	close(s.done)
+	if s.sock == nil {
+		return nil
+	}
+	return s.sock.Close()
+}
+
+// Listen starts accepting connections on port.
+func (s *Stack) Listen(port uint16) (*Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.listeners[port]; ok {
+		return nil, errors.Errorf("port %d is already in use", port)
+	}
+	l := &Listener{
+		stack:  s,
+		port:   port,
+		accept: make(chan *Conn, acceptBacklog),
+		done:   make(chan struct{}),
+	}
+	s.listeners[port] = l
+	return l, nil
+}
+
+// Dial opens a connection to ip:port. It fails if the handshake does not complete within timeout.
+// Zero timeout means the handshake is retried until MaxRetries.
+func (s *Stack) Dial(ip net.IP, port uint16, timeout time.Duration) (*Conn, error) {
+	if ip.To4() == nil {
+		return nil, errors.Errorf("'%s' is not an IPv4 address", ip)
+	}
+	mac, err := s.resolve(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	localPort, err := s.ephemeralPort(ip, port)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	c := s.newConn(newConnID(localPort, ip, port), mac)
+	c.state = stateSynSent
+	c.sendSyn(time.Now())
+	s.mu.Unlock()
+
+	var expire <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
+	select {
+	case <-c.ready:
+	case <-expire:
+		s.mu.Lock()
+		c.fail(ErrConnTimeout)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c, nil
+}
+
+// ephemeralPort returns a random local port which is not used for the remote endpoint.
+func (s *Stack) ephemeralPort(ip net.IP, port uint16) (uint16, error) {
+	n := ephemeralPortMax - ephemeralPortMin + 1
+	start := rand.Intn(n)
+	for i := 0; i < n; i++ {
+		p := uint16(ephemeralPortMin + (start+i)%n)
+		if _, ok := s.listeners[p]; ok {
+			continue
+		}
+		if _, ok := s.conns[newConnID(p, ip, port)]; !ok {
+			return p, nil
+		}
+	}
+	return 0, errors.New("no ephemeral port is available")
+}
+
+func (s *Stack) newConn(id connID, mac net.HardwareAddr) *Conn {
+	c := &Conn{
+		stack:     s,
+		id:        id,
+		remoteMac: mac,
+		iss:       rand.Uint32(),
+		mss:       s.cfg.MSS,
+		rto:       InitialRTO,
+		ready:     make(chan struct{}),
+		readable:  make(chan struct{}, 1),
+		writable:  make(chan struct{}, 1),
+	}
+	c.sndUna = c.iss
+	c.sndNxt = c.iss
+	c.sndBufSeq = c.iss + 1
+	s.conns[id] = c
+	return c
+}
+
+// tick drives retransmission and TIME-WAIT timers of all connections.
+func (s *Stack) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.tick(now)
+	}
+}
+
+// handle processes a received frame.
+func (s *Stack) handle(frame []byte) {
+	if len(frame) < ethernet.HeaderLen {
+		return
+	}
+	hdr := ethernet.Parse(frame)
+	if hdr.SrcAddr.String() == s.cfg.MAC.String() { // sent by ourselves
+		return
+	}
+
+	switch hdr.EtherType {
+	case ethernet.TypeARP:
+		s.handleARP(frame)
+	case ethernet.TypeIPv4:
+		pkt := ipv4.Parse(frame[ethernet.HeaderLen:])
+		if pkt == nil || pkt.Protocol != ipv4.ProtoTCP || !pkt.DstAddress.Equal(s.cfg.Addr) {
+			return
+		}
+		seg := tcp.Parse(pkt.Data)
+		if seg == nil || !seg.VerifyChecksum(pkt.SrcAddress, pkt.DstAddress) {
+			return
+		}
+		s.mu.Lock()
+		s.learn(pkt.SrcAddress, hdr.SrcAddr)
+		s.handleSegment(pkt.SrcAddress, hdr.SrcAddr, seg, time.Now())
+		s.mu.Unlock()
+	}
+}
+
+func (s *Stack) handleSegment(src net.IP, srcMac net.HardwareAddr, seg *tcp.Segment, now time.Time) {
+	id := newConnID(seg.DstPort, src, seg.SrcPort)
+	if c, ok := s.conns[id]; ok {
+		c.handle(seg, now)
+		return
+	}
+	if l, ok := s.listeners[seg.DstPort]; ok && seg.HasFlags(tcp.FlagSYN) && !seg.HasFlags(tcp.FlagACK) && !seg.HasFlags(tcp.FlagRST) {
+		c := s.newConn(id, srcMac)
+		c.listener = l
+		c.state = stateSynReceived
+		c.synReceived(seg)
+		c.sendSyn(now)
+		return
+	}
+	if !seg.HasFlags(tcp.FlagRST) {
+		s.sendReset(src, srcMac, seg)
+	}
+}
+
+// sendReset answers seg which does not belong to any connection with RST (RFC 793).
+func (s *Stack) sendReset(dst net.IP, dstMac net.HardwareAddr, seg *tcp.Segment) {
+	rst := &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: seg.DstPort,
+			DstPort: seg.SrcPort,
+		},
+	}
+	if seg.HasFlags(tcp.FlagACK) {
+		rst.SeqNum = seg.AckNum
+		rst.Flags = tcp.FlagRST
+	} else {
+		rst.AckNum = seg.SeqNum + segmentLen(seg)
+		rst.Flags = tcp.FlagRST | tcp.FlagACK
+	}
+	s.sendSegment(dst, dstMac, rst)
+}
+
+// sendSegment encodes seg into a frame and sends it to dst.
+func (s *Stack) sendSegment(dst net.IP, dstMac net.HardwareAddr, seg *tcp.Segment) error {
+	data := seg.Encode(s.cfg.Addr, dst)
+	pkt := ipv4.NewPacket(s.cfg.Addr, dst, ipv4.ProtoTCP, data,
+		ipv4.SetIdentification(uint16(rand.Uint32())),
+		ipv4.SetFlags(ipv4.FlagDontFragment),
+		ipv4.SetTTL(defaultTTL),
+	)
+	return s.send(ethernet.Frame(dstMac, s.cfg.MAC, ethernet.TypeIPv4, pkt.Encode()))
+}
+
+// handleARP answers ARP Request for our address and learns the sender.
+func (s *Stack) handleARP(frame []byte) {
+	p := arp.Parse(frame)
+	if p == nil || p.HType != arp.HardwareTypeEthernet || p.PType != arp.ProtocolTypeIPv4 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.Op == arp.OpReply || p.DstPAddr.Equal(s.cfg.Addr) {
+		s.learn(p.SrcPAddr, p.SrcHAddr)
+	}
+	if p.Op != arp.OpRequest || !p.DstPAddr.Equal(s.cfg.Addr) {
+		return
+	}
+	reply := &arp.Packet{
+		HType:    arp.HardwareTypeEthernet,
+		PType:    arp.ProtocolTypeIPv4,
+		HLen:     ethernet.EtherLen,
+		PLen:     net.IPv4len,
+		Op:       arp.OpReply,
+		SrcHAddr: s.cfg.MAC,
+		SrcPAddr: s.cfg.Addr,
+		DstHAddr: p.SrcHAddr,
+		DstPAddr: p.SrcPAddr,
+	}
+	s.send(ethernet.Frame(p.SrcHAddr, s.cfg.MAC, ethernet.TypeARP, reply.Encode()))
+}
+
+// learn records the MAC address of ip. Caller must hold s.mu.
+func (s *Stack) learn(ip net.IP, mac net.HardwareAddr) {
+	key := ip.String()
+	s.neighbors[key] = mac
+	if ch, ok := s.resolving[key]; ok {
+		close(ch)
+		delete(s.resolving, key)
+	}
+}
+
+// resolve returns the MAC address to which segments for ip are sent.
+func (s *Stack) resolve(ip net.IP) (net.HardwareAddr, error) {
+	if s.cfg.Gateway != nil {
+		return s.cfg.Gateway, nil
+	}
+
+	key := ip.String()
+	for i := 0; i < arpRetries; i++ {
+		s.mu.Lock()
+		if mac, ok := s.neighbors[key]; ok {
+			s.mu.Unlock()
+			return mac, nil
+		}
+		ch, ok := s.resolving[key]
+		if !ok {
+			ch = make(chan struct{})
+			s.resolving[key] = ch
+		}
+		s.mu.Unlock()
+
+		req := &arp.Packet{
+			HType:    arp.HardwareTypeEthernet,
+			PType:    arp.ProtocolTypeIPv4,
+			HLen:     ethernet.EtherLen,
+			PLen:     net.IPv4len,
+			Op:       arp.OpRequest,
+			SrcHAddr: s.cfg.MAC,
+			SrcPAddr: s.cfg.Addr,
+			DstHAddr: ethernet.Zero,
+			DstPAddr: ip,
+		}
+		if err := s.send(ethernet.Frame(ethernet.Broadcast, s.cfg.MAC, ethernet.TypeARP, req.Encode())); err != nil {
+			return nil, errors.Wrap(err, "failed to send ARP request")
+		}
+		select {
+		case <-ch:
+		case <-time.After(arpInterval):
+		case <-s.done:
+			return nil, ErrClosed
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mac, ok := s.neighbors[key]; ok {
+		return mac, nil
+	}
+	return nil, errors.Errorf("failed to resolve MAC address of %s", ip)
+}
@@ -0,0 +1,432 @@
+package tcpstack
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/pkg/errors"
+)
+
+var (
+	testAddrA = net.IPv4(10, 0, 0, 1)
+	testAddrB = net.IPv4(10, 0, 0, 2)
+	testMacA  = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	testMacB  = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// testLink connects two stacks. Frames are delivered asynchronously and
+// drop decides whether a frame sent by a stack is lost.
+type testLink struct {
+	a, b *Stack
+	drop func(from *Stack, seg *tcp.Segment) bool
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newTestLink(t *testing.T, drop func(from *Stack, seg *tcp.Segment) bool) *testLink {
+	l := &testLink{
+		drop: drop,
+		done: make(chan struct{}),
+	}
+	toA := make(chan []byte, 1024)
+	toB := make(chan []byte, 1024)
+	l.a = newStack(Config{Addr: testAddrA, MAC: testMacA, Gateway: testMacB, TimeWait: 100 * time.Millisecond}, l.sender(toB))
+	l.b = newStack(Config{Addr: testAddrB, MAC: testMacB, Gateway: testMacA, TimeWait: 100 * time.Millisecond}, l.sender(toA))
+	l.run(l.a, toA)
+	l.run(l.b, toB)
+	return l
+}
+
+func (l *testLink) sender(ch chan []byte) func([]byte) error {
+	return func(frame []byte) error {
+		ch <- frame
+		return nil
+	}
+}
+
+// run delivers frames to s and drives its timers.
+func (l *testLink) run(s *Stack, ch chan []byte) {
+	from := l.a
+	if s == l.a {
+		from = l.b
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case frame := <-ch:
+				if l.drop != nil {
+					pkt := ipv4.Parse(frame[ethernet.HeaderLen:])
+					if pkt != nil && l.drop(from, tcp.Parse(pkt.Data)) {
+						continue
+					}
+				}
+				s.handle(frame)
+			case now := <-ticker.C:
+				s.tick(now)
+			case <-l.done:
+				return
+			}
+		}
+	}()
+}
+
+func (l *testLink) close() {
+	close(l.done)
+	l.wg.Wait()
+}
+
+// transfer sends data from a client on A to a server on B and returns what the server read.
+func transfer(t *testing.T, l *testLink, data []byte) []byte {
+	ln, err := l.b.Listen(80)
+	if err != nil {
+		t.Fatalf("Listen() returns error: %v\n", err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer c.Close()
+		b, _ := ioutil.ReadAll(c)
+		received <- b
+	}()
+
+	c, err := l.a.Dial(testAddrB, 80, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Dial() returns error: %v\n", err)
+	}
+	if _, err := c.Write(data); err != nil {
+		t.Fatalf("Write() returns error: %v\n", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() returns error: %v\n", err)
+	}
+
+	select {
+	case b := <-received:
+		return b
+	case <-time.After(20 * time.Second):
+		t.Fatalf("transfer did not complete\n")
+	}
+	return nil
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func waitClosed(t *testing.T, s *Stack) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("connections are not removed after close\n")
+}
+
+func TestTransfer(t *testing.T) {
+	l := newTestLink(t, nil)
+	defer l.close()
+
+	data := testData(3*DefaultBufferSize + 123)
+	if b := transfer(t, l, data); !bytes.Equal(b, data) {
+		t.Errorf("received %d bytes which differ from sent %d bytes\n", len(b), len(data))
+	}
+	waitClosed(t, l.a)
+	waitClosed(t, l.b)
+}
+
+func TestRetransmission(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	drop := func(from *Stack, seg *tcp.Segment) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if seg == nil || len(seg.Data) == 0 && !seg.HasFlags(tcp.FlagSYN) {
+			return false
+		}
+		n++
+		return n%5 == 1 // lose SYN and some data segments
+	}
+	l := newTestLink(t, drop)
+	defer l.close()
+
+	data := testData(100000)
+	if b := transfer(t, l, data); !bytes.Equal(b, data) {
+		t.Errorf("received %d bytes which differ from sent %d bytes\n", len(b), len(data))
+	}
+}
+
+func TestConnRefused(t *testing.T) {
+	l := newTestLink(t, nil)
+	defer l.close()
+
+	if _, err := l.a.Dial(testAddrB, 81, time.Second); errors.Cause(err) != ErrConnRefused {
+		t.Errorf("Dial() to closed port = %v, but got %v\n", ErrConnRefused, err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	l := newTestLink(t, nil)
+	defer l.close()
+
+	ln, err := l.b.Listen(80)
+	if err != nil {
+		t.Fatalf("Listen() returns error: %v\n", err)
+	}
+	defer ln.Close()
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, _ := ln.AcceptTCP()
+		accepted <- c
+	}()
+	client, err := l.a.Dial(testAddrB, 80, time.Second)
+	if err != nil {
+		t.Fatalf("Dial() returns error: %v\n", err)
+	}
+	server := <-accepted
+
+	l.b.mu.Lock()
+	rcvNxt, wnd := server.rcvNxt, server.window()
+	l.b.mu.Unlock()
+	local := client.LocalAddr().(*net.TCPAddr)
+	rst := &tcp.Segment{
+		Header: tcp.Header{
+			SrcPort: uint16(local.Port),
+			DstPort: 80,
+			Flags:   tcp.FlagRST,
+		},
+	}
+
+	// RST outside of the window must be ignored
+	rst.SeqNum = rcvNxt + wnd + 100
+	injectSegment(l.b, rst)
+	// RST in the window but not exact must be answered with challenge ACK
+	rst.SeqNum = rcvNxt + 1
+	injectSegment(l.b, rst)
+	if s := server.State(); s != "ESTABLISHED" {
+		t.Errorf("state after invalid RST = ESTABLISHED, but got %s\n", s)
+	}
+
+	rst.SeqNum = rcvNxt
+	injectSegment(l.b, rst)
+	if _, err := server.Read(make([]byte, 1)); errors.Cause(err) != ErrConnReset {
+		t.Errorf("Read() after RST = %v, but got %v\n", ErrConnReset, err)
+	}
+}
+
+func injectSegment(s *Stack, seg *tcp.Segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seg.Encode(testAddrA, testAddrB)
+	s.handleSegment(testAddrA, testMacA, seg, time.Now())
+}
+
+func TestReadDeadline(t *testing.T) {
+	l := newTestLink(t, nil)
+	defer l.close()
+
+	ln, err := l.b.Listen(80)
+	if err != nil {
+		t.Fatalf("Listen() returns error: %v\n", err)
+	}
+	defer ln.Close()
+	go ln.Accept()
+	c, err := l.a.Dial(testAddrB, 80, time.Second)
+	if err != nil {
+		t.Fatalf("Dial() returns error: %v\n", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = c.Read(make([]byte, 1))
+	if err != ErrTimeout {
+		t.Errorf("Read() = %v, but got %v\n", ErrTimeout, err)
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("error of Read() must be timeout\n")
+	}
+	c.Close()
+	if _, err := c.Read(make([]byte, 1)); err != ErrClosed {
+		t.Errorf("Read() after Close() = %v, but got %v\n", ErrClosed, err)
+	}
+	if _, err := c.Write([]byte{0x00}); err != ErrClosed {
+		t.Errorf("Write() after Close() = %v, but got %v\n", ErrClosed, err)
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	l := newTestLink(t, nil)
+	defer l.close()
+
+	ln, err := l.b.Listen(80)
+	if err != nil {
+		t.Fatalf("Listen() returns error: %v\n", err)
+	}
+	defer ln.Close()
+
+	// server sends response after the client finished sending request
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, _ := ioutil.ReadAll(c)
+		c.Write(bytes.ToUpper(req))
+	}()
+
+	c, err := l.a.Dial(testAddrB, 80, time.Second)
+	if err != nil {
+		t.Fatalf("Dial() returns error: %v\n", err)
+	}
+	c.Write([]byte("hello"))
+	if err := c.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite() returns error: %v\n", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := ioutil.ReadAll(c)
+	if err != nil || string(b) != "HELLO" {
+		t.Errorf("response = HELLO, but got %s (%v)\n", b, err)
+	}
+	if s := c.State(); s != "TIME-WAIT" {
+		t.Errorf("state after both sides closed = TIME-WAIT, but got %s\n", s)
+	}
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() after FIN = EOF, but got %v\n", err)
+	}
+}
+
+func TestSeq(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		lt   bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{1, 1, false},
+		{0xfffffff0, 0x10, true}, // wrap around
+		{0x10, 0xfffffff0, false},
+	}
+	for _, tt := range tests {
+		if lt := seqLT(tt.a, tt.b); lt != tt.lt {
+			t.Errorf("seqLT(%#x, %#x) = %t, but got %t\n", tt.a, tt.b, tt.lt, lt)
+		}
+	}
+	if !inWindow(0x05, 0xfffffff0, 0x20) || inWindow(0x10, 0xfffffff0, 0x20) {
+		t.Errorf("inWindow() does not handle wrap around\n")
+	}
+}
+
+func TestFinTimeout(t *testing.T) {
+	l := newTestLink(t, nil)
+	defer l.close()
+	l.a.cfg.FinTimeout = 100 * time.Millisecond
+
+	ln, err := l.b.Listen(80)
+	if err != nil {
+		t.Fatalf("Listen() returns error: %v\n", err)
+	}
+	defer ln.Close()
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, _ := ln.AcceptTCP()
+		accepted <- c
+	}()
+	c, err := l.a.Dial(testAddrB, 80, time.Second)
+	if err != nil {
+		t.Fatalf("Dial() returns error: %v\n", err)
+	}
+	server := <-accepted
+
+	// the server never closes its side, so the client stays in FIN-WAIT-2 until the timer expires
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() returns error: %v\n", err)
+	}
+	waitClosed(t, l.a)
+	if s := server.State(); s != "CLOSE-WAIT" {
+		t.Errorf("server state = CLOSE-WAIT, but got %s\n", s)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	s := newStack(Config{Addr: testAddrA, MAC: testMacA, Gateway: testMacB}, func([]byte) error { return nil })
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() returns error: %v\n", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close() returns error: %v\n", err)
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	var mu sync.Mutex
+	resets := 0
+	s := newStack(Config{Addr: testAddrB, MAC: testMacB, Gateway: testMacA}, func(frame []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if seg := tcp.Parse(ipv4.Parse(frame[ethernet.HeaderLen:]).Data); seg.HasFlags(tcp.FlagRST) {
+			resets++
+		}
+		return nil
+	})
+	defer s.Close()
+	ln, err := s.Listen(80)
+	if err != nil {
+		t.Fatalf("Listen() returns error: %v\n", err)
+	}
+
+	// one connection in SYN-RECEIVED and one waiting for Accept
+	injectSegment(s, &tcp.Segment{Header: tcp.Header{SrcPort: 40000, DstPort: 80, Flags: tcp.FlagSYN, Window: 1024}})
+	injectSegment(s, &tcp.Segment{Header: tcp.Header{SrcPort: 40001, DstPort: 80, Flags: tcp.FlagSYN, Window: 1024}})
+	s.mu.Lock()
+	iss := s.conns[newConnID(80, testAddrA, 40001)].iss
+	s.mu.Unlock()
+	injectSegment(s, &tcp.Segment{Header: tcp.Header{SrcPort: 40001, DstPort: 80, SeqNum: 1, AckNum: iss + 1, Flags: tcp.FlagACK, Window: 1024}})
+	if len(ln.accept) != 1 {
+		t.Fatalf("accept queue length = 1, but got %d\n", len(ln.accept))
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("Close() returns error: %v\n", err)
+	}
+	if _, err := ln.Accept(); err != ErrClosed {
+		t.Errorf("Accept() after Close() = %v, but got %v\n", ErrClosed, err)
+	}
+	s.mu.Lock()
+	n := len(s.conns)
+	s.mu.Unlock()
+	if n != 0 {
+		t.Errorf("connections after Close() = 0, but got %d\n", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if resets != 2 {
+		t.Errorf("RST sent by Close() = 2, but got %d\n", resets)
+	}
+}
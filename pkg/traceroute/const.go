@@ -0,0 +1,25 @@
+package traceroute
+
+import "time"
+
+const (
+	// ModeICMP sends ICMP Echo Request as probe.
+	ModeICMP = "icmp"
+	// ModeUDP sends UDP datagram as probe.
+	ModeUDP = "udp"
+	// ModeTCP sends TCP SYN segment as probe.
+	ModeTCP = "tcp"
+
+	// DefaultMaxTTL is the default maximum TTL of probes.
+	DefaultMaxTTL = 30
+	// DefaultProbes is the default number of probes sent per hop.
+	DefaultProbes = 3
+	// DefaultTimeout is the default time to wait for each reply.
+	DefaultTimeout = 3 * time.Second
+	// DefaultSrcPort is the default source port of UDP and TCP probes.
+	DefaultSrcPort = 33433
+	// DefaultUDPPort is the default destination port of UDP probes.
+	DefaultUDPPort = 33434
+	// DefaultTCPPort is the default destination port of TCP probes.
+	DefaultTCPPort = 80
+)
@@ -0,0 +1,368 @@
+package traceroute
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/mas9612/nwspeaker/pkg/ethernet"
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/iface"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+	"github.com/mas9612/nwspeaker/pkg/tcp"
+	"github.com/mas9612/nwspeaker/pkg/udp"
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of Tracer.
+type Config struct {
+	Interface string
+	Dst       net.IP
+	DstMac    net.HardwareAddr // MAC address of the next hop
+	SrcIP     net.IP           // if nil, the address of Interface is used
+	Mode      string
+	FirstTTL  uint8
+	MaxTTL    uint8
+	Probes    int
+	Timeout   time.Duration
+	SrcPort   uint16 // used by ModeUDP and ModeTCP
+	DstPort   uint16 // used by ModeUDP and ModeTCP
+	TOS       uint8
+	Options   []byte // raw IPv4 options added to each probe
+	DataLen   int    // length of probe payload
+}
+
+// Reply represents the result of a probe.
+type Reply struct {
+	Addr    net.IP
+	RTT     time.Duration
+	Type    uint8 // ICMP type of the reply. not used when the reply is TCP segment.
+	Code    uint8 // ICMP code of the reply. not used when the reply is TCP segment.
+	Reached bool  // true if the reply came from the destination
+	Timeout bool
+	MPLS    []icmp.MPLSLabel // label stack reported by ICMP extension (RFC 4950)
+}
+
+// Hop represents the result of probes sent with the same TTL.
+type Hop struct {
+	TTL     uint8
+	Replies []Reply
+}
+
+// Reached reports whether any probe of this hop reached the destination.
+func (h *Hop) Reached() bool {
+	for _, r := range h.Replies {
+		if r.Reached {
+			return true
+		}
+	}
+	return false
+}
+
+// Unreachable reports whether a router on the path answered any probe of this hop
+// with Destination Unreachable, so probes with larger TTL cannot reach the destination either.
+func (h *Hop) Unreachable() bool {
+	for _, r := range h.Replies {
+		if !r.Timeout && !r.Reached && r.Type == icmp.TypeDestinationUnreachable {
+			return true
+		}
+	}
+	return false
+}
+
+// PathChanged reports whether probes of this hop were answered by different addresses.
+// Because all probes share the same flow identifiers, this indicates the path changed while tracing.
+func (h *Hop) PathChanged() bool {
+	var addr net.IP
+	for _, r := range h.Replies {
+		if r.Timeout {
+			continue
+		}
+		if addr == nil {
+			addr = r.Addr
+		} else if !addr.Equal(r.Addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tracer traces the path to the destination.
+type Tracer struct {
+	cfg    Config
+	src    net.IP
+	sock   *ethernet.Socket
+	flowID uint16 // ICMP identifier. fixed for all probes.
+	ipID   uint16 // IP identification of the next probe
+	seq    uint16
+}
+
+// New returns new Tracer instance.
+func New(cfg Config) (*Tracer, error) {
+	switch cfg.Mode {
+	case ModeICMP, ModeUDP, ModeTCP:
+	default:
+		return nil, errors.Errorf("unsupported probe mode '%s'", cfg.Mode)
+	}
+	if cfg.Dst.To4() == nil {
+		return nil, errors.Errorf("destination '%s' is not an IPv4 address", cfg.Dst)
+	}
+	if cfg.FirstTTL == 0 {
+		cfg.FirstTTL = 1
+	}
+	if cfg.MaxTTL == 0 {
+		cfg.MaxTTL = DefaultMaxTTL
+	}
+	if cfg.Probes <= 0 {
+		cfg.Probes = DefaultProbes
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.SrcPort == 0 {
+		cfg.SrcPort = DefaultSrcPort
+	}
+	if cfg.DstPort == 0 {
+		if cfg.Mode == ModeTCP {
+			cfg.DstPort = DefaultTCPPort
+		} else {
+			cfg.DstPort = DefaultUDPPort
+		}
+	}
+
+	src := cfg.SrcIP
+	if src == nil {
+		var err error
+		src, err = iface.IPv4AddressByName(cfg.Interface)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get source IP address")
+		}
+		if src == nil {
+			return nil, errors.Errorf("no IPv4 address is assigned to \"%s\"", cfg.Interface)
+		}
+	}
+
+	sock, err := ethernet.Listen(cfg.Interface, ethernet.TypeIPv4)
+	if err != nil {
+		return nil, err
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	return &Tracer{
+		cfg:    cfg,
+		src:    src,
+		sock:   sock,
+		flowID: uint16(rand.Uint32()),
+		ipID:   uint16(rand.Uint32()),
+	}, nil
+}
+
+// Close closes the socket used to receive replies.
+func (t *Tracer) Close() error {
+	return t.sock.Close()
+}
+
+// Run sends probes with increasing TTL and calls fn with the result of each hop.
+// It stops when the destination is reached, a router reports it unreachable or TTL reaches MaxTTL.
+func (t *Tracer) Run(fn func(*Hop)) error {
+	for ttl := int(t.cfg.FirstTTL); ttl <= int(t.cfg.MaxTTL); ttl++ {
+		hop := &Hop{
+			TTL:     uint8(ttl),
+			Replies: make([]Reply, 0, t.cfg.Probes),
+		}
+		for i := 0; i < t.cfg.Probes; i++ {
+			r, err := t.probe(uint8(ttl))
+			if err != nil {
+				return err
+			}
+			hop.Replies = append(hop.Replies, *r)
+		}
+		fn(hop)
+		if hop.Reached() || hop.Unreachable() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// probe sends a probe with given TTL and waits for the reply.
+func (t *Tracer) probe(ttl uint8) (*Reply, error) {
+	p := &probe{
+		id:  t.ipID,
+		seq: t.seq,
+	}
+	t.ipID++
+	t.seq++
+
+	payload := t.encodeProbe(p)
+	start := time.Now()
+	err := ipv4.Send(t.cfg.Interface, t.cfg.Dst, payload, t.proto(),
+		ipv4.SetDstMac(t.cfg.DstMac),
+		ipv4.SetSrcIP(t.src),
+		ipv4.SetTTL(ttl),
+		ipv4.SetTOS(t.cfg.TOS),
+		ipv4.SetIdentification(p.id),
+		ipv4.SetOptions(t.cfg.Options),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send probe")
+	}
+
+	deadline := start.Add(t.cfg.Timeout)
+	for {
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return &Reply{Timeout: true}, nil
+		}
+		if err := t.sock.SetRecvTimeout(remain); err != nil {
+			return nil, err
+		}
+		b, err := t.sock.Recv(0)
+		if err != nil {
+			if ethernet.IsTimeout(err) {
+				return &Reply{Timeout: true}, nil
+			}
+			return nil, err
+		}
+		r := t.match(p, b[ethernet.HeaderLen:])
+		if r == nil {
+			continue
+		}
+		r.RTT = time.Since(start)
+		return r, nil
+	}
+}
+
+func (t *Tracer) proto() uint8 {
+	switch t.cfg.Mode {
+	case ModeUDP:
+		return ipv4.ProtoUDP
+	case ModeTCP:
+		return ipv4.ProtoTCP
+	default:
+		return ipv4.ProtoICMP
+	}
+}
+
+// probe holds identifiers of a probe which are used to match the reply.
+type probe struct {
+	id  uint16 // IP identification
+	seq uint16
+}
+
+// encodeProbe returns byte-encoded transport data of the probe.
+// Transport headers are kept identical between probes (Paris traceroute) so that
+// load balancers which hash flow identifiers forward all probes to the same path.
+func (t *Tracer) encodeProbe(p *probe) []byte {
+	data := make([]byte, t.cfg.DataLen)
+	for i := range data {
+		data[i] = byte('A' + i%26)
+	}
+
+	switch t.cfg.Mode {
+	case ModeUDP:
+		return udp.New(t.cfg.SrcPort, t.cfg.DstPort, data).Encode(t.src, t.cfg.Dst)
+	case ModeTCP:
+		syn := &tcp.Segment{
+			Header: tcp.Header{
+				SrcPort: t.cfg.SrcPort,
+				DstPort: t.cfg.DstPort,
+				SeqNum:  tcpSeq(p.seq),
+				Flags:   tcp.FlagSYN,
+				Window:  0xffff,
+			},
+		}
+		return syn.Encode(t.src, t.cfg.Dst)
+	default:
+		// first 2 bytes of data cancel out sequence number so that ICMP checksum stays constant
+		comp := make([]byte, 2+len(data))
+		binary.BigEndian.PutUint16(comp, ^p.seq)
+		copy(comp[2:], data)
+		msg := &icmp.Message{
+			Type: icmp.TypeEcho,
+			Data: &icmp.Echo{
+				Identifier:     t.flowID,
+				SequenceNumber: p.seq,
+				Data:           comp,
+			},
+		}
+		return msg.Encode()
+	}
+}
+
+// match checks whether given IPv4 packet is the reply of probe p.
+// If so, it returns Reply without RTT, otherwise returns nil.
+func (t *Tracer) match(p *probe, b []byte) *Reply {
+	pkt := ipv4.Parse(b)
+	if pkt == nil || !pkt.DstAddress.Equal(t.src) {
+		return nil
+	}
+
+	switch pkt.Protocol {
+	case ipv4.ProtoICMP:
+		msg := icmp.Parse(pkt.Data)
+		if msg == nil {
+			return nil
+		}
+		r := &Reply{
+			Addr: pkt.SrcAddress,
+			Type: msg.Type,
+			Code: msg.Code,
+		}
+		switch data := msg.Data.(type) {
+		case *icmp.Echo:
+			if t.cfg.Mode != ModeICMP || msg.Type != icmp.TypeEchoReply {
+				return nil
+			}
+			if data.Identifier != t.flowID || data.SequenceNumber != p.seq {
+				return nil
+			}
+			r.Reached = true
+			return r
+		case *icmp.Error:
+			orig := ipv4.Parse(data.Original)
+			if orig == nil || orig.Identification != p.id || orig.Protocol != t.proto() || !orig.DstAddress.Equal(t.cfg.Dst) {
+				return nil
+			}
+			// e.g. Port Unreachable for UDP probe. Routers on the path may also send Destination Unreachable.
+			r.Reached = msg.Type == icmp.TypeDestinationUnreachable && pkt.SrcAddress.Equal(t.cfg.Dst)
+			r.MPLS = mplsLabels(data.Extension)
+			return r
+		}
+	case ipv4.ProtoTCP:
+		if t.cfg.Mode != ModeTCP || !pkt.SrcAddress.Equal(t.cfg.Dst) {
+			return nil
+		}
+		seg := tcp.Parse(pkt.Data)
+		if seg == nil || seg.SrcPort != t.cfg.DstPort || seg.DstPort != t.cfg.SrcPort || seg.AckNum != tcpSeq(p.seq)+1 {
+			return nil
+		}
+		if !seg.HasFlags(tcp.FlagRST) && !seg.HasFlags(tcp.FlagSYN|tcp.FlagACK) {
+			return nil
+		}
+		return &Reply{
+			Addr:    pkt.SrcAddress,
+			Reached: true,
+		}
+	}
+	return nil
+}
+
+// mplsLabels returns MPLS label stack in ext. If ext does not have it, nil is returned.
+func mplsLabels(ext *icmp.Extension) []icmp.MPLSLabel {
+	if ext == nil {
+		return nil
+	}
+	for _, o := range ext.Objects {
+		if labels, err := o.MPLSLabels(); err == nil {
+			return labels
+		}
+	}
+	return nil
+}
+
+// tcpSeq returns the TCP sequence number of n-th probe.
+func tcpSeq(n uint16) uint32 {
+	return uint32(n) << 16
+}
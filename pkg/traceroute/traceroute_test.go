@@ -0,0 +1,131 @@
+package traceroute
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mas9612/nwspeaker/pkg/icmp"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+)
+
+var (
+	testSrc    = net.IPv4(192, 168, 0, 1)
+	testDst    = net.IPv4(198, 51, 100, 1)
+	testRouter = net.IPv4(192, 168, 0, 254)
+)
+
+func newTestTracer(mode string) *Tracer {
+	return &Tracer{
+		cfg: Config{
+			Dst:     testDst,
+			Mode:    mode,
+			SrcPort: DefaultSrcPort,
+			DstPort: DefaultUDPPort,
+		},
+		src:    testSrc,
+		flowID: 0x1234,
+	}
+}
+
+// icmpError returns ICMP error message sent by from which quotes probe p.
+func icmpError(t *Tracer, p *probe, from net.IP, typ, code uint8) []byte {
+	orig := ipv4.NewPacket(testSrc, testDst, t.proto(), t.encodeProbe(p), ipv4.SetIdentification(p.id)).Encode()
+	msg := &icmp.Message{
+		Type: typ,
+		Code: code,
+		Data: &icmp.Error{Original: orig[:ipv4.HeaderLen+8]},
+	}
+	return ipv4.NewPacket(from, testSrc, ipv4.ProtoICMP, msg.Encode()).Encode()
+}
+
+func timeExceeded(t *Tracer, p *probe) []byte {
+	return icmpError(t, p, testRouter, icmp.TypeTimeExceeded, icmp.CodeTTLExceeded)
+}
+
+func TestMatch(t *testing.T) {
+	udp := newTestTracer(ModeUDP)
+	icmpTracer := newTestTracer(ModeICMP)
+	p := &probe{id: 100, seq: 1}
+	other := &probe{id: 101, seq: 2}
+
+	echoReply := &icmp.Message{
+		Type: icmp.TypeEchoReply,
+		Data: &icmp.Echo{Identifier: 0x1234, SequenceNumber: p.seq},
+	}
+
+	tests := []struct {
+		tracer *Tracer
+		probe  *probe
+		in     []byte
+		out    *Reply
+	}{
+		{udp, p, timeExceeded(udp, p), &Reply{Addr: testRouter, Type: icmp.TypeTimeExceeded}},
+		{udp, other, timeExceeded(udp, p), nil},
+		{icmpTracer, p, timeExceeded(udp, p), nil}, // quoted protocol differs
+		{
+			udp, p, icmpError(udp, p, testDst, icmp.TypeDestinationUnreachable, icmp.CodePortUnreachable),
+			&Reply{Addr: testDst, Type: icmp.TypeDestinationUnreachable, Code: icmp.CodePortUnreachable, Reached: true},
+		},
+		{
+			udp, p, icmpError(udp, p, testRouter, icmp.TypeDestinationUnreachable, icmp.CodeHostUnreachable),
+			&Reply{Addr: testRouter, Type: icmp.TypeDestinationUnreachable, Code: icmp.CodeHostUnreachable},
+		},
+		{icmpTracer, p, ipv4.NewPacket(testDst, testSrc, ipv4.ProtoICMP, echoReply.Encode()).Encode(), &Reply{Addr: testDst, Reached: true}},
+		{icmpTracer, other, ipv4.NewPacket(testDst, testSrc, ipv4.ProtoICMP, echoReply.Encode()).Encode(), nil},
+	}
+	for _, tt := range tests {
+		r := tt.tracer.match(tt.probe, tt.in)
+		if r != nil {
+			r.Addr = r.Addr.To4()
+		}
+		if tt.out != nil {
+			tt.out.Addr = tt.out.Addr.To4()
+		}
+		if !reflect.DeepEqual(r, tt.out) {
+			t.Errorf("match(%x) = %v, but got %v\n", tt.in, tt.out, r)
+		}
+	}
+}
+
+func TestEncodeProbeICMPChecksum(t *testing.T) {
+	tracer := newTestTracer(ModeICMP)
+	a := tracer.encodeProbe(&probe{seq: 1})
+	b := tracer.encodeProbe(&probe{seq: 2})
+	if a[2] != b[2] || a[3] != b[3] {
+		t.Errorf("ICMP checksum should be constant between probes, but got %x and %x\n", a[2:4], b[2:4])
+	}
+}
+
+var pathChangedTests = []struct {
+	in  *Hop
+	out bool
+}{
+	{&Hop{Replies: []Reply{{Addr: testRouter}, {Timeout: true}, {Addr: testRouter}}}, false},
+	{&Hop{Replies: []Reply{{Addr: testRouter}, {Addr: testDst}}}, true},
+}
+
+func TestPathChanged(t *testing.T) {
+	for _, tt := range pathChangedTests {
+		if changed := tt.in.PathChanged(); changed != tt.out {
+			t.Errorf("PathChanged() = %v, but got %v\n", tt.out, changed)
+		}
+	}
+}
+
+var stopTests = []struct {
+	in                   *Hop
+	reached, unreachable bool
+}{
+	{&Hop{Replies: []Reply{{Addr: testRouter, Type: icmp.TypeTimeExceeded}, {Timeout: true}}}, false, false},
+	{&Hop{Replies: []Reply{{Timeout: true}, {Addr: testDst, Type: icmp.TypeDestinationUnreachable, Reached: true}}}, true, false},
+	{&Hop{Replies: []Reply{{Timeout: true}, {Addr: testRouter, Type: icmp.TypeDestinationUnreachable}}}, false, true},
+}
+
+func TestStop(t *testing.T) {
+	for _, tt := range stopTests {
+		if reached, unreachable := tt.in.Reached(), tt.in.Unreachable(); reached != tt.reached || unreachable != tt.unreachable {
+			t.Errorf("Reached(), Unreachable() = %v, %v, but got %v, %v\n", tt.reached, tt.unreachable, reached, unreachable)
+		}
+	}
+}
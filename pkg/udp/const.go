@@ -0,0 +1,6 @@
+package udp
+
+const (
+	// HeaderLen is the length of UDP header.
+	HeaderLen = 8
+)
@@ -0,0 +1,112 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"github.com/mas9612/nwspeaker/pkg/checksum"
+	"github.com/mas9612/nwspeaker/pkg/ipv4"
+)
+
+// Header represents UDP header.
+type Header struct {
+	SrcPort  uint16
+	DstPort  uint16
+	Length   uint16
+	Checksum uint16
+}
+
+// Datagram represents UDP datagram.
+type Datagram struct {
+	Header
+	Data []byte
+}
+
+// New returns Datagram which has given ports and data.
+// Length and Checksum are calculated when it is encoded.
+func New(srcPort, dstPort uint16, data []byte) *Datagram {
+	return &Datagram{
+		Header: Header{
+			SrcPort: srcPort,
+			DstPort: dstPort,
+		},
+		Data: data,
+	}
+}
+
+// Encode returns byte-encoded data of UDP datagram.
+// Length is set to the actual length and Checksum is calculated over the IPv4
+// pseudo header made from src and dst. If the calculated checksum is zero,
+// 0xffff is used instead because zero means no checksum (RFC 768).
+func (d *Datagram) Encode(src, dst net.IP) []byte {
+	d.Length = uint16(HeaderLen + len(d.Data))
+	d.Checksum = 0
+	buffer := d.encode()
+	copy(buffer[6:], Checksum(src, dst, buffer))
+	d.Checksum = binary.BigEndian.Uint16(buffer[6:])
+	if d.Checksum == 0x0000 {
+		d.Checksum = 0xffff
+		binary.BigEndian.PutUint16(buffer[6:], d.Checksum)
+	}
+	return buffer
+}
+
+// EncodeNoChecksum returns byte-encoded data of UDP datagram without checksum.
+// Checksum field is set to zero which means the sender did not compute it.
+func (d *Datagram) EncodeNoChecksum() []byte {
+	d.Length = uint16(HeaderLen + len(d.Data))
+	d.Checksum = 0
+	return d.encode()
+}
+
+func (d *Datagram) encode() []byte {
+	buffer := make([]byte, HeaderLen+len(d.Data))
+	binary.BigEndian.PutUint16(buffer[0:], d.SrcPort)
+	binary.BigEndian.PutUint16(buffer[2:], d.DstPort)
+	binary.BigEndian.PutUint16(buffer[4:], d.Length)
+	binary.BigEndian.PutUint16(buffer[6:], d.Checksum)
+	copy(buffer[HeaderLen:], d.Data)
+	return buffer
+}
+
+// VerifyChecksum reports whether the checksum of d is valid.
+// d must be the result of Parse, and src and dst are the addresses of the IPv4 packet
+// which contains d. Datagram without checksum (zero) is always valid.
+func (d *Datagram) VerifyChecksum(src, dst net.IP) bool {
+	if d.Checksum == 0 {
+		return true
+	}
+	return bytes.Equal(Checksum(src, dst, d.encode()), []byte{0x00, 0x00})
+}
+
+// Checksum calculates UDP checksum of b over the IPv4 pseudo header.
+// b is the whole UDP datagram including header.
+func Checksum(src, dst net.IP, b []byte) []byte {
+	return checksum.SumOfOnesComplement16(append(ipv4.PseudoHeader(src, dst, ipv4.ProtoUDP, len(b)), b...))
+}
+
+// Parse parses given UDP datagram and returns a pointer to Datagram instance.
+// b must not include IPv4 header.
+// Data is truncated to the length indicated by Length field.
+func Parse(b []byte) *Datagram {
+	if len(b) < HeaderLen { // incomplete datagram
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(b[4:]))
+	if length < HeaderLen || length > len(b) {
+		return nil
+	}
+
+	d := &Datagram{
+		Header: Header{
+			SrcPort:  binary.BigEndian.Uint16(b[0:]),
+			DstPort:  binary.BigEndian.Uint16(b[2:]),
+			Length:   uint16(length),
+			Checksum: binary.BigEndian.Uint16(b[6:]),
+		},
+		Data: make([]byte, length-HeaderLen),
+	}
+	copy(d.Data, b[HeaderLen:length])
+	return d
+}
@@ -0,0 +1,97 @@
+package udp
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var (
+	testSrc = net.IPv4(192, 168, 0, 1)
+	testDst = net.IPv4(192, 168, 0, 2)
+)
+
+var encodeTests = []struct {
+	in  *Datagram
+	out []byte
+}{
+	{
+		in:  New(53000, 53, []byte("hello")),
+		out: []byte{0xcf, 0x08, 0x00, 0x35, 0x00, 0x0d, 0x6b, 0x70, 0x68, 0x65, 0x6c, 0x6c, 0x6f},
+	},
+	{
+		// calculated checksum is zero, so it must be sent as 0xffff
+		in:  New(1000, 2000, []byte{0x72, 0xce}),
+		out: []byte{0x03, 0xe8, 0x07, 0xd0, 0x00, 0x0a, 0xff, 0xff, 0x72, 0xce},
+	},
+}
+
+func TestEncode(t *testing.T) {
+	for _, tt := range encodeTests {
+		b := tt.in.Encode(testSrc, testDst)
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("Encode() = %x, but got %x\n", tt.out, b)
+		}
+	}
+}
+
+func TestEncodeNoChecksum(t *testing.T) {
+	b := New(1000, 2000, []byte{0x01}).EncodeNoChecksum()
+	want := []byte{0x03, 0xe8, 0x07, 0xd0, 0x00, 0x09, 0x00, 0x00, 0x01}
+	if !bytes.Equal(b, want) {
+		t.Errorf("EncodeNoChecksum() = %x, but got %x\n", want, b)
+	}
+}
+
+var parseTests = []struct {
+	in  []byte
+	out *Datagram
+}{
+	{
+		in: []byte{0xcf, 0x08, 0x00, 0x35, 0x00, 0x0d, 0x6b, 0x70, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x00}, // with trailing padding
+		out: &Datagram{
+			Header: Header{
+				SrcPort:  53000,
+				DstPort:  53,
+				Length:   13,
+				Checksum: 0x6b70,
+			},
+			Data: []byte("hello"),
+		},
+	},
+	{
+		in:  []byte{0xcf, 0x08, 0x00, 0x35, 0x00, 0x0d, 0x6b, 0x70, 0x68}, // truncated
+		out: nil,
+	},
+	{
+		in:  []byte{0xcf, 0x08, 0x00, 0x35, 0x00, 0x07, 0x6b, 0x70}, // length is shorter than header
+		out: nil,
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		d := Parse(tt.in)
+		if !reflect.DeepEqual(d, tt.out) {
+			t.Errorf("Parse(%x) = %v, but got %v\n", tt.in, tt.out, d)
+		}
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		out bool
+	}{
+		{[]byte{0xcf, 0x08, 0x00, 0x35, 0x00, 0x0d, 0x6b, 0x70, 0x68, 0x65, 0x6c, 0x6c, 0x6f}, true},
+		{[]byte{0xcf, 0x08, 0x00, 0x35, 0x00, 0x0d, 0x6b, 0x70, 0x68, 0x65, 0x6c, 0x6c, 0x6e}, false},
+		{[]byte{0x03, 0xe8, 0x07, 0xd0, 0x00, 0x0a, 0xff, 0xff, 0x72, 0xce}, true},
+		{[]byte{0x03, 0xe8, 0x07, 0xd0, 0x00, 0x09, 0x00, 0x00, 0x01}, true}, // no checksum
+	}
+	for _, tt := range tests {
+		if ok := Parse(tt.in).VerifyChecksum(testSrc, testDst); ok != tt.out {
+			t.Errorf("VerifyChecksum(%x) = %t, but got %t\n", tt.in, tt.out, ok)
+		}
+	}
+}